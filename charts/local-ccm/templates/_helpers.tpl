@@ -63,3 +63,50 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Host directories of the query API socket, output files, detector plugins and
+D-Bus socket, mounted once each
+*/}}
+{{- define "local-ccm.hostDirs" -}}
+{{- $dirs := list }}
+{{- with .Values.queryAPI.socketPath }}
+{{- $dirs = append $dirs (dir .) }}
+{{- end }}
+{{- with .Values.outputFile }}
+{{- $dirs = append $dirs (dir .) }}
+{{- end }}
+{{- with .Values.kubeletNodeIP.file }}
+{{- $dirs = append $dirs (dir .) }}
+{{- end }}
+{{- with .Values.ipDetection.pluginDir }}
+{{- $dirs = append $dirs . }}
+{{- end }}
+{{- if has .Values.controller.networkEvents (list "networkd" "networkmanager") }}
+{{- $dirs = append $dirs "/run/dbus" }}
+{{- end }}
+{{- $dirs | uniq | toJson }}
+{{- end }}
+
+{{/*
+Host directory of the Talos machine config read by the talos detector, empty
+for other detectors
+*/}}
+{{- define "local-ccm.talosConfigDir" -}}
+{{- $detector := .Values.ipDetection.detector | default "" }}
+{{- if eq $detector "talos" }}
+{{- "/system/state" }}
+{{- else if hasPrefix "talos:" $detector }}
+{{- trimPrefix "talos:" $detector | dir }}
+{{- end }}
+{{- end }}
+
+{{/*
+Whether the webhook certificate is mounted from a secret, empty when
+local-ccm generates it
+*/}}
+{{- define "local-ccm.webhookCertMount" -}}
+{{- if and .Values.webhook.enabled (ne .Values.webhook.certificates "selfSigned") }}
+{{- "true" }}
+{{- end }}
+{{- end }}
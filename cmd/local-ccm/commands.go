@@ -0,0 +1,56 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/cozystack/local-ccm/pkg/ccm"
+	"github.com/cozystack/local-ccm/pkg/node"
+)
+
+// taintKey is the taint removed by the remove-taint command
+var taintKey string
+
+func init() {
+	flag.StringVar(&taintKey, "taint-key", node.TaintKey, "Key of the taints removed by the remove-taint command")
+}
+
+// commands are the subcommands of local-ccm, e.g. local-ccm status. They run
+// once instead of the controller, configured by the same flags.
+var commands = map[string]func(ctx context.Context, cfg ccm.Config) error{
+	"status": func(ctx context.Context, cfg ccm.Config) error {
+		return ccm.PrintStatus(ctx, cfg, os.Stdout)
+	},
+	"remove-taint": func(ctx context.Context, cfg ccm.Config) error {
+		return ccm.RemoveTaint(ctx, cfg, taintKey)
+	},
+	"set-provider-id": ccm.SetProviderID,
+}
+
+// commandNames returns the names of the subcommands, sorted
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -18,203 +18,540 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 
+	"github.com/cozystack/local-ccm/pkg/ccm"
+	"github.com/cozystack/local-ccm/pkg/config"
 	"github.com/cozystack/local-ccm/pkg/detector"
-	"github.com/cozystack/local-ccm/pkg/node"
+	"github.com/cozystack/local-ccm/pkg/features"
 )
 
 var (
 	nodeName          string
 	kubeconfig        string
+	master            string
+	targetKubeconfig  string
+	targetSecret      string
+	asUser            string
+	asGroups          []string
+	kubeAPIQPS        float64
+	kubeAPIBurst      int
+	userAgent         string
 	internalIPTarget  string
 	externalIPTarget  string
+	defaultIPv4Target string
+	defaultIPv6Target string
+	egressIPTarget    string
+	withdrawal        string
+	withdrawalGrace   time.Duration
+	removalGrace      time.Duration
+	minUpdateInterval time.Duration
+	conflictPolicy    string
+	taintAfter        time.Duration
+	detectionTaint    string
+	initializingTaint bool
+	traceRequests     bool
+	managedTypes      string
+	addressFamilies   string
+	preferFamily      string
+	coexistence       bool
+	dnsNames          bool
+	probeTargets      bool
+	hostnameOverride  string
+	hostnamePolicy    string
+	hostnameDomain    string
+	providedNodeIP    bool
+	nodeIPFile        string
 	runOnce           bool
+	runOnceTimeout    time.Duration
+	runOnceSummary    bool
+	mode              string
+	resyncInterval    time.Duration
+	simulateNodes     int
+	simulationMode    string
+	selfNode          bool
+	privilegeMode     string
+	startupTimeout    time.Duration
 	removeTaint       bool
+	distribution      string
 	reconcileInterval time.Duration
+	networkEvents     string
+	zone              string
+	region            string
+	configureRoutes   bool
+	excludeFromLBs    string
+	publicIPLabel     bool
+	statusAnnotation  bool
+
+	enableServiceController bool
+	serviceLBForwarderImage string
+	serviceLBPools          string
+	enableIPAddressPools    bool
+	loadBalancerClass       string
+	serviceLBHostname       string
+
+	leResourceLock  string
+	leNamespace     string
+	leIdentity      string
+	leLeaseDuration time.Duration
+	leRenewDeadline time.Duration
+	leRetryPeriod   time.Duration
+
+	enableL2Announcement bool
+	l2Interfaces         string
+
+	configFile            string
+	clusterConfig         string
+	enableBGPAnnouncement bool
+
+	nodeAddressesConfigMap string
+	dnsEndpointTemplate    string
+	dnsEndpointNamespace   string
+	concurrentNodeSyncs    int
+	nodeEndpointsService   string
+	nodeEndpointsSelector  string
+	remoteSecret           string
+	remoteSelector         string
+	inventory              string
+	inventoryController    bool
+
+	bindAddress   string
+	livenessFails int
+	livenessWin   time.Duration
+	metricsAddr   string
+	metricsCert   string
+	metricsKey    string
+	metricsCA     string
+	metricsTokens bool
+	socketPath    string
+	webhookAddr   string
+	webhookCert   string
+	webhookKey    string
+	webhookSecret string
+	webhookSvc    string
+	webhookConfig string
+	providerID    string
+	metadataAddr  string
+	metadataLocal bool
+	outputFile    string
+	networkStatus bool
+
+	detectorSpec          string
+	pluginDir             string
+	internalDetectorSpecs []string
+	externalDetectorSpecs []string
+	addressPolicy         string
+	factLabels            []string
+	factAnnotations       []string
+	featureGates          string
 )
 
 func init() {
 	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "Name of the node to update (env: NODE_NAME)")
-	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (for local testing)")
-	flag.StringVar(&internalIPTarget, "internal-ip-target", "", "Target IP for internal IP detection via 'ip route get'. If empty, internal IP detection is disabled")
-	flag.StringVar(&externalIPTarget, "external-ip-target", "8.8.8.8", "Target IP for external IP detection via 'ip route get'")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to kubeconfig file (for local testing). If empty, the KUBECONFIG environment variable is used, or the in-cluster config")
+	flag.StringVar(&targetKubeconfig, "target-kubeconfig", "", "Path to the kubeconfig of the cluster holding the Node objects, e.g. a hosted control plane, if it differs from the cluster of --kubeconfig. Leader election leases stay in the cluster of --kubeconfig")
+	flag.StringVar(&targetSecret, "target-kubeconfig-secret", "", "Secret ([namespace/]name[:key]) in the cluster of --kubeconfig holding the kubeconfig of the cluster holding the Node objects, instead of --target-kubeconfig. The key defaults to 'value', as used by Cluster API")
+	flag.StringVar(&asUser, "as", "", "User to impersonate for API requests. If empty, the user of the credentials is used")
+	flag.Func("as-group", "Group to impersonate for API requests, requires --as. Can be repeated", func(group string) error {
+		asGroups = append(asGroups, group)
+		return nil
+	})
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 5, "Queries per second to the API server")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 10, "Burst of queries to the API server")
+	flag.StringVar(&userAgent, "user-agent", "", "User-Agent of the requests to the API server. If empty, local-ccm/<version> (<os>/<arch>) node/<node name>")
+	flag.StringVar(&master, "master", "", "Address of the API server, overriding the server of the kubeconfig (e.g. https://host:6443)")
+	flag.StringVar(&internalIPTarget, "internal-ip-target", "", "Target IP for internal IP detection via 'ip route get'. Comma-separated targets are tried in order. If empty, internal IP detection is disabled")
+	flag.StringVar(&externalIPTarget, "external-ip-target", "", "Target IP for external IP detection via 'ip route get'. Comma-separated targets are tried in order. Defaults to the default targets of the address families, IPv4 first")
+	flag.StringVar(&defaultIPv4Target, "default-ipv4-target", ccm.DefaultExternalIPTarget, "Default IPv4 target for external IP detection if --external-ip-target is not set")
+	flag.StringVar(&defaultIPv6Target, "default-ipv6-target", ccm.DefaultExternalIPv6Target, "Default IPv6 target for external IP detection if --external-ip-target is not set")
+	flag.StringVar(&hostnameOverride, "hostname-override", "", "Publish this value as Hostname address instead of the hostname of the host, like the --hostname-override of kubelet. --hostname-policy still applies to it. If empty, the hostname of the host is used")
+	flag.StringVar(&hostnamePolicy, "hostname-policy", "", "Publish the Hostname address as the short hostname (short) or the FQDN (fqdn), matching the --hostname-override of kubelet. The FQDN is the hostname if qualified, or its canonical name in DNS. If empty, the Hostname set by kubelet is preserved")
+	flag.StringVar(&hostnameDomain, "hostname-domain", "", "Domain appended to the short hostname to form the FQDN instead of resolving it. Requires --hostname-policy=fqdn")
+	flag.BoolVar(&providedNodeIP, "sync-provided-node-ip", false, "Publish the detected InternalIP as alpha.kubernetes.io/provided-node-ip annotation, which disables the node IP drift report. Requires --internal-ip-target or --internal-ip-detector")
+	flag.StringVar(&nodeIPFile, "kubelet-node-ip-file", "", "Path of a file the detected InternalIP is atomically written to on change as KUBELET_NODE_IP environment variable, e.g. /run/local-ccm/kubelet-node-ip.env. Requires --internal-ip-target or --internal-ip-detector. If empty, disabled")
+	flag.StringVar(&egressIPTarget, "egress-ip-target", "", "Target IP for egress IP detection, published as local-ccm.io/egress-ip annotation. If empty, disabled")
+	flag.StringVar(&withdrawal, "external-ip-withdrawal", "", "Remove the published ExternalIP once it is stale for --external-ip-withdrawal-grace: failed while external detection fails, unassigned while it fails and the address is not assigned locally. If empty, the ExternalIP is kept")
+	flag.DurationVar(&withdrawalGrace, "external-ip-withdrawal-grace", ccm.DefaultExternalIPWithdrawalGrace, "Time the ExternalIP must be stale before it is withdrawn")
+	flag.BoolVar(&probeTargets, "probe-targets", false, "Ping the detection targets from the detected addresses before trusting them, falling back to the next comma-separated target if one does not answer")
+	flag.BoolVar(&dnsNames, "publish-dns-names", false, "Publish the reverse DNS names of the InternalIP and ExternalIP as InternalDNS and ExternalDNS addresses while they resolve back to the IP, withdrawing them with an event otherwise")
+	flag.DurationVar(&removalGrace, "address-removal-grace", 0, "Time a published InternalIP or ExternalIP is kept after it first disappeared from detection, to ride through brief uplink blips. If 0, addresses are removed right away")
+	flag.DurationVar(&minUpdateInterval, "min-update-interval", 0, "Minimum time between two updates of the node addresses, so flapping detections do not flood the API server. Changes are published once it passed, if still detected. If 0, changes are published right away")
+	flag.StringVar(&conflictPolicy, "address-conflict-policy", "", "Response to another writer changing the node addresses written by local-ccm, reported as event and metric: takeover (rewrite them), backoff (pause updates, exponentially longer on each conflict) or alert (leave them in place). If empty, other writers are not detected")
+	flag.DurationVar(&taintAfter, "detection-taint-after", 0, "Add a NoSchedule taint to the node once the detection of its addresses failed for this long after it succeeded, and remove it once detection succeeds again. If 0, disabled")
+	flag.StringVar(&detectionTaint, "detection-taint", ccm.DefaultDetectionTaint, "Key of the taint added by --detection-taint-after")
+	flag.BoolVar(&initializingTaint, "initializing-taint", false, "Add the local-ccm.io/initializing NoSchedule taint to registering nodes, by the webhook or the first reconciliation, and remove it once the addresses are detected and published and the targets answered the probes. Enables --probe-targets unless set explicitly")
+	flag.BoolVar(&traceRequests, "trace-api-requests", false, "Send the API requests of each reconciliation as spans of a W3C trace, in the traceparent header and as audit ID '<trace ID>-<span ID>', and log the trace ID of each reconciliation, to correlate API server audit events with the reconciliations")
+	flag.StringVar(&managedTypes, "managed-address-types", "", "Comma-separated address types published by local-ccm (InternalIP, ExternalIP, Hostname, InternalDNS, ExternalDNS), leaving the addresses of other types as published by others. If empty, all types are managed, or only ExternalIP with --coexistence. Nodes override it with the local-ccm.io/managed-address-types annotation")
+	flag.StringVar(&addressFamilies, "address-families", "", "Comma-separated address families detected and published: ipv4, ipv6. With ipv6 only, IPv4 targets are refused, IPv4 detections fail and IPv4 addresses are not published. If empty, both")
+	flag.StringVar(&preferFamily, "prefer-family", "", "Address family published if both are detected or published for an address type: ipv4 or ipv6, trying the targets of the family first, or dual to publish an InternalIP and ExternalIP of each family, detected via the targets of each family. If empty, the targets are tried in order")
+	flag.BoolVar(&coexistence, "coexistence", false, "Only augment another cloud provider managing the node: publish the --managed-address-types, keep the uninitialized taint for the provider to remove, and refuse the features writing fields owned by the provider (topology labels, routes, webhook)")
+	flag.StringVar(&detectorSpec, "detector", detector.StrategyRoute, "How to detect addresses: 'route' for the source IP of the route to the targets, 'interface:<name>' for the primary address of an interface, 'default-interface' for the primary address of the interface of the default route, 'talos[:<path>]' for the addresses declared in the Talos machine config, 'kubevirt[:<interface>]' for the pod network interface of KubeVirt VMs, or 'static:<ip>' / 'static:<target>=<ip>,...' for fixed addresses (e.g. for CI and kind)")
+	flag.StringVar(&addressPolicy, "address-policy", detector.PolicyPrimary, "How the route, interface and default-interface detectors select among the addresses of an interface: 'primary' for the primary address, or 'stable' to also accept secondary addresses. Temporary, deprecated and tentative addresses are never selected")
+	flag.StringVar(&pluginDir, "detector-plugin-dir", "", "Directory of detector plugins, e.g. /etc/local-ccm/detectors.d. Executables, unix sockets and WebAssembly modules (*.wasm) in it are registered as detectors named by their file name on startup. If empty, disabled")
+	flag.Func("internal-ip-detector", "Detector of the InternalIP instead of --detector, in the syntax of --detector. Can be repeated to try the detectors in order until one succeeds, e.g. talos and route:10.0.0.1. 'route:<target>' and 'udp:<target>' use their own target. Enables internal IP detection without --internal-ip-target", func(spec string) error {
+		internalDetectorSpecs = append(internalDetectorSpecs, spec)
+		return nil
+	})
+	flag.Func("external-ip-detector", "Detector of the ExternalIP instead of --detector, like --internal-ip-detector", func(spec string) error {
+		externalDetectorSpecs = append(externalDetectorSpecs, spec)
+		return nil
+	})
 	flag.BoolVar(&runOnce, "run-once", false, "Run once and exit instead of running in a loop")
+	flag.DurationVar(&runOnceTimeout, "run-once-timeout", 0, "Overall timeout of --run-once. If zero, there is no timeout")
+	flag.BoolVar(&runOnceSummary, "run-once-summary", false, "Print a JSON summary of the reconciliation of --run-once to stdout: the addresses before and after, whether the taint was removed, the duration, the errors and the exit code")
+	flag.IntVar(&simulateNodes, "simulate-nodes", 0, "Create this many fake nodes named <node-name>-sim-<n> and reconcile them with static addresses, to load-test the API server. The fake nodes are deleted on exit. If 0, disabled")
+	flag.StringVar(&simulationMode, "simulation-mode", ccm.SimulationStandalone, "How --simulate-nodes runs: 'standalone' to reconcile only the fake nodes instead of the own node, or 'controller' to simulate them in the elected instance of the agents next to their own nodes")
+	flag.DurationVar(&startupTimeout, "startup-timeout", 5*time.Minute, "Time to wait for the API server to become reachable on startup")
 	flag.BoolVar(&removeTaint, "remove-taint", true, "Remove node.cloudprovider.kubernetes.io/uninitialized taint")
+	flag.StringVar(&distribution, "distribution", "", "Kubernetes distribution whose declared addresses take precedence over detection: k3s (--node-ip and --node-external-ip) or k0s (k0sproject.io/node-ip-external annotation). If empty, addresses are always detected")
 	flag.DurationVar(&reconcileInterval, "reconcile-interval", 10*time.Second, "Interval between reconciliation loops")
+	flag.StringVar(&mode, "mode", ccm.ModeLoop, "How to reconcile: loop (every --reconcile-interval) or once-then-watch (right away, retrying every --reconcile-interval until it succeeds, then on changes of the network and the node, and every --resync-interval). once-then-watch defaults --network-events to netlink")
+	flag.DurationVar(&resyncInterval, "resync-interval", ccm.DefaultResyncInterval, "Interval between reconciliations of --mode=once-then-watch")
+	flag.StringVar(&networkEvents, "network-events", "", "Also reconcile on network changes reported by: netlink (address and link changes), networkd (systemd-networkd link states via D-Bus) or networkmanager (NetworkManager device states and DHCP leases via D-Bus). If empty, only the interval applies")
+	flag.StringVar(&zone, "zone", os.Getenv("ZONE"), "Zone of the node, published as topology.kubernetes.io/zone label (env: ZONE)")
+	flag.StringVar(&region, "region", os.Getenv("REGION"), "Region of the node, published as topology.kubernetes.io/region label. If empty, derived from --zone (env: REGION)")
+
+	flag.BoolVar(&configureRoutes, "configure-routes", false, "Program static routes to the pod CIDRs of other nodes via their InternalIP of the family of each CIDR")
+	flag.StringVar(&excludeFromLBs, "exclude-from-external-load-balancers", "", "Manage the node.kubernetes.io/exclude-from-external-load-balancers label: auto sets it while the node has no public ExternalIP, always or never set or remove it. If empty, the label is left alone")
+	flag.BoolVar(&publicIPLabel, "public-ip-label", false, "Label the node with local-ccm.io/has-public-ip=true|false, telling whether the detected ExternalIP is public")
+	flag.Func("fact-label", "Label key=template rendered from the facts of the node: {interface}, {ipv4}, {ipv6}, {dualstack}, {nat} and {uplinks}, e.g. example.com/uplink={interface}. Can be repeated", func(pair string) error {
+		factLabels = append(factLabels, pair)
+		return nil
+	})
+	flag.Func("fact-annotation", "Annotation key=template rendered from the facts of the node, like --fact-label. Can be repeated", func(pair string) error {
+		factAnnotations = append(factAnnotations, pair)
+		return nil
+	})
+	flag.BoolVar(&statusAnnotation, "status-annotation", false, "Annotate the node with local-ccm.io/status, holding the phase, time and error of the last reconciliation")
+	flag.StringVar(&leResourceLock, "leader-elect-resource-lock", ccm.DefaultLeaderElectResourceLock, "Type of the lock electing the instance running each cluster-wide controller. Only 'leases' is supported")
+	flag.StringVar(&leNamespace, "leader-elect-resource-namespace", "", "Namespace of the leases. If empty, the namespace of local-ccm is used")
+	flag.StringVar(&leIdentity, "leader-elect-identity", "", "Holder identity of the leases. If empty, the node name is used")
+	flag.DurationVar(&leLeaseDuration, "leader-elect-lease-duration", ccm.DefaultLeaderElectLeaseDuration, "Time non-leaders wait after the last renewal before taking over a lease")
+	flag.DurationVar(&leRenewDeadline, "leader-elect-renew-deadline", ccm.DefaultLeaderElectRenewDeadline, "Time the leader retries renewing a lease before giving it up. Must be less than --leader-elect-lease-duration")
+	flag.DurationVar(&leRetryPeriod, "leader-elect-retry-period", ccm.DefaultLeaderElectRetryPeriod, "Time between attempts to acquire or renew a lease")
+	flag.BoolVar(&enableServiceController, "enable-service-controller", false, "Publish node IPs as ingress of LoadBalancer services (one instance is elected cluster-wide)")
+	flag.StringVar(&serviceLBForwarderImage, "service-lb-forwarder-image", "", "Image of the hostPort forwarder DaemonSet created per LoadBalancer service (e.g. rancher/klipper-lb:v0.4.9). If empty, no forwarders are created")
+	flag.StringVar(&serviceLBPools, "service-lb-pools", "", "Comma-separated CIDRs to allocate LoadBalancer IPs from (deprecated, use IPAddressPool resources). If empty, node IPs are published as LoadBalancer ingress")
+	flag.BoolVar(&enableIPAddressPools, "enable-ip-address-pools", false, "Allocate LoadBalancer IPs from IPAddressPool resources")
+	flag.StringVar(&loadBalancerClass, "load-balancer-class", "", "Only handle LoadBalancer services with this spec.loadBalancerClass (e.g. local-ccm.io/node-ip). If empty, only services without a class are handled")
+	flag.StringVar(&serviceLBHostname, "service-lb-hostname", "", "Publish hostnames instead of IPs as LoadBalancer ingress: 'reverse-dns' or a template like '{service}.{zone}.example.com' ({service}, {namespace}, {zone} and {ip} are replaced). If empty, IPs are published")
+	flag.BoolVar(&enableL2Announcement, "enable-l2-announcement", false, "Answer ARP (IPv4) and NDP (IPv6) requests for LoadBalancer IPs from pools elected to this node")
+	flag.StringVar(&l2Interfaces, "l2-interfaces", "", "Comma-separated interfaces to announce LoadBalancer IPs on. If empty, the interface routing to each IP is used")
+	flag.StringVar(&configFile, "config", "", "Path to the config file")
+	flag.StringVar(&clusterConfig, "cluster-config", "", "Name of a LocalCCMConfig resource whose set fields override the detection, taint and label flags. If empty, disabled")
+	flag.StringVar(&nodeAddressesConfigMap, "node-addresses-configmap", "", "ConfigMap ([namespace/]name) to publish the addresses of all nodes to (one instance is elected cluster-wide). If empty, addresses are not published")
+	flag.StringVar(&dnsEndpointTemplate, "dns-endpoint-template", "", "Maintain external-dns DNSEndpoints with the ExternalIPs of each node under this name template, e.g. {node}.nodes.example.com (one instance is elected cluster-wide). If empty, disabled")
+	flag.StringVar(&dnsEndpointNamespace, "dns-endpoint-namespace", "", "Namespace of the DNSEndpoints. If empty, the namespace of local-ccm is used")
+	flag.IntVar(&concurrentNodeSyncs, "concurrent-node-syncs", ccm.DefaultConcurrentNodeSyncs, "Number of nodes whose DNSEndpoints are synced, or which are detected remotely or simulated, in parallel. Failed nodes are retried with backoff without delaying the others")
+	flag.StringVar(&nodeEndpointsService, "node-endpoints-service", "", "Headless Service ([namespace/]name) to publish the ExternalIPs of the nodes as EndpointSlices of (one instance is elected cluster-wide). If empty, disabled")
+	flag.StringVar(&nodeEndpointsSelector, "node-endpoints-selector", "", "Label selector of the nodes published by --node-endpoints-service. If empty, all nodes are published")
+	flag.StringVar(&remoteSecret, "remote-detection-secret", "", "Secret ([namespace/]name) with the SSH credentials (ssh-privatekey, known_hosts, optional username and port) to detect the addresses of nodes without an agent by running ip route get on them (one instance is elected cluster-wide). If empty, disabled")
+	flag.StringVar(&remoteSelector, "remote-detection-selector", ccm.DefaultRemoteDetectionSelector, "Label selector of the nodes detected over SSH by --remote-detection-secret")
+	flag.StringVar(&inventory, "inventory", "", "NodeInventory ([namespace/]name) to report the detected addresses into instead of updating the node, for nodes of a cluster set up from a management cluster. If empty, disabled")
+	flag.BoolVar(&inventoryController, "inventory-controller", false, "Update the nodes of the clusters referenced by the NodeInventories with the addresses reported into them by --inventory (one instance is elected cluster-wide)")
+	flag.StringVar(&bindAddress, "bind-address", "", "Address to serve the local HTTP endpoints (/debug/detection, /debug/routes, /healthz, and /metrics unless --metrics-bind-address is set) on, e.g. 127.0.0.1:10290. If empty, disabled")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", "", "Address to serve /metrics on via TLS instead of on --bind-address, e.g. :10292. If empty, disabled")
+	flag.StringVar(&metricsCert, "metrics-cert-file", "", "Certificate file of --metrics-bind-address, reloaded on change")
+	flag.StringVar(&metricsKey, "metrics-key-file", "", "Key file of --metrics-bind-address, reloaded on change")
+	flag.StringVar(&metricsCA, "metrics-client-ca-file", "", "CA file of the client certificates allowed to get /metrics of --metrics-bind-address")
+	flag.BoolVar(&metricsTokens, "metrics-authorize-tokens", false, "Allow bearer tokens whose user may get /metrics, checked with TokenReviews and SubjectAccessReviews. Without it and --metrics-client-ca-file, /metrics of --metrics-bind-address is not authenticated")
+	flag.IntVar(&livenessFails, "liveness-failures", ccm.DefaultLivenessFailures, "Report unhealthy on /healthz once this many reconciliations failed in a row on API server requests within --liveness-window. Detection failures do not count. If negative, only stalled reconciliations are reported")
+	flag.DurationVar(&livenessWin, "liveness-window", ccm.DefaultLivenessWindow, "Window of --liveness-failures, and time after which a reconciliation that is due but did not complete is reported unhealthy on /healthz")
+	flag.StringVar(&socketPath, "socket-path", "", "Path of a unix socket to serve the detected addresses on for other host agents, e.g. /run/local-ccm/local-ccm.sock. If empty, disabled")
+	flag.StringVar(&webhookAddr, "webhook-bind-address", "", "Address to serve the mutating admission webhook for Nodes on via TLS, e.g. :10291. If empty, disabled")
+	flag.StringVar(&webhookCert, "webhook-cert-file", "", "Certificate file of the admission webhook, reloaded on change")
+	flag.StringVar(&webhookKey, "webhook-key-file", "", "Key file of the admission webhook, reloaded on change")
+	flag.StringVar(&webhookSecret, "webhook-cert-secret", "", "Secret ([namespace/]name) to keep a self-signed CA and serving certificate of the admission webhook in, generated and renewed by one elected instance, instead of --webhook-cert-file and --webhook-key-file")
+	flag.StringVar(&webhookSvc, "webhook-service", "", "Service ([namespace/]name) of the admission webhook, whose DNS names the certificate of --webhook-cert-secret is valid for")
+	flag.StringVar(&webhookConfig, "webhook-configuration", "", "MutatingWebhookConfiguration to inject the CA bundle of --webhook-cert-secret into")
+	flag.StringVar(&providerID, "provider-id-template", ccm.DefaultProviderIDTemplate, "providerID set by the admission webhook and the set-provider-id command, with the {node}, {zone} and {region} placeholders")
+	flag.StringVar(&metadataAddr, "metadata-bind-address", "", "Address to serve EC2-style instance metadata of the node on, e.g. 169.254.169.254:80. If empty, disabled")
+	flag.BoolVar(&metadataLocal, "metadata-local-address", false, "Add the IP of --metadata-bind-address to the loopback interface, so pods reach it via their default route. Requires NET_ADMIN")
+	flag.BoolVar(&networkStatus, "publish-network-status", false, "Publish the detections, interfaces, default routes and errors of the node in a NodeNetworkStatus resource")
+	flag.StringVar(&outputFile, "output-file", "", "Path of a file the detected addresses are atomically written to on change, e.g. /run/local-ccm/addresses.json. If empty, disabled")
+	flag.BoolVar(&enableBGPAnnouncement, "enable-bgp-announcement", false, "Advertise LoadBalancer IPs from pools to the BGP peers of the config file")
+
+	flag.StringVar(&privilegeMode, "privilege-mode", ccm.PrivilegeModePrivileged, "privileged, or restricted to refuse features requiring capabilities (route configuration, L2 announcement), so local-ccm can run without capabilities and as non-root")
+	flag.BoolVar(&selfNode, "self-node", false, "Only access the local node, as granted to the kubelet by the Node authorizer (e.g. with --kubeconfig=/etc/kubernetes/kubelet.conf). Disables taint removal unless set explicitly, and rejects controllers requiring cluster-wide permissions")
+	flag.StringVar(&featureGates, "feature-gates", "", "Comma-separated Name=true|false pairs toggling features in development. Known gates: "+strings.Join(features.Known(), ", "))
 
 	klog.InitFlags(nil)
 }
 
 func main() {
-	flag.Parse()
+	// Invalid flags exit with ExitConfigError instead of the exit code 2 of
+	// the flag package, which means a detection failure
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	parseFlags(os.Args[1:])
+
+	// A subcommand runs instead of the controller, taking flags before and
+	// after its name
+	run := ccm.Run
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			configFatalf("Unknown command %q, must be one of %s", flag.Arg(0), strings.Join(commandNames(), ", "))
+		}
+		run = command
+		parseFlags(flag.Args()[1:])
+		if flag.NArg() > 0 {
+			configFatalf("Unexpected arguments %v", flag.Args())
+		}
+	}
 
 	if nodeName == "" {
-		klog.Fatal("--node-name or NODE_NAME environment variable must be set")
+		configFatalf("--node-name or NODE_NAME environment variable must be set")
 	}
 
-	klog.Infof("Starting local-ccm for node %s", nodeName)
-	klog.V(2).Infof("Configuration: internalIPTarget=%q externalIPTarget=%q",
-		internalIPTarget, externalIPTarget)
+	// Register the plugins before parsing the detectors referencing them
+	if pluginDir != "" {
+		names, err := detector.LoadPlugins(pluginDir)
+		if err != nil {
+			configFatalf("Failed to load detector plugins: %v", err)
+		}
+		klog.Infof("Registered detector plugins: %v", names)
+	}
 
-	// Create Kubernetes client
-	k8sClient, err := createKubernetesClient(kubeconfig)
+	addressDetector, err := detector.ParseDetector(detectorSpec)
 	if err != nil {
-		klog.Fatalf("Failed to create Kubernetes client: %v", err)
-	}
-
-	// Create node updater
-	nodeUpdater := node.NewUpdater(k8sClient, nodeName)
-
-	ctx := context.Background()
-
-	// Main reconciliation loop
-	for {
-		if err := reconcile(ctx, nodeUpdater); err != nil {
-			klog.Errorf("Reconciliation failed: %v", err)
-			if runOnce {
-				os.Exit(1)
-			}
-		} else {
-			klog.Infof("Reconciliation completed successfully")
-			if runOnce {
-				os.Exit(0)
-			}
+		configFatalf("Invalid --detector: %v", err)
+	}
+	var internalDetector, externalDetector detector.Detector
+	if len(internalDetectorSpecs) > 0 {
+		if internalDetector, err = detector.ParseChain(internalDetectorSpecs); err != nil {
+			configFatalf("Invalid --internal-ip-detector: %v", err)
+		}
+	}
+	if len(externalDetectorSpecs) > 0 {
+		if externalDetector, err = detector.ParseChain(externalDetectorSpecs); err != nil {
+			configFatalf("Invalid --external-ip-detector: %v", err)
 		}
-
-		klog.V(2).Infof("Sleeping for %v until next reconciliation", reconcileInterval)
-		time.Sleep(reconcileInterval)
 	}
-}
-
-func reconcile(ctx context.Context, nodeUpdater *node.Updater) error {
-	klog.V(2).Infof("Starting reconciliation for node %s", nodeName)
 
-	// Get current node
-	currentNode, err := nodeUpdater.GetNode(ctx)
+	policy, err := detector.ParsePolicy(addressPolicy)
 	if err != nil {
-		return fmt.Errorf("failed to get node: %w", err)
+		configFatalf("Invalid --address-policy: %v", err)
 	}
 
-	// Start with existing addresses
-	addressMap := make(map[v1.NodeAddressType]string)
-	for _, addr := range currentNode.Status.Addresses {
-		addressMap[addr.Type] = addr.Address
+	gates, err := features.Parse(featureGates)
+	if err != nil {
+		configFatalf("Invalid --feature-gates: %v", err)
 	}
 
-	// Detect Internal IP if configured
-	if internalIPTarget != "" {
-		klog.V(3).Infof("Detecting internal IP using target %s", internalIPTarget)
-		internalIP, err := detector.DetectIP(internalIPTarget)
-		if err != nil {
-			return fmt.Errorf("failed to detect internal IP: %w", err)
-		}
-		klog.V(2).Infof("Detected internal IP: %s", internalIP)
-		addressMap[v1.NodeInternalIP] = internalIP
+	// Nodes cannot modify their taints, so only remove them if asked to
+	if selfNode && !flagSet("remove-taint") {
+		removeTaint = false
+	}
+	// Verifying a node includes probing the targets
+	if initializingTaint && !flagSet("probe-targets") {
+		probeTargets = true
+	}
+	// The other cloud provider removes the taint once it initialized the node
+	if coexistence && !flagSet("remove-taint") {
+		removeTaint = false
 	}
-	// If internalIPTarget is not set, preserve existing InternalIP (e.g., set by kubelet)
 
-	// Always detect and update External IP
-	klog.V(3).Infof("Detecting external IP using target %s", externalIPTarget)
-	detectedExternalIP, err := detector.DetectIP(externalIPTarget)
-	if err != nil {
-		return fmt.Errorf("failed to detect external IP: %w", err)
-	}
-	klog.V(2).Infof("Detected external IP: %s", detectedExternalIP)
-
-	// Check if external IP equals internal IP - if so, don't set external IP
-	if internalIP, hasInternal := addressMap[v1.NodeInternalIP]; hasInternal && internalIP == detectedExternalIP {
-		klog.V(2).Infof("External IP %s matches internal IP, removing external IP from addresses", detectedExternalIP)
-		delete(addressMap, v1.NodeExternalIP)
-	} else {
-		addressMap[v1.NodeExternalIP] = detectedExternalIP
-	}
-
-	// Convert map back to slice
-	addresses := make([]v1.NodeAddress, 0, len(addressMap))
-	for addrType, addrValue := range addressMap {
-		addresses = append(addresses, v1.NodeAddress{
-			Type:    addrType,
-			Address: addrValue,
-		})
-	}
-
-	// Check if addresses changed
-	if addressesEqual(currentNode.Status.Addresses, addresses) {
-		klog.V(3).Info("Addresses unchanged, skipping update")
-	} else {
-		klog.Info("Addresses changed, updating node")
-		if err := nodeUpdater.UpdateAddresses(ctx, addresses); err != nil {
-			return fmt.Errorf("failed to update addresses: %w", err)
+	cfg := ccm.Config{
+		NodeName:                  nodeName,
+		Namespace:                 os.Getenv("POD_NAMESPACE"),
+		FeatureGates:              gates,
+		Kubeconfig:                kubeconfig,
+		Master:                    master,
+		TargetKubeconfig:          targetKubeconfig,
+		TargetKubeconfigSecret:    targetSecret,
+		ImpersonateUser:           asUser,
+		ImpersonateGroups:         asGroups,
+		QPS:                       float32(kubeAPIQPS),
+		Burst:                     kubeAPIBurst,
+		UserAgent:                 userAgent,
+		SelfNode:                  selfNode,
+		PrivilegeMode:             privilegeMode,
+		InternalIPTarget:          internalIPTarget,
+		ExternalIPTarget:          externalIPTarget,
+		DefaultIPv4Target:         defaultIPv4Target,
+		DefaultIPv6Target:         defaultIPv6Target,
+		EgressIPTarget:            egressIPTarget,
+		ExternalIPWithdrawal:      withdrawal,
+		ExternalIPWithdrawalGrace: withdrawalGrace,
+		AddressRemovalGrace:       removalGrace,
+		MinUpdateInterval:         minUpdateInterval,
+		AddressConflictPolicy:     conflictPolicy,
+		DetectionTaintAfter:       taintAfter,
+		DetectionTaint:            detectionTaint,
+		InitializingTaint:         initializingTaint,
+		TraceAPIRequests:          traceRequests,
+		Coexistence:               coexistence,
+		PreferFamily:              preferFamily,
+		DNSNames:                  dnsNames,
+		ProbeTargets:              probeTargets,
+		HostnameOverride:          hostnameOverride,
+		HostnamePolicy:            hostnamePolicy,
+		HostnameDomain:            hostnameDomain,
+		ProvidedNodeIP:            providedNodeIP,
+		Detector:                  addressDetector,
+		InternalIPDetector:        internalDetector,
+		ExternalIPDetector:        externalDetector,
+		AddressPolicy:             policy,
+		RunOnce:                   runOnce,
+		RunOnceTimeout:            runOnceTimeout,
+		SimulateNodes:             simulateNodes,
+		SimulationMode:            simulationMode,
+		StartupTimeout:            startupTimeout,
+		RemoveTaint:               removeTaint,
+		Distribution:              distribution,
+		ReconcileInterval:         reconcileInterval,
+		Mode:                      mode,
+		ResyncInterval:            resyncInterval,
+		NetworkEvents:             networkEvents,
+		Zone:                      zone,
+		Region:                    region,
+		ConfigureRoutes:           configureRoutes,
+		ExcludeFromLoadBalancers:  excludeFromLBs,
+		PublicIPLabel:             publicIPLabel,
+		StatusAnnotation:          statusAnnotation,
+		FactLabels:                factLabels,
+		FactAnnotations:           factAnnotations,
+		ServiceController:         enableServiceController,
+		LeaderElectResourceLock:   leResourceLock,
+		LeaderElectNamespace:      leNamespace,
+		LeaderElectIdentity:       leIdentity,
+		LeaderElectLeaseDuration:  leLeaseDuration,
+		LeaderElectRenewDeadline:  leRenewDeadline,
+		LeaderElectRetryPeriod:    leRetryPeriod,
+		ForwarderImage:            serviceLBForwarderImage,
+		IPAddressPools:            enableIPAddressPools,
+		LoadBalancerClass:         loadBalancerClass,
+		Hostname:                  serviceLBHostname,
+		L2Announcement:            enableL2Announcement,
+		BGPAnnouncement:           enableBGPAnnouncement,
+		NodeAddressesConfigMap:    nodeAddressesConfigMap,
+		DNSEndpointTemplate:       dnsEndpointTemplate,
+		DNSEndpointNamespace:      dnsEndpointNamespace,
+		ConcurrentNodeSyncs:       concurrentNodeSyncs,
+		NodeEndpointsService:      nodeEndpointsService,
+		NodeEndpointsSelector:     nodeEndpointsSelector,
+		RemoteDetectionSecret:     remoteSecret,
+		RemoteDetectionSelector:   remoteSelector,
+		InventoryController:       inventoryController,
+		Inventory:                 inventory,
+		BindAddress:               bindAddress,
+		LivenessFailures:          livenessFails,
+		LivenessWindow:            livenessWin,
+		MetricsBindAddress:        metricsAddr,
+		MetricsCertFile:           metricsCert,
+		MetricsKeyFile:            metricsKey,
+		MetricsClientCAFile:       metricsCA,
+		MetricsAuthorizeTokens:    metricsTokens,
+		SocketPath:                socketPath,
+		WebhookBindAddress:        webhookAddr,
+		WebhookCertFile:           webhookCert,
+		WebhookCertSecret:         webhookSecret,
+		WebhookService:            webhookSvc,
+		WebhookConfiguration:      webhookConfig,
+		WebhookKeyFile:            webhookKey,
+		ProviderIDTemplate:        providerID,
+		MetadataBindAddress:       metadataAddr,
+		MetadataLocalAddress:      metadataLocal,
+		OutputFile:                outputFile,
+		NetworkStatus:             networkStatus,
+		ClusterConfig:             clusterConfig,
+		KubeletNodeIPFile:         nodeIPFile,
+	}
+	if serviceLBPools != "" {
+		cfg.Pools = strings.Split(serviceLBPools, ",")
+	}
+	if runOnceSummary {
+		cfg.RunOnceSummary = os.Stdout
+	}
+	if l2Interfaces != "" {
+		cfg.L2Interfaces = strings.Split(l2Interfaces, ",")
+	}
+	if addressFamilies != "" {
+		for _, family := range strings.Split(addressFamilies, ",") {
+			cfg.AddressFamilies = append(cfg.AddressFamilies, strings.TrimSpace(family))
 		}
 	}
-
-	// Remove taint if requested
-	if removeTaint {
-		if err := nodeUpdater.RemoveTaint(ctx); err != nil {
-			return fmt.Errorf("failed to remove taint: %w", err)
+	if managedTypes != "" {
+		for _, addressType := range strings.Split(managedTypes, ",") {
+			cfg.ManagedAddressTypes = append(cfg.ManagedAddressTypes, v1.NodeAddressType(strings.TrimSpace(addressType)))
 		}
 	}
 
-	return nil
-}
-
-func createKubernetesClient(kubeconfigPath string) (kubernetes.Interface, error) {
-	var restConfig *rest.Config
-	var err error
-
-	if kubeconfigPath != "" {
-		klog.V(2).Infof("Using kubeconfig from %s", kubeconfigPath)
-		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	} else {
-		klog.V(2).Info("Using in-cluster config")
-		restConfig, err = rest.InClusterConfig()
+	if configFile != "" {
+		fileConfig, err := config.Load(configFile)
+		if err != nil {
+			configFatalf("Failed to load config: %v", err)
+		}
+		cfg.BGP = fileConfig.BGP
+		if d := fileConfig.Detection; d != nil {
+			applyAddressDetection(d.InternalIP, "internal-ip", &cfg.InternalIPTarget, &cfg.InternalIPDetector)
+			applyAddressDetection(d.ExternalIP, "external-ip", &cfg.ExternalIPTarget, &cfg.ExternalIPDetector)
+		}
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create rest config: %w", err)
-	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	client, err := kubernetes.NewForConfig(restConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	if err := run(ctx, cfg); err != nil {
+		klog.Errorf("%v", err)
+		cancel()
+		os.Exit(ccm.ExitCode(err))
 	}
-
-	return client, nil
 }
 
-// addressesEqual checks if two address slices are equal
-func addressesEqual(a, b []v1.NodeAddress) bool {
-	if len(a) != len(b) {
-		return false
+// parseFlags parses the flags of the command line, exiting with
+// ExitConfigError if they are invalid
+func parseFlags(args []string) {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(ccm.ExitOK)
+		}
+		os.Exit(ccm.ExitConfigError)
 	}
+}
 
-	// Create maps for comparison
-	aMap := make(map[string]string)
-	bMap := make(map[string]string)
-
-	for _, addr := range a {
-		aMap[string(addr.Type)] = addr.Address
+// applyAddressDetection applies the detection of an address type from the
+// config file, unless the --<prefix>-target and --<prefix>-detector flags
+// were set on the command line
+func applyAddressDetection(d *config.AddressDetection, prefix string, target *string, addressDetector *detector.Detector) {
+	if d == nil {
+		return
 	}
-	for _, addr := range b {
-		bMap[string(addr.Type)] = addr.Address
+	if d.Target != "" && !flagSet(prefix+"-target") {
+		*target = d.Target
 	}
-
-	// Compare maps
-	for k, v := range aMap {
-		if bMap[k] != v {
-			return false
+	if len(d.Detectors) > 0 && !flagSet(prefix+"-detector") {
+		// Validated when loading the config file
+		chain, err := detector.ParseChain(d.Detectors)
+		if err != nil {
+			configFatalf("Invalid detectors in config file: %v", err)
 		}
+		*addressDetector = chain
 	}
+}
 
-	return true
+// configFatalf logs an invalid configuration and exits with ExitConfigError
+func configFatalf(format string, args ...interface{}) {
+	klog.Errorf(format, args...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, ccm.ExitConfigError)
+}
+
+// flagSet reports whether a flag was set on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -0,0 +1,333 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/ipam"
+	"github.com/cozystack/local-ccm/pkg/service"
+)
+
+// Responder answers address resolution requests for a set of IPs
+type Responder interface {
+	// SetAddresses replaces the set of announced IPs
+	SetAddresses(ips []net.IP) error
+	// Close stops answering requests
+	Close() error
+}
+
+// multiResponder hands all addresses to each of its responders
+type multiResponder []Responder
+
+// NewL2Responder creates a responder announcing IPv4 addresses via ARP and
+// IPv6 addresses via NDP
+func NewL2Responder(interfaces []string) Responder {
+	return multiResponder{
+		NewARPResponder(interfaces),
+		NewNDPResponder(interfaces),
+	}
+}
+
+func (m multiResponder) SetAddresses(ips []net.IP) error {
+	var errs []error
+	for _, r := range m {
+		errs = append(errs, r.SetAddresses(ips))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiResponder) Close() error {
+	var errs []error
+	for _, r := range m {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Config holds the settings of the announcer
+type Config struct {
+	// NodeName is the name of the local node
+	NodeName string
+	// Pools provides the address pools LoadBalancer IPs are allocated from.
+	// Only ingress IPs inside the pools are announced, by the nodes selected
+	// by their pool.
+	Pools *ipam.PoolWatcher
+	// LoadBalancerClass restricts announcements to services of this class
+	LoadBalancerClass string
+	// AllNodes announces every IP from every ready node instead of electing
+	// a single node, as routers balance across BGP next hops
+	AllNodes bool
+	// NodeExternalIPs also announces the ExternalIPs of the local node
+	NodeExternalIPs bool
+}
+
+// Announcer announces the LoadBalancer IPs elected to the local node. Unless
+// AllNodes is set, one node is elected for each IP among all ready nodes by
+// hashing the node name with the IP, so every speaker comes to the same result
+// without coordination and another node takes over when the elected node is lost.
+type Announcer struct {
+	config    Config
+	responder Responder
+
+	serviceLister       corelisters.ServiceLister
+	nodeLister          corelisters.NodeLister
+	endpointSliceLister discoverylisters.EndpointSliceLister
+	synced              []cache.InformerSynced
+	// handlers are removed from the shared informers when Run returns
+	handlers map[cache.SharedIndexInformer]cache.ResourceEventHandlerRegistration
+
+	trigger chan struct{}
+}
+
+// NewAnnouncer creates a new announcer using the given responder
+func NewAnnouncer(factory informers.SharedInformerFactory, responder Responder, config Config) (*Announcer, error) {
+	a := &Announcer{
+		config:    config,
+		responder: responder,
+		handlers:  make(map[cache.SharedIndexInformer]cache.ResourceEventHandlerRegistration),
+		trigger:   make(chan struct{}, 1),
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { a.enqueue() },
+		UpdateFunc: func(interface{}, interface{}) { a.enqueue() },
+		DeleteFunc: func(interface{}) { a.enqueue() },
+	}
+
+	serviceInformer := factory.Core().V1().Services()
+	registration, err := serviceInformer.Informer().AddEventHandler(handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add service event handler: %w", err)
+	}
+	a.handlers[serviceInformer.Informer()] = registration
+	a.serviceLister = serviceInformer.Lister()
+	a.synced = append(a.synced, registration.HasSynced)
+
+	nodeInformer := factory.Core().V1().Nodes()
+	registration, err = nodeInformer.Informer().AddEventHandler(handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+	a.handlers[nodeInformer.Informer()] = registration
+	a.nodeLister = nodeInformer.Lister()
+	a.synced = append(a.synced, registration.HasSynced)
+
+	endpointSliceInformer := factory.Discovery().V1().EndpointSlices()
+	registration, err = endpointSliceInformer.Informer().AddEventHandler(handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add endpoint slice event handler: %w", err)
+	}
+	a.handlers[endpointSliceInformer.Informer()] = registration
+	a.endpointSliceLister = endpointSliceInformer.Lister()
+	a.synced = append(a.synced, registration.HasSynced)
+
+	if err := config.Pools.AddEventHandler(a.enqueue); err != nil {
+		return nil, fmt.Errorf("failed to add pool event handler: %w", err)
+	}
+	a.synced = append(a.synced, config.Pools.HasSynced)
+
+	return a, nil
+}
+
+// Run announces the elected IPs until the context is cancelled
+func (a *Announcer) Run(ctx context.Context) {
+	defer func() {
+		if err := a.responder.Close(); err != nil {
+			klog.Errorf("Failed to close responder: %v", err)
+		}
+		for informer, registration := range a.handlers {
+			if err := informer.RemoveEventHandler(registration); err != nil {
+				klog.Errorf("Failed to remove event handler: %v", err)
+			}
+		}
+	}()
+
+	klog.Info("Starting announcer")
+
+	if !cache.WaitForCacheSync(ctx.Done(), a.synced...) {
+		klog.Error("Failed to wait for announcer caches to sync")
+		return
+	}
+
+	// Resync periodically in case a responder update failed
+	go wait.UntilWithContext(ctx, func(context.Context) { a.enqueue() }, time.Minute)
+
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Stopping announcer")
+			return
+		case <-a.trigger:
+			if err := a.sync(); err != nil {
+				klog.Errorf("Failed to sync announcements: %v", err)
+			}
+		}
+	}
+}
+
+func (a *Announcer) enqueue() {
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync computes the IPs elected to the local node and hands them to the responder
+func (a *Announcer) sync() error {
+	nodes, err := a.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	services, err := a.serviceLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list services: %w", err)
+	}
+
+	var ips []net.IP
+	if a.config.NodeExternalIPs {
+		for _, node := range nodes {
+			if node.Name != a.config.NodeName {
+				continue
+			}
+			for _, addr := range node.Status.Addresses {
+				if ip := net.ParseIP(addr.Address); addr.Type == v1.NodeExternalIP && ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+
+	pools := a.config.Pools.Pools()
+	for _, svc := range services {
+		if !service.IsManaged(svc, a.config.LoadBalancerClass) {
+			continue
+		}
+
+		svcNodes, err := a.serviceCandidates(svc, nodes)
+		if err != nil {
+			klog.Warningf("Skipping service %s/%s: %v", svc.Namespace, svc.Name, err)
+			continue
+		}
+
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			ip := net.ParseIP(ing.IP)
+			if ip == nil {
+				continue
+			}
+			pool := ipam.FindPool(pools, ip)
+			if pool == nil {
+				continue
+			}
+
+			var candidates []string
+			for _, node := range svcNodes {
+				if pool.SelectsNode(node.Labels) {
+					candidates = append(candidates, node.Name)
+				}
+			}
+			if !a.announces(ip, candidates) {
+				continue
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	klog.V(3).Infof("Announcing %d LoadBalancer IPs from node %s: %v", len(ips), a.config.NodeName, ips)
+	return a.responder.SetAddresses(ips)
+}
+
+// announces checks if the local node announces an IP
+func (a *Announcer) announces(ip net.IP, candidates []string) bool {
+	if !a.config.AllNodes {
+		return ElectNode(ip.String(), candidates) == a.config.NodeName
+	}
+	for _, name := range candidates {
+		if name == a.config.NodeName {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceCandidates returns the ready nodes sorted by name eligible for
+// announcing the IPs of a service. Nodes must match the node selector of the
+// service, and run one of its endpoints if its externalTrafficPolicy is Local.
+func (a *Announcer) serviceCandidates(svc *v1.Service, nodes []*v1.Node) ([]*v1.Node, error) {
+	selector, err := service.NodeSelector(svc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", service.NodeSelectorAnnotation, err)
+	}
+
+	var endpointNodes map[string]bool
+	if service.HasLocalTrafficPolicy(svc) {
+		if endpointNodes, err = service.EndpointNodes(a.endpointSliceLister, svc); err != nil {
+			return nil, err
+		}
+	}
+
+	var candidates []*v1.Node
+	for _, node := range nodes {
+		if !service.IsNodeReady(node) {
+			continue
+		}
+		if _, excluded := node.Labels[v1.LabelNodeExcludeBalancers]; excluded {
+			continue
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		if endpointNodes != nil && !endpointNodes[node.Name] {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	return candidates, nil
+}
+
+// ElectNode deterministically elects the node announcing an IP. The node
+// with the lowest hash of its name and the IP wins, which spreads IPs across
+// nodes and only moves the IPs of a lost node.
+func ElectNode(ip string, nodes []string) string {
+	var elected string
+	var electedHash [sha256.Size]byte
+	for _, node := range nodes {
+		hash := sha256.Sum256([]byte(node + "#" + ip))
+		if elected == "" || string(hash[:]) < string(electedHash[:]) {
+			elected = node
+			electedHash = hash
+		}
+	}
+	return elected
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cozystack/local-ccm/pkg/service"
+)
+
+// testNode returns a node with the given readiness and labels
+func testNode(name string, ready bool, labels map[string]string) *v1.Node {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}},
+		},
+	}
+}
+
+func names(nodes []*v1.Node) []string {
+	var names []string
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+func TestElectNode(t *testing.T) {
+	nodes := []string{"node-a", "node-b", "node-c", "node-d"}
+	tests := []struct {
+		name  string
+		ip    string
+		nodes []string
+		want  string
+	}{
+		{name: "no candidates", ip: "192.0.2.10", want: ""},
+		{name: "single candidate", ip: "192.0.2.10", nodes: []string{"node-b"}, want: "node-b"},
+		// The winners must not change between releases, as speakers of
+		// different versions would announce the same IP otherwise
+		{name: "IPv4", ip: "192.0.2.10", nodes: nodes, want: "node-d"},
+		{name: "IPv6", ip: "2001:db8::10", nodes: nodes, want: "node-b"},
+		{name: "order of the candidates", ip: "192.0.2.10", nodes: []string{"node-d", "node-b", "node-a", "node-c"}, want: "node-d"},
+	}
+	for _, tc := range tests {
+		if got := ElectNode(tc.ip, tc.nodes); got != tc.want {
+			t.Errorf("%s: elected %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestElectNodeFailover(t *testing.T) {
+	nodes := []string{"node-a", "node-b", "node-c", "node-d"}
+	winners := make(map[string]string)
+	elected := make(map[string]bool)
+	for i := 0; i < 64; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		winners[ip] = ElectNode(ip, nodes)
+		elected[winners[ip]] = true
+	}
+	if len(elected) != len(nodes) {
+		t.Errorf("64 IPs are spread across %d of %d nodes", len(elected), len(nodes))
+	}
+
+	// Losing a node only moves its IPs, to another candidate
+	lost := "node-b"
+	remaining := []string{"node-a", "node-c", "node-d"}
+	for ip, winner := range winners {
+		got := ElectNode(ip, remaining)
+		switch {
+		case winner == lost && got == lost:
+			t.Errorf("%s: elected the lost node", ip)
+		case winner != lost && got != winner:
+			t.Errorf("%s: moved from %s to %s after losing %s", ip, winner, got, lost)
+		}
+	}
+}
+
+func TestServiceCandidates(t *testing.T) {
+	local := func(svc *v1.Service) {
+		svc.Spec.ExternalTrafficPolicy = v1.ServiceExternalTrafficPolicyLocal
+	}
+	selector := func(value string) func(*v1.Service) {
+		return func(svc *v1.Service) {
+			svc.Annotations = map[string]string{service.NodeSelectorAnnotation: value}
+		}
+	}
+	excluded := map[string]string{v1.LabelNodeExcludeBalancers: ""}
+
+	tests := []struct {
+		name    string
+		nodes   []*v1.Node
+		modify  func(*v1.Service)
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "ready nodes sorted by name",
+			nodes: []*v1.Node{testNode("node-c", true, nil), testNode("node-a", true, nil), testNode("node-b", true, nil)},
+			want:  []string{"node-a", "node-b", "node-c"},
+		},
+		{
+			name:  "NotReady node skipped",
+			nodes: []*v1.Node{testNode("node-a", false, nil), testNode("node-b", true, nil)},
+			want:  []string{"node-b"},
+		},
+		{
+			name:  "node without Ready condition skipped",
+			nodes: []*v1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, testNode("node-b", true, nil)},
+			want:  []string{"node-b"},
+		},
+		{
+			name:  "excluded node skipped",
+			nodes: []*v1.Node{testNode("node-a", true, excluded), testNode("node-b", true, nil)},
+			want:  []string{"node-b"},
+		},
+		{
+			name:   "node selector",
+			nodes:  []*v1.Node{testNode("node-a", true, map[string]string{"edge": "true"}), testNode("node-b", true, nil)},
+			modify: selector("edge=true"),
+			want:   []string{"node-a"},
+		},
+		{
+			name:    "invalid node selector",
+			nodes:   []*v1.Node{testNode("node-a", true, nil)},
+			modify:  selector("edge in (true"),
+			wantErr: true,
+		},
+		{
+			name:   "local traffic policy selects the nodes of ready endpoints",
+			nodes:  []*v1.Node{testNode("node-a", true, nil), testNode("node-b", true, nil), testNode("node-c", true, nil)},
+			modify: local,
+			want:   []string{"node-b"},
+		},
+		{
+			name:  "no candidates",
+			nodes: []*v1.Node{testNode("node-a", false, nil), testNode("node-b", true, excluded)},
+		},
+		{
+			name: "no nodes",
+		},
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	ready, notReady := true, false
+	nodeB, nodeC := "node-b", "node-c"
+	if err := indexer.Add(&discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-1",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "web"},
+		},
+		Endpoints: []discoveryv1.Endpoint{
+			{NodeName: &nodeB, Conditions: discoveryv1.EndpointConditions{Ready: &ready}},
+			{NodeName: &nodeC, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	a := &Announcer{endpointSliceLister: discoverylisters.NewEndpointSliceLister(indexer)}
+
+	for _, tc := range tests {
+		svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+		if tc.modify != nil {
+			tc.modify(svc)
+		}
+		candidates, err := a.serviceCandidates(svc, tc.nodes)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := names(candidates); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: candidates %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAnnouncesFailover(t *testing.T) {
+	ip := "192.0.2.10"
+	nodes := []*v1.Node{testNode("node-a", true, nil), testNode("node-b", true, nil), testNode("node-c", true, nil)}
+	svc := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+
+	a := &Announcer{}
+	candidates, err := a.serviceCandidates(svc, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	winner := ElectNode(ip, names(candidates))
+
+	for _, tc := range []struct {
+		name string
+		lose func(*v1.Node)
+	}{
+		{
+			name: "NotReady",
+			lose: func(node *v1.Node) { node.Status.Conditions[0].Status = v1.ConditionFalse },
+		},
+		{
+			name: "excluded",
+			lose: func(node *v1.Node) { node.Labels = map[string]string{v1.LabelNodeExcludeBalancers: "true"} },
+		},
+	} {
+		var changed []*v1.Node
+		for _, node := range nodes {
+			node = node.DeepCopy()
+			if node.Name == winner {
+				tc.lose(node)
+			}
+			changed = append(changed, node)
+		}
+		candidates, err := a.serviceCandidates(svc, changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != len(nodes)-1 {
+			t.Errorf("%s: candidates %v still hold %s", tc.name, names(candidates), winner)
+		}
+		// Exactly one of the remaining nodes takes over
+		var announcing []string
+		for _, node := range nodes {
+			announcer := &Announcer{config: Config{NodeName: node.Name}}
+			if announcer.announces(net.ParseIP(ip), names(candidates)) {
+				announcing = append(announcing, node.Name)
+			}
+		}
+		if len(announcing) != 1 || announcing[0] == winner {
+			t.Errorf("%s: %v announce after losing %s", tc.name, announcing, winner)
+		}
+	}
+}
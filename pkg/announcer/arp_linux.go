@@ -0,0 +1,287 @@
+//go:build linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ethHeaderLen = 14
+	arpPacketLen = 28
+
+	arpOpRequest = 1
+	arpOpReply   = 2
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// ARPResponder answers ARP requests for announced IPv4 addresses on the
+// interface routing to each address, or on the configured interfaces
+type ARPResponder struct {
+	interfaces []string
+
+	mu        sync.Mutex
+	listeners map[int]*arpListener
+}
+
+// NewARPResponder creates an ARP responder. If interfaces is empty, each IP
+// is announced on the interface the host routes it through.
+func NewARPResponder(interfaces []string) *ARPResponder {
+	return &ARPResponder{
+		interfaces: interfaces,
+		listeners:  make(map[int]*arpListener),
+	}
+}
+
+// SetAddresses replaces the set of announced IPs. IPv6 addresses are ignored.
+func (r *ARPResponder) SetAddresses(ips []net.IP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byLink := make(map[int][]net.IP)
+	ifaces := make(map[int]*net.Interface)
+	var errs []error
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			continue
+		}
+		links, err := interfacesFor(r.interfaces, ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, iface := range links {
+			byLink[iface.Index] = append(byLink[iface.Index], ip.To4())
+			ifaces[iface.Index] = iface
+		}
+	}
+
+	// Stop listeners of interfaces without announced IPs
+	for index, listener := range r.listeners {
+		if _, ok := byLink[index]; !ok {
+			listener.close()
+			delete(r.listeners, index)
+		}
+	}
+
+	for index, linkIPs := range byLink {
+		listener, ok := r.listeners[index]
+		if !ok {
+			var err error
+			listener, err = newARPListener(ifaces[index])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			r.listeners[index] = listener
+		}
+		listener.setAddresses(linkIPs)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close stops all listeners
+func (r *ARPResponder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for index, listener := range r.listeners {
+		listener.close()
+		delete(r.listeners, index)
+	}
+	return nil
+}
+
+// arpListener answers ARP requests on a single interface
+type arpListener struct {
+	iface *net.Interface
+	fd    int
+
+	mu  sync.RWMutex
+	ips map[[4]byte]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newARPListener(iface *net.Interface) (*arpListener, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ARP socket: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+	}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind ARP socket to %s: %w", iface.Name, err)
+	}
+
+	// Wake up periodically to notice close requests
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to set ARP socket timeout: %w", err)
+	}
+
+	l := &arpListener{
+		iface: iface,
+		fd:    fd,
+		ips:   make(map[[4]byte]bool),
+		done:  make(chan struct{}),
+	}
+
+	l.wg.Add(1)
+	go l.serve()
+
+	klog.V(2).Infof("Started ARP responder on %s", iface.Name)
+	return l, nil
+}
+
+// setAddresses replaces the announced IPs, sending gratuitous ARP for new
+// ones so neighbors learn about the takeover immediately
+func (l *arpListener) setAddresses(ips []net.IP) {
+	next := make(map[[4]byte]bool, len(ips))
+	for _, ip := range ips {
+		var addr [4]byte
+		copy(addr[:], ip.To4())
+		next[addr] = true
+	}
+
+	l.mu.Lock()
+	var added [][4]byte
+	for addr := range next {
+		if !l.ips[addr] {
+			added = append(added, addr)
+		}
+	}
+	l.ips = next
+	l.mu.Unlock()
+
+	for _, addr := range added {
+		klog.Infof("Announcing %s via ARP on %s", net.IP(addr[:]), l.iface.Name)
+		if err := l.send(broadcastMAC, arpOpRequest, addr, addr, nil); err != nil {
+			klog.Warningf("Failed to send gratuitous ARP for %s on %s: %v", net.IP(addr[:]), l.iface.Name, err)
+		}
+	}
+}
+
+func (l *arpListener) close() {
+	close(l.done)
+	l.wg.Wait()
+	unix.Close(l.fd)
+	klog.V(2).Infof("Stopped ARP responder on %s", l.iface.Name)
+}
+
+func (l *arpListener) serve() {
+	defer l.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+
+		n, from, err := unix.Recvfrom(l.fd, buf, 0)
+		if err != nil {
+			if !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EINTR) {
+				klog.V(4).Infof("Failed to read ARP packet on %s: %v", l.iface.Name, err)
+			}
+			continue
+		}
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+		l.handle(buf[:n])
+	}
+}
+
+// handle answers an ARP request if it asks for an announced IP
+func (l *arpListener) handle(frame []byte) {
+	if len(frame) < ethHeaderLen+arpPacketLen {
+		return
+	}
+	arp := frame[ethHeaderLen:]
+
+	// Only Ethernet/IPv4 requests
+	if binary.BigEndian.Uint16(arp[0:2]) != 1 || binary.BigEndian.Uint16(arp[2:4]) != unix.ETH_P_IP ||
+		arp[4] != 6 || arp[5] != 4 || binary.BigEndian.Uint16(arp[6:8]) != arpOpRequest {
+		return
+	}
+
+	senderMAC := net.HardwareAddr(append([]byte(nil), arp[8:14]...))
+	var senderIP, targetIP [4]byte
+	copy(senderIP[:], arp[14:18])
+	copy(targetIP[:], arp[24:28])
+
+	l.mu.RLock()
+	announced := l.ips[targetIP]
+	l.mu.RUnlock()
+	if !announced {
+		return
+	}
+
+	klog.V(4).Infof("Answering ARP request for %s from %s on %s", net.IP(targetIP[:]), senderMAC, l.iface.Name)
+	if err := l.send(senderMAC, arpOpReply, targetIP, senderIP, senderMAC); err != nil {
+		klog.Warningf("Failed to send ARP reply for %s on %s: %v", net.IP(targetIP[:]), l.iface.Name, err)
+	}
+}
+
+// send writes an ARP packet claiming senderIP for the interface MAC
+func (l *arpListener) send(dst net.HardwareAddr, op uint16, senderIP, targetIP [4]byte, targetMAC net.HardwareAddr) error {
+	frame := make([]byte, ethHeaderLen+arpPacketLen)
+	copy(frame[0:6], dst)
+	copy(frame[6:12], l.iface.HardwareAddr)
+	binary.BigEndian.PutUint16(frame[12:14], unix.ETH_P_ARP)
+
+	arp := frame[ethHeaderLen:]
+	binary.BigEndian.PutUint16(arp[0:2], 1)
+	binary.BigEndian.PutUint16(arp[2:4], unix.ETH_P_IP)
+	arp[4] = 6
+	arp[5] = 4
+	binary.BigEndian.PutUint16(arp[6:8], op)
+	copy(arp[8:14], l.iface.HardwareAddr)
+	copy(arp[14:18], senderIP[:])
+	copy(arp[18:24], targetMAC)
+	copy(arp[24:28], targetIP[:])
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  l.iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], dst)
+
+	return unix.Sendto(l.fd, frame, 0, addr)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"fmt"
+	"net"
+)
+
+// ARPResponder is not supported on this platform
+type ARPResponder struct{}
+
+// NewARPResponder creates an ARP responder
+func NewARPResponder(interfaces []string) *ARPResponder {
+	return &ARPResponder{}
+}
+
+// SetAddresses always fails, ARP announcement requires Linux
+func (r *ARPResponder) SetAddresses(ips []net.IP) error {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return fmt.Errorf("ARP announcement is only supported on Linux")
+		}
+	}
+	return nil
+}
+
+// Close does nothing
+func (r *ARPResponder) Close() error {
+	return nil
+}
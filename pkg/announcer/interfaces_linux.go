@@ -0,0 +1,61 @@
+//go:build linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// interfacesFor returns the interfaces an IP is announced on: the configured
+// interfaces, or the interface the host routes the IP through
+func interfacesFor(configured []string, ip net.IP) ([]*net.Interface, error) {
+	if len(configured) > 0 {
+		ifaces := make([]*net.Interface, 0, len(configured))
+		for _, name := range configured {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get interface %s: %w", name, err)
+			}
+			ifaces = append(ifaces, iface)
+		}
+		return ifaces, nil
+	}
+
+	start := time.Now()
+	routes, err := netlink.RouteGet(ip)
+	metrics.ObserveNetlink(metrics.NetlinkRouteGet, start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get route to %s: %w", ip, err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route found to %s", ip)
+	}
+
+	iface, err := net.InterfaceByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface of route to %s: %w", ip, err)
+	}
+	return []*net.Interface{iface}, nil
+}
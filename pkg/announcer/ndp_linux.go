@@ -0,0 +1,398 @@
+//go:build linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+const (
+	ndpFlagSolicited = 0x40
+	ndpFlagOverride  = 0x20
+
+	ndpOptionTargetLinkLayerAddress = 2
+
+	ipv6HeaderLen      = 40
+	ndpSolicitationLen = 24
+)
+
+var allNodesMulticast = net.ParseIP("ff02::1")
+
+// solicitationFilter passes neighbor solicitations without extension headers
+// carrying the maximum hop limit required by RFC 4861, so the packet socket
+// is not woken up by every IPv6 frame of the interface
+var solicitationFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.ETH_P_IPV6, SkipTrue: 7},
+	bpf.LoadAbsolute{Off: ethHeaderLen + 6, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.IPPROTO_ICMPV6, SkipTrue: 5},
+	bpf.LoadAbsolute{Off: ethHeaderLen + 7, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 255, SkipTrue: 3},
+	bpf.LoadAbsolute{Off: ethHeaderLen + ipv6HeaderLen, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(ipv6.ICMPTypeNeighborSolicitation), SkipTrue: 1},
+	bpf.RetConstant{Val: 0xffff},
+	bpf.RetConstant{Val: 0},
+}
+
+// NDPResponder answers neighbor solicitations for announced IPv6 addresses
+// on the interface routing to each address, or on the configured interfaces
+type NDPResponder struct {
+	interfaces []string
+
+	mu        sync.Mutex
+	listeners map[int]*ndpListener
+}
+
+// NewNDPResponder creates an NDP responder. If interfaces is empty, each IP
+// is announced on the interface the host routes it through.
+func NewNDPResponder(interfaces []string) *NDPResponder {
+	return &NDPResponder{
+		interfaces: interfaces,
+		listeners:  make(map[int]*ndpListener),
+	}
+}
+
+// SetAddresses replaces the set of announced IPs. IPv4 addresses are ignored.
+func (r *NDPResponder) SetAddresses(ips []net.IP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byLink := make(map[int][]net.IP)
+	ifaces := make(map[int]*net.Interface)
+	var errs []error
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			continue
+		}
+		links, err := interfacesFor(r.interfaces, ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, iface := range links {
+			byLink[iface.Index] = append(byLink[iface.Index], ip)
+			ifaces[iface.Index] = iface
+		}
+	}
+
+	// Stop listeners of interfaces without announced IPs
+	for index, listener := range r.listeners {
+		if _, ok := byLink[index]; !ok {
+			listener.close()
+			delete(r.listeners, index)
+		}
+	}
+
+	for index, linkIPs := range byLink {
+		listener, ok := r.listeners[index]
+		if !ok {
+			var err error
+			listener, err = newNDPListener(ifaces[index])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			r.listeners[index] = listener
+		}
+		if err := listener.setAddresses(linkIPs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close stops all listeners
+func (r *NDPResponder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for index, listener := range r.listeners {
+		listener.close()
+		delete(r.listeners, index)
+	}
+	return nil
+}
+
+// ndpListener answers neighbor solicitations on a single interface. They are
+// read from a packet socket, as the kernel drops the unicast solicitations
+// of neighbor unreachability detection, addressed to the announced IP, before
+// they reach ICMPv6 sockets. The ICMPv6 socket joins the solicited-node
+// multicast groups and sends the advertisements.
+type ndpListener struct {
+	iface *net.Interface
+	fd    int
+	conn  *icmp.PacketConn
+	pc    *ipv6.PacketConn
+
+	mu  sync.RWMutex
+	ips map[[16]byte]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newNDPListener(iface *net.Interface) (*ndpListener, error) {
+	fd, err := newSolicitationSocket(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to open ICMPv6 socket: %w", err)
+	}
+	pc := conn.IPv6PacketConn()
+
+	// Solicitations are read from the packet socket, so none are queued here
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	if err := pc.SetICMPFilter(&filter); err != nil {
+		unix.Close(fd)
+		conn.Close()
+		return nil, fmt.Errorf("failed to set ICMPv6 filter: %w", err)
+	}
+
+	l := &ndpListener{
+		iface: iface,
+		fd:    fd,
+		conn:  conn,
+		pc:    pc,
+		ips:   make(map[[16]byte]bool),
+		done:  make(chan struct{}),
+	}
+
+	l.wg.Add(1)
+	go l.serve()
+
+	klog.V(2).Infof("Started NDP responder on %s", iface.Name)
+	return l, nil
+}
+
+// newSolicitationSocket opens a packet socket receiving the neighbor
+// solicitations of the interface
+func newSolicitationSocket(iface *net.Interface) (int, error) {
+	program, err := bpf.Assemble(solicitationFilter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assemble solicitation filter: %w", err)
+	}
+	filter := make([]unix.SockFilter, len(program))
+	for i, instruction := range program {
+		filter[i] = unix.SockFilter{Code: instruction.Op, Jt: instruction.Jt, Jf: instruction.Jf, K: instruction.K}
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_IPV6)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open NDP socket: %w", err)
+	}
+
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}); err != nil {
+		unix.Close(fd)
+		return 0, fmt.Errorf("failed to attach solicitation filter: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_IPV6),
+		Ifindex:  iface.Index,
+	}); err != nil {
+		unix.Close(fd)
+		return 0, fmt.Errorf("failed to bind NDP socket to %s: %w", iface.Name, err)
+	}
+
+	// Wake up periodically to notice close requests
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		unix.Close(fd)
+		return 0, fmt.Errorf("failed to set NDP socket timeout: %w", err)
+	}
+
+	return fd, nil
+}
+
+// setAddresses replaces the announced IPs, joining their solicited-node
+// multicast groups and sending unsolicited advertisements for new ones
+func (l *ndpListener) setAddresses(ips []net.IP) error {
+	next := make(map[[16]byte]bool, len(ips))
+	for _, ip := range ips {
+		var addr [16]byte
+		copy(addr[:], ip.To16())
+		next[addr] = true
+	}
+
+	l.mu.Lock()
+	var added, removed [][16]byte
+	for addr := range next {
+		if !l.ips[addr] {
+			added = append(added, addr)
+		}
+	}
+	for addr := range l.ips {
+		if !next[addr] {
+			removed = append(removed, addr)
+		}
+	}
+	l.ips = next
+	l.mu.Unlock()
+
+	var errs []error
+	for _, addr := range removed {
+		group := &net.IPAddr{IP: solicitedNodeMulticast(addr)}
+		if err := l.pc.LeaveGroup(l.iface, group); err != nil {
+			klog.V(4).Infof("Failed to leave %s on %s: %v", group, l.iface.Name, err)
+		}
+	}
+	for _, addr := range added {
+		group := &net.IPAddr{IP: solicitedNodeMulticast(addr)}
+		if err := l.pc.JoinGroup(l.iface, group); err != nil {
+			errs = append(errs, fmt.Errorf("failed to join %s on %s: %w", group, l.iface.Name, err))
+			continue
+		}
+		klog.Infof("Announcing %s via NDP on %s", net.IP(addr[:]), l.iface.Name)
+		if err := l.advertise(addr, allNodesMulticast, false); err != nil {
+			klog.Warningf("Failed to send unsolicited advertisement for %s on %s: %v", net.IP(addr[:]), l.iface.Name, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (l *ndpListener) close() {
+	close(l.done)
+	l.wg.Wait()
+	unix.Close(l.fd)
+	l.conn.Close()
+	klog.V(2).Infof("Stopped NDP responder on %s", l.iface.Name)
+}
+
+func (l *ndpListener) serve() {
+	defer l.wg.Done()
+
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case <-l.done:
+			return
+		default:
+		}
+
+		n, from, err := unix.Recvfrom(l.fd, buf, 0)
+		if err != nil {
+			if !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EINTR) {
+				klog.V(4).Infof("Failed to read NDP packet on %s: %v", l.iface.Name, err)
+			}
+			continue
+		}
+		// Frames to other hosts are only seen in promiscuous mode
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && (ll.Pkttype == unix.PACKET_OUTGOING || ll.Pkttype == unix.PACKET_OTHERHOST) {
+			continue
+		}
+		l.handle(buf[:n])
+	}
+}
+
+// handle answers a neighbor solicitation if it asks for an announced IP,
+// whether sent to its solicited-node multicast group or, to probe
+// reachability, to the IP itself
+func (l *ndpListener) handle(frame []byte) {
+	if len(frame) < ethHeaderLen+ipv6HeaderLen+ndpSolicitationLen {
+		return
+	}
+	header := frame[ethHeaderLen:]
+	msg := header[ipv6HeaderLen:]
+	// RFC 4861 requires solicitations to carry the maximum hop limit
+	if header[6] != unix.IPPROTO_ICMPV6 || header[7] != 255 ||
+		msg[0] != byte(ipv6.ICMPTypeNeighborSolicitation) || msg[1] != 0 {
+		return
+	}
+
+	var target [16]byte
+	copy(target[:], msg[8:24])
+
+	l.mu.RLock()
+	announced := l.ips[target]
+	l.mu.RUnlock()
+	if !announced {
+		return
+	}
+
+	if dst := net.IP(header[24:40]); !dst.Equal(net.IP(target[:])) && !dst.Equal(solicitedNodeMulticast(target)) {
+		return
+	}
+
+	// Solicitations from the unspecified address are duplicate address
+	// detection probes and must be answered to all nodes
+	src := net.IP(append([]byte(nil), header[8:24]...))
+	dst, solicited := src, true
+	if dst.IsUnspecified() {
+		dst, solicited = allNodesMulticast, false
+	}
+
+	klog.V(4).Infof("Answering neighbor solicitation for %s from %s on %s", net.IP(target[:]), src, l.iface.Name)
+	if err := l.advertise(target, dst, solicited); err != nil {
+		klog.Warningf("Failed to send neighbor advertisement for %s on %s: %v", net.IP(target[:]), l.iface.Name, err)
+	}
+}
+
+// advertise sends a neighbor advertisement claiming target for the interface MAC
+func (l *ndpListener) advertise(target [16]byte, dst net.IP, solicited bool) error {
+	body := make([]byte, 4+16+8)
+	body[0] = ndpFlagOverride
+	if solicited {
+		body[0] |= ndpFlagSolicited
+	}
+	copy(body[4:20], target[:])
+	body[20] = ndpOptionTargetLinkLayerAddress
+	body[21] = 1 // length in units of 8 bytes
+	copy(body[22:28], l.iface.HardwareAddr)
+
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborAdvertisement,
+		Body: &icmp.RawBody{Data: body},
+	}
+	// The kernel computes the checksum of raw ICMPv6 sockets
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("failed to marshal neighbor advertisement: %w", err)
+	}
+
+	_, err = l.pc.WriteTo(data, &ipv6.ControlMessage{
+		HopLimit: 255,
+		IfIndex:  l.iface.Index,
+	}, &net.IPAddr{IP: dst, Zone: l.iface.Name})
+	return err
+}
+
+// solicitedNodeMulticast returns the solicited-node multicast address of an IP
+func solicitedNodeMulticast(addr [16]byte) net.IP {
+	ip := net.ParseIP("ff02::1:ff00:0")
+	copy(ip[13:], addr[13:])
+	return ip
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package announcer
+
+import (
+	"fmt"
+	"net"
+)
+
+// NDPResponder is not supported on this platform
+type NDPResponder struct{}
+
+// NewNDPResponder creates an NDP responder
+func NewNDPResponder(interfaces []string) *NDPResponder {
+	return &NDPResponder{}
+}
+
+// SetAddresses always fails, NDP announcement requires Linux
+func (r *NDPResponder) SetAddresses(ips []net.IP) error {
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			return fmt.Errorf("NDP announcement is only supported on Linux")
+		}
+	}
+	return nil
+}
+
+// Close does nothing
+func (r *NDPResponder) Close() error {
+	return nil
+}
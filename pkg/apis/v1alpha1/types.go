@@ -0,0 +1,259 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the local-ccm.io/v1alpha1 API types. Objects are
+// handled as unstructured objects and converted to these types.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of local-ccm resources
+	GroupName = "local-ccm.io"
+	// Version is the API version of local-ccm resources
+	Version = "v1alpha1"
+)
+
+// IPAddressPoolResource is the resource of IPAddressPool objects
+var IPAddressPoolResource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "ipaddresspools"}
+
+// IPAddressPool is a cluster-scoped pool of LoadBalancer IPs
+type IPAddressPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPAddressPoolSpec   `json:"spec"`
+	Status IPAddressPoolStatus `json:"status,omitempty"`
+}
+
+// IPAddressPoolSpec defines the addresses of a pool
+type IPAddressPoolSpec struct {
+	// CIDRs are the address ranges of the pool
+	CIDRs []string `json:"cidrs"`
+	// AutoAssign allows allocating addresses to services not requesting this
+	// pool explicitly. Defaults to true.
+	AutoAssign *bool `json:"autoAssign,omitempty"`
+	// NodeSelectors restrict the nodes announcing the addresses of the pool.
+	// A node matching any selector is eligible. If empty, all nodes are.
+	NodeSelectors []metav1.LabelSelector `json:"nodeSelectors,omitempty"`
+}
+
+// IPAddressPoolStatus reports the usage of a pool
+type IPAddressPoolStatus struct {
+	// Allocated is the number of allocated addresses
+	Allocated int64 `json:"allocated"`
+	// Free is the number of addresses left, capped for large IPv6 pools
+	Free int64 `json:"free"`
+}
+
+// LocalCCMConfigResource is the resource of LocalCCMConfig objects
+var LocalCCMConfigResource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "localccmconfigs"}
+
+// LocalCCMConfig is a cluster-scoped configuration watched by the agents,
+// overriding their command-line flags
+type LocalCCMConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LocalCCMConfigSpec   `json:"spec"`
+	Status LocalCCMConfigStatus `json:"status,omitempty"`
+}
+
+// LocalCCMConfigSpec defines the configuration of the agents. Unset fields
+// keep the value of the command-line flags.
+type LocalCCMConfigSpec struct {
+	// Detection configures the address detection
+	Detection *DetectionSpec `json:"detection,omitempty"`
+	// RemoveTaint removes the node.cloudprovider.kubernetes.io/uninitialized taint
+	RemoveTaint *bool `json:"removeTaint,omitempty"`
+	// ExcludeFromLoadBalancers manages the
+	// node.kubernetes.io/exclude-from-external-load-balancers label: "auto",
+	// "always" or "never"
+	ExcludeFromLoadBalancers string `json:"excludeFromLoadBalancers,omitempty"`
+	// Labels configures the managed labels
+	Labels *LabelsSpec `json:"labels,omitempty"`
+}
+
+// DetectionSpec configures how addresses are detected
+type DetectionSpec struct {
+	// Detector is "route", "udp", "interface:<name>", "default-interface",
+	// "talos[:<path>]", "kubevirt[:<interface>]" or "static:..."
+	Detector string `json:"detector,omitempty"`
+	// InternalIPTarget is the target IP of internal IP detection
+	InternalIPTarget string `json:"internalIPTarget,omitempty"`
+	// ExternalIPTarget is the target IP of external IP detection
+	ExternalIPTarget string `json:"externalIPTarget,omitempty"`
+	// EgressIPTarget is the target IP of egress IP detection
+	EgressIPTarget string `json:"egressIPTarget,omitempty"`
+}
+
+// LabelsSpec configures the managed labels
+type LabelsSpec struct {
+	// PublicIP labels the nodes with local-ccm.io/has-public-ip
+	PublicIP *bool `json:"publicIP,omitempty"`
+}
+
+// LocalCCMConfigStatus reports the rollout of the configuration
+type LocalCCMConfigStatus struct {
+	// ObservedGeneration is the generation the status was computed for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// UpdatedNodes is the number of nodes that applied the current generation
+	UpdatedNodes int32 `json:"updatedNodes"`
+	// Generations are the number of nodes per applied generation
+	Generations []GenerationStatus `json:"generations,omitempty"`
+}
+
+// GenerationStatus is the number of nodes that applied a generation
+type GenerationStatus struct {
+	// Generation is the applied generation of the config
+	Generation int64 `json:"generation"`
+	// Nodes is the number of nodes that applied it last
+	Nodes int32 `json:"nodes"`
+}
+
+// NodeNetworkStatusResource is the resource of NodeNetworkStatus objects
+var NodeNetworkStatusResource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "nodenetworkstatuses"}
+
+const (
+	// ConditionDetectionFailed is true while the detection of an address of
+	// the node fails
+	ConditionDetectionFailed = "DetectionFailed"
+	// ConditionPatchConflict is true while writing the node conflicts with
+	// other writers beyond the retries
+	ConditionPatchConflict = "PatchConflict"
+	// ConditionUnauthorized is true while the API server rejects requests of
+	// the agent as unauthenticated or forbidden
+	ConditionUnauthorized = "Unauthorized"
+)
+
+// NodeNetworkStatus reports the network state of a node as seen by its agent.
+// It is cluster-scoped, named after its node and deleted along with it.
+type NodeNetworkStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status NodeNetworkStatusStatus `json:"status,omitempty"`
+}
+
+// NodeNetworkStatusStatus is the network state of a node
+type NodeNetworkStatusStatus struct {
+	// Detections are the outcomes of the address detections
+	Detections []DetectionStatus `json:"detections,omitempty"`
+	// BehindNAT is set if the node is reachable through NAT only
+	BehindNAT bool `json:"behindNAT"`
+	// Interfaces are the network interfaces of the host
+	Interfaces []InterfaceStatus `json:"interfaces,omitempty"`
+	// Routes are the default routes of the host
+	Routes []RouteStatus `json:"routes,omitempty"`
+	// Errors are the errors of the last reconciliation
+	Errors []string `json:"errors,omitempty"`
+	// Conditions classify the errors of the last reconciliation, one of
+	// each condition type, with the time their status last changed
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastUpdateTime is the time the status last changed
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// DetectionStatus is the outcome of detecting one address
+type DetectionStatus struct {
+	// Type is the detected address: "InternalIP", "ExternalIP" or "EgressIP"
+	Type      string `json:"type"`
+	Strategy  string `json:"strategy"`
+	Target    string `json:"target,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Gateway   string `json:"gateway,omitempty"`
+	// Address is the picked address, empty if none
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Degraded tells why the address is not trusted, e.g. as the target
+	// did not answer a probe
+	Degraded string `json:"degraded,omitempty"`
+	// Candidates are the addresses that were not picked
+	Candidates []CandidateStatus `json:"candidates,omitempty"`
+}
+
+// CandidateStatus is an address that was considered but not picked
+type CandidateStatus struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+// InterfaceStatus is a network interface of the host
+type InterfaceStatus struct {
+	Name         string   `json:"name"`
+	Up           bool     `json:"up"`
+	MTU          int32    `json:"mtu,omitempty"`
+	HardwareAddr string   `json:"hardwareAddr,omitempty"`
+	Addresses    []string `json:"addresses,omitempty"`
+}
+
+// RouteStatus is a route of the host
+type RouteStatus struct {
+	Destination string `json:"destination"`
+	Gateway     string `json:"gateway,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Source      string `json:"source,omitempty"`
+}
+
+// NodeInventoryResource is the resource of NodeInventory objects
+var NodeInventoryResource = schema.GroupVersionResource{Group: GroupName, Version: Version, Resource: "nodeinventories"}
+
+// ConditionNodeSynced is true while the inventory controller publishes the
+// reported addresses on the node
+const ConditionNodeSynced = "NodeSynced"
+
+// NodeInventory is a node of another cluster, e.g. a tenant cluster, whose
+// agent reports the detected addresses into the inventory instead of
+// publishing them. The inventory controller publishes them on the node. It
+// is namespaced, next to the kubeconfig secret of the cluster of the node.
+type NodeInventory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeInventorySpec   `json:"spec"`
+	Status NodeInventoryStatus `json:"status,omitempty"`
+}
+
+// NodeInventorySpec locates the node of an inventory
+type NodeInventorySpec struct {
+	// NodeName is the name of the Node in its cluster
+	NodeName string `json:"nodeName"`
+	// KubeconfigSecretRef is the Secret in the namespace of the inventory
+	// holding the kubeconfig of the cluster of the node
+	KubeconfigSecretRef SecretKeyReference `json:"kubeconfigSecretRef"`
+}
+
+// SecretKeyReference selects a key of a Secret in the same namespace
+type SecretKeyReference struct {
+	Name string `json:"name"`
+	// Key defaults to "value", as used by Cluster API and Kamaji
+	Key string `json:"key,omitempty"`
+}
+
+// NodeInventoryStatus holds the report of the agent and the conditions set
+// by the inventory controller
+type NodeInventoryStatus struct {
+	// Detections are the outcomes of the detections of the agent, one per
+	// detection target
+	Detections []DetectionStatus `json:"detections,omitempty"`
+	// ReportTime is the time the agent last reported
+	ReportTime metav1.Time `json:"reportTime,omitempty"`
+	// Conditions are set by the inventory controller
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Message types, see RFC 4271
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+)
+
+const (
+	headerLen     = 19
+	maxMessageLen = 4096
+
+	bgpVersion = 4
+	// asTrans replaces 4-octet ASNs towards peers without 4-octet AS support
+	asTrans = 23456
+
+	paramCapabilities = 2
+	capMultiprotocol  = 1
+	capFourOctetAS    = 65
+
+	afiIPv4     = 1
+	afiIPv6     = 2
+	safiUnicast = 1
+
+	attrOrigin    = 1
+	attrASPath    = 2
+	attrNextHop   = 3
+	attrLocalPref = 5
+	attrMPReach   = 14
+	attrMPUnreach = 15
+
+	attrFlagOptional       = 0x80
+	attrFlagTransitive     = 0x40
+	attrFlagExtendedLength = 0x10
+
+	originIGP  = 0
+	asSequence = 2
+
+	defaultLocalPref = 100
+)
+
+// Notification error codes and subcodes
+const (
+	errOpenMessage        = 2
+	errOpenBadPeerAS      = 2
+	errOpenBadHoldTime    = 6
+	errHoldTimerExpired   = 4
+	errCease              = 6
+	errCeaseAdminShutdown = 2
+)
+
+// openMessage holds the fields of a received OPEN message
+type openMessage struct {
+	asn         uint32
+	holdTime    uint16
+	routerID    net.IP
+	fourOctetAS bool
+}
+
+// routeAttributes holds the session properties determining path attributes
+type routeAttributes struct {
+	localASN    uint32
+	ebgp        bool
+	fourOctetAS bool
+	nextHop     net.IP
+}
+
+// readMessage reads a single message, returning its type and body
+func readMessage(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	for _, b := range header[:16] {
+		if b != 0xff {
+			return 0, nil, fmt.Errorf("invalid message marker")
+		}
+	}
+	length := int(binary.BigEndian.Uint16(header[16:18]))
+	if length < headerLen || length > maxMessageLen {
+		return 0, nil, fmt.Errorf("invalid message length %d", length)
+	}
+
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[18], body, nil
+}
+
+func marshalMessage(typ byte, body []byte) []byte {
+	msg := make([]byte, headerLen, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:18], uint16(headerLen+len(body)))
+	msg[18] = typ
+	return append(msg, body...)
+}
+
+func marshalKeepalive() []byte {
+	return marshalMessage(msgKeepalive, nil)
+}
+
+func marshalNotification(code, subcode byte) []byte {
+	return marshalMessage(msgNotification, []byte{code, subcode})
+}
+
+// marshalOpen builds an OPEN message announcing the multiprotocol capability
+// for the given address family and 4-octet AS support
+func marshalOpen(asn uint32, holdTime uint16, routerID net.IP, afi uint16) []byte {
+	var caps bytes.Buffer
+	caps.Write([]byte{capMultiprotocol, 4})
+	_ = binary.Write(&caps, binary.BigEndian, afi)
+	caps.Write([]byte{0, safiUnicast})
+	caps.Write([]byte{capFourOctetAS, 4})
+	_ = binary.Write(&caps, binary.BigEndian, asn)
+
+	var body bytes.Buffer
+	body.WriteByte(bgpVersion)
+	_ = binary.Write(&body, binary.BigEndian, uint16(twoOctetAS(asn)))
+	_ = binary.Write(&body, binary.BigEndian, holdTime)
+	body.Write(routerID.To4())
+	body.WriteByte(byte(2 + caps.Len()))
+	body.Write([]byte{paramCapabilities, byte(caps.Len())})
+	body.Write(caps.Bytes())
+
+	return marshalMessage(msgOpen, body.Bytes())
+}
+
+func parseOpen(body []byte) (*openMessage, error) {
+	if len(body) < 10 {
+		return nil, fmt.Errorf("OPEN message too short")
+	}
+	if body[0] != bgpVersion {
+		return nil, fmt.Errorf("unsupported BGP version %d", body[0])
+	}
+
+	open := &openMessage{
+		asn:      uint32(binary.BigEndian.Uint16(body[1:3])),
+		holdTime: binary.BigEndian.Uint16(body[3:5]),
+		routerID: net.IP(append([]byte(nil), body[5:9]...)),
+	}
+
+	params := body[10:]
+	if len(params) != int(body[9]) {
+		return nil, fmt.Errorf("invalid OPEN optional parameters length")
+	}
+	for len(params) >= 2 {
+		typ, length := params[0], int(params[1])
+		if len(params) < 2+length {
+			return nil, fmt.Errorf("truncated OPEN optional parameter")
+		}
+		value := params[2 : 2+length]
+		params = params[2+length:]
+		if typ != paramCapabilities {
+			continue
+		}
+		for len(value) >= 2 {
+			code, capLength := value[0], int(value[1])
+			if len(value) < 2+capLength {
+				return nil, fmt.Errorf("truncated OPEN capability")
+			}
+			if code == capFourOctetAS && capLength == 4 {
+				open.asn = binary.BigEndian.Uint32(value[2:6])
+				open.fourOctetAS = true
+			}
+			value = value[2+capLength:]
+		}
+	}
+
+	return open, nil
+}
+
+// marshalUpdate builds an UPDATE message advertising or withdrawing a single
+// prefix. IPv6 prefixes are carried in the multiprotocol attributes.
+func marshalUpdate(prefix *net.IPNet, withdraw bool, route routeAttributes) []byte {
+	nlri := marshalPrefix(prefix)
+	ipv4 := prefix.IP.To4() != nil
+
+	var withdrawn, attrs bytes.Buffer
+	switch {
+	case withdraw && ipv4:
+		withdrawn.Write(nlri)
+	case withdraw:
+		var value bytes.Buffer
+		_ = binary.Write(&value, binary.BigEndian, uint16(afiIPv6))
+		value.WriteByte(safiUnicast)
+		value.Write(nlri)
+		writeAttribute(&attrs, attrFlagOptional, attrMPUnreach, value.Bytes())
+	default:
+		writeAttribute(&attrs, attrFlagTransitive, attrOrigin, []byte{originIGP})
+		writeAttribute(&attrs, attrFlagTransitive, attrASPath, marshalASPath(route))
+		if ipv4 {
+			writeAttribute(&attrs, attrFlagTransitive, attrNextHop, route.nextHop.To4())
+		}
+		if !route.ebgp {
+			localPref := make([]byte, 4)
+			binary.BigEndian.PutUint32(localPref, defaultLocalPref)
+			writeAttribute(&attrs, attrFlagTransitive, attrLocalPref, localPref)
+		}
+		if !ipv4 {
+			var value bytes.Buffer
+			_ = binary.Write(&value, binary.BigEndian, uint16(afiIPv6))
+			value.WriteByte(safiUnicast)
+			value.WriteByte(net.IPv6len)
+			value.Write(route.nextHop.To16())
+			value.WriteByte(0)
+			value.Write(nlri)
+			writeAttribute(&attrs, attrFlagOptional, attrMPReach, value.Bytes())
+		}
+	}
+
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, uint16(withdrawn.Len()))
+	body.Write(withdrawn.Bytes())
+	_ = binary.Write(&body, binary.BigEndian, uint16(attrs.Len()))
+	body.Write(attrs.Bytes())
+	if !withdraw && ipv4 {
+		body.Write(nlri)
+	}
+
+	return marshalMessage(msgUpdate, body.Bytes())
+}
+
+// marshalASPath returns the AS_PATH of locally originated routes, which
+// holds the local ASN towards external peers and is empty otherwise
+func marshalASPath(route routeAttributes) []byte {
+	if !route.ebgp {
+		return nil
+	}
+	if route.fourOctetAS {
+		path := []byte{asSequence, 1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(path[2:], route.localASN)
+		return path
+	}
+	path := []byte{asSequence, 1, 0, 0}
+	binary.BigEndian.PutUint16(path[2:], uint16(twoOctetAS(route.localASN)))
+	return path
+}
+
+func writeAttribute(buf *bytes.Buffer, flags, code byte, value []byte) {
+	if len(value) > 255 {
+		buf.Write([]byte{flags | attrFlagExtendedLength, code})
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	} else {
+		buf.Write([]byte{flags, code, byte(len(value))})
+	}
+	buf.Write(value)
+}
+
+func marshalPrefix(prefix *net.IPNet) []byte {
+	ones, _ := prefix.Mask.Size()
+	ip := prefix.IP.To4()
+	if ip == nil {
+		ip = prefix.IP.To16()
+	}
+	return append([]byte{byte(ones)}, ip[:(ones+7)/8]...)
+}
+
+func twoOctetAS(asn uint32) uint32 {
+	if asn > 0xffff {
+		return asTrans
+	}
+	return asn
+}
@@ -0,0 +1,315 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// IPv6 addresses of the tests, in wire format
+var (
+	v6Host    = []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}
+	v6NextHop = []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfe}
+)
+
+// concat joins byte slices
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+// testMessage prepends the header of a message of the given type to body
+func testMessage(typ byte, body []byte) []byte {
+	length := headerLen + len(body)
+	return concat(bytes.Repeat([]byte{0xff}, 16), []byte{byte(length >> 8), byte(length), typ}, body)
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prefix
+}
+
+func TestMarshalKeepalive(t *testing.T) {
+	want := []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x13, msgKeepalive,
+	}
+	if got := marshalKeepalive(); !bytes.Equal(got, want) {
+		t.Errorf("KEEPALIVE = % x, want % x", got, want)
+	}
+}
+
+func TestMarshalOpen(t *testing.T) {
+	routerID := net.ParseIP("192.0.2.1")
+	tests := []struct {
+		name string
+		asn  uint32
+		afi  uint16
+		want []byte
+	}{
+		{
+			name: "2-octet ASN, IPv4",
+			asn:  65001,
+			afi:  afiIPv4,
+			want: []byte{
+				4, 0xfd, 0xe9, 0, 90, 192, 0, 2, 1,
+				14, paramCapabilities, 12,
+				capMultiprotocol, 4, 0, 1, 0, 1,
+				capFourOctetAS, 4, 0, 0, 0xfd, 0xe9,
+			},
+		},
+		{
+			name: "4-octet ASN as AS_TRANS, IPv6",
+			asn:  4200000000,
+			afi:  afiIPv6,
+			want: []byte{
+				4, 0x5b, 0xa0, 0, 90, 192, 0, 2, 1,
+				14, paramCapabilities, 12,
+				capMultiprotocol, 4, 0, 2, 0, 1,
+				capFourOctetAS, 4, 0xfa, 0x56, 0xea, 0x00,
+			},
+		},
+	}
+	for _, tc := range tests {
+		got := marshalOpen(tc.asn, 90, routerID, tc.afi)
+		if want := testMessage(msgOpen, tc.want); !bytes.Equal(got, want) {
+			t.Errorf("%s: OPEN = % x, want % x", tc.name, got, want)
+		}
+	}
+}
+
+func TestParseOpen(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		asn         uint32
+		holdTime    uint16
+		fourOctetAS bool
+	}{
+		{
+			name:        "own OPEN",
+			body:        marshalOpen(4200000000, 90, net.ParseIP("192.0.2.1"), afiIPv4)[headerLen:],
+			asn:         4200000000,
+			holdTime:    90,
+			fourOctetAS: true,
+		},
+		{
+			name:     "without capabilities",
+			body:     []byte{4, 0xfd, 0xea, 0, 180, 198, 51, 100, 1, 0},
+			asn:      65002,
+			holdTime: 180,
+		},
+		{
+			name: "unknown parameter and capability skipped",
+			body: []byte{
+				4, 0xfd, 0xea, 0, 3, 198, 51, 100, 1,
+				13, 1, 1, 0xaa,
+				paramCapabilities, 8, 2, 0, capFourOctetAS, 4, 0, 0, 0xfd, 0xea,
+			},
+			asn:         65002,
+			holdTime:    3,
+			fourOctetAS: true,
+		},
+	}
+	for _, tc := range tests {
+		open, err := parseOpen(tc.body)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if open.asn != tc.asn || open.holdTime != tc.holdTime || open.fourOctetAS != tc.fourOctetAS {
+			t.Errorf("%s: got %+v, want ASN %d, hold time %d, 4-octet AS %v", tc.name, open, tc.asn, tc.holdTime, tc.fourOctetAS)
+		}
+	}
+}
+
+func TestParseOpenInvalid(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"too short":                 {4, 0xfd, 0xea, 0, 90},
+		"unsupported version":       {3, 0xfd, 0xea, 0, 90, 198, 51, 100, 1, 0},
+		"parameters length":         {4, 0xfd, 0xea, 0, 90, 198, 51, 100, 1, 4, 2, 0},
+		"truncated parameter":       {4, 0xfd, 0xea, 0, 90, 198, 51, 100, 1, 3, 2, 6, 65},
+		"truncated capability":      {4, 0xfd, 0xea, 0, 90, 198, 51, 100, 1, 4, 2, 2, 65, 4},
+		"truncated 4-octet AS data": {4, 0xfd, 0xea, 0, 90, 198, 51, 100, 1, 6, 2, 4, 65, 4, 0, 0},
+	} {
+		if _, err := parseOpen(body); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMarshalPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []byte
+	}{
+		{"198.51.100.1/32", []byte{32, 198, 51, 100, 1}},
+		{"10.0.0.0/8", []byte{8, 10}},
+		{"10.2.0.0/15", []byte{15, 10, 2}},
+		{"0.0.0.0/0", []byte{0}},
+		{"2001:db8::1/128", concat([]byte{128}, v6Host)},
+		{"2001:db8::/32", []byte{32, 0x20, 0x01, 0x0d, 0xb8}},
+		{"2001:db8::/33", []byte{33, 0x20, 0x01, 0x0d, 0xb8, 0}},
+	}
+	for _, tc := range tests {
+		if got := marshalPrefix(mustParseCIDR(t, tc.prefix)); !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: prefix = % x, want % x", tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestMarshalUpdate(t *testing.T) {
+	v4 := mustParseCIDR(t, "198.51.100.1/32")
+	v6 := mustParseCIDR(t, "2001:db8::1/128")
+	v4NextHop := net.ParseIP("192.0.2.254")
+	v6Route := routeAttributes{localASN: 65001, ebgp: true, fourOctetAS: true, nextHop: net.ParseIP("2001:db8::fe")}
+
+	origin := []byte{attrFlagTransitive, attrOrigin, 1, originIGP}
+	nextHop := []byte{attrFlagTransitive, attrNextHop, 4, 192, 0, 2, 254}
+	v4NLRI := []byte{32, 198, 51, 100, 1}
+	v6NLRI := concat([]byte{128}, v6Host)
+
+	tests := []struct {
+		name     string
+		prefix   *net.IPNet
+		withdraw bool
+		route    routeAttributes
+		want     []byte
+	}{
+		{
+			name:   "IPv4 to 2-octet eBGP peer",
+			prefix: v4,
+			route:  routeAttributes{localASN: 65001, ebgp: true, nextHop: v4NextHop},
+			want: concat(
+				[]byte{0, 0, 0, 18},
+				origin,
+				[]byte{attrFlagTransitive, attrASPath, 4, asSequence, 1, 0xfd, 0xe9},
+				nextHop,
+				v4NLRI,
+			),
+		},
+		{
+			name:   "IPv4 with 4-octet ASN to 4-octet eBGP peer",
+			prefix: v4,
+			route:  routeAttributes{localASN: 4200000000, ebgp: true, fourOctetAS: true, nextHop: v4NextHop},
+			want: concat(
+				[]byte{0, 0, 0, 20},
+				origin,
+				[]byte{attrFlagTransitive, attrASPath, 6, asSequence, 1, 0xfa, 0x56, 0xea, 0x00},
+				nextHop,
+				v4NLRI,
+			),
+		},
+		{
+			name:   "IPv4 with 4-octet ASN as AS_TRANS to 2-octet eBGP peer",
+			prefix: v4,
+			route:  routeAttributes{localASN: 4200000000, ebgp: true, nextHop: v4NextHop},
+			want: concat(
+				[]byte{0, 0, 0, 18},
+				origin,
+				[]byte{attrFlagTransitive, attrASPath, 4, asSequence, 1, 0x5b, 0xa0},
+				nextHop,
+				v4NLRI,
+			),
+		},
+		{
+			name:   "IPv4 to iBGP peer",
+			prefix: v4,
+			route:  routeAttributes{localASN: 65001, nextHop: v4NextHop},
+			want: concat(
+				[]byte{0, 0, 0, 21},
+				origin,
+				[]byte{attrFlagTransitive, attrASPath, 0},
+				nextHop,
+				[]byte{attrFlagTransitive, attrLocalPref, 4, 0, 0, 0, defaultLocalPref},
+				v4NLRI,
+			),
+		},
+		{
+			name:     "IPv4 withdrawal",
+			prefix:   v4,
+			withdraw: true,
+			route:    routeAttributes{localASN: 65001, ebgp: true, nextHop: v4NextHop},
+			want:     concat([]byte{0, 5}, v4NLRI, []byte{0, 0}),
+		},
+		{
+			name:   "IPv6 in MP_REACH_NLRI",
+			prefix: v6,
+			route:  v6Route,
+			want: concat(
+				[]byte{0, 0, 0, 54},
+				origin,
+				[]byte{attrFlagTransitive, attrASPath, 6, asSequence, 1, 0, 0, 0xfd, 0xe9},
+				[]byte{attrFlagOptional, attrMPReach, 38, 0, afiIPv6, safiUnicast, 16},
+				v6NextHop,
+				[]byte{0},
+				v6NLRI,
+			),
+		},
+		{
+			name:     "IPv6 withdrawal in MP_UNREACH_NLRI",
+			prefix:   v6,
+			withdraw: true,
+			route:    v6Route,
+			want: concat(
+				[]byte{0, 0, 0, 23},
+				[]byte{attrFlagOptional, attrMPUnreach, 20, 0, afiIPv6, safiUnicast},
+				v6NLRI,
+			),
+		},
+	}
+	for _, tc := range tests {
+		got := marshalUpdate(tc.prefix, tc.withdraw, tc.route)
+		if want := testMessage(msgUpdate, tc.want); !bytes.Equal(got, want) {
+			t.Errorf("%s: UPDATE = % x, want % x", tc.name, got, want)
+		}
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	typ, body, err := readMessage(bytes.NewReader(marshalNotification(errCease, errCeaseAdminShutdown)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != msgNotification || !bytes.Equal(body, []byte{errCease, errCeaseAdminShutdown}) {
+		t.Errorf("got type %d body % x", typ, body)
+	}
+
+	badMarker := marshalKeepalive()
+	badMarker[0] = 0
+	badLength := marshalKeepalive()
+	badLength[17] = headerLen - 1
+	for name, msg := range map[string][]byte{
+		"marker":    badMarker,
+		"length":    badLength,
+		"truncated": testMessage(msgUpdate, []byte{0, 0, 0, 0})[:headerLen+2],
+	} {
+		if _, _, err := readMessage(bytes.NewReader(msg)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
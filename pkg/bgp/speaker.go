@@ -0,0 +1,388 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultPort     = 179
+	defaultHoldTime = 90 * time.Second
+
+	connectTimeout = 10 * time.Second
+	retryInterval  = 5 * time.Second
+	// openHoldTime bounds the wait for the OPEN and KEEPALIVE of the peer
+	openHoldTime = 4 * time.Minute
+)
+
+var errHoldTimeExpired = errors.New("hold timer expired")
+
+// Config configures the speaker
+type Config struct {
+	// ASN is the local autonomous system number
+	ASN uint32
+	// RouterID is the IPv4 BGP identifier of the speaker
+	RouterID net.IP
+	// Peers are the routers to advertise to
+	Peers []Peer
+}
+
+// Peer configures a single BGP session
+type Peer struct {
+	Address  net.IP
+	ASN      uint32
+	Port     int
+	HoldTime time.Duration
+}
+
+// Speaker advertises host routes for a set of IPs to its peers. It only
+// originates routes and ignores the routes received from peers. Each session
+// carries the address family of its transport, the local address of the
+// session is used as next hop.
+type Speaker struct {
+	config Config
+
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	sessions []*session
+}
+
+// NewSpeaker creates a speaker and starts connecting to its peers
+func NewSpeaker(config Config) (*Speaker, error) {
+	if config.RouterID.To4() == nil {
+		return nil, fmt.Errorf("router ID %s is not an IPv4 address", config.RouterID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Speaker{
+		config: config,
+		cancel: cancel,
+	}
+
+	for _, peer := range config.Peers {
+		if peer.Port == 0 {
+			peer.Port = defaultPort
+		}
+		if peer.HoldTime == 0 {
+			peer.HoldTime = defaultHoldTime
+		}
+		sess := &session{
+			local:    config,
+			peer:     peer,
+			prefixes: make(map[string]*net.IPNet),
+			changed:  make(chan struct{}, 1),
+		}
+		s.sessions = append(s.sessions, sess)
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			sess.run(ctx)
+		}()
+	}
+
+	return s, nil
+}
+
+// SetAddresses replaces the set of advertised IPs
+func (s *Speaker) SetAddresses(ips []net.IP) error {
+	prefixes := make(map[string]*net.IPNet, len(ips))
+	for _, ip := range ips {
+		prefix := hostPrefix(ip)
+		prefixes[prefix.String()] = prefix
+	}
+
+	for _, sess := range s.sessions {
+		sess.setPrefixes(prefixes)
+	}
+	return nil
+}
+
+// Close shuts down all sessions, withdrawing the advertised routes
+func (s *Speaker) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// session maintains the connection to a single peer
+type session struct {
+	local Config
+	peer  Peer
+
+	mu       sync.Mutex
+	prefixes map[string]*net.IPNet
+	changed  chan struct{}
+}
+
+func (s *session) setPrefixes(prefixes map[string]*net.IPNet) {
+	s.mu.Lock()
+	s.prefixes = prefixes
+	s.mu.Unlock()
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) desired() map[string]*net.IPNet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prefixes
+}
+
+func (s *session) address() string {
+	return net.JoinHostPort(s.peer.Address.String(), strconv.Itoa(s.peer.Port))
+}
+
+// run keeps the session established until ctx is done
+func (s *session) run(ctx context.Context) {
+	for {
+		if err := s.connect(ctx); err != nil && ctx.Err() == nil {
+			klog.Errorf("BGP session with %s failed: %v", s.address(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// connect establishes the session and advertises the desired prefixes until
+// the session fails or ctx is done
+func (s *session) connect(ctx context.Context) error {
+	klog.V(2).Infof("Connecting to BGP peer %s", s.address())
+
+	dialer := net.Dialer{Timeout: connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address())
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock reads when the speaker is closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	afi := uint16(afiIPv4)
+	if s.peer.Address.To4() == nil {
+		afi = afiIPv6
+	}
+	proposedHoldTime := uint16(s.peer.HoldTime / time.Second)
+
+	if _, err := conn.Write(marshalOpen(s.local.ASN, proposedHoldTime, s.local.RouterID, afi)); err != nil {
+		return fmt.Errorf("failed to send OPEN: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(openHoldTime))
+	typ, body, err := readMessage(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read OPEN: %w", err)
+	}
+	if typ != msgOpen {
+		return fmt.Errorf("expected OPEN, got message type %d", typ)
+	}
+	open, err := parseOpen(body)
+	if err != nil {
+		return err
+	}
+	if open.asn != s.peer.ASN {
+		conn.Write(marshalNotification(errOpenMessage, errOpenBadPeerAS))
+		return fmt.Errorf("peer has ASN %d, expected %d", open.asn, s.peer.ASN)
+	}
+	if open.holdTime == 1 || open.holdTime == 2 {
+		conn.Write(marshalNotification(errOpenMessage, errOpenBadHoldTime))
+		return fmt.Errorf("peer proposed invalid hold time %ds", open.holdTime)
+	}
+
+	holdTime := time.Duration(min(open.holdTime, proposedHoldTime)) * time.Second
+
+	if _, err := conn.Write(marshalKeepalive()); err != nil {
+		return fmt.Errorf("failed to send KEEPALIVE: %w", err)
+	}
+
+	typ, _, err = readMessage(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read KEEPALIVE: %w", err)
+	}
+	if typ != msgKeepalive {
+		return fmt.Errorf("expected KEEPALIVE, got message type %d", typ)
+	}
+
+	klog.Infof("BGP session with %s (AS %d, router ID %s) established", s.address(), open.asn, open.routerID)
+
+	localAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unexpected local address %s", conn.LocalAddr())
+	}
+	route := routeAttributes{
+		localASN:    s.local.ASN,
+		ebgp:        s.local.ASN != s.peer.ASN,
+		fourOctetAS: open.fourOctetAS,
+		nextHop:     localAddr.IP,
+	}
+
+	// Read messages in the background to detect expired hold timers and
+	// notifications of the peer
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.receive(conn, holdTime)
+	}()
+
+	var keepalive <-chan time.Time
+	if holdTime > 0 {
+		ticker := time.NewTicker(holdTime / 3)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	advertised := make(map[string]*net.IPNet)
+	if err := s.sync(conn, route, advertised); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.shutdown(conn, route, advertised)
+			return nil
+		case err := <-readErr:
+			if ctx.Err() != nil {
+				// The read was interrupted by the shutdown
+				s.shutdown(conn, route, advertised)
+				return nil
+			}
+			if errors.Is(err, errHoldTimeExpired) {
+				conn.Write(marshalNotification(errHoldTimerExpired, 0))
+			}
+			return err
+		case <-keepalive:
+			if _, err := conn.Write(marshalKeepalive()); err != nil {
+				return fmt.Errorf("failed to send KEEPALIVE: %w", err)
+			}
+		case <-s.changed:
+			if err := s.sync(conn, route, advertised); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// shutdown withdraws all routes and closes the session gracefully
+func (s *session) shutdown(conn net.Conn, route routeAttributes, advertised map[string]*net.IPNet) {
+	conn.SetReadDeadline(time.Time{})
+	if err := s.advertise(conn, route, advertised, nil); err != nil {
+		klog.V(2).Infof("Failed to withdraw routes from %s: %v", s.address(), err)
+	}
+	conn.Write(marshalNotification(errCease, errCeaseAdminShutdown))
+	klog.Infof("BGP session with %s closed", s.address())
+}
+
+// receive reads messages until the session fails
+func (s *session) receive(conn net.Conn, holdTime time.Duration) error {
+	for {
+		if holdTime > 0 {
+			conn.SetReadDeadline(time.Now().Add(holdTime))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return errHoldTimeExpired
+			}
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+
+		switch typ {
+		case msgNotification:
+			if len(body) >= 2 {
+				return fmt.Errorf("peer sent NOTIFICATION %d/%d", body[0], body[1])
+			}
+			return fmt.Errorf("peer sent NOTIFICATION")
+		case msgKeepalive, msgUpdate:
+			// Received routes are ignored
+		default:
+			return fmt.Errorf("unexpected message type %d", typ)
+		}
+	}
+}
+
+// sync advertises the desired prefixes of the session address family
+func (s *session) sync(conn net.Conn, route routeAttributes, advertised map[string]*net.IPNet) error {
+	desired := make(map[string]*net.IPNet)
+	for key, prefix := range s.desired() {
+		if (prefix.IP.To4() != nil) == (route.nextHop.To4() != nil) {
+			desired[key] = prefix
+		}
+	}
+	return s.advertise(conn, route, advertised, desired)
+}
+
+// advertise sends the updates turning advertised into desired
+func (s *session) advertise(conn net.Conn, route routeAttributes, advertised, desired map[string]*net.IPNet) error {
+	for key, prefix := range advertised {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if _, err := conn.Write(marshalUpdate(prefix, true, route)); err != nil {
+			return fmt.Errorf("failed to withdraw %s: %w", key, err)
+		}
+		delete(advertised, key)
+		klog.Infof("Withdrew %s from BGP peer %s", key, s.address())
+	}
+
+	for key, prefix := range desired {
+		if _, ok := advertised[key]; ok {
+			continue
+		}
+		if _, err := conn.Write(marshalUpdate(prefix, false, route)); err != nil {
+			return fmt.Errorf("failed to advertise %s: %w", key, err)
+		}
+		advertised[key] = prefix
+		klog.Infof("Advertised %s to BGP peer %s", key, s.address())
+	}
+
+	return nil
+}
+
+func hostPrefix(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+}
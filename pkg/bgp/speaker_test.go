@@ -0,0 +1,155 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakePeer is the router end of a single session
+type fakePeer struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// startPeer listens on the loopback address and starts a speaker of AS
+// 65001 connecting to it as peer of the given AS
+func startPeer(t *testing.T, peerASN uint32) (*Speaker, *fakePeer) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	speaker, err := NewSpeaker(Config{
+		ASN:      65001,
+		RouterID: net.ParseIP("192.0.2.1"),
+		Peers:    []Peer{{Address: addr.IP, ASN: peerASN, Port: addr.Port}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { speaker.Close() })
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return speaker, &fakePeer{t: t, conn: conn}
+}
+
+// read returns the next message other than a KEEPALIVE
+func (p *fakePeer) read() (byte, []byte) {
+	p.t.Helper()
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		typ, body, err := readMessage(p.conn)
+		if err != nil {
+			p.t.Fatalf("failed to read message: %v", err)
+		}
+		if typ != msgKeepalive {
+			return typ, body
+		}
+	}
+}
+
+func (p *fakePeer) write(msg []byte) {
+	p.t.Helper()
+	if _, err := p.conn.Write(msg); err != nil {
+		p.t.Fatalf("failed to write message: %v", err)
+	}
+}
+
+// expect reads the next message other than a KEEPALIVE and compares it
+func (p *fakePeer) expect(what string, want []byte) {
+	p.t.Helper()
+	typ, body := p.read()
+	if got := marshalMessage(typ, body); !bytes.Equal(got, want) {
+		p.t.Fatalf("%s = % x, want % x", what, got, want)
+	}
+}
+
+// establish exchanges OPEN and KEEPALIVE as the given AS
+func (p *fakePeer) establish(asn uint32) {
+	p.t.Helper()
+	p.expect("OPEN", marshalOpen(65001, 90, net.ParseIP("192.0.2.1"), afiIPv4))
+	p.write(marshalOpen(asn, 90, net.ParseIP("198.51.100.1"), afiIPv4))
+	p.write(marshalKeepalive())
+	p.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if typ, _, err := readMessage(p.conn); err != nil || typ != msgKeepalive {
+		p.t.Fatalf("expected KEEPALIVE, got message type %d: %v", typ, err)
+	}
+}
+
+func TestSessionAdvertise(t *testing.T) {
+	speaker, peer := startPeer(t, 65002)
+	peer.establish(65002)
+
+	prefix := mustParseCIDR(t, "192.0.2.10/32")
+	route := routeAttributes{localASN: 65001, ebgp: true, fourOctetAS: true, nextHop: net.ParseIP("127.0.0.1")}
+	if err := speaker.SetAddresses([]net.IP{net.ParseIP("192.0.2.10"), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// The IPv6 address is not advertised over the IPv4 session
+	peer.expect("UPDATE", marshalUpdate(prefix, false, route))
+
+	if err := speaker.SetAddresses(nil); err != nil {
+		t.Fatal(err)
+	}
+	peer.expect("withdrawal", marshalUpdate(prefix, true, route))
+
+	if err := speaker.SetAddresses([]net.IP{net.ParseIP("192.0.2.10")}); err != nil {
+		t.Fatal(err)
+	}
+	peer.expect("UPDATE", marshalUpdate(prefix, false, route))
+
+	// Closing the speaker withdraws the routes before the NOTIFICATION
+	done := make(chan struct{})
+	go func() {
+		speaker.Close()
+		close(done)
+	}()
+	peer.expect("withdrawal", marshalUpdate(prefix, true, route))
+	peer.expect("NOTIFICATION", marshalNotification(errCease, errCeaseAdminShutdown))
+	<-done
+}
+
+func TestSessionBadPeerAS(t *testing.T) {
+	_, peer := startPeer(t, 65002)
+	peer.expect("OPEN", marshalOpen(65001, 90, net.ParseIP("192.0.2.1"), afiIPv4))
+	peer.write(marshalOpen(65003, 90, net.ParseIP("198.51.100.1"), afiIPv4))
+	peer.expect("NOTIFICATION", marshalNotification(errOpenMessage, errOpenBadPeerAS))
+}
+
+func TestSessionPeerNotification(t *testing.T) {
+	speaker, peer := startPeer(t, 65002)
+	peer.establish(65002)
+
+	// The session ends on a NOTIFICATION of the peer without a reply
+	peer.write(marshalNotification(errCease, errCeaseAdminShutdown))
+	peer.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if typ, body, err := readMessage(peer.conn); err == nil {
+		t.Errorf("expected the session to be closed, got message type %d % x", typ, body)
+	}
+	speaker.Close()
+}
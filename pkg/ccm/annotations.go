@@ -0,0 +1,60 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// EgressIPAnnotation holds the source IP of the route to the egress target,
+// which differs from the ExternalIP if egress leaves through another uplink
+const EgressIPAnnotation = "local-ccm.io/egress-ip"
+
+// ProvidedNodeIPAnnotation holds the node IP kubelet was started with when
+// using an external cloud provider
+const ProvidedNodeIPAnnotation = "alpha.kubernetes.io/provided-node-ip"
+
+// syncAnnotations publishes the managed annotations of the node. Like labels,
+// they are updated together for server-side apply.
+func (r *runner) syncAnnotations(ctx context.Context, currentNode *v1.Node, annotations map[string]string, remove []string) error {
+	changed := len(annotations) > 0 && !hasLabels(currentNode.Annotations, annotations)
+	if !changed && len(remove) == 0 {
+		klog.V(3).Info("Annotations unchanged, skipping update")
+		return nil
+	}
+	for key := range annotations {
+		r.ownKeys.wrote(false, key)
+	}
+	r.ownKeys.wrote(false, remove...)
+	if changed {
+		klog.Info("Annotations changed, updating node")
+		if err := r.nodeUpdater.UpdateAnnotations(ctx, annotations); err != nil {
+			return &APIError{Err: fmt.Errorf("failed to update annotations: %w", err)}
+		}
+	}
+	if len(remove) > 0 {
+		klog.Infof("Removing annotations %v from node", remove)
+		if err := r.nodeUpdater.RemoveAnnotations(ctx, remove...); err != nil {
+			return &APIError{Err: fmt.Errorf("failed to remove annotations: %w", err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,923 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/configstatus"
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/facts"
+	"github.com/cozystack/local-ccm/pkg/features"
+	"github.com/cozystack/local-ccm/pkg/metrics"
+	"github.com/cozystack/local-ccm/pkg/networkstatus"
+	"github.com/cozystack/local-ccm/pkg/node"
+	"github.com/cozystack/local-ccm/pkg/routes"
+	"github.com/cozystack/local-ccm/pkg/tracing"
+	"github.com/cozystack/local-ccm/pkg/version"
+	"github.com/cozystack/local-ccm/pkg/zones"
+)
+
+// runner holds the clients and providers shared by the reconcile loop and
+// the controllers
+type runner struct {
+	config        Config
+	client        kubernetes.Interface
+	dynamicClient dynamic.Interface
+	leaseClient   kubernetes.Interface
+	// informers caches the resources watched by the controllers
+	informers *sharedInformers
+
+	nodeUpdater node.Interface
+	nodeZones   *zones.Zones
+	nodeRoutes  *routes.Routes
+	detector    detector.Detector
+	detection   *detector.State
+	// detectors detect the address types with their own detectors, and
+	// strategies record the strategy that last detected them
+	detectors  map[v1.NodeAddressType]detector.Detector
+	strategies map[v1.NodeAddressType]string
+	// factLabels and factAnnotations are rendered from the facts of the node
+	factLabels      map[string]*facts.Template
+	factAnnotations map[string]*facts.Template
+	// networkStatus publishes the NodeNetworkStatus if configured
+	networkStatus *networkstatus.Publisher
+	// events records events on the node once started
+	events *nodeEvents
+	// dnsNames publishes the DNS names of the node IPs if configured
+	dnsNames *dnsNames
+	// drift reports differences between the node IP of kubelet and the
+	// detected InternalIP
+	drift *driftMonitor
+	// staleExternalIP measures for how long the ExternalIP was not detected
+	staleExternalIP *staleClock
+	// missingAddresses measure for how long published addresses were
+	// missing from detection, by type
+	missingAddresses map[v1.NodeAddressType]*staleClock
+	// addressUpdates limits the address updates to one per MinUpdateInterval
+	addressUpdates *updateClock
+	// ownership detects other writers of the addresses if configured
+	ownership *ownershipGuard
+	// failureTaint records detection failures for the detection failure
+	// taint if configured
+	failureTaint *failureTaint
+	// liveness reports wedged loops on /healthz if served
+	liveness *liveness
+	// ownKeys records the labels and annotations written to the node,
+	// shared with the runners of the LocalCCMConfig
+	ownKeys *ownKeys
+
+	// clusterConfig watches the LocalCCMConfig if configured, and
+	// appliedConfig records its generation applied to this runner
+	clusterConfig *clusterConfigWatcher
+	appliedConfig string
+}
+
+// Run runs local-ccm for the configured node until ctx is done. In run-once
+// mode, it reconciles once and returns the result.
+func Run(ctx context.Context, config Config) error {
+	if config.RunOnce && config.RunOnceTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.RunOnceTimeout)
+		defer cancel()
+	}
+
+	if err := config.Validate(); err != nil {
+		return &ConfigError{Err: fmt.Errorf("invalid config: %w", err)}
+	}
+
+	// Wait for the API server instead of crash-looping while it starts
+	if err := waitForClients(ctx, &config); err != nil {
+		return err
+	}
+	r, err := newRunner(config)
+	if err != nil {
+		return err
+	}
+	defer r.informers.shutdown()
+	config = r.config
+
+	// The own node need not exist when simulating standalone
+	if config.SimulateNodes > 0 && config.SimulationMode == SimulationStandalone {
+		return r.runSimulation(ctx)
+	}
+
+	// The node of an inventory agent is in another cluster, it only
+	// reports to the inventory
+	if config.Inventory != "" {
+		return r.runInventoryAgent(ctx)
+	}
+
+	klog.Infof("Starting local-ccm %s for node %s", version.Version, config.NodeName)
+	klog.V(2).Infof("Configuration: internalIPTarget=%q externalIPTarget=%q",
+		config.InternalIPTarget, config.ExternalIPTarget)
+
+	if err := r.waitForAPIServer(ctx); err != nil {
+		return err
+	}
+	r.adoptManagedFields(ctx)
+	if config.SelfNode {
+		r.checkSelfNodeIdentity(ctx)
+	}
+	if err := r.checkDistribution(ctx); err != nil {
+		return err
+	}
+	if config.ClusterConfig != "" {
+		r.clusterConfig = r.newClusterConfigWatcher()
+		if err := r.clusterConfig.start(ctx, config.StartupTimeout); err != nil {
+			return &APIError{Err: err}
+		}
+	}
+
+	if config.RunOnce {
+		r.warnRunOnce()
+		if err := r.reconcileOnce(ctx); err != nil {
+			return fmt.Errorf("reconciliation failed: %w", err)
+		}
+		klog.Infof("Reconciliation completed successfully")
+		return nil
+	}
+
+	if config.BindAddress != "" {
+		r.liveness = newLiveness(config.LivenessFailures, config.LivenessWindow)
+	}
+	r.start(ctx)
+	networkChanged := r.watchNetworkEvents(ctx)
+	notifier := r.startSystemdNotifier(ctx)
+	var nodeUpdated <-chan struct{}
+	if config.Mode == ModeOnceThenWatch {
+		nodeUpdated = r.watchOwnNode(ctx)
+	}
+
+	// Main reconciliation loop
+	for {
+		err := r.reconcile(ctx)
+		if err != nil {
+			klog.Errorf("Reconciliation failed: %v", err)
+		} else {
+			klog.Infof("Reconciliation completed successfully")
+		}
+		notifier.reconciled(err)
+
+		// Once reconciled, only resync while watching for changes
+		interval := config.ReconcileInterval
+		if config.Mode == ModeOnceThenWatch && err == nil {
+			interval = config.ResyncInterval
+		}
+		r.liveness.reconciled(err, interval)
+		klog.V(2).Infof("Sleeping for %v until next reconciliation", interval)
+		select {
+		case <-ctx.Done():
+			notifier.stopping()
+			return nil
+		case <-time.After(interval):
+		case <-networkChanged:
+		case <-nodeUpdated:
+		}
+	}
+}
+
+// newRunner validates the config and creates the clients and providers
+func newRunner(config Config) (*runner, error) {
+	if err := config.Validate(); err != nil {
+		return nil, &ConfigError{Err: fmt.Errorf("invalid config: %w", err)}
+	}
+
+	r := &runner{
+		config:        config,
+		client:        config.Client,
+		dynamicClient: config.DynamicClient,
+	}
+
+	// Create Kubernetes clients unless provided
+	if r.client == nil || r.dynamicClient == nil {
+		client, dynamicClient, err := createKubernetesClients(context.Background(), config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+		if r.client == nil {
+			r.client = client
+		}
+		if r.dynamicClient == nil {
+			r.dynamicClient = dynamicClient
+		}
+	}
+
+	r.informers = newSharedInformers(r.client)
+
+	// Keep the leases next to the pods if the nodes are in another cluster
+	r.leaseClient = config.LeaseClient
+	if r.leaseClient == nil && config.targetCluster() {
+		leaseClient, err := createLocalClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lease client: %w", err)
+		}
+		r.leaseClient = leaseClient
+	}
+	if r.leaseClient == nil {
+		r.leaseClient = r.client
+	}
+
+	// Create node updater unless provided
+	r.nodeUpdater = config.NodeUpdater
+	if r.nodeUpdater == nil {
+		var opts []node.Option
+		if config.FeatureGates.Enabled(features.ServerSideApply) {
+			opts = append(opts, node.WithPatchType(types.ApplyPatchType))
+		}
+		r.nodeUpdater = node.NewUpdater(r.client, config.NodeName, opts...)
+	}
+
+	// Create zones provider
+	r.nodeZones = zones.NewZones(r.client, config.NodeName, config.Zone, config.Region)
+
+	// Create routes provider, watching all nodes only if routes are configured
+	if config.ConfigureRoutes {
+		r.nodeRoutes = routes.NewRoutes(r.informers.factory.Core().V1().Nodes(), config.NodeName)
+	}
+
+	// Detect addresses via routes unless another detector is provided
+	r.detector = config.Detector
+	if r.detector == nil {
+		r.detector = detector.Route{}
+	}
+	r.detector = config.wrapDetector(r.detector)
+	r.detectors = make(map[v1.NodeAddressType]detector.Detector)
+	if config.InternalIPDetector != nil {
+		r.detectors[v1.NodeInternalIP] = config.wrapDetector(config.InternalIPDetector)
+	}
+	if config.ExternalIPDetector != nil {
+		r.detectors[v1.NodeExternalIP] = config.wrapDetector(config.ExternalIPDetector)
+	}
+	r.strategies = make(map[v1.NodeAddressType]string)
+
+	// Parse the templated labels and annotations, validated above
+	var err error
+	if r.factLabels, err = facts.ParseTemplates(config.FactLabels); err != nil {
+		return nil, err
+	}
+	if r.factAnnotations, err = facts.ParseTemplates(config.FactAnnotations); err != nil {
+		return nil, err
+	}
+
+	// Keep the detection results, writing them to the output file if requested
+	r.detection = detector.NewState(config.OutputFile, config.KubeletNodeIPFile)
+
+	// Publish the NodeNetworkStatus if requested
+	if config.NetworkStatus {
+		r.networkStatus = networkstatus.NewPublisher(r.dynamicClient)
+	}
+
+	r.events = &nodeEvents{}
+	r.ownKeys = &ownKeys{}
+	if !config.ProvidedNodeIP {
+		// The annotation no longer holds the node IP of kubelet once
+		// local-ccm rewrites it
+		r.drift = &driftMonitor{events: r.events}
+	}
+	if config.DNSNames {
+		r.dnsNames = newDNSNames(r.events)
+	}
+	r.staleExternalIP = &staleClock{}
+	r.missingAddresses = map[v1.NodeAddressType]*staleClock{
+		v1.NodeInternalIP: {},
+		v1.NodeExternalIP: {},
+	}
+	r.addressUpdates = &updateClock{}
+	if config.AddressConflictPolicy != "" {
+		r.ownership = &ownershipGuard{events: r.events, policy: config.AddressConflictPolicy}
+	}
+	if config.DetectionTaintAfter > 0 {
+		r.failureTaint = &failureTaint{}
+	}
+
+	return r, nil
+}
+
+// warnRunOnce warns about the enabled features that are not started in
+// run-once mode
+func (r *runner) warnRunOnce() {
+	for _, name := range r.config.controllers() {
+		klog.Warningf("%s is not started in run-once mode", name)
+	}
+}
+
+// start starts the local endpoints and the enabled controllers
+func (r *runner) start(ctx context.Context) {
+	// Record events on the node
+	r.events.start(ctx, r.client.CoreV1())
+
+	// Serve local HTTP endpoints if requested
+	if r.config.BindAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/detection", r.detection)
+		mux.Handle("/debug/routes", r.detection.RoutesHandler())
+		// Metrics are only served with authentication if configured
+		if r.config.MetricsBindAddress == "" {
+			mux.Handle("/metrics", metrics.Handler())
+		}
+		if r.liveness != nil {
+			mux.Handle("/healthz", r.liveness)
+		}
+		go runHTTPServer(ctx, r.config.BindAddress, mux)
+	}
+	if r.config.SocketPath != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/addresses", r.detection.AddressesHandler())
+		go runUnixServer(ctx, r.config.SocketPath, mux)
+	}
+
+	// Start service controller if requested
+	if r.config.ServiceController {
+		go r.runLeaderElected(ctx, serviceControllerLeaseName, r.runServiceController)
+	}
+
+	// Start LoadBalancer IP announcement if requested
+	if r.config.L2Announcement {
+		go r.runAnnouncer(ctx)
+	}
+
+	// Start BGP announcement if requested
+	if r.config.BGPAnnouncement {
+		go r.runBGPAnnouncer(ctx)
+	}
+
+	// Start node addresses publisher if requested
+	if r.config.NodeAddressesConfigMap != "" {
+		go r.runLeaderElected(ctx, nodeAddressesLeaseName, r.runNodeAddressesPublisher)
+	}
+
+	// Start DNSEndpoint controller if requested
+	if r.config.DNSEndpointTemplate != "" {
+		go r.runLeaderElected(ctx, dnsEndpointsLeaseName, r.runDNSEndpointController)
+	}
+
+	// Report the rollout of LocalCCMConfigs if they are used. Nodes may not
+	// update them in self-node mode.
+	if r.config.ClusterConfig != "" && !r.config.SelfNode {
+		go r.runLeaderElected(ctx, configStatusLeaseName, r.runConfigStatusController)
+	}
+
+	// Serve the instance metadata of the node if requested
+	if r.config.MetadataBindAddress != "" {
+		go r.runMetadataServer(ctx)
+	}
+
+	// Serve the node admission webhook if requested
+	if r.config.WebhookBindAddress != "" {
+		go r.runWebhook(ctx)
+	}
+	if r.config.WebhookCertSecret != "" {
+		go r.runLeaderElected(ctx, webhookCertsLeaseName, r.runWebhookCertController)
+	}
+	if r.config.MetricsBindAddress != "" {
+		go r.runSecureMetrics(ctx)
+	}
+
+	// Start node endpoints controller if requested
+	if r.config.NodeEndpointsService != "" {
+		go r.runLeaderElected(ctx, nodeEndpointsLeaseName, r.runNodeEndpointsController)
+	}
+
+	// Detect the nodes without an agent over SSH if requested
+	if r.config.RemoteDetectionSecret != "" {
+		go r.runLeaderElected(ctx, remoteDetectionLeaseName, r.runRemoteDetection)
+	}
+
+	// Publish the addresses reported into NodeInventories if requested
+	if r.config.InventoryController {
+		go r.runLeaderElected(ctx, inventoryControllerLeaseName, r.runInventoryController)
+	}
+
+	// Simulate nodes next to the own one if requested
+	if r.config.SimulateNodes > 0 && r.config.SimulationMode == SimulationController {
+		go r.runLeaderElected(ctx, simulationLeaseName, r.runSimulationController)
+	}
+}
+
+// reconcile reconciles the node once with the LocalCCMConfig applied, if
+// configured. An invalid LocalCCMConfig is ignored in favor of the flags.
+// The reconciliation is traced, so its API requests can be correlated.
+func (r *runner) reconcile(ctx context.Context) error {
+	ctx, traceID := tracing.Start(ctx)
+	if r.config.TraceAPIRequests {
+		klog.Infof("Reconciling node %s in trace %s", r.config.NodeName, traceID)
+	}
+	if r.clusterConfig == nil {
+		return r.reconcileNode(ctx)
+	}
+	applied, err := r.withClusterConfig()
+	if err != nil {
+		klog.Errorf("Ignoring LocalCCMConfig %s: %v", r.clusterConfig.name, err)
+		return r.reconcileNode(ctx)
+	}
+	return applied.reconcileNode(ctx)
+}
+
+// reconcileNode updates the addresses, labels, taints and routes of the node once
+func (r *runner) reconcileNode(ctx context.Context) error {
+	klog.V(2).Infof("Starting reconciliation for node %s", r.config.NodeName)
+
+	// Get current node
+	currentNode, err := r.nodeUpdater.GetNode(ctx)
+	if err != nil {
+		return &APIError{Err: fmt.Errorf("failed to get node: %w", err)}
+	}
+
+	// Start with existing addresses, and those of the other family if both
+	// families are published
+	addressMap, secondary := r.config.seedAddresses(currentNode)
+
+	// Record the detection results for the debug endpoint
+	report := detector.Report{Time: time.Now()}
+	defer func() { r.detection.Set(report) }()
+
+	// Each step is attempted independently, so a failing step does not keep
+	// the others from publishing their results
+	var errs []error
+	succeeded := false
+	// Whether the node has a public ExternalIP, unknown if detection failed
+	var publicIP *bool
+	// Whether the detection of an address failed, and whether the addresses
+	// are published
+	var detectionFailed, addressesPublished bool
+	step := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			succeeded = true
+		}
+	}
+
+	// Managed annotations, keeping their published value if detection fails
+	annotations := make(map[string]string)
+	keepAnnotation := func(key string) {
+		if current, ok := currentNode.Annotations[key]; ok {
+			annotations[key] = current
+		}
+	}
+
+	// Detect Internal IP if configured
+	if r.config.detectsInternalIP() {
+		klog.V(3).Infof("Detecting internal IP using target %s", r.config.InternalIPTarget)
+		internal := r.detect(currentNode, v1.NodeInternalIP, r.config.InternalIPTarget)
+		report.Internal = &internal
+		r.recordStrategy(currentNode, v1.NodeInternalIP, internal, annotations)
+		if internal.Error != "" {
+			// Keep the published InternalIP
+			detectionFailed = true
+			step(&DetectionError{Err: fmt.Errorf("failed to detect internal IP: %s", internal.Error)})
+			if r.config.ProvidedNodeIP {
+				keepAnnotation(ProvidedNodeIPAnnotation)
+			}
+		} else {
+			klog.V(2).Infof("Detected internal IP: %s", internal.Address)
+			addressMap[v1.NodeInternalIP] = internal.Address
+			r.detectSecondary(v1.NodeInternalIP, r.config.InternalIPTarget, internal.Address, secondary)
+			if r.drift != nil {
+				r.drift.check(currentNode, internal.Address)
+			}
+			if r.config.ProvidedNodeIP {
+				annotations[ProvidedNodeIPAnnotation] = internal.Address
+			}
+			step(nil)
+		}
+	} else {
+		// If internal IP detection is not configured, preserve existing InternalIP (e.g., set by kubelet)
+		report.Internal = &detector.Detection{
+			Strategy: detector.StrategyPreserved,
+			Address:  addressMap[v1.NodeInternalIP],
+		}
+	}
+
+	// Always detect and update External IP
+	klog.V(3).Infof("Detecting external IP using target %s", r.config.ExternalIPTarget)
+	external := r.detect(currentNode, v1.NodeExternalIP, r.config.ExternalIPTarget)
+	report.External = &external
+	r.recordStrategy(currentNode, v1.NodeExternalIP, external, annotations)
+	if external.Error != "" {
+		// Keep the published ExternalIP unless it is withdrawn
+		detectionFailed = true
+		step(&DetectionError{Err: fmt.Errorf("failed to detect external IP: %s", external.Error)})
+		if r.withdrawExternalIP(addressMap[v1.NodeExternalIP]) {
+			delete(addressMap, v1.NodeExternalIP)
+		}
+	} else {
+		r.staleExternalIP.reset()
+		detectedExternalIP := external.Address
+		klog.V(2).Infof("Detected external IP: %s", detectedExternalIP)
+		report.BehindNAT = detector.IsBehindNAT(detectedExternalIP)
+		public := !report.BehindNAT
+		publicIP = &public
+
+		// Check if external IP equals internal IP - if so, don't set external IP
+		if internalIP, hasInternal := addressMap[v1.NodeInternalIP]; hasInternal && internalIP == detectedExternalIP {
+			klog.V(2).Infof("External IP %s matches internal IP, removing external IP from addresses", detectedExternalIP)
+			delete(addressMap, v1.NodeExternalIP)
+			report.External.Address = ""
+			report.External.Filtered = append(report.External.Filtered, detector.Candidate{
+				Address: detectedExternalIP,
+				Reason:  "matches the InternalIP",
+			})
+		} else {
+			addressMap[v1.NodeExternalIP] = detectedExternalIP
+		}
+		r.detectSecondary(v1.NodeExternalIP, r.config.ExternalIPTarget, detectedExternalIP, secondary)
+		step(nil)
+	}
+
+	// Detect the egress IP if configured
+	if r.config.EgressIPTarget != "" {
+		klog.V(3).Infof("Detecting egress IP using target %s", r.config.EgressIPTarget)
+		egress := r.detectTargets(r.detector, r.config.EgressIPTarget)
+		report.Egress = &egress
+		if egress.Error != "" {
+			// Keep the published egress IP
+			step(&DetectionError{Err: fmt.Errorf("failed to detect egress IP: %s", egress.Error)})
+			keepAnnotation(EgressIPAnnotation)
+		} else {
+			klog.V(2).Infof("Detected egress IP: %s", egress.Address)
+			annotations[EgressIPAnnotation] = egress.Address
+			step(nil)
+		}
+	}
+
+	// Keep the routing state the detections saw for /debug/routes
+	if r.config.BindAddress != "" {
+		r.detection.SetSnapshot(detector.TakeSnapshot())
+	}
+
+	// Publish the Hostname address by the override and policy if configured
+	if r.config.HostnameOverride != "" || r.config.HostnamePolicy != "" {
+		hostname, err := r.nodeHostname(ctx)
+		if err != nil {
+			// Keep the published Hostname
+			step(&DetectionError{Err: err})
+		} else {
+			klog.V(3).Infof("Using hostname %s", hostname)
+			addressMap[v1.NodeHostName] = hostname
+			step(nil)
+		}
+	}
+
+	// Delay the removal of addresses during brief blips if configured
+	r.holdRemovedAddresses(currentNode, addressMap)
+
+	// Publish the validated DNS names of the addresses if requested
+	if r.dnsNames != nil {
+		r.dnsNames.sync(ctx, currentNode, addressMap)
+	}
+
+	// Publish only the addresses of the address families
+	r.config.dropOtherFamilies(addressMap)
+
+	// Leave the address types managed by others as published
+	unmanaged, err := r.keepUnmanagedAddresses(currentNode, addressMap, secondary)
+	if err != nil {
+		step(err)
+	}
+
+	// Convert maps back to slice
+	addresses := append(addressList(addressMap, secondary), unmanaged...)
+
+	// Check if addresses changed
+	if r.ownership != nil && !r.ownership.allow(currentNode, time.Now()) {
+		klog.V(2).Infof("Addresses changed by another writer, skipping update by the %s policy", r.config.AddressConflictPolicy)
+	} else if addressesEqual(currentNode.Status.Addresses, addresses) {
+		klog.V(3).Info("Addresses unchanged, skipping update")
+		addressesPublished = true
+	} else if wait := r.addressUpdates.wait(time.Now(), r.config.MinUpdateInterval); wait > 0 {
+		// Oscillating detections are published at the pace of the interval
+		klog.V(2).Infof("Addresses changed, deferring update by %s to keep the minimum update interval", wait.Round(time.Second))
+		metrics.AddressUpdatesDeferred.Inc()
+	} else {
+		klog.Info("Addresses changed, updating node")
+		if err := r.nodeUpdater.UpdateAddresses(ctx, addresses); err != nil {
+			step(&APIError{Err: fmt.Errorf("failed to update addresses: %w", err)})
+		} else {
+			r.addressUpdates.record(time.Now())
+			addressesPublished = true
+			if r.ownership != nil {
+				r.ownership.wrote(addresses, time.Now())
+			}
+			step(nil)
+		}
+	}
+
+	// Derive the facts of the node for templated labels and annotations
+	var nodeFacts facts.Facts
+	if r.factsEnabled() {
+		nodeFacts = facts.Collect(&report)
+		for key, value := range renderFacts(r.factAnnotations, nodeFacts, currentNode.Annotations, false) {
+			annotations[key] = value
+		}
+	}
+
+	// Publish the managed labels if configured
+	if r.labelsEnabled() {
+		step(r.syncLabels(ctx, currentNode, publicIP, nodeFacts))
+	}
+
+	// Record the applied LocalCCMConfig, keeping the last one if it is
+	// ignored, and remove the one of earlier runs if none is watched
+	var removeAnnotations []string
+	if r.appliedConfig != "" {
+		annotations[configstatus.GenerationAnnotation] = r.appliedConfig
+	} else if r.clusterConfig != nil {
+		keepAnnotation(configstatus.GenerationAnnotation)
+	} else if _, ok := currentNode.Annotations[configstatus.GenerationAnnotation]; ok {
+		removeAnnotations = append(removeAnnotations, configstatus.GenerationAnnotation)
+	}
+
+	// Gate the registering node until it is verified if requested, before
+	// the uninitialized taint is removed
+	if r.config.InitializingTaint {
+		if err := r.syncInitializingTaint(ctx, currentNode, &report, !detectionFailed && addressesPublished); err != nil {
+			step(&APIError{Err: err})
+		}
+	}
+
+	// Remove taint if requested
+	if r.config.RemoveTaint {
+		if err := r.nodeUpdater.RemoveTaint(ctx); err != nil {
+			step(&APIError{Err: fmt.Errorf("failed to remove taint: %w", err)})
+		} else {
+			step(nil)
+		}
+	}
+
+	// Taint the node while detection keeps failing if requested
+	if r.failureTaint != nil {
+		if err := r.syncFailureTaint(ctx, currentNode, detectionFailed); err != nil {
+			step(&APIError{Err: err})
+		}
+	}
+
+	// Program pod CIDR routes if requested
+	if r.config.ConfigureRoutes {
+		r.informers.start()
+		if err := r.nodeRoutes.Sync(ctx); err != nil {
+			step(fmt.Errorf("failed to sync routes: %w", err))
+		} else {
+			step(nil)
+		}
+	}
+
+	// Publish the network status with the errors of this reconciliation
+	if r.networkStatus != nil {
+		status := networkstatus.Collect(&report, errs)
+		if err := r.networkStatus.Publish(ctx, currentNode, status); err != nil {
+			step(&APIError{Err: fmt.Errorf("failed to publish network status: %w", err)})
+		}
+	}
+
+	// Publish the managed annotations if any, last so the status annotation
+	// holds the errors of the other steps. Publishing the status alone does
+	// not count as a successful step.
+	managedAnnotations := len(annotations) > 0 || len(removeAnnotations) > 0
+	if r.config.StatusAnnotation {
+		annotations[StatusAnnotation] = reconcileStatus(currentNode, errs, succeeded)
+	}
+	if len(annotations) > 0 || len(removeAnnotations) > 0 {
+		if err := r.syncAnnotations(ctx, currentNode, annotations, removeAnnotations); err != nil || managedAnnotations {
+			step(err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if succeeded {
+		return &PartialError{Err: errors.Join(errs...)}
+	}
+	return errors.Join(errs...)
+}
+
+// createKubernetesClients creates clients from the kubeconfig and master of
+// config. Token and certificate files, and exec plugins, are refreshed for
+// the lifetime of the clients.
+func createKubernetesClients(ctx context.Context, config Config) (kubernetes.Interface, dynamic.Interface, error) {
+	metrics.RegisterClientMetrics()
+
+	var restConfig *rest.Config
+	var err error
+	var secret *targetKubeconfigSecret
+	var secretData []byte
+	switch {
+	case config.TargetKubeconfigSecret != "":
+		if secret, err = newTargetKubeconfigSecret(config); err == nil {
+			secretData, restConfig, err = secret.load(ctx)
+		}
+	case config.TargetKubeconfig != "":
+		// Master overrides the server of Kubeconfig only
+		restConfig, err = buildRestConfig(config.TargetKubeconfig, "")
+	default:
+		restConfig, err = buildRestConfig(config.Kubeconfig, config.Master)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create rest config: %w", err)
+	}
+	// The BearerTokenFile of the config is re-read by client-go every minute,
+	// so rotated bound service account tokens are picked up
+	applyClientSettings := func(restConfig *rest.Config) {
+		if config.ImpersonateUser != "" {
+			restConfig.Impersonate = rest.ImpersonationConfig{
+				UserName: config.ImpersonateUser,
+				Groups:   config.ImpersonateGroups,
+			}
+		}
+	}
+	if config.ImpersonateUser != "" {
+		klog.V(2).Infof("Impersonating user %s with groups %v", config.ImpersonateUser, config.ImpersonateGroups)
+	}
+	if secret != nil {
+		// The credentials of the secret are applied by its transport
+		klog.V(2).Infof("Using target kubeconfig from secret %s/%s", secret.namespace, secret.name)
+		if err := reloadTargetKubeconfigSecret(ctx, secret, secretData, restConfig, applyClientSettings); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		applyClientSettings(restConfig)
+	}
+	traceRequests(restConfig, config)
+	restConfig.QPS = config.QPS
+	restConfig.Burst = config.Burst
+	restConfig.UserAgent = config.UserAgent
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	return client, dynamicClient, nil
+}
+
+// traceRequests propagates the trace of the reconciliations into the API
+// requests if configured
+func traceRequests(restConfig *rest.Config, config Config) {
+	if config.TraceAPIRequests {
+		restConfig.Wrap(tracing.WrapTransport)
+	}
+}
+
+// createLocalClient creates a client from the kubeconfig and master of
+// config, ignoring the target cluster
+func createLocalClient(config Config) (kubernetes.Interface, error) {
+	restConfig, err := buildRestConfig(config.Kubeconfig, config.Master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rest config: %w", err)
+	}
+	traceRequests(restConfig, config)
+	restConfig.QPS = config.QPS
+	restConfig.Burst = config.Burst
+	restConfig.UserAgent = config.UserAgent
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// buildRestConfig loads the kubeconfig from kubeconfigPath, or the KUBECONFIG
+// environment variable if empty, overriding its server with master. Without
+// both, the in-cluster config is used.
+func buildRestConfig(kubeconfigPath, master string) (*rest.Config, error) {
+	loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath}
+	if kubeconfigPath == "" {
+		loadingRules.Precedence = filepath.SplitList(os.Getenv(clientcmd.RecommendedConfigPathEnvVar))
+	}
+
+	if kubeconfigPath == "" && len(loadingRules.Precedence) == 0 && master == "" {
+		klog.V(2).Info("Using in-cluster config")
+		return rest.InClusterConfig()
+	}
+
+	switch {
+	case kubeconfigPath != "":
+		klog.V(2).Infof("Using kubeconfig from %s", kubeconfigPath)
+	case len(loadingRules.Precedence) > 0:
+		klog.V(2).Infof("Using kubeconfig from $%s: %v", clientcmd.RecommendedConfigPathEnvVar, loadingRules.Precedence)
+	}
+	if master != "" {
+		klog.V(2).Infof("Using API server %s", master)
+	}
+	overrides := &clientcmd.ConfigOverrides{ClusterInfo: clientcmdapi.Cluster{Server: master}}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+// runHTTPServer serves the local HTTP endpoints until ctx is done
+func runHTTPServer(ctx context.Context, addr string, handler http.Handler) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		klog.Errorf("Failed to listen on %s: %v", addr, err)
+		return
+	}
+	klog.Infof("Serving HTTP endpoints on %s", addr)
+	serveHTTP(ctx, listener, handler)
+}
+
+// runUnixServer serves the query API on a unix socket until ctx is done
+func runUnixServer(ctx context.Context, path string, handler http.Handler) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		klog.Errorf("Failed to create socket directory: %v", err)
+		return
+	}
+	// Remove the socket of a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("Failed to remove stale socket %s: %v", path, err)
+		return
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		klog.Errorf("Failed to listen on %s: %v", path, err)
+		return
+	}
+	klog.Infof("Serving query API on %s", path)
+	serveHTTP(ctx, listener, handler)
+}
+
+func serveHTTP(ctx context.Context, listener net.Listener, handler http.Handler) {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("Failed to serve HTTP endpoints: %v", err)
+	}
+}
+
+// addressesEqual checks if two address slices are equal
+func addressesEqual(a, b []v1.NodeAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	// Create maps for comparison, keeping the order of the addresses of a
+	// type, as the first address of a type is used by most consumers
+	aMap := make(map[string][]string)
+	bMap := make(map[string][]string)
+
+	for _, addr := range a {
+		aMap[string(addr.Type)] = append(aMap[string(addr.Type)], addr.Address)
+	}
+	for _, addr := range b {
+		bMap[string(addr.Type)] = append(bMap[string(addr.Type)], addr.Address)
+	}
+
+	// Compare maps
+	for k, v := range aMap {
+		if !slices.Equal(bMap[k], v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasLabels checks if all wanted labels are already present with the same value
+func hasLabels(current, wanted map[string]string) bool {
+	for k, v := range wanted {
+		if current[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
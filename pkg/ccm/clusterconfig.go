@@ -0,0 +1,171 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+	"github.com/cozystack/local-ccm/pkg/configstatus"
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+// configStatusLeaseName is the name of the Lease used to elect the single
+// instance reporting the rollout of LocalCCMConfigs
+const configStatusLeaseName = "local-ccm-config-status"
+
+// clusterConfigWatcher watches the LocalCCMConfig named in Config.ClusterConfig
+type clusterConfigWatcher struct {
+	name     string
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	informer cache.SharedIndexInformer
+}
+
+// newClusterConfigWatcher creates a watcher of the configured LocalCCMConfig
+func (r *runner) newClusterConfigWatcher() *clusterConfigWatcher {
+	name := r.config.ClusterConfig
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(r.dynamicClient, 0, metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	})
+	return &clusterConfigWatcher{
+		name:     name,
+		factory:  factory,
+		informer: factory.ForResource(v1alpha1.LocalCCMConfigResource).Informer(),
+	}
+}
+
+// start starts watching and waits until the LocalCCMConfig has been listed
+func (w *clusterConfigWatcher) start(ctx context.Context, timeout time.Duration) error {
+	w.factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		w.factory.Shutdown()
+	}()
+
+	syncCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(syncCtx.Done(), w.informer.HasSynced) {
+		return fmt.Errorf("failed to list LocalCCMConfig %s, is the CRD installed?", w.name)
+	}
+	return nil
+}
+
+// get returns the LocalCCMConfig, or nil if it does not exist
+func (w *clusterConfigWatcher) get() (*v1alpha1.LocalCCMConfig, error) {
+	obj, exists, err := w.informer.GetStore().GetByKey(w.name)
+	if err != nil || !exists {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	config := &v1alpha1.LocalCCMConfig{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, config); err != nil {
+		return nil, fmt.Errorf("failed to convert %s: %w", w.name, err)
+	}
+	return config, nil
+}
+
+// withClusterConfig returns a copy of the runner with the LocalCCMConfig
+// applied over the flags. The runner itself is returned if it does not exist.
+func (r *runner) withClusterConfig() (*runner, error) {
+	clusterConfig, err := r.clusterConfig.get()
+	if err != nil {
+		return nil, err
+	}
+	if clusterConfig == nil {
+		klog.V(3).Infof("LocalCCMConfig %s not found, using flags", r.clusterConfig.name)
+		return r, nil
+	}
+
+	applied := *r
+	if err := applied.applyClusterConfig(&clusterConfig.Spec); err != nil {
+		return nil, err
+	}
+	applied.appliedConfig = configstatus.FormatGeneration(clusterConfig.Name, clusterConfig.Generation)
+	return &applied, nil
+}
+
+// applyClusterConfig overrides the config with the set fields of spec
+func (r *runner) applyClusterConfig(spec *v1alpha1.LocalCCMConfigSpec) error {
+	config := r.config
+	if d := spec.Detection; d != nil {
+		if d.Detector != "" {
+			det, err := detector.ParseDetector(d.Detector)
+			if err != nil {
+				return err
+			}
+			config.Detector = det
+		}
+		if d.InternalIPTarget != "" {
+			config.InternalIPTarget = d.InternalIPTarget
+		}
+		if d.ExternalIPTarget != "" {
+			config.ExternalIPTarget = d.ExternalIPTarget
+		}
+		if d.EgressIPTarget != "" {
+			config.EgressIPTarget = d.EgressIPTarget
+		}
+	}
+	if spec.RemoveTaint != nil {
+		config.RemoveTaint = *spec.RemoveTaint
+	}
+	if spec.ExcludeFromLoadBalancers != "" {
+		config.ExcludeFromLoadBalancers = spec.ExcludeFromLoadBalancers
+	}
+	if spec.Labels != nil && spec.Labels.PublicIP != nil {
+		config.PublicIPLabel = *spec.Labels.PublicIP
+	}
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	r.config = config
+	if config.Detector != nil {
+		r.detector = config.wrapDetector(config.Detector)
+	}
+	return nil
+}
+
+// runConfigStatusController reports the rollout of the LocalCCMConfigs until
+// ctx is done
+func (r *runner) runConfigStatusController(ctx context.Context) {
+	configFactory := dynamicinformer.NewDynamicSharedInformerFactory(r.dynamicClient, 0)
+
+	controller, err := configstatus.NewController(r.dynamicClient, r.informers.factory, configFactory)
+	if err != nil {
+		klog.Errorf("Failed to create LocalCCMConfig status controller: %v", err)
+		return
+	}
+
+	r.informers.start()
+	configFactory.Start(ctx.Done())
+	defer configFactory.Shutdown()
+
+	controller.Run(ctx)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ManagedAddressTypesAnnotation overrides ManagedAddressTypes for a node with
+// a comma-separated list of address types, or "none" to leave all addresses
+// of the node as published
+const ManagedAddressTypesAnnotation = "local-ccm.io/managed-address-types"
+
+// addressTypes are the address types local-ccm can manage
+var addressTypes = []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP, v1.NodeHostName, v1.NodeInternalDNS, v1.NodeExternalDNS}
+
+// validateAddressTypes checks that the address types are known
+func validateAddressTypes(types []v1.NodeAddressType) error {
+	for _, addressType := range types {
+		if !slices.Contains(addressTypes, addressType) {
+			return fmt.Errorf("unknown address type %q", addressType)
+		}
+	}
+	return nil
+}
+
+// coexistenceConflicts returns the enabled features writing fields owned by
+// the cloud provider local-ccm coexists with
+func (c *Config) coexistenceConflicts() []string {
+	var names []string
+	if c.RemoveTaint {
+		names = append(names, "taint removal")
+	}
+	if c.Zone != "" || c.Region != "" {
+		names = append(names, "topology labels")
+	}
+	if c.ConfigureRoutes {
+		names = append(names, "route configuration")
+	}
+	if c.WebhookBindAddress != "" {
+		names = append(names, "the node admission webhook")
+	}
+	return names
+}
+
+// managedAddressTypes returns the address types managed for the node, nil
+// if all. An invalid annotation is reported, and no types are managed.
+func (r *runner) managedAddressTypes(currentNode *v1.Node) ([]v1.NodeAddressType, error) {
+	value, ok := currentNode.Annotations[ManagedAddressTypesAnnotation]
+	if !ok {
+		return r.config.ManagedAddressTypes, nil
+	}
+	managed := []v1.NodeAddressType{}
+	if value = strings.TrimSpace(value); value == "none" || value == "" {
+		return managed, nil
+	}
+	for _, addressType := range strings.Split(value, ",") {
+		managed = append(managed, v1.NodeAddressType(strings.TrimSpace(addressType)))
+	}
+	if err := validateAddressTypes(managed); err != nil {
+		return []v1.NodeAddressType{}, fmt.Errorf("invalid %s annotation: %w", ManagedAddressTypesAnnotation, err)
+	}
+	return managed, nil
+}
+
+// keepUnmanagedAddresses drops the types not managed for the node from the
+// detected addresses and returns all published addresses of those types in
+// order, so addresses owned by others are left untouched
+func (r *runner) keepUnmanagedAddresses(currentNode *v1.Node, addressMap, secondary map[v1.NodeAddressType]string) ([]v1.NodeAddress, error) {
+	managed, err := r.managedAddressTypes(currentNode)
+	if managed == nil {
+		return nil, err
+	}
+	for _, addressType := range addressTypes {
+		if !slices.Contains(managed, addressType) {
+			delete(addressMap, addressType)
+			delete(secondary, addressType)
+		}
+	}
+	var unmanaged []v1.NodeAddress
+	for _, addr := range currentNode.Status.Addresses {
+		if !slices.Contains(managed, addr.Type) {
+			unmanaged = append(unmanaged, addr)
+		}
+	}
+	return unmanaged, err
+}
@@ -0,0 +1,856 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/cozystack/local-ccm/pkg/config"
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/features"
+	"github.com/cozystack/local-ccm/pkg/ipam"
+	"github.com/cozystack/local-ccm/pkg/netevents"
+	"github.com/cozystack/local-ccm/pkg/node"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// DefaultExternalIPTarget is the default IPv4 target of external IP
+	// detection
+	DefaultExternalIPTarget = "1.1.1.1"
+	// DefaultReconcileInterval is the default interval between reconciliations
+	DefaultReconcileInterval = 10 * time.Second
+	// DefaultResyncInterval is the default interval between reconciliations
+	// of ModeOnceThenWatch
+	DefaultResyncInterval = 10 * time.Minute
+	// DefaultStartupTimeout is the default time to wait for the API server on startup
+	DefaultStartupTimeout = 5 * time.Minute
+	// DefaultNamespace is the default namespace of leases and ConfigMaps
+	DefaultNamespace = "kube-system"
+	// DefaultExternalIPWithdrawalGrace is the default time the ExternalIP
+	// must be stale before it is withdrawn
+	DefaultExternalIPWithdrawalGrace = 5 * time.Minute
+	// DefaultConcurrentNodeSyncs is the default number of nodes synced in
+	// parallel by the controllers managing per-node objects
+	DefaultConcurrentNodeSyncs = 5
+	// DefaultLivenessFailures is the default number of consecutive failed
+	// reconciliations reported unhealthy on /healthz
+	DefaultLivenessFailures = 5
+	// DefaultLivenessWindow is the default window of LivenessFailures
+	DefaultLivenessWindow = 5 * time.Minute
+)
+
+const (
+	// ExcludeLoadBalancersAuto excludes nodes without a public ExternalIP
+	// from external load balancers
+	ExcludeLoadBalancersAuto = "auto"
+	// ExcludeLoadBalancersAlways excludes the node from external load balancers
+	ExcludeLoadBalancersAlways = "always"
+	// ExcludeLoadBalancersNever includes the node in external load balancers
+	ExcludeLoadBalancersNever = "never"
+)
+
+const (
+	// WithdrawExternalIPFailed withdraws the ExternalIP while external
+	// detection fails
+	WithdrawExternalIPFailed = "failed"
+	// WithdrawExternalIPUnassigned withdraws the ExternalIP while external
+	// detection fails and the address is not assigned to a local interface
+	WithdrawExternalIPUnassigned = "unassigned"
+)
+
+const (
+	// SimulationStandalone reconciles only the simulated nodes, without an
+	// own node, e.g. from a workstation or a Job
+	SimulationStandalone = "standalone"
+	// SimulationController simulates the nodes in the elected instance of
+	// the agents, next to the reconciliation of their own nodes
+	SimulationController = "controller"
+)
+
+const (
+	// PrivilegeModePrivileged allows features requiring capabilities
+	PrivilegeModePrivileged = "privileged"
+	// PrivilegeModeRestricted refuses features requiring capabilities, so
+	// local-ccm runs with all capabilities dropped and as non-root. Address
+	// detection only uses read-only netlink queries or UDP sockets.
+	PrivilegeModeRestricted = "restricted"
+)
+
+// Config holds the settings of local-ccm. The zero value of each field
+// disables the respective feature, except where a default is documented.
+type Config struct {
+	// NodeName is the name of the node to manage. Required.
+	NodeName string
+	// Namespace holds the leader election leases and the LoadBalancer IP
+	// allocations, and is the default namespace of published objects.
+	// Defaults to DefaultNamespace.
+	Namespace string
+
+	// LeaderElectResourceLock is the type of the lock electing the instance
+	// running each cluster-wide controller. Only "leases" is supported, as
+	// by client-go. Defaults to DefaultLeaderElectResourceLock.
+	LeaderElectResourceLock string
+	// LeaderElectNamespace holds the leases. Defaults to Namespace.
+	LeaderElectNamespace string
+	// LeaderElectIdentity is the holder identity of the leases. Defaults to
+	// NodeName.
+	LeaderElectIdentity string
+	// LeaderElectLeaseDuration, LeaderElectRenewDeadline and
+	// LeaderElectRetryPeriod tune the failover, as the flags of
+	// kube-controller-manager. Default to DefaultLeaderElectLeaseDuration,
+	// DefaultLeaderElectRenewDeadline and DefaultLeaderElectRetryPeriod.
+	LeaderElectLeaseDuration time.Duration
+	LeaderElectRenewDeadline time.Duration
+	LeaderElectRetryPeriod   time.Duration
+
+	// Client and DynamicClient are used to access the API server. If nil,
+	// they are created from TargetKubeconfig or TargetKubeconfigSecret if
+	// set, otherwise from
+	// Kubeconfig, or the KUBECONFIG environment variable if empty, with the
+	// server overridden by Master. Without both, the in-cluster config is used.
+	Client        kubernetes.Interface
+	DynamicClient dynamic.Interface
+	Kubeconfig    string
+	Master        string
+	// TargetKubeconfig creates the clients for the cluster holding the Node
+	// objects instead, e.g. a hosted control plane. The leader election
+	// leases stay in the cluster of Kubeconfig, where the pods run.
+	TargetKubeconfig string
+	// TargetKubeconfigSecret reads the kubeconfig of the target cluster from
+	// the Secret "[namespace/]name[:key]" in the cluster of Kubeconfig
+	// instead, e.g. the kubeconfig secret of a Cluster API cluster. The
+	// namespace defaults to Namespace and the key to
+	// DefaultTargetKubeconfigKey.
+	TargetKubeconfigSecret string
+	// LeaseClient holds the leader election leases. If nil, Client is used,
+	// or a client of Kubeconfig if the target cluster is set.
+	LeaseClient kubernetes.Interface
+	// ImpersonateUser and ImpersonateGroups are impersonated by the created
+	// clients, so API requests are authorized and audited as a dedicated
+	// identity instead of the one of the credentials
+	ImpersonateUser   string
+	ImpersonateGroups []string
+	// QPS and Burst limit the requests of the created clients. If zero, the
+	// client-go defaults are used.
+	QPS   float32
+	Burst int
+	// UserAgent is the User-Agent of the requests of the created clients.
+	// Defaults to version.UserAgent of the node.
+	UserAgent string
+	// NodeUpdater updates the node. If nil, a node.Updater is created.
+	NodeUpdater node.Interface
+	// PrivilegeMode is PrivilegeModePrivileged or PrivilegeModeRestricted.
+	// Defaults to PrivilegeModePrivileged.
+	PrivilegeMode string
+	// SelfNode restricts local-ccm to the node access the Node authorizer
+	// grants the kubelet, e.g. when running with the kubelet credentials.
+	// Controllers requiring cluster-wide permissions and taint removal
+	// are rejected.
+	SelfNode bool
+
+	// ClusterConfig is the name of a LocalCCMConfig whose set fields
+	// override the detection, taint and label settings of this config. If
+	// empty, no LocalCCMConfig is watched. Only applied by Run.
+	ClusterConfig string
+
+	// FeatureGates toggles features in development. If nil, all gates are
+	// at their default.
+	FeatureGates *features.Gates
+
+	// InternalIPTarget is the target IP of internal IP detection. If empty
+	// and InternalIPDetector is nil, the InternalIP set by kubelet is
+	// preserved.
+	InternalIPTarget string
+	// ExternalIPTarget is the target IP of external IP detection. Defaults
+	// to the default targets of the address families, IPv4 first.
+	ExternalIPTarget string
+	// DefaultIPv4Target is the default IPv4 target of external IP
+	// detection. Defaults to DefaultExternalIPTarget.
+	DefaultIPv4Target string
+	// DefaultIPv6Target is the default IPv6 target of external IP
+	// detection. Defaults to DefaultExternalIPv6Target.
+	DefaultIPv6Target string
+	// EgressIPTarget is the target IP of egress IP detection, published as
+	// the EgressIPAnnotation annotation. If empty, the egress IP is not
+	// detected.
+	EgressIPTarget string
+	// ExternalIPWithdrawal removes the published ExternalIP once it was
+	// stale for ExternalIPWithdrawalGrace: WithdrawExternalIPFailed while
+	// external detection fails, WithdrawExternalIPUnassigned while it fails
+	// and the address is not assigned locally. If empty, the ExternalIP is
+	// kept while detection fails.
+	ExternalIPWithdrawal string
+	// ExternalIPWithdrawalGrace is how long the ExternalIP must be stale
+	// before it is withdrawn. Defaults to DefaultExternalIPWithdrawalGrace.
+	ExternalIPWithdrawalGrace time.Duration
+	// AddressRemovalGrace is how long a published InternalIP or ExternalIP
+	// is kept after it first disappeared from detection, e.g. when the
+	// ExternalIP matches the InternalIP during an uplink blip. If zero,
+	// addresses are removed right away.
+	AddressRemovalGrace time.Duration
+	// MinUpdateInterval is the minimum time between two updates of the
+	// addresses of the node. Changes detected earlier are published once it
+	// passed, if still detected. If zero, changes are published right away.
+	MinUpdateInterval time.Duration
+	// AddressConflictPolicy is how to respond when another writer changes
+	// the addresses written by local-ccm: ConflictPolicyTakeOver,
+	// ConflictPolicyBackOff or ConflictPolicyAlert. If empty, other writers
+	// are not detected and the addresses are rewritten.
+	AddressConflictPolicy string
+	// DetectionTaintAfter adds a NoSchedule taint with the key
+	// DetectionTaint once the detection of the addresses failed for
+	// this long after it succeeded, removing it once detection succeeds
+	// again. If 0, disabled.
+	DetectionTaintAfter time.Duration
+	// DetectionTaint is the key of the detection failure taint,
+	// defaulting to DefaultDetectionTaint.
+	DetectionTaint string
+	// TraceAPIRequests sends the API requests of a reconciliation as spans of
+	// its trace, in the traceparent header and as audit ID, so audit events
+	// can be correlated with the trace ID logged for the reconciliation.
+	// Only applies to the clients created by Run.
+	TraceAPIRequests bool
+	// InitializingTaint adds the local-ccm.io/initializing NoSchedule taint
+	// to registering nodes, by the webhook or the first reconciliation, and
+	// removes it once their addresses are detected and published, and the
+	// targets answered the probes if ProbeTargets is set.
+	InitializingTaint bool
+	// ManagedAddressTypes are the address types published by local-ccm,
+	// keeping the addresses of other types as published by others. If
+	// empty, all types are managed. Nodes override it with the
+	// ManagedAddressTypesAnnotation.
+	ManagedAddressTypes []v1.NodeAddressType
+	// AddressFamilies are the address families detected and published,
+	// AddressFamilyIPv4 and AddressFamilyIPv6. If empty, both.
+	AddressFamilies []string
+	// PreferFamily is the family of the address published if both families
+	// are detected or published for a type, PreferFamilyIPv4 or
+	// PreferFamilyIPv6, or PreferFamilyDual to publish both. If empty, the
+	// targets are tried in order.
+	PreferFamily string
+	// Coexistence only augments another cloud provider: local-ccm manages
+	// the ExternalIP unless ManagedAddressTypes is set, and refuses the
+	// features writing fields owned by the provider, such as taint removal.
+	Coexistence bool
+	// DNSNames publishes the reverse DNS names of the InternalIP and
+	// ExternalIP as InternalDNS and ExternalDNS addresses while they are
+	// forward-confirmed, withdrawing them with an event if they break
+	DNSNames bool
+	// HostnameOverride is published as Hostname address instead of the
+	// hostname of the host, like the --hostname-override of kubelet for
+	// intentionally renamed nodes. HostnamePolicy still applies to it.
+	HostnameOverride string
+	// HostnamePolicy publishes the Hostname address as HostnamePolicyShort
+	// or HostnamePolicyFQDN, matching the hostname-override of kubelet. If
+	// empty, the Hostname set by kubelet is preserved unless HostnameOverride
+	// is set.
+	HostnamePolicy string
+	// HostnameDomain is appended to the short hostname to form the FQDN
+	// instead of resolving it. Requires HostnamePolicyFQDN.
+	HostnameDomain string
+	// ProvidedNodeIP publishes the detected InternalIP as the
+	// ProvidedNodeIPAnnotation annotation, so it matches the node IP after
+	// uplink changes. Requires internal IP detection. Disables the drift
+	// report, as the annotation no longer holds the node IP of kubelet.
+	ProvidedNodeIP bool
+	// Detector detects the addresses. Defaults to detector.Route.
+	Detector detector.Detector
+	// InternalIPDetector detects the InternalIP instead of Detector, e.g. a
+	// detector.Chain trying several strategies in order. The strategy that
+	// detected it is published as InternalIPStrategyAnnotation annotation.
+	// Enables internal IP detection without InternalIPTarget, for detectors
+	// that need no target, e.g. detector.Interface picking IPv4 then.
+	InternalIPDetector detector.Detector
+	// ExternalIPDetector detects the ExternalIP instead of Detector. The
+	// strategy that detected it is published as ExternalIPStrategyAnnotation
+	// annotation.
+	ExternalIPDetector detector.Detector
+	// AddressPolicy selects the address among the candidates of the route,
+	// interface and default-interface detectors, e.g.
+	// detector.StablePolicy. Defaults to detector.PrimaryPolicy.
+	AddressPolicy detector.Policy
+	// ProbeTargets pings the detection targets from the detected addresses
+	// before trusting them, falling back to the next of the comma-separated
+	// targets if one does not answer
+	ProbeTargets bool
+	// RunOnce reconciles once and returns instead of running in a loop
+	RunOnce bool
+	// RunOnceTimeout bounds the reconciliation of run-once mode. If zero,
+	// there is no timeout.
+	RunOnceTimeout time.Duration
+	// RunOnceSummary receives the Summary of the reconciliation of run-once
+	// mode as JSON if set, e.g. os.Stdout.
+	RunOnceSummary io.Writer
+	// StartupTimeout bounds the retries to create the clients and reach the
+	// API server on startup. Defaults to DefaultStartupTimeout.
+	StartupTimeout time.Duration
+	// RemoveTaint removes the node.cloudprovider.kubernetes.io/uninitialized taint
+	RemoveTaint bool
+	// Distribution is the Kubernetes distribution, DistributionK3s or
+	// DistributionK0s, whose declared addresses take precedence over
+	// detection. If empty, addresses are always detected.
+	Distribution string
+	// ReconcileInterval is the interval between reconciliations. Defaults
+	// to DefaultReconcileInterval.
+	ReconcileInterval time.Duration
+	// NetworkEvents reconciles on the network changes reported by this
+	// netevents source, in addition to the periodic reconciliation. If
+	// empty, only the interval applies.
+	NetworkEvents string
+	// Mode is ModeLoop or ModeOnceThenWatch. Defaults to ModeLoop.
+	Mode string
+	// ResyncInterval is the interval between reconciliations of
+	// ModeOnceThenWatch. Defaults to DefaultResyncInterval.
+	ResyncInterval time.Duration
+	// ExcludeFromLoadBalancers manages the
+	// node.kubernetes.io/exclude-from-external-load-balancers label:
+	// ExcludeLoadBalancersAuto sets it while the node has no public
+	// ExternalIP, ExcludeLoadBalancersAlways and ExcludeLoadBalancersNever set
+	// or remove it. If empty, the label is left alone.
+	ExcludeFromLoadBalancers string
+	// PublicIPLabel publishes whether the detected ExternalIP is public as
+	// the HasPublicIPLabel label
+	PublicIPLabel bool
+	// FactLabels and FactAnnotations are "key=template" pairs publishing
+	// labels and annotations rendered from the facts of the node, e.g.
+	// "example.com/uplink={interface}". See the facts package for the facts.
+	// A value is kept while a fact of its template is unknown.
+	FactLabels      []string
+	FactAnnotations []string
+	// StatusAnnotation publishes the outcome of the last reconciliation as
+	// the StatusAnnotation annotation
+	StatusAnnotation bool
+	// Zone and Region are published as topology labels
+	Zone   string
+	Region string
+	// ConfigureRoutes programs routes to the pod CIDRs of other nodes
+	ConfigureRoutes bool
+
+	// ServiceController publishes LoadBalancer ingress (one instance is elected)
+	ServiceController bool
+	// ForwarderImage is the image of the per-service hostPort forwarders
+	ForwarderImage string
+	// Pools are CIDRs to allocate LoadBalancer IPs from (deprecated)
+	Pools []string
+	// IPAddressPools allocates LoadBalancer IPs from IPAddressPool resources
+	IPAddressPools bool
+	// LoadBalancerClass restricts the handled services to this class
+	LoadBalancerClass string
+	// Hostname publishes hostnames instead of IPs as LoadBalancer ingress
+	Hostname string
+
+	// L2Announcement answers ARP and NDP requests for LoadBalancer IPs
+	L2Announcement bool
+	// L2Interfaces are the interfaces to announce on. If empty, the
+	// interface routing to each IP is used.
+	L2Interfaces []string
+
+	// BGP configures the BGP speaker, required by BGPAnnouncement
+	BGP *config.BGPConfig
+	// BGPAnnouncement advertises LoadBalancer IPs to BGP peers
+	BGPAnnouncement bool
+
+	// NodeAddressesConfigMap is the ConfigMap ([namespace/]name) to publish
+	// the addresses of all nodes to
+	NodeAddressesConfigMap string
+	// DNSEndpointTemplate is the name template of the DNSEndpoints of the nodes
+	DNSEndpointTemplate string
+	// DNSEndpointNamespace is the namespace of the DNSEndpoints. Defaults to Namespace.
+	DNSEndpointNamespace string
+	// ConcurrentNodeSyncs is the number of nodes whose DNSEndpoints are
+	// synced, or which are detected remotely or simulated, in parallel.
+	// Defaults to DefaultConcurrentNodeSyncs.
+	ConcurrentNodeSyncs int
+	// NodeEndpointsService is the headless Service ([namespace/]name)
+	// publishing the ExternalIPs of the nodes
+	NodeEndpointsService string
+	// NodeEndpointsSelector selects the nodes published by NodeEndpointsService
+	NodeEndpointsSelector string
+	// RemoteDetectionSecret is the Secret ([namespace/]name) with the SSH
+	// credentials to detect the addresses of nodes without an agent on them
+	RemoteDetectionSecret string
+	// RemoteDetectionSelector selects the nodes detected remotely. Defaults
+	// to DefaultRemoteDetectionSelector.
+	RemoteDetectionSelector string
+	// InventoryController publishes the addresses reported into the
+	// NodeInventories of the cluster of the pods on their nodes, in the
+	// clusters of their kubeconfig secrets
+	InventoryController bool
+	// Inventory is the NodeInventory ([namespace/]name) in the cluster of
+	// Kubeconfig to report the detected addresses to, instead of publishing
+	// them on the node
+	Inventory string
+
+	// BindAddress serves the local HTTP endpoints, including /metrics
+	// unless MetricsBindAddress is set
+	BindAddress string
+	// MetricsBindAddress serves /metrics via TLS with MetricsCertFile and
+	// MetricsKeyFile instead of on BindAddress. If empty, disabled.
+	MetricsBindAddress string
+	MetricsCertFile    string
+	MetricsKeyFile     string
+	// MetricsClientCAFile allows clients with a certificate signed by it
+	MetricsClientCAFile string
+	// MetricsAuthorizeTokens allows clients with a bearer token whose user
+	// may get /metrics, checked with TokenReviews and SubjectAccessReviews
+	// in the cluster of LeaseClient. Without it and MetricsClientCAFile,
+	// all clients are allowed.
+	MetricsAuthorizeTokens bool
+	// LivenessFailures reports the loop unhealthy on /healthz once as many
+	// reconciliations failed in a row with an APIError within
+	// LivenessWindow, or once no reconciliation completed for
+	// LivenessWindow after it was due.
+	// Defaults to DefaultLivenessFailures, negative only reports stalls.
+	LivenessFailures int
+	// LivenessWindow defaults to DefaultLivenessWindow
+	LivenessWindow time.Duration
+	// SocketPath serves the query API on a unix socket
+	SocketPath string
+	// WebhookBindAddress serves the mutating admission webhook for Nodes
+	// via TLS with WebhookCertFile and WebhookKeyFile. If empty, disabled.
+	WebhookBindAddress string
+	WebhookCertFile    string
+	WebhookKeyFile     string
+	// WebhookCertSecret is the Secret ([namespace/]name) holding a
+	// self-signed CA and serving certificate of the webhook, generated and
+	// renewed by one elected instance, instead of WebhookCertFile and
+	// WebhookKeyFile. The certificate is valid for the DNS names of
+	// WebhookService ([namespace/]name), and the CA bundle is injected into
+	// the MutatingWebhookConfiguration WebhookConfiguration.
+	WebhookCertSecret    string
+	WebhookService       string
+	WebhookConfiguration string
+	// ProviderIDTemplate is the providerID set by the admission webhook and
+	// SetProviderID, with the {node}, {zone} and {region} placeholders.
+	// Defaults to DefaultProviderIDTemplate.
+	ProviderIDTemplate string
+	// MetadataBindAddress serves the instance metadata of the node, e.g. on
+	// 169.254.169.254:80. If empty, disabled.
+	MetadataBindAddress string
+	// MetadataLocalAddress adds the IP of MetadataBindAddress to the loopback
+	// interface, so pods reach it via their default route
+	MetadataLocalAddress bool
+	// NetworkStatus publishes the detections, interfaces, default routes and
+	// errors of each reconciliation in the NodeNetworkStatus of the node
+	NetworkStatus bool
+	// OutputFile receives the detected addresses on change
+	OutputFile string
+	// KubeletNodeIPFile receives the detected InternalIP on change as
+	// KUBELET_NODE_IP environment variable, for a kubelet drop-in passing
+	// it as --node-ip. Requires internal IP detection.
+	KubeletNodeIPFile string
+	// SimulateNodes creates as many fake nodes and reconciles them with a
+	// static detector, to load-test the API server
+	SimulateNodes int
+	// SimulationMode is SimulationStandalone or SimulationController.
+	// Defaults to SimulationStandalone.
+	SimulationMode string
+}
+
+// Validate checks the config for errors and fills in defaults
+func (c *Config) Validate() error {
+	if c.NodeName == "" {
+		return fmt.Errorf("node name must be set")
+	}
+	if c.Namespace == "" {
+		c.Namespace = DefaultNamespace
+	}
+	if err := c.validateLeaderElection(); err != nil {
+		return err
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = version.UserAgent(c.NodeName)
+	}
+	if c.DefaultIPv4Target == "" {
+		c.DefaultIPv4Target = DefaultExternalIPTarget
+	}
+	if c.DefaultIPv6Target == "" {
+		c.DefaultIPv6Target = DefaultExternalIPv6Target
+	}
+	if err := c.validateDefaultTargets(); err != nil {
+		return err
+	}
+	if c.ExternalIPTarget == "" {
+		c.ExternalIPTarget = c.defaultTargets()
+	}
+	if c.ReconcileInterval == 0 {
+		c.ReconcileInterval = DefaultReconcileInterval
+	}
+	if c.StartupTimeout == 0 {
+		c.StartupTimeout = DefaultStartupTimeout
+	}
+	if c.LivenessFailures == 0 {
+		c.LivenessFailures = DefaultLivenessFailures
+	}
+	if c.LivenessWindow < 0 {
+		return fmt.Errorf("liveness window must not be negative")
+	}
+	if c.LivenessWindow == 0 {
+		c.LivenessWindow = DefaultLivenessWindow
+	}
+	if c.PrivilegeMode == "" {
+		c.PrivilegeMode = PrivilegeModePrivileged
+	}
+	if c.ExternalIPWithdrawalGrace == 0 {
+		c.ExternalIPWithdrawalGrace = DefaultExternalIPWithdrawalGrace
+	}
+	if c.ConcurrentNodeSyncs < 0 {
+		return fmt.Errorf("concurrent node syncs must not be negative")
+	}
+	if c.ConcurrentNodeSyncs == 0 {
+		c.ConcurrentNodeSyncs = DefaultConcurrentNodeSyncs
+	}
+
+	switch c.ExcludeFromLoadBalancers {
+	case "", ExcludeLoadBalancersAuto, ExcludeLoadBalancersAlways, ExcludeLoadBalancersNever:
+	default:
+		return fmt.Errorf("unknown exclude from load balancers policy %q", c.ExcludeFromLoadBalancers)
+	}
+
+	switch c.ExternalIPWithdrawal {
+	case "", WithdrawExternalIPFailed, WithdrawExternalIPUnassigned:
+	default:
+		return fmt.Errorf("unknown external IP withdrawal policy %q", c.ExternalIPWithdrawal)
+	}
+	if c.ExternalIPWithdrawalGrace < 0 {
+		return fmt.Errorf("external IP withdrawal grace period must not be negative")
+	}
+	if c.AddressRemovalGrace < 0 {
+		return fmt.Errorf("address removal grace period must not be negative")
+	}
+	if c.MinUpdateInterval < 0 {
+		return fmt.Errorf("minimum update interval must not be negative")
+	}
+	if c.DetectionTaintAfter < 0 {
+		return fmt.Errorf("detection failure taint delay must not be negative")
+	}
+	if c.DetectionTaintAfter > 0 && c.DetectionTaint == "" {
+		c.DetectionTaint = DefaultDetectionTaint
+	}
+	if c.DetectionTaint != "" {
+		if errs := validation.IsQualifiedName(c.DetectionTaint); len(errs) > 0 {
+			return fmt.Errorf("invalid detection failure taint %q: %s", c.DetectionTaint, strings.Join(errs, ", "))
+		}
+	}
+
+	if err := c.validateAddressFamilies(); err != nil {
+		return err
+	}
+	if err := c.validatePreferFamily(); err != nil {
+		return err
+	}
+	if err := validateAddressTypes(c.ManagedAddressTypes); err != nil {
+		return fmt.Errorf("invalid managed address types: %w", err)
+	}
+	if c.Coexistence {
+		if names := c.coexistenceConflicts(); len(names) > 0 {
+			return fmt.Errorf("coexistence mode does not allow %s, which write fields owned by the other cloud provider", strings.Join(names, ", "))
+		}
+		if len(c.ManagedAddressTypes) == 0 {
+			c.ManagedAddressTypes = []v1.NodeAddressType{v1.NodeExternalIP}
+		}
+	}
+
+	switch c.AddressConflictPolicy {
+	case "", ConflictPolicyTakeOver, ConflictPolicyBackOff, ConflictPolicyAlert:
+	default:
+		return fmt.Errorf("unknown address conflict policy %q", c.AddressConflictPolicy)
+	}
+
+	switch c.HostnamePolicy {
+	case "", HostnamePolicyShort, HostnamePolicyFQDN:
+	default:
+		return fmt.Errorf("unknown hostname policy %q", c.HostnamePolicy)
+	}
+	if c.HostnameDomain != "" && c.HostnamePolicy != HostnamePolicyFQDN {
+		return fmt.Errorf("the hostname domain requires the fqdn hostname policy")
+	}
+
+	switch c.Mode {
+	case "":
+		c.Mode = ModeLoop
+	case ModeLoop:
+	case ModeOnceThenWatch:
+		if c.RunOnce {
+			return fmt.Errorf("run-once mode does not allow the %s mode", c.Mode)
+		}
+		// Watch the network, as the interval is long
+		if c.NetworkEvents == "" {
+			c.NetworkEvents = netevents.SourceNetlink
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	if c.ResyncInterval < 0 {
+		return fmt.Errorf("resync interval must not be negative")
+	}
+	if c.ResyncInterval == 0 {
+		c.ResyncInterval = DefaultResyncInterval
+	}
+	if c.NetworkEvents != "" && !netevents.ValidSource(c.NetworkEvents) {
+		return fmt.Errorf("unknown network event source %q", c.NetworkEvents)
+	}
+	if (c.Mode == ModeOnceThenWatch || c.NetworkEvents != "") && !c.FeatureGates.Enabled(features.EventDrivenReconcile) {
+		return fmt.Errorf("network events and the %s mode require the %s feature gate", ModeOnceThenWatch, features.EventDrivenReconcile)
+	}
+	if c.ServiceController && !c.FeatureGates.Enabled(features.LoadBalancerController) {
+		return fmt.Errorf("the Service controller requires the %s feature gate", features.LoadBalancerController)
+	}
+
+	switch c.Distribution {
+	case "", DistributionK3s, DistributionK0s:
+	default:
+		return fmt.Errorf("unknown distribution %q", c.Distribution)
+	}
+
+	switch c.PrivilegeMode {
+	case PrivilegeModePrivileged:
+	case PrivilegeModeRestricted:
+		if names := c.privilegedFeatures(); len(names) > 0 {
+			return fmt.Errorf("restricted privilege mode does not allow %s", strings.Join(names, ", "))
+		}
+	default:
+		return fmt.Errorf("unknown privilege mode %q", c.PrivilegeMode)
+	}
+
+	if c.TargetKubeconfig != "" && c.TargetKubeconfigSecret != "" {
+		return fmt.Errorf("the target kubeconfig can be set either as file or as secret")
+	}
+
+	if len(c.ImpersonateGroups) > 0 && c.ImpersonateUser == "" {
+		return fmt.Errorf("impersonating groups requires a user to impersonate")
+	}
+
+	if c.WebhookCertSecret != "" {
+		if c.WebhookCertFile != "" || c.WebhookKeyFile != "" {
+			return fmt.Errorf("the webhook certificate secret and files are mutually exclusive")
+		}
+		if c.WebhookBindAddress == "" {
+			return fmt.Errorf("the webhook certificate secret requires a webhook bind address")
+		}
+		if c.WebhookService == "" || c.WebhookConfiguration == "" {
+			return fmt.Errorf("the webhook certificate secret requires the webhook service and configuration")
+		}
+	} else if c.WebhookBindAddress != "" && (c.WebhookCertFile == "" || c.WebhookKeyFile == "") {
+		return fmt.Errorf("the node admission webhook requires a certificate and key file, or a certificate secret")
+	}
+	if c.MetricsBindAddress != "" && (c.MetricsCertFile == "" || c.MetricsKeyFile == "") {
+		return fmt.Errorf("serving metrics via TLS requires a certificate and key file")
+	}
+	if c.MetricsBindAddress == "" && (c.MetricsClientCAFile != "" || c.MetricsAuthorizeTokens) {
+		return fmt.Errorf("metrics authentication requires a metrics bind address")
+	}
+	if c.ProviderIDTemplate == "" {
+		c.ProviderIDTemplate = DefaultProviderIDTemplate
+	}
+	if err := validateProviderIDTemplate(c.ProviderIDTemplate); err != nil {
+		return err
+	}
+
+	if c.MetadataLocalAddress {
+		host, _, err := net.SplitHostPort(c.MetadataBindAddress)
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("adding the metadata address requires a metadata bind address with an IP, got %q", c.MetadataBindAddress)
+		}
+	}
+
+	if (c.ProvidedNodeIP || c.KubeletNodeIPFile != "") && !c.detectsInternalIP() {
+		return fmt.Errorf("syncing the node IP of kubelet requires internal IP detection")
+	}
+
+	if err := validateFactTemplates(c.FactLabels); err != nil {
+		return fmt.Errorf("invalid fact labels: %w", err)
+	}
+	if err := validateFactTemplates(c.FactAnnotations); err != nil {
+		return fmt.Errorf("invalid fact annotations: %w", err)
+	}
+
+	if c.RemoteDetectionSecret != "" {
+		if c.RemoteDetectionSelector == "" {
+			c.RemoteDetectionSelector = DefaultRemoteDetectionSelector
+		}
+		if _, err := labels.Parse(c.RemoteDetectionSelector); err != nil {
+			return fmt.Errorf("invalid remote detection selector: %w", err)
+		}
+	}
+
+	if c.InventoryController && c.targetCluster() {
+		return fmt.Errorf("the inventory controller reads the kubeconfigs of the inventories and does not allow a target cluster")
+	}
+	if c.Inventory != "" {
+		if c.targetCluster() {
+			return fmt.Errorf("reporting to an inventory does not allow a target cluster")
+		}
+		if names := c.controllers(); len(names) > 0 {
+			return fmt.Errorf("reporting to an inventory does not allow %s", strings.Join(names, ", "))
+		}
+	}
+
+	if c.RunOnceSummary != nil && !c.RunOnce {
+		return fmt.Errorf("the reconcile summary requires run-once mode")
+	}
+
+	if c.SimulateNodes < 0 {
+		return fmt.Errorf("simulated node count must not be negative")
+	}
+	if c.SimulateNodes > 0 && c.SelfNode {
+		return fmt.Errorf("self-node mode does not allow creating simulated nodes")
+	}
+	switch c.SimulationMode {
+	case "":
+		c.SimulationMode = SimulationStandalone
+	case SimulationStandalone, SimulationController:
+	default:
+		return fmt.Errorf("unknown simulation mode %q", c.SimulationMode)
+	}
+
+	if c.SelfNode {
+		if c.RemoveTaint {
+			return fmt.Errorf("self-node mode does not allow taint removal, the NodeRestriction admission plugin forbids nodes to modify their taints")
+		}
+		if c.DetectionTaintAfter > 0 || c.InitializingTaint {
+			return fmt.Errorf("self-node mode does not allow the detection failure and initializing taints, the NodeRestriction admission plugin forbids nodes to modify their taints")
+		}
+		if names := c.controllers(); len(names) > 0 {
+			return fmt.Errorf("self-node mode does not allow %s, which require cluster-wide permissions", strings.Join(names, ", "))
+		}
+	}
+
+	if len(c.Pools) > 0 {
+		if _, err := ipam.ParsePools(c.Pools); err != nil {
+			return fmt.Errorf("invalid pools: %w", err)
+		}
+	}
+
+	if c.L2Announcement && !c.poolsEnabled() {
+		return fmt.Errorf("L2 announcement requires IPAddressPools or pools")
+	}
+
+	if _, err := labels.Parse(c.NodeEndpointsSelector); err != nil {
+		return fmt.Errorf("invalid node endpoints selector: %w", err)
+	}
+
+	if c.BGPAnnouncement {
+		if c.BGP == nil {
+			return fmt.Errorf("BGP announcement requires a BGP config")
+		}
+		if !c.poolsEnabled() && !c.BGP.AdvertiseNodeExternalIPs {
+			return fmt.Errorf("BGP announcement requires IPAddressPools, pools or advertiseNodeExternalIPs")
+		}
+	}
+
+	return nil
+}
+
+// controllers returns the names of the enabled controllers, which watch or
+// manage objects beyond the local node
+func (c *Config) controllers() []string {
+	var names []string
+	for _, controller := range []struct {
+		enabled bool
+		name    string
+	}{
+		{c.ServiceController, "Service controller"},
+		{c.L2Announcement, "L2 announcement"},
+		{c.BGPAnnouncement, "BGP announcement"},
+		{c.NodeAddressesConfigMap != "", "Node addresses publisher"},
+		{c.DNSEndpointTemplate != "", "DNSEndpoint controller"},
+		{c.NodeEndpointsService != "", "Node endpoints controller"},
+		{c.RemoteDetectionSecret != "", "Remote detection"},
+		{c.InventoryController, "Inventory controller"},
+		{c.SimulateNodes > 0 && c.SimulationMode == SimulationController, "Scale simulation"},
+		{c.WebhookBindAddress != "", "Node admission webhook"},
+		{c.WebhookCertSecret != "", "Webhook certificate controller"},
+	} {
+		if controller.enabled {
+			names = append(names, controller.name)
+		}
+	}
+	return names
+}
+
+// targetCluster reports whether the Node objects are in another cluster
+// than the pods
+func (c *Config) targetCluster() bool {
+	return c.TargetKubeconfig != "" || c.TargetKubeconfigSecret != ""
+}
+
+// privilegedFeatures returns the enabled features requiring capabilities
+func (c *Config) privilegedFeatures() []string {
+	var names []string
+	if c.ConfigureRoutes {
+		// Programming routes requires NET_ADMIN
+		names = append(names, "route configuration")
+	}
+	if c.L2Announcement {
+		// Raw ARP and ICMPv6 sockets require NET_RAW
+		names = append(names, "L2 announcement")
+	}
+	if c.MetadataLocalAddress {
+		// Adding addresses requires NET_ADMIN
+		names = append(names, "adding the metadata address")
+	}
+	return names
+}
+
+// detectsInternalIP reports whether the InternalIP is detected instead of
+// preserving the one set by kubelet
+func (c *Config) detectsInternalIP() bool {
+	return c.InternalIPTarget != "" || c.InternalIPDetector != nil
+}
+
+// wrapDetector sets the address policy of d and wraps it to probe the
+// detection targets if configured
+func (c *Config) wrapDetector(d detector.Detector) detector.Detector {
+	if c.AddressPolicy != nil {
+		d = detector.WithPolicy(d, c.AddressPolicy)
+	}
+	return c.probing(d)
+}
+
+// probing wraps d to probe the detection targets if configured
+func (c *Config) probing(d detector.Detector) detector.Detector {
+	if !c.ProbeTargets {
+		return d
+	}
+	// Probe each detector of a chain, so the next one is tried if the
+	// target does not answer
+	if chain, ok := d.(detector.Chain); ok {
+		probing := make(detector.Chain, len(chain))
+		for i, link := range chain {
+			probing[i] = c.probing(link)
+		}
+		return probing
+	}
+	return detector.Probing{Detector: d}
+}
+
+// poolsEnabled reports whether LoadBalancer IPs are allocated from pools
+func (c *Config) poolsEnabled() bool {
+	return len(c.Pools) > 0 || c.IPAddressPools
+}
@@ -0,0 +1,343 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/announcer"
+	"github.com/cozystack/local-ccm/pkg/bgp"
+	"github.com/cozystack/local-ccm/pkg/config"
+	"github.com/cozystack/local-ccm/pkg/externaldns"
+	"github.com/cozystack/local-ccm/pkg/ipam"
+	"github.com/cozystack/local-ccm/pkg/nodeaddresses"
+	"github.com/cozystack/local-ccm/pkg/nodeendpoints"
+	"github.com/cozystack/local-ccm/pkg/service"
+)
+
+const (
+	// serviceControllerLeaseName is the name of the Lease used to elect the
+	// single instance running the service controller
+	serviceControllerLeaseName = "local-ccm-service-controller"
+
+	// lbAllocationsConfigMapName is the name of the ConfigMap holding the
+	// LoadBalancer IP allocations
+	lbAllocationsConfigMapName = "local-ccm-lb-allocations"
+
+	// nodeAddressesLeaseName is the name of the Lease used to elect the
+	// single instance publishing node addresses
+	nodeAddressesLeaseName = "local-ccm-node-addresses"
+
+	// dnsEndpointsLeaseName is the name of the Lease used to elect the
+	// single instance maintaining DNSEndpoints
+	dnsEndpointsLeaseName = "local-ccm-dns-endpoints"
+
+	// nodeEndpointsLeaseName is the name of the Lease used to elect the
+	// single instance maintaining the node endpoints Service
+	nodeEndpointsLeaseName = "local-ccm-node-endpoints"
+)
+
+// runLeaderElected runs fn while holding the named lease. If leadership is
+// lost, fn's context is cancelled and the election is retried until ctx is done.
+func (r *runner) runLeaderElected(ctx context.Context, leaseName string, fn func(ctx context.Context)) {
+	lock, err := resourcelock.New(r.config.LeaderElectResourceLock, r.config.LeaderElectNamespace, leaseName,
+		r.leaseClient.CoreV1(), r.leaseClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: r.config.LeaderElectIdentity})
+	if err != nil {
+		klog.Errorf("Failed to create lock %s: %v", leaseName, err)
+		return
+	}
+
+	for ctx.Err() == nil {
+		// client-go keeps renewing the lease after OnStartedLeading returns,
+		// so the election is canceled, releasing the lease, if fn gives up
+		// while leading
+		electionCtx, cancel := context.WithCancel(ctx)
+		var stopped atomic.Bool
+		leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			ReleaseOnCancel: true,
+			LeaseDuration:   r.config.LeaderElectLeaseDuration,
+			RenewDeadline:   r.config.LeaderElectRenewDeadline,
+			RetryPeriod:     r.config.LeaderElectRetryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					klog.Infof("Acquired lease %s, starting", leaseName)
+					fn(leaderCtx)
+					if leaderCtx.Err() == nil {
+						klog.Warningf("Stopped while holding lease %s, releasing it", leaseName)
+						stopped.Store(true)
+						cancel()
+					}
+				},
+				OnStoppedLeading: func() {
+					klog.Infof("Lost lease %s", leaseName)
+				},
+			},
+		})
+		cancel()
+
+		// Leave the lease to the other instances for a lease duration
+		if stopped.Load() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.config.LeaderElectLeaseDuration):
+			}
+		}
+	}
+}
+
+// runServiceController runs the LoadBalancer service controller until ctx is done
+func (r *runner) runServiceController(ctx context.Context) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: r.client.CoreV1().Events("")})
+	defer broadcaster.Shutdown()
+
+	serviceConfig := service.Config{
+		ForwarderImage:    r.config.ForwarderImage,
+		LoadBalancerClass: r.config.LoadBalancerClass,
+		Hostname:          r.config.Hostname,
+		Recorder:          broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "local-ccm"}),
+	}
+
+	var poolFactory dynamicinformer.DynamicSharedInformerFactory
+	if r.config.poolsEnabled() {
+		serviceConfig.Pools, poolFactory = r.newPoolWatcher()
+		serviceConfig.Allocator = ipam.NewAllocator(r.client, r.config.Namespace, lbAllocationsConfigMapName, serviceConfig.Pools.Pools)
+	}
+
+	controller, err := service.NewController(r.client, r.informers.factory, serviceConfig)
+	if err != nil {
+		klog.Errorf("Failed to create service controller: %v", err)
+		return
+	}
+
+	r.informers.start()
+	if poolFactory != nil {
+		poolFactory.Start(ctx.Done())
+		defer poolFactory.Shutdown()
+	}
+
+	controller.Run(ctx, 1)
+}
+
+// runAnnouncer announces the LoadBalancer IPs elected to this node until ctx is done
+func (r *runner) runAnnouncer(ctx context.Context) {
+	pools, poolFactory := r.newPoolWatcher()
+
+	a, err := announcer.NewAnnouncer(r.informers.factory, announcer.NewL2Responder(r.config.L2Interfaces), announcer.Config{
+		NodeName:          r.config.NodeName,
+		Pools:             pools,
+		LoadBalancerClass: r.config.LoadBalancerClass,
+	})
+	if err != nil {
+		klog.Errorf("Failed to create announcer: %v", err)
+		return
+	}
+
+	r.informers.start()
+	if poolFactory != nil {
+		poolFactory.Start(ctx.Done())
+		defer poolFactory.Shutdown()
+	}
+
+	a.Run(ctx)
+}
+
+// runBGPAnnouncer advertises the LoadBalancer IPs to the BGP peers of this
+// node until ctx is done
+func (r *runner) runBGPAnnouncer(ctx context.Context) {
+	cfg := r.config.BGP
+
+	var node *v1.Node
+	err := wait.PollUntilContextCancel(ctx, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		var err error
+		node, err = r.client.CoreV1().Nodes().Get(ctx, r.config.NodeName, metav1.GetOptions{})
+		if err != nil {
+			klog.Errorf("Failed to get node %s: %v", r.config.NodeName, err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return
+	}
+
+	speakerConfig, err := bgpSpeakerConfig(cfg, node)
+	if err != nil {
+		klog.Errorf("Failed to configure BGP speaker: %v", err)
+		return
+	}
+	if len(speakerConfig.Peers) == 0 {
+		klog.Infof("No BGP peers selected for node %s", r.config.NodeName)
+		return
+	}
+
+	speaker, err := bgp.NewSpeaker(speakerConfig)
+	if err != nil {
+		klog.Errorf("Failed to create BGP speaker: %v", err)
+		return
+	}
+
+	pools, poolFactory := r.newPoolWatcher()
+
+	a, err := announcer.NewAnnouncer(r.informers.factory, speaker, announcer.Config{
+		NodeName:          r.config.NodeName,
+		Pools:             pools,
+		LoadBalancerClass: r.config.LoadBalancerClass,
+		AllNodes:          true,
+		NodeExternalIPs:   cfg.AdvertiseNodeExternalIPs,
+	})
+	if err != nil {
+		klog.Errorf("Failed to create announcer: %v", err)
+		speaker.Close()
+		return
+	}
+
+	r.informers.start()
+	if poolFactory != nil {
+		poolFactory.Start(ctx.Done())
+		defer poolFactory.Shutdown()
+	}
+
+	a.Run(ctx)
+}
+
+// bgpSpeakerConfig builds the speaker config of a node from the config file
+func bgpSpeakerConfig(cfg *config.BGPConfig, node *v1.Node) (bgp.Config, error) {
+	speakerConfig := bgp.Config{ASN: cfg.ASN}
+
+	if cfg.RouterID != "" {
+		speakerConfig.RouterID = net.ParseIP(cfg.RouterID)
+	} else {
+		for _, addr := range node.Status.Addresses {
+			if ip := net.ParseIP(addr.Address); addr.Type == v1.NodeInternalIP && ip.To4() != nil {
+				speakerConfig.RouterID = ip
+				break
+			}
+		}
+		if speakerConfig.RouterID == nil {
+			return bgp.Config{}, fmt.Errorf("node %s has no IPv4 InternalIP, bgp.routerID must be set", node.Name)
+		}
+	}
+
+	for _, peer := range cfg.Peers {
+		if !labels.SelectorFromSet(peer.NodeSelector).Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		speakerConfig.Peers = append(speakerConfig.Peers, bgp.Peer{
+			Address:  net.ParseIP(peer.Address),
+			ASN:      peer.ASN,
+			Port:     peer.Port,
+			HoldTime: peer.HoldTime.Duration,
+		})
+	}
+
+	return speakerConfig, nil
+}
+
+// runNodeAddressesPublisher maintains the node addresses ConfigMap until ctx is done
+func (r *runner) runNodeAddressesPublisher(ctx context.Context) {
+	namespace, name := r.config.Namespace, r.config.NodeAddressesConfigMap
+	if i := strings.Index(name, "/"); i >= 0 {
+		namespace, name = name[:i], name[i+1:]
+	}
+
+	publisher, err := nodeaddresses.NewPublisher(r.client, r.informers.factory, namespace, name)
+	if err != nil {
+		klog.Errorf("Failed to create node addresses publisher: %v", err)
+		return
+	}
+
+	r.informers.start()
+
+	publisher.Run(ctx)
+}
+
+// runNodeEndpointsController maintains the node endpoints Service until ctx is done
+func (r *runner) runNodeEndpointsController(ctx context.Context) {
+	namespace, name := r.config.Namespace, r.config.NodeEndpointsService
+	if i := strings.Index(name, "/"); i >= 0 {
+		namespace, name = name[:i], name[i+1:]
+	}
+
+	// Validated on startup
+	selector, _ := labels.Parse(r.config.NodeEndpointsSelector)
+
+	controller, err := nodeendpoints.NewController(r.client, r.informers.factory, namespace, name, selector)
+	if err != nil {
+		klog.Errorf("Failed to create node endpoints controller: %v", err)
+		return
+	}
+
+	r.informers.start()
+
+	controller.Run(ctx)
+}
+
+// runDNSEndpointController maintains the DNSEndpoints of the nodes until ctx is done
+func (r *runner) runDNSEndpointController(ctx context.Context) {
+	namespace := r.config.DNSEndpointNamespace
+	if namespace == "" {
+		namespace = r.config.Namespace
+	}
+
+	controller, err := externaldns.NewController(r.dynamicClient, r.informers.factory, namespace, r.config.DNSEndpointTemplate)
+	if err != nil {
+		klog.Errorf("Failed to create DNSEndpoint controller: %v", err)
+		return
+	}
+
+	r.informers.start()
+
+	controller.Run(ctx, r.config.ConcurrentNodeSyncs)
+}
+
+// newPoolWatcher creates a watcher of the pool configured via
+// Config.Pools and, if enabled, of IPAddressPool resources. The
+// returned informer factory is nil if IPAddressPools are disabled.
+func (r *runner) newPoolWatcher() (*ipam.PoolWatcher, dynamicinformer.DynamicSharedInformerFactory) {
+	var static []ipam.Pool
+	if len(r.config.Pools) > 0 {
+		// Validated on startup
+		cidrs, _ := ipam.ParsePools(r.config.Pools)
+		static = append(static, ipam.Pool{Name: ipam.StaticPoolName, CIDRs: cidrs, AutoAssign: true})
+	}
+
+	if !r.config.IPAddressPools {
+		return ipam.NewPoolWatcher(r.dynamicClient, nil, static), nil
+	}
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(r.dynamicClient, 0)
+	return ipam.NewPoolWatcher(r.dynamicClient, factory, static), factory
+}
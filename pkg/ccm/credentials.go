@@ -0,0 +1,41 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// checkSelfNodeIdentity warns if the client is not authenticated as the
+// node, as the Node authorizer then does not grant access to it
+func (r *runner) checkSelfNodeIdentity(ctx context.Context) {
+	review, err := r.client.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		klog.V(2).Infof("Failed to verify the identity of the client: %v", err)
+		return
+	}
+	want := "system:node:" + r.config.NodeName
+	if username := review.Status.UserInfo.Username; username != want {
+		klog.Warningf("Authenticated as %q instead of %q, the Node authorizer does not apply to self-node mode", username, want)
+		return
+	}
+	klog.V(2).Infof("Authenticated as %s", want)
+}
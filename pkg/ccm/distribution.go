@@ -0,0 +1,183 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+const (
+	// DistributionK3s honors the --node-ip and --node-external-ip flags of k3s
+	DistributionK3s = "k3s"
+	// DistributionK0s honors the ExternalIP annotation of the k0s cloud provider
+	DistributionK0s = "k0s"
+)
+
+const (
+	// k3sNodeArgsAnnotation holds the arguments k3s was started with as JSON array
+	k3sNodeArgsAnnotation = "k3s.io/node-args"
+	// k3sInternalIPAnnotation and k3sExternalIPAnnotation hold the node IPs
+	// of k3s, comma-separated if dual-stack
+	k3sInternalIPAnnotation = "k3s.io/internal-ip"
+	k3sExternalIPAnnotation = "k3s.io/external-ip"
+	// k3sProviderIDPrefix prefixes the providerID set by the embedded cloud
+	// controller of k3s
+	k3sProviderIDPrefix = "k3s://"
+	// k3sServerSelector selects the k3s servers, which run the embedded
+	// cloud controller and servicelb
+	k3sServerSelector = "node-role.kubernetes.io/control-plane=true"
+	// k0sExternalIPAnnotation holds the ExternalIP for the k0s cloud provider
+	k0sExternalIPAnnotation = "k0sproject.io/node-ip-external"
+)
+
+// detect detects an address of the node, unless it was declared to the
+// distribution, which takes precedence
+func (r *runner) detect(n *v1.Node, addrType v1.NodeAddressType, target string) detector.Detection {
+	if addr, annotation := r.declaredAddress(n, addrType); addr != "" {
+		klog.V(3).Infof("Using %s %s declared in annotation %s", addrType, addr, annotation)
+		return detector.Detection{
+			Strategy: detector.StrategyDeclared,
+			Target:   target,
+			Address:  addr,
+		}
+	}
+	return r.detectTargets(r.detectorFor(addrType), target)
+}
+
+// declaredAddress returns the address of addrType declared to the
+// distribution and the annotation holding it, or empty strings. The
+// k3s.io/internal-ip annotation is also set if k3s detected the address
+// itself, so it is only honored if --node-ip was given.
+func (r *runner) declaredAddress(n *v1.Node, addrType v1.NodeAddressType) (string, string) {
+	var annotation string
+	switch r.config.Distribution {
+	case DistributionK3s:
+		switch addrType {
+		case v1.NodeInternalIP:
+			if hasK3sNodeArg(n, "--node-ip") {
+				annotation = k3sInternalIPAnnotation
+			}
+		case v1.NodeExternalIP:
+			if hasK3sNodeArg(n, "--node-external-ip") {
+				annotation = k3sExternalIPAnnotation
+			}
+		}
+	case DistributionK0s:
+		if addrType == v1.NodeExternalIP {
+			annotation = k0sExternalIPAnnotation
+		}
+	}
+	if annotation == "" {
+		return "", ""
+	}
+
+	// Pick the first address of the address families of dual-stack lists
+	for _, value := range strings.Split(n.Annotations[annotation], ",") {
+		if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil && r.config.allowsFamily(ip) {
+			return ip.String(), annotation
+		}
+	}
+	return "", ""
+}
+
+// hasK3sNodeArg checks if k3s was started with the flag
+func hasK3sNodeArg(n *v1.Node, flag string) bool {
+	for _, arg := range k3sNodeArgs(n) {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// k3sNodeArgs returns the arguments k3s was started with
+func k3sNodeArgs(n *v1.Node) []string {
+	var args []string
+	if err := json.Unmarshal([]byte(n.Annotations[k3sNodeArgsAnnotation]), &args); err != nil {
+		return nil
+	}
+	return args
+}
+
+// k3sDisabled checks if the k3s server was started with the component
+// disabled, given as --disable=a,b or --disable a
+func k3sDisabled(n *v1.Node, component string) bool {
+	args := k3sNodeArgs(n)
+	for i, arg := range args {
+		value, ok := strings.CutPrefix(arg, "--disable=")
+		if arg == "--disable" && i+1 < len(args) {
+			value, ok = args[i+1], true
+		}
+		if !ok {
+			continue
+		}
+		for _, disabled := range strings.Split(value, ",") {
+			if strings.TrimSpace(disabled) == component {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkDistribution checks the k3s servers for built-in components that
+// fight over the addresses or LoadBalancer ingress with local-ccm. It fails
+// if the embedded cloud controller runs, and disables the Service controller
+// if servicelb may publish the ingress of the same Services.
+func (r *runner) checkDistribution(ctx context.Context) error {
+	if r.config.Distribution != DistributionK3s {
+		return nil
+	}
+	n, err := r.nodeUpdater.GetNode(ctx)
+	if err != nil {
+		klog.V(2).Infof("Failed to get node: %v", err)
+		return nil
+	}
+	if strings.HasPrefix(n.Spec.ProviderID, k3sProviderIDPrefix) {
+		klog.Warningf("Node %s was initialized by the embedded cloud controller of k3s", n.Name)
+	}
+
+	servers, err := r.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: k3sServerSelector})
+	if err != nil {
+		klog.Warningf("Failed to list k3s servers, not checking their components: %v", err)
+		return nil
+	}
+	for i := range servers.Items {
+		server := &servers.Items[i]
+		if k3sNodeArgs(server) == nil {
+			continue
+		}
+		if !hasK3sNodeArg(server, "--disable-cloud-controller") {
+			return &ConfigError{Err: fmt.Errorf("k3s server %s runs the embedded cloud controller, which overwrites the addresses; start k3s with --disable-cloud-controller", server.Name)}
+		}
+		if r.config.ServiceController && r.config.LoadBalancerClass == "" && !k3sDisabled(server, "servicelb") {
+			klog.Errorf("Disabling the Service controller: servicelb of k3s server %s also publishes the ingress of LoadBalancer Services without class; start k3s with --disable=servicelb or set --load-balancer-class", server.Name)
+			r.config.ServiceController = false
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+// DNSNameWithdrawnReason is recorded when a published DNS name of the node
+// is withdrawn as its forward and reverse records no longer match
+const DNSNameWithdrawnReason = "DNSNameWithdrawn"
+
+const (
+	// dnsRevalidateInterval is how often the DNS names of unchanged
+	// addresses are validated again
+	dnsRevalidateInterval = 5 * time.Minute
+	dnsLookupTimeout      = 5 * time.Second
+)
+
+// dnsNameTypes maps the IP address types to the DNS name types published
+// for them
+var dnsNameTypes = []struct{ ip, dns v1.NodeAddressType }{
+	{v1.NodeInternalIP, v1.NodeInternalDNS},
+	{v1.NodeExternalIP, v1.NodeExternalDNS},
+}
+
+// dnsNames publishes the reverse DNS names of the node IPs as InternalDNS and
+// ExternalDNS addresses if they are forward-confirmed, i.e. resolve back to
+// the IP
+type dnsNames struct {
+	events   *nodeEvents
+	resolver *net.Resolver
+
+	mu sync.Mutex
+	// validated holds the last validation by DNS name type
+	validated map[v1.NodeAddressType]dnsValidation
+}
+
+type dnsValidation struct {
+	ip   string
+	name string
+	time time.Time
+}
+
+func newDNSNames(events *nodeEvents) *dnsNames {
+	return &dnsNames{
+		events:    events,
+		resolver:  net.DefaultResolver,
+		validated: make(map[v1.NodeAddressType]dnsValidation),
+	}
+}
+
+// sync sets the DNS names of the IPs in addressMap, validating them again
+// when an IP changes or dnsRevalidateInterval passed. If DNS cannot be
+// queried, the published names of unchanged IPs are kept.
+func (d *dnsNames) sync(ctx context.Context, currentNode *v1.Node, addressMap map[v1.NodeAddressType]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, types := range dnsNameTypes {
+		ip := addressMap[types.ip]
+		published := addressMap[types.dns]
+		last, ok := d.validated[types.dns]
+		if ip == "" {
+			delete(addressMap, types.dns)
+			delete(d.validated, types.dns)
+			continue
+		}
+		if ok && last.ip == ip && time.Since(last.time) < dnsRevalidateInterval {
+			setAddress(addressMap, types.dns, last.name)
+			continue
+		}
+
+		// A published name belongs to the previous IP if it changed
+		changed := publishedAddress(currentNode, types.ip) != ip
+		name, reason, err := d.lookup(ctx, ip)
+		if err != nil {
+			klog.Warningf("Failed to validate the DNS name of %s %s: %v", types.ip, ip, err)
+			if changed {
+				delete(addressMap, types.dns)
+			}
+			continue
+		}
+		d.validated[types.dns] = dnsValidation{ip: ip, name: name, time: time.Now()}
+		if name == "" && published != "" && !changed {
+			klog.Warningf("Withdrawing %s %s: %s", types.dns, published, reason)
+			d.events.record(currentNode, v1.EventTypeWarning, DNSNameWithdrawnReason,
+				fmt.Sprintf("Withdrawing %s %s: %s", types.dns, published, reason))
+		} else if name == "" {
+			klog.V(2).Infof("No DNS name for %s %s: %s", types.ip, ip, reason)
+		}
+		setAddress(addressMap, types.dns, name)
+	}
+}
+
+// lookup returns the forward-confirmed reverse DNS name of ip, or the reason
+// why there is none. An error is returned if DNS could not be queried.
+func (d *dnsNames) lookup(ctx context.Context, ip string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	names, err := d.resolver.LookupAddr(ctx, ip)
+	if notFound(err) || (err == nil && len(names) == 0) {
+		return "", fmt.Sprintf("%s has no PTR record", ip), nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	target := net.ParseIP(ip)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			continue
+		}
+		addrs, err := d.resolver.LookupIPAddr(ctx, name)
+		if err != nil && !notFound(err) {
+			return "", "", err
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(target) {
+				return name, "", nil
+			}
+		}
+	}
+	return "", fmt.Sprintf("the PTR records of %s (%s) do not resolve to it", ip, strings.Join(names, ", ")), nil
+}
+
+// notFound reports whether a lookup failed as the record does not exist
+func notFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// setAddress sets the address of a type, or removes it if empty
+func setAddress(addressMap map[v1.NodeAddressType]string, addrType v1.NodeAddressType, address string) {
+	if address == "" {
+		delete(addressMap, addrType)
+	} else {
+		addressMap[addrType] = address
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// Reasons of the events recorded on the node
+const (
+	// NodeIPDriftReason is recorded when the node IP of kubelet differs from
+	// the detected InternalIP
+	NodeIPDriftReason = "NodeIPDrift"
+	// NodeIPDriftResolvedReason is recorded when they match again
+	NodeIPDriftResolvedReason = "NodeIPDriftResolved"
+)
+
+// driftMonitor compares the node IP kubelet was started with to the detected
+// InternalIP. Disagreement breaks kube-proxy and CNIs configured with the
+// node IP of kubelet, so it is reported as metric and event.
+type driftMonitor struct {
+	events *nodeEvents
+
+	mu sync.Mutex
+	// reported is the kubelet node IP of the drift last reported, empty if
+	// the addresses matched
+	reported string
+}
+
+// check compares the ProvidedNodeIPAnnotation of the node to the detected
+// InternalIP of the same family. Nodes without the annotation, i.e. kubelet
+// running without an external cloud provider or --node-ip, are skipped.
+func (d *driftMonitor) check(currentNode *v1.Node, detected string) {
+	detectedIP := net.ParseIP(detected)
+	if detectedIP == nil {
+		return
+	}
+	detected = detectedIP.String()
+	kubeletIP := kubeletNodeIP(currentNode, detectedIP)
+	if kubeletIP == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if kubeletIP == detected {
+		metrics.NodeIPDrift.Set(0)
+		if d.reported != "" {
+			klog.Infof("Kubelet node IP %s matches the detected InternalIP again", kubeletIP)
+			d.events.record(currentNode, v1.EventTypeNormal, NodeIPDriftResolvedReason,
+				fmt.Sprintf("Kubelet node IP %s matches the detected InternalIP", kubeletIP))
+			d.reported = ""
+		}
+		return
+	}
+
+	metrics.NodeIPDrift.Set(1)
+	if d.reported == kubeletIP {
+		return
+	}
+	klog.Warningf("Kubelet node IP %s differs from the detected InternalIP %s", kubeletIP, detected)
+	d.events.record(currentNode, v1.EventTypeWarning, NodeIPDriftReason,
+		fmt.Sprintf("Kubelet node IP %s differs from the detected InternalIP %s, kube-proxy and the CNI may use the wrong address until kubelet is restarted with --node-ip=%s", kubeletIP, detected, detected))
+	d.reported = kubeletIP
+}
+
+// kubeletNodeIP returns the node IP of kubelet of the family of detected, or
+// empty if kubelet has none
+func kubeletNodeIP(currentNode *v1.Node, detected net.IP) string {
+	// Dual-stack nodes list an IPv4 and an IPv6 address
+	for _, value := range strings.Split(currentNode.Annotations[ProvidedNodeIPAnnotation], ",") {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip != nil && (ip.To4() == nil) == (detected.To4() == nil) {
+			return ip.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"errors"
+)
+
+// Exit codes of run-once mode, so bootstrap scripts and init containers can
+// tell failures apart. ExitConfigError also applies to the loop.
+const (
+	// ExitOK means the node was fully reconciled
+	ExitOK = 0
+	// ExitError means local-ccm failed for another reason
+	ExitError = 1
+	// ExitDetectionFailed means an address could not be detected
+	ExitDetectionFailed = 2
+	// ExitAPIFailed means a request to the API server failed
+	ExitAPIFailed = 3
+	// ExitConfigError means the configuration is invalid
+	ExitConfigError = 4
+	// ExitPartialSuccess means some steps of the reconciliation succeeded
+	// while others failed
+	ExitPartialSuccess = 5
+	// ExitTimeout means the reconciliation did not finish in time
+	ExitTimeout = 6
+)
+
+// ConfigError reports an invalid configuration
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// DetectionError reports a failed address detection
+type DetectionError struct {
+	Err error
+}
+
+func (e *DetectionError) Error() string { return e.Err.Error() }
+func (e *DetectionError) Unwrap() error { return e.Err }
+
+// APIError reports a failed request to the API server
+type APIError struct {
+	Err error
+}
+
+func (e *APIError) Error() string { return e.Err.Error() }
+func (e *APIError) Unwrap() error { return e.Err }
+
+// PartialError reports a reconciliation of which some steps succeeded
+type PartialError struct {
+	Err error
+}
+
+func (e *PartialError) Error() string { return e.Err.Error() }
+func (e *PartialError) Unwrap() error { return e.Err }
+
+// ExitCode returns the exit code of run-once mode for an error returned by Run
+func ExitCode(err error) int {
+	var detectionErr *DetectionError
+	var apiErr *APIError
+	var partialErr *PartialError
+	var configErr *ConfigError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &configErr):
+		return ExitConfigError
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.As(err, &partialErr):
+		return ExitPartialSuccess
+	case errors.As(err, &detectionErr):
+		return ExitDetectionFailed
+	case errors.As(err, &apiErr):
+		return ExitAPIFailed
+	default:
+		return ExitError
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// nodeEvents records events on the node. Events are dropped until it is
+// started, e.g. in run-once mode.
+type nodeEvents struct {
+	mu       sync.Mutex
+	recorder record.EventRecorder
+}
+
+// start records events until ctx is done
+func (e *nodeEvents) start(ctx context.Context, client typedcorev1.EventsGetter) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.Events("")})
+	go func() {
+		<-ctx.Done()
+		broadcaster.Shutdown()
+	}()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "local-ccm"})
+}
+
+// record records an event on the node if started
+func (e *nodeEvents) record(currentNode *v1.Node, eventType, reason, message string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.recorder != nil {
+		e.recorder.Event(currentNode, eventType, reason, message)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/facts"
+)
+
+// factsEnabled reports whether any label or annotation is rendered from facts
+func (r *runner) factsEnabled() bool {
+	return len(r.factLabels) > 0 || len(r.factAnnotations) > 0
+}
+
+// renderFacts renders the templates by key with the facts of the node. If a
+// template cannot be rendered, e.g. as a fact is unknown while detection
+// fails, or renders an invalid label value, the current value is kept.
+func renderFacts(templates map[string]*facts.Template, nodeFacts facts.Facts, current map[string]string, label bool) map[string]string {
+	values := make(map[string]string, len(templates))
+	for key, template := range templates {
+		value, err := template.Execute(nodeFacts)
+		if err == nil && label {
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				err = fmt.Errorf("invalid label value %q: %s", value, strings.Join(errs, ", "))
+			}
+		}
+		if err != nil {
+			klog.V(2).Infof("Keeping %s of the node: %v", key, err)
+			if value, ok := current[key]; ok {
+				values[key] = value
+			}
+			continue
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// validateFactTemplates checks the "key=template" pairs of labels or
+// annotations
+func validateFactTemplates(pairs []string) error {
+	templates, err := facts.ParseTemplates(pairs)
+	if err != nil {
+		return err
+	}
+	for key := range templates {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid key %q: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
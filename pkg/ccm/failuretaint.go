@@ -0,0 +1,108 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// DefaultDetectionTaint is the key of the NoSchedule taint applied
+// while detection keeps failing
+const DefaultDetectionTaint = "local-ccm.io/detection-failed"
+
+// Reasons of the events recorded on the node
+const (
+	// DetectionFailureTaintedReason is recorded when the node is tainted as
+	// its addresses could not be detected for too long
+	DetectionFailureTaintedReason = "DetectionFailureTainted"
+	// DetectionRecoveredReason is recorded when the taint is removed as the
+	// addresses are detected again
+	DetectionRecoveredReason = "DetectionRecovered"
+)
+
+// failureTaint records the detection failures for the detection failure
+// taint
+type failureTaint struct {
+	// failing measures for how long detection failed
+	failing staleClock
+
+	mu sync.Mutex
+	// detected records that detection succeeded since the start
+	detected bool
+}
+
+// syncFailureTaint taints the node while the detection of its addresses
+// fails for longer than DetectionTaintAfter after it succeeded once,
+// so the scheduler avoids a node whose network identity is unknown, and
+// removes the taint once detection succeeds again
+func (r *runner) syncFailureTaint(ctx context.Context, currentNode *v1.Node, failed bool) error {
+	f, key := r.failureTaint, r.config.DetectionTaint
+	tainted := hasTaint(currentNode, key)
+	if !failed {
+		f.failing.reset()
+		f.mu.Lock()
+		f.detected = true
+		f.mu.Unlock()
+		if !tainted {
+			return nil
+		}
+		if err := r.nodeUpdater.RemoveTaintKey(ctx, key); err != nil {
+			return fmt.Errorf("failed to remove detection failure taint: %w", err)
+		}
+		r.events.record(currentNode, v1.EventTypeNormal, DetectionRecoveredReason,
+			fmt.Sprintf("Addresses are detected again, removed taint %s", key))
+		return nil
+	}
+
+	// A node never detected since the start keeps the taints it has, e.g.
+	// the uninitialized taint or this one from before a restart
+	f.mu.Lock()
+	detected := f.detected
+	f.mu.Unlock()
+	if !detected || tainted {
+		return nil
+	}
+	failing := f.failing.observe(time.Now())
+	if failing < r.config.DetectionTaintAfter {
+		return nil
+	}
+
+	klog.Warningf("Detection of node %s failing for %v, adding taint %s", currentNode.Name, failing.Round(time.Second), key)
+	taint := v1.Taint{Key: key, Value: "true", Effect: v1.TaintEffectNoSchedule}
+	if err := r.nodeUpdater.AddTaint(ctx, taint); err != nil {
+		return fmt.Errorf("failed to add detection failure taint: %w", err)
+	}
+	r.events.record(currentNode, v1.EventTypeWarning, DetectionFailureTaintedReason,
+		fmt.Sprintf("Addresses could not be detected for %v, added taint %s", failing.Round(time.Second), key))
+	return nil
+}
+
+// hasTaint reports whether the node has a taint with the key
+func hasTaint(n *v1.Node, key string) bool {
+	for _, taint := range n.Spec.Taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+	return false
+}
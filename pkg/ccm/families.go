@@ -0,0 +1,126 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+const (
+	// AddressFamilyIPv4 detects and publishes IPv4 addresses
+	AddressFamilyIPv4 = "ipv4"
+	// AddressFamilyIPv6 detects and publishes IPv6 addresses
+	AddressFamilyIPv6 = "ipv6"
+
+	// DefaultExternalIPv6Target is the default IPv6 target of external IP
+	// detection
+	DefaultExternalIPv6Target = "2606:4700::1111"
+)
+
+// validateAddressFamilies checks the address families and the detection
+// targets, which must be addresses of the families
+func (c *Config) validateAddressFamilies() error {
+	for _, family := range c.AddressFamilies {
+		switch family {
+		case AddressFamilyIPv4, AddressFamilyIPv6:
+		default:
+			return fmt.Errorf("unknown address family %q, must be %s or %s", family, AddressFamilyIPv4, AddressFamilyIPv6)
+		}
+	}
+	for name, targets := range map[string]string{
+		"internal IP": c.InternalIPTarget,
+		"external IP": c.ExternalIPTarget,
+		"egress IP":   c.EgressIPTarget,
+	} {
+		for _, target := range strings.Split(targets, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(target)); ip != nil && !c.allowsFamily(ip) {
+				return fmt.Errorf("%s target %s is not an address of the address families %s", name, ip, strings.Join(c.AddressFamilies, ","))
+			}
+		}
+	}
+	return nil
+}
+
+// validateDefaultTargets checks that the default targets are addresses of
+// their families
+func (c *Config) validateDefaultTargets() error {
+	if addressFamily(c.DefaultIPv4Target) != AddressFamilyIPv4 {
+		return fmt.Errorf("default IPv4 target %q is not an IPv4 address", c.DefaultIPv4Target)
+	}
+	if addressFamily(c.DefaultIPv6Target) != AddressFamilyIPv6 {
+		return fmt.Errorf("default IPv6 target %q is not an IPv6 address", c.DefaultIPv6Target)
+	}
+	return nil
+}
+
+// defaultTargets returns the default targets of the address families,
+// IPv4 first
+func (c *Config) defaultTargets() string {
+	var targets []string
+	if len(c.AddressFamilies) == 0 || slices.Contains(c.AddressFamilies, AddressFamilyIPv4) {
+		targets = append(targets, c.DefaultIPv4Target)
+	}
+	if len(c.AddressFamilies) == 0 || slices.Contains(c.AddressFamilies, AddressFamilyIPv6) {
+		targets = append(targets, c.DefaultIPv6Target)
+	}
+	return strings.Join(targets, ",")
+}
+
+// allowsFamily returns whether the address is of the address families, all
+// families if none are set
+func (c *Config) allowsFamily(ip net.IP) bool {
+	if len(c.AddressFamilies) == 0 {
+		return true
+	}
+	if ip.To4() != nil {
+		return slices.Contains(c.AddressFamilies, AddressFamilyIPv4)
+	}
+	return slices.Contains(c.AddressFamilies, AddressFamilyIPv6)
+}
+
+// checkFamily fails a detection of an address outside the address families
+func (c *Config) checkFamily(detection detector.Detection) detector.Detection {
+	ip := net.ParseIP(detection.Address)
+	if detection.Error != "" || ip == nil || c.allowsFamily(ip) {
+		return detection
+	}
+	reason := fmt.Sprintf("not an address of the address families %s", strings.Join(c.AddressFamilies, ","))
+	detection.Filtered = append(detection.Filtered, detector.Candidate{Address: detection.Address, Reason: reason})
+	detection.Error = fmt.Sprintf("detected address %s is %s", detection.Address, reason)
+	detection.Address = ""
+	return detection
+}
+
+// dropOtherFamilies removes the InternalIP and ExternalIP outside the
+// address families, such as an IPv4 InternalIP preserved from kubelet
+func (c *Config) dropOtherFamilies(addressMap map[v1.NodeAddressType]string) {
+	for _, addrType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		ip := net.ParseIP(addressMap[addrType])
+		if ip != nil && !c.allowsFamily(ip) {
+			klog.Warningf("Not publishing %s %s, which is not an address of the address families %s", addrType, ip, strings.Join(c.AddressFamilies, ","))
+			delete(addressMap, addrType)
+		}
+	}
+}
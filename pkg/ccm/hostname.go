@@ -0,0 +1,70 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// HostnamePolicyShort publishes the hostname up to the first dot
+	HostnamePolicyShort = "short"
+	// HostnamePolicyFQDN publishes the fully qualified hostname
+	HostnamePolicyFQDN = "fqdn"
+)
+
+// nodeHostname returns the Hostname address of the node: the hostname
+// override or the hostname of the host, shaped by the hostname policy. The
+// FQDN is the hostname with HostnameDomain appended, the hostname itself if
+// it is qualified, or its canonical name in DNS.
+func (r *runner) nodeHostname(ctx context.Context) (string, error) {
+	// Like kubelet, use the override instead of the hostname of the host
+	hostname := strings.TrimSpace(r.config.HostnameOverride)
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			return "", fmt.Errorf("failed to get hostname: %w", err)
+		}
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	short, _, qualified := strings.Cut(hostname, ".")
+
+	switch {
+	case r.config.HostnamePolicy == "":
+		return hostname, nil
+	case r.config.HostnamePolicy == HostnamePolicyShort:
+		return short, nil
+	case r.config.HostnameDomain != "":
+		return short + "." + strings.Trim(r.config.HostnameDomain, "."), nil
+	case qualified:
+		return hostname, nil
+	}
+
+	cname, err := net.DefaultResolver.LookupCNAME(ctx, hostname)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up FQDN of %s: %w", hostname, err)
+	}
+	fqdn := strings.ToLower(strings.TrimSuffix(cname, "."))
+	if !strings.Contains(fqdn, ".") {
+		return "", fmt.Errorf("hostname %s has no FQDN, set a hostname domain", hostname)
+	}
+	return fqdn, nil
+}
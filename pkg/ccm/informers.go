@@ -0,0 +1,78 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+)
+
+// lastAppliedAnnotation holds the last applied configuration of kubectl apply
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// sharedInformers is the informer factory shared by all controllers of the
+// runner, so each resource is watched and cached once however many
+// controllers use it. Informers keep running when a controller stops, e.g.
+// on a lost lease, until the runner shuts them down.
+type sharedInformers struct {
+	factory informers.SharedInformerFactory
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// newSharedInformers creates an informer factory caching trimmed nodes
+func newSharedInformers(client kubernetes.Interface) *sharedInformers {
+	return &sharedInformers{
+		factory: informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTransform(trimObject)),
+		stop:    make(chan struct{}),
+	}
+}
+
+// start starts the informers requested since the last start
+func (s *sharedInformers) start() {
+	s.factory.Start(s.stop)
+}
+
+// shutdown stops the informers and waits for them to return. Informers
+// requested afterwards are not started.
+func (s *sharedInformers) shutdown() {
+	s.once.Do(func() { close(s.stop) })
+	s.factory.Shutdown()
+}
+
+// trimObject drops the fields no controller reads from nodes before they are
+// cached. Node images and volumes make up most of a Node object, which adds
+// up when watching thousands of nodes. Nodes are only patched, never updated
+// from the cache. Other objects, such as Services and DaemonSets, are written
+// back with Update and are cached as they are.
+func trimObject(obj interface{}) (interface{}, error) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return obj, nil
+	}
+	node.ManagedFields = nil
+	if node.Annotations[lastAppliedAnnotation] != "" {
+		delete(node.Annotations, lastAppliedAnnotation)
+	}
+	node.Status.Images = nil
+	node.Status.VolumesInUse = nil
+	node.Status.VolumesAttached = nil
+	return obj, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+// InitializingTaintKey is the key of the NoSchedule taint gating a
+// registering node until its addresses are verified
+const InitializingTaintKey = "local-ccm.io/initializing"
+
+// InitializationVerifiedReason is recorded when the initializing taint is
+// removed from the node
+const InitializationVerifiedReason = "InitializationVerified"
+
+// initializingTaint is the taint gating a registering node
+var initializingTaint = v1.Taint{Key: InitializingTaintKey, Value: "true", Effect: v1.TaintEffectNoSchedule}
+
+// syncInitializingTaint gates a registering node with the initializing
+// taint until it is verified: its addresses were detected, are published,
+// and the targets answered the probes. A node is registering while it has
+// the uninitialized taint, or the initializing taint added by the webhook.
+func (r *runner) syncInitializingTaint(ctx context.Context, currentNode *v1.Node, report *detector.Report, verified bool) error {
+	for _, detection := range []*detector.Detection{report.Internal, report.External} {
+		if detection != nil && detection.Degraded != "" {
+			klog.V(2).Infof("Node %s not verified: %s", currentNode.Name, detection.Degraded)
+			verified = false
+		}
+	}
+
+	if hasTaint(currentNode, InitializingTaintKey) {
+		if !verified {
+			klog.V(2).Infof("Keeping taint %s until node %s is verified", InitializingTaintKey, currentNode.Name)
+			return nil
+		}
+		if err := r.nodeUpdater.RemoveTaintKey(ctx, InitializingTaintKey); err != nil {
+			return fmt.Errorf("failed to remove initializing taint: %w", err)
+		}
+		r.events.record(currentNode, v1.EventTypeNormal, InitializationVerifiedReason,
+			fmt.Sprintf("Addresses are verified, removed taint %s", InitializingTaintKey))
+		return nil
+	}
+
+	if verified || !hasUninitializedTaint(currentNode) {
+		return nil
+	}
+	if err := r.nodeUpdater.AddTaint(ctx, initializingTaint); err != nil {
+		return fmt.Errorf("failed to add initializing taint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,469 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// inventoryControllerLeaseName is the name of the Lease used to elect
+	// the single instance publishing the addresses of the inventories
+	inventoryControllerLeaseName = "local-ccm-inventory-controller"
+
+	// inventoryStaleIntervals is the number of reconcile intervals after
+	// which the report of an agent is no longer trusted
+	inventoryStaleIntervals = 3
+)
+
+// runInventoryAgent reports the addresses detected on this host into the
+// NodeInventory of Config.Inventory every reconcile interval until ctx is
+// done. The node is not touched, the inventory controller publishes the
+// addresses on it.
+func (r *runner) runInventoryAgent(ctx context.Context) error {
+	namespace, name := splitRef(r.config.Inventory, r.config.Namespace)
+	klog.Infof("Starting local-ccm %s reporting the addresses of node %s to NodeInventory %s/%s", version.Version, r.config.NodeName, namespace, name)
+
+	for {
+		err := r.reportInventory(ctx, namespace, name)
+		if err != nil {
+			klog.Errorf("Failed to report to NodeInventory %s/%s: %v", namespace, name, err)
+		} else {
+			klog.V(2).Infof("Reported to NodeInventory %s/%s", namespace, name)
+		}
+		if r.config.RunOnce {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.config.ReconcileInterval):
+		}
+	}
+}
+
+// reportInventory patches the detections of this host into the status of
+// the inventory, leaving the conditions of the inventory controller intact
+func (r *runner) reportInventory(ctx context.Context, namespace, name string) error {
+	status := map[string]interface{}{
+		"detections": r.inventoryDetections(),
+		"reportTime": metav1.Now(),
+	}
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+	_, err = r.dynamicClient.Resource(v1alpha1.NodeInventoryResource).Namespace(namespace).Patch(ctx, name,
+		types.MergePatchType, patch, metav1.PatchOptions{FieldManager: version.FieldManager()}, "status")
+	return err
+}
+
+// inventoryDetections detects each target of the configured address types
+// on its own, so the inventory controller can fall back to the next target
+// of a list like an agent would. A target used for several types is
+// reported once, by the detector of the first type.
+func (r *runner) inventoryDetections() []v1alpha1.DetectionStatus {
+	var detections []v1alpha1.DetectionStatus
+	reported := make(map[string]bool)
+	for _, t := range []struct {
+		addressType string
+		detector    detector.Detector
+		targets     string
+		enabled     bool
+	}{
+		{string(v1.NodeInternalIP), r.detectorFor(v1.NodeInternalIP), r.config.InternalIPTarget, r.config.detectsInternalIP()},
+		{string(v1.NodeExternalIP), r.detectorFor(v1.NodeExternalIP), r.config.ExternalIPTarget, true},
+		{"EgressIP", r.detector, r.config.EgressIPTarget, r.config.EgressIPTarget != ""},
+	} {
+		if !t.enabled {
+			continue
+		}
+		for _, target := range r.config.orderedTargets(t.targets) {
+			if reported[target] {
+				continue
+			}
+			reported[target] = true
+			d := t.detector.Detect(target)
+			detection := v1alpha1.DetectionStatus{
+				Type:      t.addressType,
+				Strategy:  d.Strategy,
+				Target:    target,
+				Interface: d.Interface,
+				Gateway:   d.Gateway,
+				Address:   d.Address,
+				Error:     d.Error,
+				Degraded:  d.Degraded,
+			}
+			for _, candidate := range d.Filtered {
+				detection.Candidates = append(detection.Candidates, v1alpha1.CandidateStatus{
+					Address: candidate.Address,
+					Reason:  candidate.Reason,
+				})
+			}
+			detections = append(detections, detection)
+		}
+	}
+	return detections
+}
+
+// inventoryDetector returns the detections reported by the agent of an
+// inventory. Detection fails once the report is older than maxAge, keeping
+// the published addresses like a failing detection on the host would.
+type inventoryDetector struct {
+	maxAge time.Duration
+
+	mu         sync.Mutex
+	detections map[string]v1alpha1.DetectionStatus
+	reportTime time.Time
+}
+
+// set replaces the report with the status of the inventory
+func (d *inventoryDetector) set(status v1alpha1.NodeInventoryStatus) {
+	detections := make(map[string]v1alpha1.DetectionStatus, len(status.Detections))
+	for _, detection := range status.Detections {
+		detections[detection.Target] = detection
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.detections, d.reportTime = detections, status.ReportTime.Time
+}
+
+// Detect returns the reported detection of target
+func (d *inventoryDetector) Detect(target string) detector.Detection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	detection := detector.Detection{Target: target}
+	if d.reportTime.IsZero() {
+		detection.Error = "the agent did not report yet"
+		return detection
+	}
+	if age := time.Since(d.reportTime); age > d.maxAge {
+		detection.Error = fmt.Sprintf("the last report of the agent is %s old", age.Round(time.Second))
+		return detection
+	}
+	reported, ok := d.detections[target]
+	if !ok {
+		detection.Error = fmt.Sprintf("the agent did not report target %s", target)
+		return detection
+	}
+	detection.Strategy = reported.Strategy
+	detection.Interface = reported.Interface
+	detection.Gateway = reported.Gateway
+	detection.Address = reported.Address
+	detection.Error = reported.Error
+	detection.Degraded = reported.Degraded
+	for _, candidate := range reported.Candidates {
+		detection.Filtered = append(detection.Filtered, detector.Candidate{
+			Address: candidate.Address,
+			Reason:  candidate.Reason,
+		})
+	}
+	return detection
+}
+
+// inventoryController publishes the addresses reported into the
+// NodeInventories on their nodes, in the clusters of their kubeconfig
+// secrets
+type inventoryController struct {
+	parent   *runner
+	informer cache.SharedIndexInformer
+	queue    workqueue.TypedRateLimitingInterface[string]
+
+	mu       sync.Mutex
+	clusters map[string]*inventoryCluster
+	runners  map[string]*inventoryRunner
+}
+
+// inventoryCluster holds the clients of a kubeconfig secret
+type inventoryCluster struct {
+	kubeconfig    []byte
+	loaded        time.Time
+	client        kubernetes.Interface
+	dynamicClient dynamic.Interface
+}
+
+// inventoryRunner reconciles the node of an inventory
+type inventoryRunner struct {
+	runner   *runner
+	detector *inventoryDetector
+	// cluster and nodeName the runner was created for
+	cluster  *inventoryCluster
+	nodeName string
+}
+
+// runInventoryController reconciles the nodes of the NodeInventories until
+// ctx is done
+func (r *runner) runInventoryController(ctx context.Context) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(r.dynamicClient, 0)
+	ic := &inventoryController{
+		parent:   r,
+		informer: factory.ForResource(v1alpha1.NodeInventoryResource).Informer(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "node-inventory"},
+		),
+		clusters: make(map[string]*inventoryCluster),
+		runners:  make(map[string]*inventoryRunner),
+	}
+	defer ic.queue.ShutDown()
+
+	if _, err := ic.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: ic.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Skip the updates of the conditions written by this controller
+			oldInventory, ok1 := oldObj.(*unstructured.Unstructured)
+			newInventory, ok2 := newObj.(*unstructured.Unstructured)
+			if ok1 && ok2 && oldInventory.GetGeneration() == newInventory.GetGeneration() &&
+				reportTime(oldInventory) == reportTime(newInventory) {
+				return
+			}
+			ic.enqueue(newObj)
+		},
+		DeleteFunc: ic.enqueue,
+	}); err != nil {
+		klog.Errorf("Failed to add NodeInventory event handler: %v", err)
+		return
+	}
+
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	klog.Infof("Starting inventory controller with %d workers", r.config.ConcurrentNodeSyncs)
+	if !cache.WaitForCacheSync(ctx.Done(), ic.informer.HasSynced) {
+		klog.Error("Failed to wait for inventory controller caches to sync")
+		return
+	}
+
+	for i := 0; i < r.config.ConcurrentNodeSyncs; i++ {
+		go wait.UntilWithContext(ctx, ic.worker, time.Second)
+	}
+
+	<-ctx.Done()
+	klog.Info("Stopping inventory controller")
+}
+
+// reportTime returns the report time of an inventory as written
+func reportTime(u *unstructured.Unstructured) string {
+	value, _, _ := unstructured.NestedString(u.Object, "status", "reportTime")
+	return value
+}
+
+func (ic *inventoryController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	ic.queue.Add(key)
+}
+
+func (ic *inventoryController) worker(ctx context.Context) {
+	for ic.processNextItem(ctx) {
+	}
+}
+
+func (ic *inventoryController) processNextItem(ctx context.Context) bool {
+	key, quit := ic.queue.Get()
+	if quit {
+		return false
+	}
+	defer ic.queue.Done(key)
+
+	requeue, err := ic.sync(ctx, key)
+	if err != nil {
+		klog.Errorf("Failed to reconcile the node of NodeInventory %s: %v", key, err)
+		ic.queue.AddRateLimited(key)
+		return true
+	}
+
+	ic.queue.Forget(key)
+	if requeue {
+		ic.queue.AddAfter(key, ic.parent.config.ReconcileInterval)
+	}
+	return true
+}
+
+// sync reconciles the node of an inventory and reports whether it is due
+// again after the reconcile interval
+func (ic *inventoryController) sync(ctx context.Context, key string) (bool, error) {
+	obj, exists, err := ic.informer.GetStore().GetByKey(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to get NodeInventory: %w", err)
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !exists || !ok {
+		ic.mu.Lock()
+		delete(ic.runners, key)
+		ic.mu.Unlock()
+		return false, nil
+	}
+	inventory := &v1alpha1.NodeInventory{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, inventory); err != nil {
+		return false, fmt.Errorf("invalid NodeInventory: %w", err)
+	}
+
+	cluster, err := ic.clusterFor(ctx, inventory)
+	if err != nil {
+		return false, ic.setCondition(ctx, inventory, "KubeconfigUnavailable", err)
+	}
+	ir, err := ic.runnerFor(key, inventory, cluster)
+	if err != nil {
+		return false, ic.setCondition(ctx, inventory, "InvalidConfig", err)
+	}
+	ir.detector.set(inventory.Status)
+	if err := ir.runner.reconcile(ctx); err != nil {
+		return false, ic.setCondition(ctx, inventory, "ReconcileFailed", err)
+	}
+	return true, ic.setCondition(ctx, inventory, "Reconciled", nil)
+}
+
+// clusterFor returns the clients of the kubeconfig secret of an inventory,
+// re-reading the secret every targetKubeconfigReloadPeriod, so rotated
+// credentials are picked up
+func (ic *inventoryController) clusterFor(ctx context.Context, inventory *v1alpha1.NodeInventory) (*inventoryCluster, error) {
+	ref := inventory.Spec.KubeconfigSecretRef
+	if ref.Key == "" {
+		ref.Key = DefaultTargetKubeconfigKey
+	}
+	id := inventory.Namespace + "/" + ref.Name + ":" + ref.Key
+
+	ic.mu.Lock()
+	cluster := ic.clusters[id]
+	ic.mu.Unlock()
+	if cluster != nil && time.Since(cluster.loaded) < targetKubeconfigReloadPeriod {
+		return cluster, nil
+	}
+
+	secret, err := ic.parent.client.CoreV1().Secrets(inventory.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubeconfig secret %s/%s: %w", inventory.Namespace, ref.Name, err)
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("kubeconfig secret %s/%s has no key %s", inventory.Namespace, ref.Name, ref.Key)
+	}
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if cluster := ic.clusters[id]; cluster != nil && bytes.Equal(cluster.kubeconfig, data) {
+		cluster.loaded = time.Now()
+		return cluster, nil
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig from secret %s/%s: %w", inventory.Namespace, ref.Name, err)
+	}
+	traceRequests(restConfig, ic.parent.config)
+	restConfig.QPS = ic.parent.config.QPS
+	restConfig.Burst = ic.parent.config.Burst
+	restConfig.UserAgent = ic.parent.config.UserAgent
+	cluster = &inventoryCluster{kubeconfig: data, loaded: time.Now()}
+	if cluster.client, err = kubernetes.NewForConfig(restConfig); err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	if cluster.dynamicClient, err = dynamic.NewForConfig(restConfig); err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	klog.V(2).Infof("Loaded kubeconfig of %s from secret %s/%s", restConfig.Host, inventory.Namespace, ref.Name)
+	ic.clusters[id] = cluster
+	return cluster, nil
+}
+
+// runnerFor returns the runner of an inventory, recreated if its node or
+// the kubeconfig of its cluster changed. Runners keep the state of the node
+// between reconciliations.
+func (ic *inventoryController) runnerFor(key string, inventory *v1alpha1.NodeInventory, cluster *inventoryCluster) (*inventoryRunner, error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if ir := ic.runners[key]; ir != nil && ir.cluster == cluster && ir.nodeName == inventory.Spec.NodeName {
+		return ir, nil
+	}
+
+	d := &inventoryDetector{maxAge: inventoryStaleIntervals * ic.parent.config.ReconcileInterval}
+	config := ic.parent.nodeRunnerConfig(inventory.Spec.NodeName)
+	config.Client = cluster.client
+	config.DynamicClient = cluster.dynamicClient
+	config.Detector = d
+	nr, err := newRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("Reconciling node %s of NodeInventory %s", inventory.Spec.NodeName, key)
+	ir := &inventoryRunner{runner: nr, detector: d, cluster: cluster, nodeName: inventory.Spec.NodeName}
+	ic.runners[key] = ir
+	return ir, nil
+}
+
+// setCondition sets the NodeSynced condition of an inventory, true if err
+// is nil, and returns err
+func (ic *inventoryController) setCondition(ctx context.Context, inventory *v1alpha1.NodeInventory, reason string, err error) error {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionNodeSynced,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: inventory.Generation,
+		Reason:             reason,
+		Message:            fmt.Sprintf("Published the addresses on node %s", inventory.Spec.NodeName),
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Message = err.Error()
+	}
+	conditions := append([]metav1.Condition(nil), inventory.Status.Conditions...)
+	if !meta.SetStatusCondition(&conditions, condition) {
+		return err
+	}
+
+	patch, marshalErr := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": conditions},
+	})
+	if marshalErr != nil {
+		klog.Errorf("Failed to marshal conditions of NodeInventory %s/%s: %v", inventory.Namespace, inventory.Name, marshalErr)
+		return err
+	}
+	if _, patchErr := ic.parent.dynamicClient.Resource(v1alpha1.NodeInventoryResource).Namespace(inventory.Namespace).Patch(ctx, inventory.Name,
+		types.MergePatchType, patch, metav1.PatchOptions{FieldManager: version.FieldManager()}, "status"); patchErr != nil {
+		klog.Errorf("Failed to update conditions of NodeInventory %s/%s: %v", inventory.Namespace, inventory.Name, patchErr)
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/facts"
+)
+
+// HasPublicIPLabel tells whether the ExternalIP of the node is public, i.e.
+// reachable for inbound connections
+const HasPublicIPLabel = "local-ccm.io/has-public-ip"
+
+// labelsEnabled reports whether any managed label is configured
+func (r *runner) labelsEnabled() bool {
+	return r.nodeZones.Enabled() || r.config.ExcludeFromLoadBalancers != "" || r.config.PublicIPLabel || len(r.factLabels) > 0
+}
+
+// syncLabels publishes the managed labels of the node. They are updated
+// together, as a server-side apply must hold all labels of its manager.
+// publicIP is nil if the ExternalIP could not be detected.
+func (r *runner) syncLabels(ctx context.Context, currentNode *v1.Node, publicIP *bool, nodeFacts facts.Facts) error {
+	labels := make(map[string]string)
+	var remove []string
+	// Keep the published value of a label that cannot be told, as an apply
+	// without it would remove it
+	keepLabel := func(key string) {
+		if current, ok := currentNode.Labels[key]; ok {
+			labels[key] = current
+		}
+	}
+
+	if r.nodeZones.Enabled() {
+		nodeZone, err := r.nodeZones.GetZone(ctx)
+		if err != nil {
+			return &APIError{Err: fmt.Errorf("failed to get zone: %w", err)}
+		}
+		for key, value := range nodeZone.Labels() {
+			labels[key] = value
+		}
+	}
+
+	if r.config.PublicIPLabel {
+		if publicIP != nil {
+			labels[HasPublicIPLabel] = strconv.FormatBool(*publicIP)
+		} else {
+			keepLabel(HasPublicIPLabel)
+		}
+	}
+
+	for key, value := range renderFacts(r.factLabels, nodeFacts, currentNode.Labels, true) {
+		labels[key] = value
+	}
+
+	if exclude, known := r.excludeFromLoadBalancers(publicIP); !known {
+		if r.config.ExcludeFromLoadBalancers != "" {
+			keepLabel(v1.LabelNodeExcludeBalancers)
+		}
+	} else if exclude {
+		labels[v1.LabelNodeExcludeBalancers] = "true"
+	} else if _, ok := currentNode.Labels[v1.LabelNodeExcludeBalancers]; ok {
+		remove = append(remove, v1.LabelNodeExcludeBalancers)
+	}
+
+	changed := len(labels) > 0 && !hasLabels(currentNode.Labels, labels)
+	if !changed && len(remove) == 0 {
+		klog.V(3).Info("Labels unchanged, skipping update")
+		return nil
+	}
+	for key := range labels {
+		r.ownKeys.wrote(true, key)
+	}
+	r.ownKeys.wrote(true, remove...)
+	if changed {
+		klog.Info("Labels changed, updating node")
+		if err := r.nodeUpdater.UpdateLabels(ctx, labels); err != nil {
+			return &APIError{Err: fmt.Errorf("failed to update labels: %w", err)}
+		}
+	}
+	if len(remove) > 0 {
+		klog.Infof("Removing labels %v from node", remove)
+		if err := r.nodeUpdater.RemoveLabels(ctx, remove...); err != nil {
+			return &APIError{Err: fmt.Errorf("failed to remove labels: %w", err)}
+		}
+	}
+	return nil
+}
+
+// excludeFromLoadBalancers returns whether the node is excluded from external
+// load balancers, and false for known if it cannot be told yet
+func (r *runner) excludeFromLoadBalancers(publicIP *bool) (exclude, known bool) {
+	switch r.config.ExcludeFromLoadBalancers {
+	case ExcludeLoadBalancersAlways:
+		return true, true
+	case ExcludeLoadBalancersNever:
+		return false, true
+	case ExcludeLoadBalancersAuto:
+		if publicIP == nil {
+			return false, false
+		}
+		// NAT-only nodes cannot receive traffic from external load balancers
+		return !*publicIP, true
+	default:
+		return false, false
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Defaults of the leader election of the cluster-wide controllers, as of
+// kube-controller-manager
+const (
+	DefaultLeaderElectResourceLock  = resourcelock.LeasesResourceLock
+	DefaultLeaderElectLeaseDuration = 15 * time.Second
+	DefaultLeaderElectRenewDeadline = 10 * time.Second
+	DefaultLeaderElectRetryPeriod   = 2 * time.Second
+)
+
+// validateLeaderElection fills in the defaults of the leader election and
+// checks that the durations allow renewing the lease before it expires
+func (c *Config) validateLeaderElection() error {
+	if c.LeaderElectResourceLock == "" {
+		c.LeaderElectResourceLock = DefaultLeaderElectResourceLock
+	}
+	if _, err := resourcelock.New(c.LeaderElectResourceLock, "", "", nil, nil, resourcelock.ResourceLockConfig{}); err != nil {
+		return fmt.Errorf("invalid leader election resource lock: %w", err)
+	}
+	if c.LeaderElectNamespace == "" {
+		c.LeaderElectNamespace = c.Namespace
+	}
+	if c.LeaderElectIdentity == "" {
+		c.LeaderElectIdentity = c.NodeName
+	}
+	if c.LeaderElectLeaseDuration == 0 {
+		c.LeaderElectLeaseDuration = DefaultLeaderElectLeaseDuration
+	}
+	if c.LeaderElectRenewDeadline == 0 {
+		c.LeaderElectRenewDeadline = DefaultLeaderElectRenewDeadline
+	}
+	if c.LeaderElectRetryPeriod == 0 {
+		c.LeaderElectRetryPeriod = DefaultLeaderElectRetryPeriod
+	}
+	if c.LeaderElectLeaseDuration <= c.LeaderElectRenewDeadline {
+		return fmt.Errorf("leader election lease duration %v must be greater than the renew deadline %v",
+			c.LeaderElectLeaseDuration, c.LeaderElectRenewDeadline)
+	}
+	if c.LeaderElectRetryPeriod <= 0 {
+		return fmt.Errorf("leader election retry period must be positive")
+	}
+	if c.LeaderElectRenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(c.LeaderElectRetryPeriod)) {
+		return fmt.Errorf("leader election renew deadline %v must be greater than %v times the retry period %v",
+			c.LeaderElectRenewDeadline, leaderelection.JitterFactor, c.LeaderElectRetryPeriod)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// liveness reports on /healthz whether the reconciliation loop is wedged.
+// It is unhealthy once LivenessFailures reconciliations failed in a row
+// within LivenessWindow, or once no reconciliation completed for
+// LivenessWindow after the next one was due, so transient API server
+// errors do not restart every agent. Only failed API requests count as
+// failures: a detection failing for good, e.g. on a misconfigured node,
+// is not fixed by a restart.
+type liveness struct {
+	failures int
+	window   time.Duration
+
+	mu sync.Mutex
+	// failed records the times of the consecutive failed reconciliations
+	failed []time.Time
+	// due is when the next reconciliation should have completed
+	due time.Time
+}
+
+func newLiveness(failures int, window time.Duration) *liveness {
+	return &liveness{failures: failures, window: window, due: time.Now()}
+}
+
+// reconciled records a completed reconciliation and when the next one is
+// due after interval
+func (l *liveness) reconciled(err error, interval time.Duration) {
+	if l == nil {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.due = now.Add(interval)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		l.failed = nil
+		return
+	}
+	l.failed = append(l.failed, now)
+	// Only the failures within the window count
+	for len(l.failed) > 0 && now.Sub(l.failed[0]) > l.window {
+		l.failed = l.failed[1:]
+	}
+}
+
+// check returns why the loop is considered wedged, or nil
+func (l *liveness) check(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if stalled := now.Sub(l.due); stalled > l.window {
+		return fmt.Errorf("no reconciliation completed for %v after it was due", stalled.Round(time.Second))
+	}
+	if l.failures > 0 && len(l.failed) >= l.failures && now.Sub(l.failed[len(l.failed)-l.failures]) <= l.window {
+		return fmt.Errorf("last %d reconciliations failed within %v", l.failures, l.window)
+	}
+	return nil
+}
+
+// ServeHTTP reports the liveness of the loop
+func (l *liveness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if err := l.check(time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/metadata"
+)
+
+// runMetadataServer serves the instance metadata of the node until ctx is done
+func (r *runner) runMetadataServer(ctx context.Context) {
+	if r.config.MetadataLocalAddress {
+		// Validated on startup
+		host, _, _ := net.SplitHostPort(r.config.MetadataBindAddress)
+		remove, err := metadata.AddLocalAddress(net.ParseIP(host))
+		if err != nil {
+			klog.Errorf("Failed to add metadata address: %v", err)
+			return
+		}
+		defer remove()
+	}
+
+	// Watch only the own node, which is also allowed in self-node mode
+	factory := informers.NewSharedInformerFactoryWithOptions(r.client, 0,
+		informers.WithTransform(trimObject),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", r.config.NodeName).String()
+		}))
+	nodes := factory.Core().V1().Nodes()
+	informer := nodes.Informer()
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return
+	}
+
+	handler := metadata.NewHandler(func() (*metadata.Metadata, error) {
+		n, err := nodes.Lister().Get(r.config.NodeName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node: %w", err)
+		}
+		return r.nodeMetadata(n), nil
+	})
+	runHTTPServer(ctx, r.config.MetadataBindAddress, handler)
+}
+
+// nodeMetadata returns the instance metadata of the node, preferring the
+// latest detection over the published addresses
+func (r *runner) nodeMetadata(n *v1.Node) *metadata.Metadata {
+	md := &metadata.Metadata{
+		NodeName:   n.Name,
+		ProviderID: n.Spec.ProviderID,
+		Zone:       n.Labels[v1.LabelTopologyZone],
+		Region:     n.Labels[v1.LabelTopologyRegion],
+	}
+	for _, addr := range n.Status.Addresses {
+		switch addr.Type {
+		case v1.NodeInternalIP:
+			md.InternalIP = addr.Address
+		case v1.NodeExternalIP:
+			md.ExternalIP = addr.Address
+		}
+	}
+	if report := r.detection.Get(); report != nil {
+		addresses := report.Addresses()
+		if addresses.InternalIP != "" {
+			md.InternalIP = addresses.InternalIP
+		}
+		if addresses.ExternalIP != "" {
+			md.ExternalIP = addresses.ExternalIP
+		}
+	}
+	return md
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/netevents"
+)
+
+// networkEventsRetryInterval is the delay before watching a failed event
+// source again
+const networkEventsRetryInterval = 10 * time.Second
+
+// watchNetworkEvents watches the configured source of network events and
+// returns a channel receiving a value when the network changed, coalescing
+// changes until the next reconciliation. It returns nil if disabled.
+func (r *runner) watchNetworkEvents(ctx context.Context) <-chan struct{} {
+	source := r.config.NetworkEvents
+	if source == "" {
+		return nil
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		for ctx.Err() == nil {
+			err := netevents.Watch(ctx, source, func(reason string) {
+				klog.V(2).Infof("Network changed, reconciling: %s", reason)
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil {
+				klog.Errorf("Failed to watch %s network events, retrying in %v: %v", source, networkEventsRetryInterval, err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(networkEventsRetryInterval):
+			}
+		}
+	}()
+	return changed
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/metrics"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// ConflictPolicyTakeOver rewrites the addresses changed by another
+	// writer right away
+	ConflictPolicyTakeOver = "takeover"
+	// ConflictPolicyBackOff stops updating the addresses after another
+	// writer changed them, for exponentially longer while it keeps doing so
+	ConflictPolicyBackOff = "backoff"
+	// ConflictPolicyAlert leaves the addresses of another writer in place
+	ConflictPolicyAlert = "alert"
+)
+
+// Reasons of the events recorded on the node
+const (
+	// AddressConflictReason is recorded when another writer changed the
+	// addresses written by local-ccm
+	AddressConflictReason = "AddressConflict"
+	// AddressConflictResolvedReason is recorded when the addresses of
+	// local-ccm are kept again
+	AddressConflictResolvedReason = "AddressConflictResolved"
+)
+
+const (
+	// conflictBackoff is the first pause of the backoff policy, doubled on
+	// each further conflict up to maxConflictBackoff
+	conflictBackoff    = time.Minute
+	maxConflictBackoff = 30 * time.Minute
+)
+
+// ownershipGuard detects other writers of the addresses by comparing the
+// published addresses to the ones last written by local-ccm, so a fight over
+// the addresses is reported instead of silently patched over
+type ownershipGuard struct {
+	events *nodeEvents
+	policy string
+
+	mu sync.Mutex
+	// written are the addresses last written, and writtenAt when
+	written   []v1.NodeAddress
+	writtenAt time.Time
+	// conflicts counts the rewrites by others since the addresses were last
+	// kept for the backoff
+	conflicts int
+	// writer is the writer of the conflict last reported, empty if none
+	writer string
+	// until is the end of the backoff of the reported conflict
+	until time.Time
+}
+
+// allow reports whether the addresses may be updated, recording a conflict if
+// the published addresses differ from the ones last written
+func (g *ownershipGuard) allow(currentNode *v1.Node, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.written == nil {
+		return true
+	}
+
+	if addressesEqual(currentNode.Status.Addresses, g.written) {
+		if g.writer != "" {
+			klog.Infof("Addresses of node %s are no longer changed by %s", currentNode.Name, g.writer)
+			g.events.record(currentNode, v1.EventTypeNormal, AddressConflictResolvedReason,
+				fmt.Sprintf("Addresses written by local-ccm are no longer changed by %s", g.writer))
+			g.writer = ""
+		}
+		if now.Sub(g.writtenAt) > backoffFor(g.conflicts) {
+			g.conflicts = 0
+		}
+		return true
+	}
+
+	// Another writer changed the addresses since they were last written
+	if g.writer == "" {
+		g.writer = addressesWriter(currentNode)
+		g.conflicts++
+		g.until = now.Add(backoffFor(g.conflicts))
+		metrics.AddressConflicts.Inc(g.writer)
+
+		var action string
+		switch g.policy {
+		case ConflictPolicyTakeOver:
+			action = "rewriting them"
+		case ConflictPolicyBackOff:
+			action = fmt.Sprintf("pausing updates for %s", backoffFor(g.conflicts))
+		case ConflictPolicyAlert:
+			action = "leaving them in place"
+		}
+		klog.Warningf("Addresses of node %s were changed by %s, %s", currentNode.Name, g.writer, action)
+		g.events.record(currentNode, v1.EventTypeWarning, AddressConflictReason,
+			fmt.Sprintf("Addresses written by local-ccm were changed by %s, %s", g.writer, action))
+	}
+
+	switch g.policy {
+	case ConflictPolicyBackOff:
+		return !now.Before(g.until)
+	case ConflictPolicyAlert:
+		return false
+	}
+	return true
+}
+
+// wrote records the addresses written
+func (g *ownershipGuard) wrote(addresses []v1.NodeAddress, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.written = addresses
+	g.writtenAt = now
+	g.writer = ""
+}
+
+// backoffFor returns the pause of the backoff policy after conflicts
+func backoffFor(conflicts int) time.Duration {
+	backoff := conflictBackoff
+	for i := 1; i < conflicts && backoff < maxConflictBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxConflictBackoff)
+}
+
+// addressesWriter returns the field manager owning the addresses other than
+// local-ccm, or "unknown" if the managed fields do not tell
+func addressesWriter(currentNode *v1.Node) string {
+	for _, entry := range currentNode.ManagedFields {
+		if entry.Subresource != "status" || entry.FieldsV1 == nil || strings.HasPrefix(entry.Manager, version.Name) {
+			continue
+		}
+		if bytes.Contains(entry.FieldsV1.Raw, []byte(`"f:addresses"`)) {
+			return entry.Manager
+		}
+	}
+	return "unknown"
+}
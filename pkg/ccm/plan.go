@@ -0,0 +1,117 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/cozystack/local-ccm/pkg/node"
+)
+
+var _ node.Interface = &planUpdater{}
+
+// planUpdater applies the writes of reconciliations to a copy of the node
+// instead of the API server, recording the labels and annotations written
+type planUpdater struct {
+	node        *v1.Node
+	labels      map[string]bool
+	annotations map[string]bool
+}
+
+// newPlanUpdater creates a plan starting from a copy of the node
+func newPlanUpdater(currentNode *v1.Node) *planUpdater {
+	return &planUpdater{
+		node:        currentNode.DeepCopy(),
+		labels:      make(map[string]bool),
+		annotations: make(map[string]bool),
+	}
+}
+
+func (p *planUpdater) GetNode(context.Context) (*v1.Node, error) {
+	return p.node.DeepCopy(), nil
+}
+
+func (p *planUpdater) UpdateAddresses(_ context.Context, addresses []v1.NodeAddress) error {
+	p.node.Status.Addresses = append([]v1.NodeAddress(nil), addresses...)
+	return nil
+}
+
+func (p *planUpdater) UpdateLabels(_ context.Context, labels map[string]string) error {
+	if p.node.Labels == nil {
+		p.node.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		p.node.Labels[key] = value
+		p.labels[key] = true
+	}
+	return nil
+}
+
+func (p *planUpdater) RemoveLabels(_ context.Context, keys ...string) error {
+	for _, key := range keys {
+		delete(p.node.Labels, key)
+		p.labels[key] = true
+	}
+	return nil
+}
+
+func (p *planUpdater) UpdateAnnotations(_ context.Context, annotations map[string]string) error {
+	if p.node.Annotations == nil {
+		p.node.Annotations = make(map[string]string)
+	}
+	for key, value := range annotations {
+		p.node.Annotations[key] = value
+		p.annotations[key] = true
+	}
+	return nil
+}
+
+func (p *planUpdater) RemoveAnnotations(_ context.Context, keys ...string) error {
+	for _, key := range keys {
+		delete(p.node.Annotations, key)
+		p.annotations[key] = true
+	}
+	return nil
+}
+
+func (p *planUpdater) RemoveTaint(ctx context.Context) error {
+	return p.RemoveTaintKey(ctx, node.TaintKey)
+}
+
+func (p *planUpdater) AddTaint(_ context.Context, taint v1.Taint) error {
+	taints := make([]v1.Taint, 0, len(p.node.Spec.Taints)+1)
+	for _, existing := range p.node.Spec.Taints {
+		if existing.Key != taint.Key || existing.Effect != taint.Effect {
+			taints = append(taints, existing)
+		}
+	}
+	p.node.Spec.Taints = append(taints, taint)
+	return nil
+}
+
+func (p *planUpdater) RemoveTaintKey(_ context.Context, key string) error {
+	taints := make([]v1.Taint, 0, len(p.node.Spec.Taints))
+	for _, taint := range p.node.Spec.Taints {
+		if taint.Key != key {
+			taints = append(taints, taint)
+		}
+	}
+	p.node.Spec.Taints = taints
+	return nil
+}
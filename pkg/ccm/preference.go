@@ -0,0 +1,163 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PreferFamilyIPv4 publishes the IPv4 address of a type if both
+	// families are detected or published
+	PreferFamilyIPv4 = "ipv4"
+	// PreferFamilyIPv6 publishes the IPv6 address of a type if both
+	// families are detected or published
+	PreferFamilyIPv6 = "ipv6"
+	// PreferFamilyDual publishes an address of each family for the
+	// InternalIP and ExternalIP, the family of the first target first
+	PreferFamilyDual = "dual"
+)
+
+// validatePreferFamily checks the family preference against the address
+// families
+func (c *Config) validatePreferFamily() error {
+	switch c.PreferFamily {
+	case "":
+	case PreferFamilyIPv4, PreferFamilyIPv6:
+		if len(c.AddressFamilies) > 0 && !slices.Contains(c.AddressFamilies, c.PreferFamily) {
+			return fmt.Errorf("preferred family %s is not one of the address families %s", c.PreferFamily, strings.Join(c.AddressFamilies, ","))
+		}
+	case PreferFamilyDual:
+		if len(c.AddressFamilies) == 1 {
+			return fmt.Errorf("preferred family %s requires both address families", PreferFamilyDual)
+		}
+	default:
+		return fmt.Errorf("invalid preferred family %q, must be %s, %s or %s", c.PreferFamily, PreferFamilyIPv4, PreferFamilyIPv6, PreferFamilyDual)
+	}
+	return nil
+}
+
+// addressFamily returns the family of an address, empty if it is no IP
+func addressFamily(address string) string {
+	ip := net.ParseIP(strings.TrimSpace(address))
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return AddressFamilyIPv4
+	default:
+		return AddressFamilyIPv6
+	}
+}
+
+// orderedTargets splits comma-separated targets, moving the targets of the
+// preferred family first
+func (c *Config) orderedTargets(targets string) []string {
+	split := strings.Split(targets, ",")
+	for i := range split {
+		split[i] = strings.TrimSpace(split[i])
+	}
+	if c.PreferFamily == PreferFamilyIPv4 || c.PreferFamily == PreferFamilyIPv6 {
+		slices.SortStableFunc(split, func(a, b string) int {
+			return preferenceRank(c.PreferFamily, a) - preferenceRank(c.PreferFamily, b)
+		})
+	}
+	return split
+}
+
+// preferenceRank orders the addresses of the preferred family first
+func preferenceRank(preferred, address string) int {
+	if addressFamily(address) == preferred {
+		return 0
+	}
+	return 1
+}
+
+// seedAddresses returns the published addresses of the node by type, and the
+// published addresses of the other family by type if both families are
+// published. Of several addresses of a type, the last one is used, the first
+// one of the preferred family if a family is preferred, or the first one if
+// both are.
+func (c *Config) seedAddresses(currentNode *v1.Node) (map[v1.NodeAddressType]string, map[v1.NodeAddressType]string) {
+	addressMap := make(map[v1.NodeAddressType]string)
+	secondary := make(map[v1.NodeAddressType]string)
+	for _, addr := range currentNode.Status.Addresses {
+		current, ok := addressMap[addr.Type]
+		switch {
+		case !ok || c.PreferFamily == "":
+			addressMap[addr.Type] = addr.Address
+		case c.PreferFamily == PreferFamilyDual:
+			if _, found := secondary[addr.Type]; !found && addressFamily(addr.Address) != addressFamily(current) {
+				secondary[addr.Type] = addr.Address
+			}
+		case preferenceRank(c.PreferFamily, addr.Address) < preferenceRank(c.PreferFamily, current):
+			addressMap[addr.Type] = addr.Address
+		}
+	}
+	return addressMap, secondary
+}
+
+// detectSecondary detects the address of addrType of the family other than
+// primary via the targets of that family, if both families are published.
+// If the detection fails, the published address is kept.
+func (r *runner) detectSecondary(addrType v1.NodeAddressType, targets, primary string, secondary map[v1.NodeAddressType]string) {
+	if r.config.PreferFamily != PreferFamilyDual {
+		return
+	}
+	var others []string
+	for _, target := range r.config.orderedTargets(targets) {
+		if family := addressFamily(target); family != "" && family != addressFamily(primary) {
+			others = append(others, target)
+		}
+	}
+	if len(others) == 0 {
+		return
+	}
+	detection := r.detectTargets(r.detectorFor(addrType), strings.Join(others, ","))
+	if detection.Error != "" {
+		klog.V(2).Infof("Failed to detect the %s of the other family, keeping the published one: %s", addrType, detection.Error)
+		return
+	}
+	klog.V(2).Infof("Detected %s of the other family: %s", addrType, detection.Address)
+	secondary[addrType] = detection.Address
+}
+
+// addressList returns the addresses to publish, the address of the other
+// family after the address of each type
+func addressList(addressMap, secondary map[v1.NodeAddressType]string) []v1.NodeAddress {
+	addresses := make([]v1.NodeAddress, 0, len(addressMap)+len(secondary))
+	for addrType, addrValue := range addressMap {
+		addresses = append(addresses, v1.NodeAddress{Type: addrType, Address: addrValue})
+		other := secondary[addrType]
+		if other == "" || addressFamily(other) == addressFamily(addrValue) {
+			continue
+		}
+		// Like the ExternalIP, the ExternalIP of the other family is not
+		// published if it is an InternalIP
+		if addrType == v1.NodeExternalIP && (other == addressMap[v1.NodeInternalIP] || other == secondary[v1.NodeInternalIP]) {
+			continue
+		}
+		addresses = append(addresses, v1.NodeAddress{Type: addrType, Address: other})
+	}
+	return addresses
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+// PrintStatus fetches the node, reconciles it without writing it and prints
+// the published next to the detected addresses, taints and the labels and
+// annotations written by local-ccm, marking the drift between them. The
+// flags apply, a LocalCCMConfig does not. It returns the errors of the
+// reconciliation.
+func PrintStatus(ctx context.Context, config Config, out io.Writer) error {
+	// Only plan the writes of the node, without touching the host
+	config.ConfigureRoutes = false
+	config.NetworkStatus = false
+	config.OutputFile = ""
+	config.KubeletNodeIPFile = ""
+	config.ClusterConfig = ""
+
+	r, err := newRunner(config)
+	if err != nil {
+		return err
+	}
+	published, err := r.nodeUpdater.GetNode(ctx)
+	if err != nil {
+		return &APIError{Err: fmt.Errorf("failed to get node: %w", err)}
+	}
+	plan := newPlanUpdater(published)
+	r.nodeUpdater = plan
+	reconcileErr := r.reconcileNode(ctx)
+
+	if err := writeStatus(out, published, plan, r.detection.Get()); err != nil {
+		return fmt.Errorf("failed to write status: %w", err)
+	}
+	return reconcileErr
+}
+
+// writeStatus writes the table of published and detected values, and the
+// detections
+func writeStatus(out io.Writer, published *v1.Node, plan *planUpdater, report *detector.Report) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tPUBLISHED\tDETECTED\tDRIFT")
+	row := func(field, before, after string) {
+		drift := ""
+		if before != after {
+			drift = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", field, orNone(before), orNone(after), drift)
+	}
+
+	before, after := addressesByType(published.Status.Addresses), addressesByType(plan.node.Status.Addresses)
+	for _, addrType := range unionKeys(before, after) {
+		row(addrType, before[addrType], after[addrType])
+	}
+	before, after = taintsByKey(published.Spec.Taints), taintsByKey(plan.node.Spec.Taints)
+	for _, key := range unionKeys(before, after) {
+		row("taint "+key, before[key], after[key])
+	}
+	for _, key := range sortedKeys(plan.labels) {
+		row("label "+key, published.Labels[key], plan.node.Labels[key])
+	}
+	for _, key := range sortedKeys(plan.annotations) {
+		// The status annotation changes with every reconciliation
+		if key == StatusAnnotation {
+			continue
+		}
+		row("annotation "+key, published.Annotations[key], plan.node.Annotations[key])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if report == nil {
+		return nil
+	}
+	fmt.Fprintln(out)
+	for _, d := range []struct {
+		addressType string
+		detection   *detector.Detection
+	}{
+		{string(v1.NodeInternalIP), report.Internal},
+		{string(v1.NodeExternalIP), report.External},
+		{"EgressIP", report.Egress},
+	} {
+		if d.detection != nil {
+			fmt.Fprintf(out, "%s: %s\n", d.addressType, describeDetection(d.detection))
+		}
+	}
+	return nil
+}
+
+// describeDetection summarizes a detection in a line
+func describeDetection(detection *detector.Detection) string {
+	var b strings.Builder
+	b.WriteString(detection.Strategy)
+	if detection.Target != "" {
+		fmt.Fprintf(&b, " to %s", detection.Target)
+	}
+	if detection.Interface != "" {
+		fmt.Fprintf(&b, " via %s", detection.Interface)
+	}
+	switch {
+	case detection.Error != "":
+		fmt.Fprintf(&b, ": failed: %s", detection.Error)
+	case detection.Address != "":
+		fmt.Fprintf(&b, ": %s", detection.Address)
+	}
+	if detection.Degraded != "" {
+		fmt.Fprintf(&b, " (degraded: %s)", detection.Degraded)
+	}
+	for _, candidate := range detection.Filtered {
+		fmt.Fprintf(&b, "\n  skipped %s: %s", candidate.Address, candidate.Reason)
+	}
+	return b.String()
+}
+
+// addressesByType joins the addresses of each type in their order
+func addressesByType(addresses []v1.NodeAddress) map[string]string {
+	byType := make(map[string]string)
+	for _, addr := range addresses {
+		if current := byType[string(addr.Type)]; current != "" {
+			byType[string(addr.Type)] = current + "," + addr.Address
+		} else {
+			byType[string(addr.Type)] = addr.Address
+		}
+	}
+	return byType
+}
+
+// taintsByKey joins the value and effect of the taints of each key
+func taintsByKey(taints []v1.Taint) map[string]string {
+	byKey := make(map[string]string)
+	for _, taint := range taints {
+		value := string(taint.Effect)
+		if taint.Value != "" {
+			value = taint.Value + ":" + value
+		}
+		if current := byKey[taint.Key]; current != "" {
+			value = current + "," + value
+		}
+		byKey[taint.Key] = value
+	}
+	return byKey
+}
+
+// unionKeys returns the keys of both maps, sorted
+func unionKeys(a, b map[string]string) []string {
+	keys := make(map[string]bool)
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return sortedKeys(keys)
+}
+
+// sortedKeys returns the keys of a set, sorted
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// orNone returns value, or "<none>" if it is empty
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
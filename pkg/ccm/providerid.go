@@ -0,0 +1,113 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ProviderIDPrefix prefixes the node name in the default providerID
+	ProviderIDPrefix = "local-ccm://"
+	// DefaultProviderIDTemplate is the default providerID of the nodes
+	DefaultProviderIDTemplate = ProviderIDPrefix + "{node}"
+)
+
+// validateProviderIDTemplate checks that the template is a providerID of the
+// form <provider>://<id> without unknown placeholders
+func validateProviderIDTemplate(template string) error {
+	filled := strings.NewReplacer("{node}", "node", "{zone}", "zone", "{region}", "region").Replace(template)
+	if strings.ContainsAny(filled, "{}") {
+		return fmt.Errorf("providerID template %q has unknown placeholders, must be {node}, {zone} or {region}", template)
+	}
+	if provider, id, ok := strings.Cut(filled, "://"); !ok || provider == "" || id == "" {
+		return fmt.Errorf("providerID template %q must be of the form <provider>://<id>", template)
+	}
+	return nil
+}
+
+// providerID renders the ProviderIDTemplate for a node. It fails if the
+// template uses a topology label the node does not have.
+func (c *Config) providerID(n *v1.Node) (string, error) {
+	zone := n.Labels[v1.LabelTopologyZone]
+	region := n.Labels[v1.LabelTopologyRegion]
+	if zone == "" && strings.Contains(c.ProviderIDTemplate, "{zone}") {
+		return "", fmt.Errorf("node has no %s label", v1.LabelTopologyZone)
+	}
+	if region == "" && strings.Contains(c.ProviderIDTemplate, "{region}") {
+		return "", fmt.Errorf("node has no %s label", v1.LabelTopologyRegion)
+	}
+	return strings.NewReplacer(
+		"{node}", n.Name,
+		"{zone}", zone,
+		"{region}", region,
+	).Replace(c.ProviderIDTemplate), nil
+}
+
+// SetProviderID sets the providerID of the node once from the
+// ProviderIDTemplate, e.g. in provisioning pipelines before local-ccm is
+// deployed. As the providerID cannot be changed, a node with the same one
+// is left as is, and a node with another one fails.
+func SetProviderID(ctx context.Context, config Config) error {
+	if err := config.Validate(); err != nil {
+		return &ConfigError{Err: fmt.Errorf("invalid config: %w", err)}
+	}
+
+	if err := waitForClients(ctx, &config); err != nil {
+		return err
+	}
+	r, err := newRunner(config)
+	if err != nil {
+		return err
+	}
+	if err := r.waitForAPIServer(ctx); err != nil {
+		return err
+	}
+	currentNode, err := r.nodeUpdater.GetNode(ctx)
+	if err != nil {
+		return &APIError{Err: fmt.Errorf("failed to get node: %w", err)}
+	}
+	providerID, err := r.config.providerID(currentNode)
+	if err != nil {
+		return fmt.Errorf("failed to render providerID of node %s: %w", currentNode.Name, err)
+	}
+
+	switch currentNode.Spec.ProviderID {
+	case providerID:
+		klog.Infof("Node %s already has providerID %s", currentNode.Name, providerID)
+		return nil
+	case "":
+	default:
+		return fmt.Errorf("node %s has providerID %s, which cannot be changed to %s", currentNode.Name, currentNode.Spec.ProviderID, providerID)
+	}
+
+	setter, ok := r.nodeUpdater.(interface {
+		SetProviderID(ctx context.Context, providerID string) error
+	})
+	if !ok {
+		return fmt.Errorf("the node updater cannot set the providerID")
+	}
+	if err := setter.SetProviderID(ctx, providerID); err != nil {
+		return &APIError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Reconciler exposes the reconcile logic of the local node for controllers
+// driven by another framework. It does not start the controllers of Config
+// or the periodic loop, the caller decides when to reconcile. Package
+// nodereconciler adapts it to a controller-runtime manager.
+type Reconciler struct {
+	r *runner
+}
+
+// NewReconciler creates a reconciler of the node named in config
+func NewReconciler(config Config) (*Reconciler, error) {
+	r, err := newRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconciler{r: r}, nil
+}
+
+// ReconcileNode reconciles the node if it is the local node and returns when
+// to reconcile again, as addresses may change without any API event. Requests
+// for other nodes are ignored, their addresses can only be detected on
+// their own host.
+func (rc *Reconciler) ReconcileNode(ctx context.Context, nodeName string) (time.Duration, error) {
+	if nodeName != rc.r.config.NodeName {
+		klog.V(4).Infof("Ignoring reconcile request for node %s", nodeName)
+		return 0, nil
+	}
+	if err := rc.r.reconcile(ctx); err != nil {
+		return 0, err
+	}
+	return rc.r.config.ReconcileInterval, nil
+}
@@ -0,0 +1,320 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+const (
+	// RemoteDetectionLabel selects the nodes detected remotely by default
+	RemoteDetectionLabel = "local-ccm.io/remote-detection"
+	// DefaultRemoteDetectionSelector is the default selector of the nodes
+	// detected remotely
+	DefaultRemoteDetectionSelector = RemoteDetectionLabel + "=true"
+
+	// remoteDetectionLeaseName is the name of the Lease used to elect the
+	// single instance detecting nodes remotely
+	remoteDetectionLeaseName = "local-ccm-remote-detection"
+
+	// Keys of the remote detection secret besides ssh-privatekey
+	sshUsernameKey   = "username"
+	sshKnownHostsKey = "known_hosts"
+	sshPortKey       = "port"
+)
+
+// remoteDetection reconciles the selected nodes by running the detection on
+// them over SSH, for nodes without an agent
+type remoteDetection struct {
+	parent   *runner
+	ssh      *sshCredentials
+	selector labels.Selector
+	lister   corelisters.NodeLister
+	queue    workqueue.TypedRateLimitingInterface[string]
+
+	mu      sync.Mutex
+	runners map[string]*remoteRunner
+}
+
+// sshCredentials are read from the remote detection secret
+type sshCredentials struct {
+	// detector is copied with the address of each node
+	detector detector.SSH
+	port     string
+}
+
+// remoteRunner reconciles a node detected over SSH
+type remoteRunner struct {
+	runner  *runner
+	address string
+}
+
+// runRemoteDetection reconciles the nodes selected by
+// RemoteDetectionSelector over SSH until ctx is done
+func (r *runner) runRemoteDetection(ctx context.Context) {
+	credentials, err := r.loadSSHCredentials(ctx)
+	if err != nil {
+		klog.Errorf("Failed to load SSH credentials for remote detection: %v", err)
+		return
+	}
+	// Validated on startup
+	selector, _ := labels.Parse(r.config.RemoteDetectionSelector)
+
+	rd := &remoteDetection{
+		parent:   r,
+		ssh:      credentials,
+		selector: selector,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "remote-detection"},
+		),
+		runners: make(map[string]*remoteRunner),
+	}
+	defer rd.queue.ShutDown()
+
+	nodeInformer := r.informers.factory.Core().V1().Nodes()
+	registration, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: rd.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Selected nodes are requeued after each reconciliation
+			oldNode, ok1 := oldObj.(*v1.Node)
+			newNode, ok2 := newObj.(*v1.Node)
+			if ok1 && ok2 && rd.selected(oldNode) == rd.selected(newNode) {
+				return
+			}
+			rd.enqueue(newObj)
+		},
+		DeleteFunc: rd.enqueue,
+	})
+	if err != nil {
+		klog.Errorf("Failed to add node event handler: %v", err)
+		return
+	}
+	defer func() {
+		if err := nodeInformer.Informer().RemoveEventHandler(registration); err != nil {
+			klog.Errorf("Failed to remove node event handler: %v", err)
+		}
+	}()
+	rd.lister = nodeInformer.Lister()
+
+	r.informers.start()
+
+	klog.Infof("Starting remote detection of nodes matching %q with %d workers", r.config.RemoteDetectionSelector, r.config.ConcurrentNodeSyncs)
+	if !cache.WaitForCacheSync(ctx.Done(), registration.HasSynced) {
+		klog.Error("Failed to wait for remote detection caches to sync")
+		return
+	}
+
+	for i := 0; i < r.config.ConcurrentNodeSyncs; i++ {
+		go wait.UntilWithContext(ctx, rd.worker, time.Second)
+	}
+
+	<-ctx.Done()
+	klog.Info("Stopping remote detection")
+}
+
+// loadSSHCredentials reads the SSH credentials from the remote detection
+// secret in the cluster of the pods
+func (r *runner) loadSSHCredentials(ctx context.Context) (*sshCredentials, error) {
+	namespace, name, _ := parseSecretRef(r.config.RemoteDetectionSecret, r.config.Namespace)
+	secret, err := r.leaseClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+
+	user := string(secret.Data[sshUsernameKey])
+	if user == "" {
+		user = "root"
+	}
+	port := string(secret.Data[sshPortKey])
+	if port == "" {
+		port = "22"
+	}
+	config, err := detector.NewSSHConfig(user, secret.Data[v1.SSHAuthPrivateKey], secret.Data[sshKnownHostsKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret %s/%s: %w", namespace, name, err)
+	}
+	klog.V(2).Infof("Using SSH credentials of %s from secret %s/%s", user, namespace, name)
+	return &sshCredentials{detector: detector.SSH{Config: config}, port: port}, nil
+}
+
+func (rd *remoteDetection) worker(ctx context.Context) {
+	for rd.processNextItem(ctx) {
+	}
+}
+
+func (rd *remoteDetection) processNextItem(ctx context.Context) bool {
+	name, quit := rd.queue.Get()
+	if quit {
+		return false
+	}
+	defer rd.queue.Done(name)
+
+	requeue, err := rd.sync(ctx, name)
+	if err != nil {
+		klog.Errorf("Failed to reconcile node %s remotely: %v", name, err)
+		rd.queue.AddRateLimited(name)
+		return true
+	}
+
+	rd.queue.Forget(name)
+	if requeue {
+		rd.queue.AddAfter(name, rd.parent.config.ReconcileInterval)
+	}
+	return true
+}
+
+func (rd *remoteDetection) enqueue(obj interface{}) {
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	rd.queue.Add(name)
+}
+
+// selected reports whether the node is detected remotely. The node of this
+// instance is reconciled locally.
+func (rd *remoteDetection) selected(node *v1.Node) bool {
+	return node.Name != rd.parent.config.NodeName && rd.selector.Matches(labels.Set(node.Labels))
+}
+
+// sync reconciles a node over SSH and reports whether it is still selected
+func (rd *remoteDetection) sync(ctx context.Context, name string) (bool, error) {
+	node, err := rd.lister.Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get node: %w", err)
+	}
+	if node == nil || !rd.selected(node) {
+		rd.mu.Lock()
+		delete(rd.runners, name)
+		rd.mu.Unlock()
+		return false, nil
+	}
+
+	nr, err := rd.runnerFor(node)
+	if err != nil {
+		return false, err
+	}
+	if err := nr.reconcile(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// runnerFor returns the runner of a node, recreated if its SSH address
+// changed. Runners keep the state of the node between reconciliations.
+func (rd *remoteDetection) runnerFor(node *v1.Node) (*runner, error) {
+	address := net.JoinHostPort(sshHost(node), rd.ssh.port)
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rr := rd.runners[node.Name]; rr != nil && rr.address == address {
+		return rr.runner, nil
+	}
+
+	d := rd.ssh.detector
+	d.Address = address
+	config := rd.parent.nodeRunnerConfig(node.Name)
+	config.Detector = d
+	nr, err := newRunner(config)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("Detecting node %s remotely via %s", node.Name, address)
+	rd.runners[node.Name] = &remoteRunner{runner: nr, address: address}
+	return nr, nil
+}
+
+// sshHost returns the host to connect to: the node IP passed to kubelet,
+// the published InternalIP, or the hostname of the node
+func sshHost(node *v1.Node) string {
+	if ip := node.Annotations[ProvidedNodeIPAnnotation]; ip != "" {
+		return ip
+	}
+	if ip := publishedAddress(node, v1.NodeInternalIP); ip != "" {
+		return ip
+	}
+	if hostname := publishedAddress(node, v1.NodeHostName); hostname != "" {
+		return hostname
+	}
+	return node.Name
+}
+
+// nodeRunnerConfig returns the config of a runner reconciling another node
+// than the own one, sharing the clients. Only the settings of what is
+// detected and published are copied, so features touching the host or
+// running controllers stay disabled, including ones added later.
+func (r *runner) nodeRunnerConfig(nodeName string) Config {
+	c := r.config
+	return Config{
+		NodeName:      nodeName,
+		Namespace:     c.Namespace,
+		Client:        r.client,
+		DynamicClient: r.dynamicClient,
+		LeaseClient:   r.leaseClient,
+		FeatureGates:  c.FeatureGates,
+		PrivilegeMode: c.PrivilegeMode,
+
+		InternalIPTarget:          c.InternalIPTarget,
+		ExternalIPTarget:          c.ExternalIPTarget,
+		EgressIPTarget:            c.EgressIPTarget,
+		ExternalIPWithdrawal:      c.ExternalIPWithdrawal,
+		ExternalIPWithdrawalGrace: c.ExternalIPWithdrawalGrace,
+		AddressRemovalGrace:       c.AddressRemovalGrace,
+		MinUpdateInterval:         c.MinUpdateInterval,
+		AddressConflictPolicy:     c.AddressConflictPolicy,
+		ManagedAddressTypes:       c.ManagedAddressTypes,
+		AddressFamilies:           c.AddressFamilies,
+		PreferFamily:              c.PreferFamily,
+		Coexistence:               c.Coexistence,
+		ProvidedNodeIP:            c.ProvidedNodeIP,
+		Detector:                  c.Detector,
+		AddressPolicy:             c.AddressPolicy,
+
+		DetectionTaintAfter: c.DetectionTaintAfter,
+		DetectionTaint:      c.DetectionTaint,
+		InitializingTaint:   c.InitializingTaint,
+		RemoveTaint:         c.RemoveTaint,
+		TraceAPIRequests:    c.TraceAPIRequests,
+		RunOnce:             c.RunOnce,
+		ReconcileInterval:   c.ReconcileInterval,
+
+		ExcludeFromLoadBalancers: c.ExcludeFromLoadBalancers,
+		PublicIPLabel:            c.PublicIPLabel,
+		StatusAnnotation:         c.StatusAnnotation,
+		Zone:                     c.Zone,
+		Region:                   c.Region,
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RemoveTaint removes the taints with the key from the node once, e.g. for
+// bootstrap scripts when the addresses are managed elsewhere. It waits for
+// the API server and patches the taints like the reconciliation.
+func RemoveTaint(ctx context.Context, config Config, key string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return &ConfigError{Err: fmt.Errorf("invalid taint key %q: %s", key, strings.Join(errs, ", "))}
+	}
+	if err := config.Validate(); err != nil {
+		return &ConfigError{Err: fmt.Errorf("invalid config: %w", err)}
+	}
+
+	if err := waitForClients(ctx, &config); err != nil {
+		return err
+	}
+	r, err := newRunner(config)
+	if err != nil {
+		return err
+	}
+	if err := r.waitForAPIServer(ctx); err != nil {
+		return err
+	}
+	if err := r.nodeUpdater.RemoveTaintKey(ctx, key); err != nil {
+		return &APIError{Err: err}
+	}
+	return nil
+}
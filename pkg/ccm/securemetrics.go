@@ -0,0 +1,276 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/certs"
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// tokenReviewTTL is how long the review of a bearer token is cached, so
+// scrapes do not cause two API requests each. Denied tokens are cached as
+// well, so retrying them does not cause reviews either.
+const tokenReviewTTL = time.Minute
+
+// reviewsPerClientQPS and reviewsPerClientBurst limit the token reviews
+// caused by each client address, so a client sending ever new tokens cannot
+// flood the API server with reviews
+const (
+	reviewsPerClientQPS   = 0.1
+	reviewsPerClientBurst = 5
+)
+
+// runSecureMetrics serves /metrics via TLS until ctx is done. Clients
+// authenticate with a certificate signed by MetricsClientCAFile, or with a
+// bearer token allowed to get /metrics if MetricsAuthorizeTokens is set.
+func (r *runner) runSecureMetrics(ctx context.Context) {
+	loader := certs.NewLoader("metrics", r.config.MetricsCertFile, r.config.MetricsKeyFile)
+	// Fail early on missing or invalid certificates
+	if _, err := loader.GetCertificate(nil); err != nil {
+		klog.Errorf("Failed to serve metrics: %v", err)
+		return
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.GetCertificate,
+	}
+	if r.config.MetricsClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.MetricsClientCAFile)
+		if err != nil {
+			klog.Errorf("Failed to read metrics client CA: %v", err)
+			return
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			klog.Errorf("No certificates found in metrics client CA %s", r.config.MetricsClientCAFile)
+			return
+		}
+		// Clients with tokens need not present a certificate
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	listener, err := net.Listen("tcp", r.config.MetricsBindAddress)
+	if err != nil {
+		klog.Errorf("Failed to listen on %s: %v", r.config.MetricsBindAddress, err)
+		return
+	}
+	auth := &metricsAuth{
+		clientCerts: r.config.MetricsClientCAFile != "",
+		client:      r.leaseClient,
+		reviews:     make(map[[sha256.Size]byte]tokenReview),
+		limiters:    make(map[string]*clientLimiter),
+	}
+	if !r.config.MetricsAuthorizeTokens {
+		auth.client = nil
+	}
+	go wait.UntilWithContext(ctx, func(context.Context) { auth.prune(time.Now()) }, tokenReviewTTL)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", auth.wrap(metrics.Handler()))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	klog.Infof("Serving metrics via TLS on %s", r.config.MetricsBindAddress)
+	if err := server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("Failed to serve metrics: %v", err)
+	}
+}
+
+// metricsAuth authenticates and authorizes the requests for metrics. Without
+// client certificates and tokens, all requests are allowed.
+type metricsAuth struct {
+	// clientCerts accepts verified client certificates
+	clientCerts bool
+	// client reviews bearer tokens if set
+	client kubernetes.Interface
+
+	mu      sync.Mutex
+	reviews map[[sha256.Size]byte]tokenReview
+	// limiters limit the reviews by client address
+	limiters map[string]*clientLimiter
+}
+
+// tokenReview is the cached review of a bearer token
+type tokenReview struct {
+	allowed bool
+	expires time.Time
+}
+
+// clientLimiter limits the reviews of a client address
+type clientLimiter struct {
+	limiter  flowcontrol.RateLimiter
+	lastUsed time.Time
+}
+
+func (a *metricsAuth) wrap(handler http.Handler) http.Handler {
+	if !a.clientCerts && a.client == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if a.clientCerts && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if a.client == nil || !ok || token == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		allowed, err := a.review(req.Context(), clientAddress(req), token, req.URL.Path)
+		if errors.Is(err, errTooManyReviews) {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			klog.Errorf("Failed to review metrics token: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// errTooManyReviews is returned when a client exceeded its review rate
+var errTooManyReviews = errors.New("too many token reviews")
+
+// review authenticates the token with a TokenReview and authorizes its user
+// to get path with a SubjectAccessReview, caching the result. Reviews not
+// cached are limited per client address.
+func (a *metricsAuth) review(ctx context.Context, client, token, path string) (bool, error) {
+	key := sha256.Sum256([]byte(path + "\n" + token))
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.reviews[key]
+	if ok && now.Before(cached.expires) {
+		a.mu.Unlock()
+		return cached.allowed, nil
+	}
+	limiter, ok := a.limiters[client]
+	if !ok {
+		limiter = &clientLimiter{limiter: flowcontrol.NewTokenBucketRateLimiter(reviewsPerClientQPS, reviewsPerClientBurst)}
+		a.limiters[client] = limiter
+	}
+	limiter.lastUsed = now
+	accepted := limiter.limiter.TryAccept()
+	a.mu.Unlock()
+	if !accepted {
+		klog.V(2).Infof("Rejected metrics token of %s: %v", client, errTooManyReviews)
+		return false, errTooManyReviews
+	}
+
+	allowed, err := a.authorize(ctx, token, path)
+	if err != nil {
+		return false, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reviews[key] = tokenReview{allowed: allowed, expires: now.Add(tokenReviewTTL)}
+	return allowed, nil
+}
+
+// prune drops the expired reviews and the limiters of clients that caused
+// no review for tokenReviewTTL, whose buckets are full again by then
+func (a *metricsAuth) prune(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, review := range a.reviews {
+		if !now.Before(review.expires) {
+			delete(a.reviews, key)
+		}
+	}
+	for client, limiter := range a.limiters {
+		if now.Sub(limiter.lastUsed) >= tokenReviewTTL {
+			delete(a.limiters, client)
+		}
+	}
+}
+
+// clientAddress returns the IP address of the client of a request
+func clientAddress(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (a *metricsAuth) authorize(ctx context.Context, token, path string) (bool, error) {
+	tokenReview, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to create token review: %w", err)
+	}
+	if !tokenReview.Status.Authenticated {
+		return false, nil
+	}
+	user := tokenReview.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	accessReview, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: path,
+				Verb: "get",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to create subject access review: %w", err)
+	}
+	if !accessReview.Status.Allowed {
+		klog.V(2).Infof("Denied metrics to %s: %s", user.Username, accessReview.Status.Reason)
+	}
+	return accessReview.Status.Allowed, nil
+}
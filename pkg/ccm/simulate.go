@@ -0,0 +1,228 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/metrics"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// SimulatedNodeLabel marks the fake nodes of the scale simulation
+const SimulatedNodeLabel = "local-ccm.io/simulated"
+
+// simulationCleanupTimeout bounds the deletion of the fake nodes on exit
+const simulationCleanupTimeout = time.Minute
+
+// simulationLeaseName is the name of the Lease used to elect the single
+// instance simulating nodes in SimulationController mode
+const simulationLeaseName = "local-ccm-simulation"
+
+// simulation reconciles the fake nodes from a rate-limited workqueue, so a
+// slow or failing node is retried with backoff without delaying the others
+type simulation struct {
+	parent  *runner
+	runners map[string]*runner
+	queue   workqueue.TypedRateLimitingInterface[string]
+	// pending counts the nodes not reconciled yet in run-once mode
+	pending sync.WaitGroup
+}
+
+// runSimulationController runs the simulation while elected, deleting the
+// fake nodes when the leadership is lost
+func (r *runner) runSimulationController(ctx context.Context) {
+	if err := r.runSimulation(ctx); err != nil {
+		klog.Errorf("Scale simulation failed: %v", err)
+	}
+}
+
+// runSimulation creates SimulateNodes fake nodes and reconciles each of them
+// with a static detector like an agent would, sharing the clients and their
+// rate limits, until ctx is done. The fake nodes are deleted on exit.
+func (r *runner) runSimulation(ctx context.Context) error {
+	count := r.config.SimulateNodes
+	klog.Infof("Simulating %d nodes with prefix %s-sim-", count, r.config.NodeName)
+
+	// The agent serves the metrics in SimulationController mode
+	if r.config.BindAddress != "" && r.config.SimulationMode == SimulationStandalone {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go runHTTPServer(ctx, r.config.BindAddress, mux)
+	}
+
+	runners := make([]*runner, 0, count)
+	defer func() { r.deleteSimulatedNodes(runners) }()
+	for i := 0; i < count; i++ {
+		sim, err := r.newSimulatedRunner(i)
+		if err != nil {
+			return err
+		}
+		if err := sim.createSimulatedNode(ctx); err != nil {
+			return err
+		}
+		runners = append(runners, sim)
+	}
+
+	s := &simulation{
+		parent:  r,
+		runners: make(map[string]*runner, len(runners)),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "simulation"},
+		),
+	}
+	defer s.queue.ShutDown()
+	for _, sim := range runners {
+		s.runners[sim.config.NodeName] = sim
+		if r.config.RunOnce {
+			s.pending.Add(1)
+		}
+		// Spread the first reconciliations over the interval, so the nodes
+		// do not reconcile in lockstep
+		s.queue.AddAfter(sim.config.NodeName, time.Duration(rand.Int63n(int64(r.config.ReconcileInterval))))
+	}
+
+	klog.Infof("Reconciling simulated nodes with %d workers", r.config.ConcurrentNodeSyncs)
+	for i := 0; i < r.config.ConcurrentNodeSyncs; i++ {
+		go wait.UntilWithContext(ctx, s.worker, time.Second)
+	}
+
+	if r.config.RunOnce {
+		done := make(chan struct{})
+		go func() {
+			s.pending.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (s *simulation) worker(ctx context.Context) {
+	for s.processNextItem(ctx) {
+	}
+}
+
+func (s *simulation) processNextItem(ctx context.Context) bool {
+	name, quit := s.queue.Get()
+	if quit {
+		return false
+	}
+	defer s.queue.Done(name)
+
+	sim := s.runners[name]
+	start := time.Now()
+	err := sim.reconcile(ctx)
+	result := "success"
+	if err != nil {
+		result = "error"
+		klog.V(2).Infof("Reconciliation of simulated node %s failed: %v", name, err)
+	}
+	metrics.SimulatedReconcileDuration.Observe(time.Since(start).Seconds(), result)
+
+	switch {
+	case s.parent.config.RunOnce:
+		s.queue.Forget(name)
+		s.pending.Done()
+	case err != nil:
+		s.queue.AddRateLimited(name)
+	default:
+		s.queue.Forget(name)
+		s.queue.AddAfter(name, s.parent.config.ReconcileInterval)
+	}
+	return true
+}
+
+// newSimulatedRunner creates the runner of the i-th fake node. Features
+// touching the host or running controllers are disabled.
+func (r *runner) newSimulatedRunner(i int) (*runner, error) {
+	config := r.nodeRunnerConfig(fmt.Sprintf("%s-sim-%d", r.config.NodeName, i))
+
+	// Unique addresses from 10.0.0.0/8 and the 198.18.0.0/15 benchmark range
+	n := i + 1
+	internal := fmt.Sprintf("10.%d.%d.%d", n>>16&0xff, n>>8&0xff, n&0xff)
+	external := fmt.Sprintf("198.%d.%d.%d", 18+(n>>16&1), n>>8&0xff, n&0xff)
+	config.Detector = detector.Static{Default: external}
+	config.InternalIPDetector = detector.Static{Default: internal}
+	config.ExternalIPDetector = detector.Static{Default: external}
+	if config.InternalIPTarget == "" {
+		config.InternalIPTarget = "10.0.0.1"
+	}
+	return newRunner(config)
+}
+
+// createSimulatedNode creates the fake node with the uninitialized taint, as
+// kubelet registers nodes with an external cloud provider
+func (r *runner) createSimulatedNode(ctx context.Context) error {
+	n := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   r.config.NodeName,
+			Labels: map[string]string{SimulatedNodeLabel: "true"},
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{
+				Key:    "node.cloudprovider.kubernetes.io/uninitialized",
+				Value:  "true",
+				Effect: v1.TaintEffectNoSchedule,
+			}},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeHostName, Address: r.config.NodeName}},
+		},
+	}
+	_, err := r.client.CoreV1().Nodes().Create(ctx, n, metav1.CreateOptions{FieldManager: version.FieldManager()})
+	if apierrors.IsAlreadyExists(err) {
+		klog.V(2).Infof("Reusing simulated node %s", n.Name)
+		return nil
+	}
+	if err != nil {
+		return &APIError{Err: fmt.Errorf("failed to create simulated node %s: %w", n.Name, err)}
+	}
+	return nil
+}
+
+// deleteSimulatedNodes deletes the fake nodes of the runners
+func (r *runner) deleteSimulatedNodes(runners []*runner) {
+	ctx, cancel := context.WithTimeout(context.Background(), simulationCleanupTimeout)
+	defer cancel()
+	for _, sim := range runners {
+		err := r.client.CoreV1().Nodes().Delete(ctx, sim.config.NodeName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("Failed to delete simulated node %s: %v", sim.config.NodeName, err)
+		}
+	}
+	klog.Infof("Deleted %d simulated nodes", len(runners))
+}
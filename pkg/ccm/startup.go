@@ -0,0 +1,116 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// startupBackoff is the backoff between attempts to reach the API server on
+// startup, which often comes up after local-ccm on self-hosted control planes
+var startupBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      30 * time.Second,
+}
+
+// waitForClients creates the clients missing from config, retrying until
+// StartupTimeout has passed
+func waitForClients(ctx context.Context, config *Config) error {
+	if config.Client != nil && config.DynamicClient != nil {
+		return nil
+	}
+	return retryStartup(ctx, config.StartupTimeout, "create Kubernetes client", func(ctx context.Context) error {
+		client, dynamicClient, err := createKubernetesClients(ctx, *config)
+		if err != nil {
+			return err
+		}
+		if config.Client == nil {
+			config.Client = client
+		}
+		if config.DynamicClient == nil {
+			config.DynamicClient = dynamicClient
+		}
+		return nil
+	})
+}
+
+// waitForAPIServer gets the node, retrying until StartupTimeout has passed
+func (r *runner) waitForAPIServer(ctx context.Context) error {
+	err := retryStartup(ctx, r.config.StartupTimeout, "get node", func(ctx context.Context) error {
+		_, err := r.nodeUpdater.GetNode(ctx)
+		return err
+	})
+	if err != nil {
+		return &APIError{Err: err}
+	}
+	return nil
+}
+
+// retryStartup calls fn with backoff until it succeeds, the timeout has
+// passed or ctx is done, and returns the last error
+func retryStartup(ctx context.Context, timeout time.Duration, what string, fn func(context.Context) error) error {
+	deadline := time.Now().Add(timeout)
+	backoff := startupBackoff
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		// Outside of a cluster, waiting does not help
+		if errors.Is(err, rest.ErrNotInCluster) {
+			return fmt.Errorf("failed to %s: %w", what, err)
+		}
+
+		delay := backoff.Step()
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("failed to %s within %v: %w", what, timeout, err)
+		}
+		klog.Warningf("Failed to %s, retrying in %v: %v", what, delay.Round(time.Millisecond), err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to %s: %w", what, errors.Join(err, ctx.Err()))
+		case <-time.After(delay):
+		}
+	}
+}
+
+// adoptManagedFields moves the managed fields of the node written by older
+// versions of local-ccm to the current field manager, so the ownership of
+// the fields tells the version writing them
+func (r *runner) adoptManagedFields(ctx context.Context) {
+	adopter, ok := r.nodeUpdater.(interface {
+		AdoptManagedFields(ctx context.Context) error
+	})
+	if !ok {
+		return
+	}
+	if err := adopter.AdoptManagedFields(ctx); err != nil {
+		klog.Warningf("Failed to adopt managed fields of node %s: %v", r.config.NodeName, err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StatusAnnotation holds the ReconcileStatus of the node as JSON
+const StatusAnnotation = "local-ccm.io/status"
+
+// Phases of a ReconcileStatus
+const (
+	// PhaseReconciled means all steps of the last reconciliation succeeded
+	PhaseReconciled = "Reconciled"
+	// PhaseDegraded means some steps of the last reconciliation failed
+	PhaseDegraded = "Degraded"
+	// PhaseFailed means all steps of the last reconciliation failed
+	PhaseFailed = "Failed"
+)
+
+const (
+	// statusRefreshInterval is how often lastReconcile is refreshed while
+	// the phase and error do not change, limiting the writes to the node
+	statusRefreshInterval = 5 * time.Minute
+	// maxStatusErrorLength truncates lastError to keep the node small
+	maxStatusErrorLength = 1024
+)
+
+// ReconcileStatus is the outcome of the last reconciliation of a node
+type ReconcileStatus struct {
+	Phase         string      `json:"phase"`
+	LastReconcile metav1.Time `json:"lastReconcile"`
+	LastError     string      `json:"lastError,omitempty"`
+}
+
+// reconcileStatus returns the value of the StatusAnnotation for the errors
+// of a reconciliation, keeping the published value if it only differs in a
+// recent lastReconcile
+func reconcileStatus(currentNode *v1.Node, errs []error, succeeded bool) string {
+	status := ReconcileStatus{
+		Phase:         PhaseReconciled,
+		LastReconcile: metav1.NewTime(time.Now()),
+	}
+	if len(errs) > 0 {
+		status.Phase = PhaseFailed
+		if succeeded {
+			status.Phase = PhaseDegraded
+		}
+		status.LastError = errors.Join(errs...).Error()
+		if len(status.LastError) > maxStatusErrorLength {
+			status.LastError = status.LastError[:maxStatusErrorLength] + "..."
+		}
+	}
+
+	if current, ok := currentNode.Annotations[StatusAnnotation]; ok {
+		var published ReconcileStatus
+		if err := json.Unmarshal([]byte(current), &published); err == nil &&
+			published.Phase == status.Phase && published.LastError == status.LastError &&
+			time.Since(published.LastReconcile.Time) < statusRefreshInterval {
+			return current
+		}
+	}
+
+	value, _ := json.Marshal(status)
+	return string(value)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+const (
+	// InternalIPStrategyAnnotation holds the strategy that detected the
+	// InternalIP if it has its own detectors
+	InternalIPStrategyAnnotation = "local-ccm.io/internal-ip-strategy"
+	// ExternalIPStrategyAnnotation holds the strategy that detected the
+	// ExternalIP if it has its own detectors
+	ExternalIPStrategyAnnotation = "local-ccm.io/external-ip-strategy"
+)
+
+// strategyAnnotations are the strategy annotations by address type
+var strategyAnnotations = map[v1.NodeAddressType]string{
+	v1.NodeInternalIP: InternalIPStrategyAnnotation,
+	v1.NodeExternalIP: ExternalIPStrategyAnnotation,
+}
+
+// detectorFor returns the detector of addrType, falling back to the
+// detector of all addresses
+func (r *runner) detectorFor(addrType v1.NodeAddressType) detector.Detector {
+	if d, ok := r.detectors[addrType]; ok {
+		return d
+	}
+	return r.detector
+}
+
+// recordStrategy publishes the strategy that detected the address of
+// addrType as annotation and metric, if the type has its own detectors. The
+// published strategy is kept while detection fails.
+func (r *runner) recordStrategy(currentNode *v1.Node, addrType v1.NodeAddressType, detection detector.Detection, annotations map[string]string) {
+	if _, ok := r.detectors[addrType]; !ok {
+		return
+	}
+	key := strategyAnnotations[addrType]
+	if detection.Error != "" {
+		if current, ok := currentNode.Annotations[key]; ok {
+			annotations[key] = current
+		}
+		return
+	}
+
+	annotations[key] = detection.Strategy
+	if previous := r.strategies[addrType]; previous != "" && previous != detection.Strategy {
+		metrics.DetectionStrategy.Set(0, string(addrType), previous)
+	}
+	metrics.DetectionStrategy.Set(1, string(addrType), detection.Strategy)
+	r.strategies[addrType] = detection.Strategy
+}
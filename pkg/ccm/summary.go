@@ -0,0 +1,122 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// summaryGetTimeout bounds getting the node for the summary after the
+// reconciliation, which may have used up RunOnceTimeout
+const summaryGetTimeout = 10 * time.Second
+
+// Summary is the outcome of the reconciliation of run-once mode, written as
+// JSON to RunOnceSummary for provisioning pipelines
+type Summary struct {
+	Node string `json:"node"`
+	// AddressesBefore and AddressesAfter are the addresses of the node
+	// before and after the reconciliation
+	AddressesBefore []v1.NodeAddress `json:"addressesBefore"`
+	AddressesAfter  []v1.NodeAddress `json:"addressesAfter"`
+	// TaintRemoved is set if the uninitialized taint was removed
+	TaintRemoved    bool           `json:"taintRemoved"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Errors          []SummaryError `json:"errors,omitempty"`
+	// ExitCode is the exit code of local-ccm for the reconciliation
+	ExitCode int `json:"exitCode"`
+}
+
+// SummaryError is an error of the reconciliation, with its type: detection,
+// api or other
+type SummaryError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// reconcileOnce reconciles the node once, writing the summary to
+// RunOnceSummary if set
+func (r *runner) reconcileOnce(ctx context.Context) error {
+	if r.config.RunOnceSummary == nil {
+		return r.reconcile(ctx)
+	}
+
+	summary := Summary{Node: r.config.NodeName}
+	before, err := r.nodeUpdater.GetNode(ctx)
+	if err == nil {
+		summary.AddressesBefore = before.Status.Addresses
+	}
+
+	start := time.Now()
+	err = r.reconcile(ctx)
+	summary.DurationSeconds = time.Since(start).Seconds()
+	summary.Errors = summaryErrors(err)
+	summary.ExitCode = ExitCode(err)
+
+	getCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), summaryGetTimeout)
+	defer cancel()
+	if after, getErr := r.nodeUpdater.GetNode(getCtx); getErr == nil {
+		summary.AddressesAfter = after.Status.Addresses
+		summary.TaintRemoved = before != nil && hasUninitializedTaint(before) && !hasUninitializedTaint(after)
+	} else {
+		klog.Errorf("Failed to get node for the summary: %v", getErr)
+	}
+
+	if encodeErr := json.NewEncoder(r.config.RunOnceSummary).Encode(summary); encodeErr != nil {
+		klog.Errorf("Failed to write summary: %v", encodeErr)
+	}
+	return err
+}
+
+// summaryErrors returns the errors joined into err with their type
+func summaryErrors(err error) []SummaryError {
+	if err == nil {
+		return nil
+	}
+	var partialErr *PartialError
+	if errors.As(err, &partialErr) {
+		err = partialErr.Err
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []SummaryError{summaryError(err)}
+	}
+	var summaryErrs []SummaryError
+	for _, e := range joined.Unwrap() {
+		summaryErrs = append(summaryErrs, summaryErrors(e)...)
+	}
+	return summaryErrs
+}
+
+// summaryError returns a single error with its type
+func summaryError(err error) SummaryError {
+	var detectionErr *DetectionError
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &detectionErr):
+		return SummaryError{Type: "detection", Message: err.Error()}
+	case errors.As(err, &apiErr):
+		return SummaryError{Type: "api", Message: err.Error()}
+	default:
+		return SummaryError{Type: "other", Message: err.Error()}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/systemd"
+)
+
+// systemdNotifier reports the progress of the reconciliation loop to
+// systemd. Readiness is reported after the first reconciliation, and the
+// watchdog is only kept alive while reconciliations complete, so systemd
+// restarts a wedged loop. Failed reconciliations still count as progress, as
+// a restart does not help against an unreachable API server.
+type systemdNotifier struct {
+	interval time.Duration
+
+	mu    sync.Mutex
+	ready bool
+	last  time.Time
+}
+
+// startSystemdNotifier starts pinging the watchdog if local-ccm runs as a
+// systemd notify service, and returns nil otherwise
+func (r *runner) startSystemdNotifier(ctx context.Context) *systemdNotifier {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil
+	}
+	n := &systemdNotifier{interval: r.config.ReconcileInterval, last: time.Now()}
+	if timeout := systemd.WatchdogTimeout(); timeout > 0 {
+		klog.Infof("Pinging the systemd watchdog, timeout %v", timeout)
+		go n.runWatchdog(ctx, timeout)
+	}
+	return n
+}
+
+// runWatchdog pings the watchdog twice per timeout while the loop progresses
+func (n *systemdNotifier) runWatchdog(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n.mu.Lock()
+		stalled := time.Since(n.last)
+		n.mu.Unlock()
+		// A reconciliation is due every interval, allow it the timeout to finish
+		if stalled > n.interval+timeout {
+			klog.Errorf("No reconciliation completed for %v, letting the systemd watchdog fire", stalled.Round(time.Second))
+			continue
+		}
+		n.notify(systemd.Watchdog)
+	}
+}
+
+// reconciled records a completed reconciliation and reports its outcome
+func (n *systemdNotifier) reconciled(err error) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.last = time.Now()
+	state := ""
+	if !n.ready {
+		n.ready = true
+		state = systemd.Ready + "\n"
+	}
+	n.mu.Unlock()
+
+	status := fmt.Sprintf("Reconciled at %s", time.Now().Format(time.RFC3339))
+	if err != nil {
+		status = fmt.Sprintf("Reconciliation failed at %s: %v", time.Now().Format(time.RFC3339), err)
+	}
+	n.notify(state + systemd.Status(status))
+}
+
+// stopping reports the shutdown
+func (n *systemdNotifier) stopping() {
+	if n == nil {
+		return
+	}
+	n.notify(systemd.Stopping)
+}
+
+func (n *systemdNotifier) notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		klog.V(2).Infof("Failed to notify systemd: %v", err)
+	}
+}
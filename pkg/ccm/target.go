@@ -0,0 +1,162 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// DefaultTargetKubeconfigKey is the key of the kubeconfig in the target
+// kubeconfig secret, as used by Cluster API
+const DefaultTargetKubeconfigKey = "value"
+
+// targetKubeconfigReloadPeriod is the interval between reads of the target
+// kubeconfig secret, so rotated credentials are picked up without a restart
+const targetKubeconfigReloadPeriod = time.Minute
+
+// targetKubeconfigSecret reads the kubeconfig of the target cluster from
+// the secret in the cluster of Kubeconfig
+type targetKubeconfigSecret struct {
+	client               kubernetes.Interface
+	namespace, name, key string
+}
+
+func newTargetKubeconfigSecret(config Config) (*targetKubeconfigSecret, error) {
+	client, err := createLocalClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	namespace, name, key := parseSecretRef(config.TargetKubeconfigSecret, config.Namespace)
+	return &targetKubeconfigSecret{client: client, namespace: namespace, name: name, key: key}, nil
+}
+
+// load returns the kubeconfig and its rest config
+func (s *targetKubeconfigSecret) load(ctx context.Context) ([]byte, *rest.Config, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get target kubeconfig secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	data, ok := secret.Data[s.key]
+	if !ok {
+		return nil, nil, fmt.Errorf("target kubeconfig secret %s/%s has no key %s", s.namespace, s.name, s.key)
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load target kubeconfig from secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return data, restConfig, nil
+}
+
+// targetCredentials sends the requests of the target clients with the
+// credentials of the latest kubeconfig in the secret. The server is only
+// read on startup, as the clients are bound to it.
+type targetCredentials struct {
+	secret *targetKubeconfigSecret
+	// apply copies the settings of the clients, e.g. impersonation, to the
+	// config of a reloaded kubeconfig
+	apply func(*rest.Config)
+	host  string
+
+	mu        sync.RWMutex
+	data      []byte
+	transport http.RoundTripper
+}
+
+// reloadTargetKubeconfigSecret makes the clients of restConfig, loaded from
+// data, follow the changes of the secret until ctx is done
+func reloadTargetKubeconfigSecret(ctx context.Context, secret *targetKubeconfigSecret, data []byte, restConfig *rest.Config, apply func(*rest.Config)) error {
+	c := &targetCredentials{secret: secret, apply: apply, host: restConfig.Host}
+	if err := c.use(data, rest.CopyConfig(restConfig)); err != nil {
+		return err
+	}
+	// Drop the credentials, so the clients don't add them on top of the
+	// ones of the transport
+	restConfig.BearerToken, restConfig.BearerTokenFile = "", ""
+	restConfig.Username, restConfig.Password = "", ""
+	restConfig.AuthProvider, restConfig.ExecProvider = nil, nil
+	restConfig.TLSClientConfig = rest.TLSClientConfig{}
+	restConfig.Wrap(func(http.RoundTripper) http.RoundTripper { return c })
+	go wait.UntilWithContext(ctx, c.reload, targetKubeconfigReloadPeriod)
+	return nil
+}
+
+// use switches to the transport of the kubeconfig
+func (c *targetCredentials) use(data []byte, restConfig *rest.Config) error {
+	c.apply(restConfig)
+	transport, err := rest.TransportFor(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create transport: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data, c.transport = data, transport
+	return nil
+}
+
+func (c *targetCredentials) reload(ctx context.Context) {
+	data, restConfig, err := c.secret.load(ctx)
+	if err != nil {
+		klog.Errorf("Failed to reload target kubeconfig, keeping the previous credentials: %v", err)
+		return
+	}
+	c.mu.RLock()
+	unchanged := bytes.Equal(data, c.data)
+	c.mu.RUnlock()
+	if unchanged {
+		return
+	}
+	if restConfig.Host != c.host {
+		klog.Warningf("Server of target kubeconfig secret %s/%s changed to %s, restart to use it", c.secret.namespace, c.secret.name, restConfig.Host)
+	}
+	if err := c.use(data, restConfig); err != nil {
+		klog.Errorf("Failed to reload target kubeconfig, keeping the previous credentials: %v", err)
+		return
+	}
+	klog.Infof("Reloaded target kubeconfig from secret %s/%s", c.secret.namespace, c.secret.name)
+}
+
+func (c *targetCredentials) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.RLock()
+	transport := c.transport
+	c.mu.RUnlock()
+	return transport.RoundTrip(req)
+}
+
+// parseSecretRef splits "[namespace/]name[:key]"
+func parseSecretRef(ref, defaultNamespace string) (namespace, name, key string) {
+	namespace, name, key = defaultNamespace, ref, DefaultTargetKubeconfigKey
+	if i := strings.Index(name, "/"); i >= 0 {
+		namespace, name = name[:i], name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, key = name[:i], name[i+1:]
+	}
+	return namespace, name, key
+}
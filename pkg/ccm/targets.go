@@ -0,0 +1,57 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+// detectTargets detects the address with d via the first of the comma-separated
+// targets whose detection neither failed nor is degraded, e.g. as the target
+// did not answer a probe. The addresses of the skipped targets are listed as
+// filtered candidates. If no target succeeds, the detection of the first
+// target is returned.
+func (r *runner) detectTargets(d detector.Detector, targets string) detector.Detection {
+	var first detector.Detection
+	var skipped []detector.Candidate
+	for i, target := range r.config.orderedTargets(targets) {
+		detection := r.config.checkFamily(d.Detect(target))
+		if detection.Error == "" && detection.Degraded == "" {
+			detection.Filtered = append(detection.Filtered, skipped...)
+			return detection
+		}
+		if i == 0 {
+			first = detection
+		}
+
+		reason := detection.Error
+		if reason == "" {
+			reason = detection.Degraded
+		}
+		klog.V(2).Infof("Detection via target %s failed: %s", target, reason)
+		if detection.Address != "" {
+			skipped = append(skipped, detector.Candidate{Address: detection.Address, Reason: reason})
+		}
+	}
+
+	if first.Error == "" {
+		klog.Warningf("Using degraded detection of %s: %s", first.Address, first.Degraded)
+	}
+	return first
+}
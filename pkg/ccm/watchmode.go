@@ -0,0 +1,133 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ModeLoop reconciles every ReconcileInterval
+	ModeLoop = "loop"
+	// ModeOnceThenWatch reconciles right away, retrying every
+	// ReconcileInterval until it succeeds, and then only on changes of the
+	// network and of the node, and every ResyncInterval
+	ModeOnceThenWatch = "once-then-watch"
+)
+
+// watchOwnNode returns a channel receiving a value when the addresses,
+// taints or annotations of the own node changed
+func (r *runner) watchOwnNode(ctx context.Context) <-chan struct{} {
+	// Watch only the own node, which is also allowed in self-node mode
+	factory := informers.NewSharedInformerFactoryWithOptions(r.client, 0,
+		informers.WithTransform(trimObject),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", r.config.NodeName).String()
+		}))
+	informer := factory.Core().V1().Nodes().Informer()
+
+	changed := make(chan struct{}, 1)
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok1 := oldObj.(*v1.Node)
+			newNode, ok2 := newObj.(*v1.Node)
+			if !ok1 || !ok2 || !nodeChanged(oldNode, newNode, r.ownKeys) {
+				return
+			}
+			klog.V(2).Infof("Node %s changed, reconciling", newNode.Name)
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		klog.Errorf("Failed to watch node %s: %v", r.config.NodeName, err)
+		return nil
+	}
+	factory.Start(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		factory.Shutdown()
+	}()
+	return changed
+}
+
+// nodeChanged reports whether a node changed in the fields reconciled,
+// ignoring the labels and annotations written by local-ccm itself, such as
+// the status annotation, so its own writes do not trigger reconciliations
+func nodeChanged(oldNode, newNode *v1.Node, own *ownKeys) bool {
+	return !slices.Equal(oldNode.Status.Addresses, newNode.Status.Addresses) ||
+		!equality.Semantic.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) ||
+		!own.equal(false, oldNode.Annotations, newNode.Annotations) ||
+		!own.equal(true, oldNode.Labels, newNode.Labels)
+}
+
+// ownKeys records the label and annotation keys written by the
+// reconciliations
+type ownKeys struct {
+	mu          sync.Mutex
+	labels      sets.Set[string]
+	annotations sets.Set[string]
+}
+
+// wrote records keys of labels, or of annotations if labels is false
+func (k *ownKeys) wrote(labels bool, keys ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.labels == nil {
+		k.labels, k.annotations = sets.New[string](), sets.New[string]()
+	}
+	if labels {
+		k.labels.Insert(keys...)
+	} else {
+		k.annotations.Insert(keys...)
+	}
+}
+
+// equal compares labels, or annotations if labels is false, ignoring the
+// keys written by local-ccm
+func (k *ownKeys) equal(labels bool, a, b map[string]string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	own := k.annotations
+	if labels {
+		own = k.labels
+	}
+	for key, value := range a {
+		if other, ok := b[key]; (!ok || other != value) && !own.Has(key) {
+			return false
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok && !own.Has(key) {
+			return false
+		}
+	}
+	return true
+}
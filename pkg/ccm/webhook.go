@@ -0,0 +1,138 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"context"
+	"net"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/node"
+	"github.com/cozystack/local-ccm/pkg/webhook"
+)
+
+// runWebhook serves the node admission webhook until ctx is done
+func (r *runner) runWebhook(ctx context.Context) {
+	handler := webhook.NewHandler(func(n *v1.Node, operation admissionv1.Operation) {
+		// Apply the LocalCCMConfig like the reconciliation
+		applied := r
+		if r.clusterConfig != nil {
+			var err error
+			if applied, err = r.withClusterConfig(); err != nil {
+				klog.Errorf("Ignoring LocalCCMConfig %s: %v", r.clusterConfig.name, err)
+				applied = r
+			}
+		}
+		applied.mutateNode(n, operation)
+	})
+	var err error
+	if r.config.WebhookCertSecret != "" {
+		cert := &secretCertificate{r: r}
+		go cert.run(ctx)
+		err = webhook.Serve(ctx, r.config.WebhookBindAddress, cert.getCertificate, handler)
+	} else {
+		err = webhook.ServeFiles(ctx, r.config.WebhookBindAddress, r.config.WebhookCertFile, r.config.WebhookKeyFile, handler)
+	}
+	if err != nil {
+		klog.Errorf("Failed to run node admission webhook: %v", err)
+	}
+}
+
+// mutateNode initializes a registering node as far as possible without
+// detection on its host. The InternalIP is taken from the node IP kubelet
+// was started with, which is only accepted on creation, as updates of the
+// node ignore its status. The labels depending on the ExternalIP are only
+// set if the node IP is public.
+func (r *runner) mutateNode(n *v1.Node, operation admissionv1.Operation) {
+	// Only initialize nodes still waiting for a cloud provider
+	if !hasUninitializedTaint(n) {
+		return
+	}
+
+	if n.Spec.ProviderID == "" {
+		if providerID, err := r.config.providerID(n); err != nil {
+			klog.Warningf("Not setting providerID of registering node %s: %v", n.Name, err)
+		} else {
+			n.Spec.ProviderID = providerID
+		}
+	}
+
+	// Gate the node until local-ccm on its host verified it
+	if r.config.InitializingTaint && operation == admissionv1.Create && !hasTaint(n, InitializingTaintKey) {
+		n.Spec.Taints = append(n.Spec.Taints, initializingTaint)
+		klog.V(2).Infof("Adding taint %s to registering node %s", InitializingTaintKey, n.Name)
+	}
+
+	nodeIP := net.ParseIP(n.Annotations[ProvidedNodeIPAnnotation])
+	if operation == admissionv1.Create && nodeIP != nil && !hasAddress(n, v1.NodeInternalIP) {
+		n.Status.Addresses = append(n.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: nodeIP.String()})
+	}
+
+	var publicIP *bool
+	if nodeIP != nil && !detector.IsBehindNAT(nodeIP.String()) {
+		public := true
+		publicIP = &public
+	}
+	labels := make(map[string]string)
+	if r.config.PublicIPLabel && publicIP != nil {
+		labels[HasPublicIPLabel] = "true"
+	}
+	if exclude, known := r.excludeFromLoadBalancers(publicIP); known {
+		if exclude {
+			labels[v1.LabelNodeExcludeBalancers] = "true"
+		} else {
+			delete(n.Labels, v1.LabelNodeExcludeBalancers)
+		}
+	}
+	for key, value := range labels {
+		if n.Labels == nil {
+			n.Labels = make(map[string]string)
+		}
+		n.Labels[key] = value
+	}
+
+	// The node is initialized once its InternalIP is known
+	if r.config.RemoveTaint && hasAddress(n, v1.NodeInternalIP) {
+		taints := make([]v1.Taint, 0, len(n.Spec.Taints))
+		for _, taint := range n.Spec.Taints {
+			if taint.Key != node.TaintKey {
+				taints = append(taints, taint)
+			}
+		}
+		n.Spec.Taints = taints
+		klog.V(2).Infof("Removing taint %s from registering node %s", node.TaintKey, n.Name)
+	}
+}
+
+// hasUninitializedTaint checks if the node waits for a cloud provider
+func hasUninitializedTaint(n *v1.Node) bool {
+	return hasTaint(n, node.TaintKey)
+}
+
+// hasAddress checks if the node has an address of the given type
+func hasAddress(n *v1.Node, addressType v1.NodeAddressType) bool {
+	for _, addr := range n.Status.Addresses {
+		if addr.Type == addressType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/certs"
+)
+
+const (
+	// webhookCertsLeaseName is the name of the Lease used to elect the
+	// single instance generating the webhook certificates
+	webhookCertsLeaseName = "local-ccm-webhook-certs"
+	// webhookCertsInterval is the interval between checks of the webhook
+	// certificates, and between reloads of the serving certificate
+	webhookCertsInterval = time.Minute
+	// webhookCertsWait is the interval between loads of the serving
+	// certificate while it is not generated yet
+	webhookCertsWait = 5 * time.Second
+
+	// Keys of the webhook certificate secret besides tls.crt and tls.key
+	webhookCACertKey   = "ca.crt"
+	webhookCABundleKey = "ca-bundle.crt"
+	// webhookCAKeyKey held the CA key in earlier versions, and is removed
+	webhookCAKeyKey = "ca.key"
+	// Keys of a renewed certificate and its CA, staged until the CA is
+	// injected into the caBundle of the webhooks
+	webhookPendingCACertKey = "pending-ca.crt"
+	webhookPendingCertKey   = "pending-tls.crt"
+	webhookPendingKeyKey    = "pending-tls.key"
+)
+
+// splitRef splits "[namespace/]name"
+func splitRef(ref, defaultNamespace string) (namespace, name string) {
+	namespace, name = defaultNamespace, ref
+	if i := strings.Index(name, "/"); i >= 0 {
+		namespace, name = name[:i], name[i+1:]
+	}
+	return namespace, name
+}
+
+// webhookDNSNames returns the DNS names of the webhook Service
+func (c *Config) webhookDNSNames() []string {
+	namespace, name := splitRef(c.WebhookService, c.Namespace)
+	return []string{name + "." + namespace + ".svc", name + "." + namespace, name}
+}
+
+// runWebhookCertController keeps the self-signed certificates of the
+// webhook in WebhookCertSecret valid and injects their CA bundle into
+// WebhookConfiguration until ctx is done
+func (r *runner) runWebhookCertController(ctx context.Context) {
+	klog.Infof("Managing the webhook certificates in secret %s", r.config.WebhookCertSecret)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		bundle, err := r.syncWebhookCerts(ctx, time.Now())
+		if err != nil {
+			klog.Errorf("Failed to sync webhook certificates: %v", err)
+			return
+		}
+		if err := r.injectCABundle(ctx, bundle); err != nil {
+			klog.Errorf("Failed to inject webhook CA bundle: %v", err)
+			return
+		}
+		if err := r.promoteWebhookCert(ctx); err != nil {
+			klog.Errorf("Failed to swap in renewed webhook certificate: %v", err)
+		}
+	}, webhookCertsInterval)
+}
+
+// syncWebhookCerts generates the serving certificate of the webhook if
+// missing, invalid or due for renewal, and returns the CA bundle. Each
+// certificate is signed by a new CA whose key is discarded right away, as
+// every instance reads the secret, so a compromised node cannot issue
+// certificates. The new certificate is staged under the pending keys, and
+// only swapped in by promoteWebhookCert once the bundle including its CA
+// was injected, so the API server trusts it before any instance serves it.
+// The previous CAs are kept in the bundle until they expire, so instances
+// still serving the previous certificate remain trusted until they load
+// the new one.
+func (r *runner) syncWebhookCerts(ctx context.Context, now time.Time) ([]byte, error) {
+	namespace, name := splitRef(r.config.WebhookCertSecret, r.config.Namespace)
+	secrets := r.leaseClient.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Type:       v1.SecretTypeTLS,
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	data := make(map[string][]byte, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+
+	dnsNames := r.config.webhookDNSNames()
+	if !webhookCertValid(data[webhookCACertKey], data[v1.TLSCertKey], dnsNames, now) &&
+		!webhookCertValid(data[webhookPendingCACertKey], data[webhookPendingCertKey], dnsNames, now) {
+		klog.Infof("Generating webhook serving certificate for %s in secret %s/%s", dnsNames[0], namespace, name)
+		ca, err := certs.GenerateCA("local-ccm-webhook-ca", now)
+		if err != nil {
+			return nil, err
+		}
+		serving, err := certs.GenerateServing(ca, dnsNames, now)
+		if err != nil {
+			return nil, err
+		}
+		data[webhookPendingCACertKey] = ca.Cert
+		data[webhookPendingCertKey], data[webhookPendingKeyKey] = serving.Cert, serving.Key
+	}
+	delete(data, webhookCAKeyKey)
+	data[webhookCABundleKey] = certs.Bundle(now, data[webhookPendingCACertKey], data[webhookCACertKey], data[webhookCABundleKey])
+
+	if secretDataEqual(secret.Data, data) {
+		return data[webhookCABundleKey], nil
+	}
+	secret = secret.DeepCopy()
+	secret.Data = data
+	if secret.ResourceVersion == "" {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write secret %s/%s: %w", namespace, name, err)
+	}
+	return data[webhookCABundleKey], nil
+}
+
+// promoteWebhookCert swaps the staged certificate in as the serving
+// certificate. It is called once the CA bundle was injected, as instances
+// start serving the certificate of the secret within a minute.
+func (r *runner) promoteWebhookCert(ctx context.Context) error {
+	namespace, name := splitRef(r.config.WebhookCertSecret, r.config.Namespace)
+	secrets := r.leaseClient.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	if _, ok := secret.Data[webhookPendingCertKey]; !ok {
+		return nil
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data[webhookCACertKey] = secret.Data[webhookPendingCACertKey]
+	secret.Data[v1.TLSCertKey] = secret.Data[webhookPendingCertKey]
+	secret.Data[v1.TLSPrivateKeyKey] = secret.Data[webhookPendingKeyKey]
+	delete(secret.Data, webhookPendingCACertKey)
+	delete(secret.Data, webhookPendingCertKey)
+	delete(secret.Data, webhookPendingKeyKey)
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %w", namespace, name, err)
+	}
+	klog.Infof("Swapped in the renewed webhook serving certificate in secret %s/%s", namespace, name)
+	return nil
+}
+
+// webhookCertValid reports whether the serving certificate is signed by
+// the CA, valid for the DNS names and not due for renewal
+func webhookCertValid(caData, certData []byte, dnsNames []string, now time.Time) bool {
+	caCert, err := certs.ParseCert(caData)
+	if err != nil {
+		return false
+	}
+	cert, err := certs.ParseCert(certData)
+	if err != nil {
+		return false
+	}
+	return !certs.NeedsRenewal(cert, now) && certs.Covers(cert, caCert, dnsNames)
+}
+
+// injectCABundle sets the CA bundle of the webhooks of WebhookConfiguration
+func (r *runner) injectCABundle(ctx context.Context, bundle []byte) error {
+	configurations := r.client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	configuration, err := configurations.Get(ctx, r.config.WebhookConfiguration, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get MutatingWebhookConfiguration %s: %w", r.config.WebhookConfiguration, err)
+	}
+	configuration = configuration.DeepCopy()
+	changed := false
+	for i := range configuration.Webhooks {
+		if !bytes.Equal(configuration.Webhooks[i].ClientConfig.CABundle, bundle) {
+			configuration.Webhooks[i].ClientConfig.CABundle = bundle
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := configurations.Update(ctx, configuration, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update MutatingWebhookConfiguration %s: %w", r.config.WebhookConfiguration, err)
+	}
+	klog.Infof("Injected the webhook CA bundle into MutatingWebhookConfiguration %s", r.config.WebhookConfiguration)
+	return nil
+}
+
+func secretDataEqual(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// secretCertificate serves the certificate of the webhook certificate
+// secret, reloading it every webhookCertsInterval
+type secretCertificate struct {
+	r *runner
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	raw  []byte
+}
+
+// run reloads the certificate until ctx is done
+func (s *secretCertificate) run(ctx context.Context) {
+	for {
+		if err := s.reload(ctx); err != nil {
+			klog.Errorf("Failed to reload webhook certificate: %v", err)
+		}
+		interval := webhookCertsInterval
+		s.mu.Lock()
+		if s.cert == nil {
+			interval = webhookCertsWait
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (s *secretCertificate) reload(ctx context.Context) error {
+	namespace, name := splitRef(s.r.config.WebhookCertSecret, s.r.config.Namespace)
+	secret, err := s.r.leaseClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.V(2).Infof("Waiting for the webhook certificate in secret %s/%s", namespace, name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	raw := append(append([]byte(nil), secret.Data[v1.TLSCertKey]...), secret.Data[v1.TLSPrivateKeyKey]...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(raw, s.raw) {
+		return nil
+	}
+	cert, err := tls.X509KeyPair(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("invalid certificate in secret %s/%s: %w", namespace, name, err)
+	}
+	klog.Infof("Loaded webhook certificate from secret %s/%s", namespace, name)
+	s.cert, s.raw = &cert, raw
+	return nil
+}
+
+func (s *secretCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cert == nil {
+		return nil, fmt.Errorf("no webhook certificate loaded yet")
+	}
+	return s.cert, nil
+}
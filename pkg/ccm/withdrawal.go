@@ -0,0 +1,148 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ccm
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// staleClock measures for how long an address has been stale
+type staleClock struct {
+	mu    sync.Mutex
+	since time.Time
+}
+
+// observe records that the address is stale and returns for how long
+func (c *staleClock) observe(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.since.IsZero() {
+		c.since = now
+	}
+	return now.Sub(c.since)
+}
+
+// reset records that the address is current
+func (c *staleClock) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.since = time.Time{}
+}
+
+// updateClock records when the addresses of the node were last updated
+type updateClock struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// wait returns how long the next update must wait to keep interval after
+// the last one
+func (c *updateClock) wait(now time.Time, interval time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if interval <= 0 || c.last.IsZero() {
+		return 0
+	}
+	return max(c.last.Add(interval).Sub(now), 0)
+}
+
+// record records an update
+func (c *updateClock) record(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = now
+}
+
+// holdRemovedAddresses keeps the published addresses of the types missing
+// from addressMap until they were missing for AddressRemovalGrace, so brief
+// uplink blips do not churn the node and the load balancers and DNS records
+// using its addresses
+func (r *runner) holdRemovedAddresses(currentNode *v1.Node, addressMap map[v1.NodeAddressType]string) {
+	if r.config.AddressRemovalGrace <= 0 {
+		return
+	}
+	now := time.Now()
+	for _, addrType := range []v1.NodeAddressType{v1.NodeInternalIP, v1.NodeExternalIP} {
+		clock := r.missingAddresses[addrType]
+		published := publishedAddress(currentNode, addrType)
+		if _, ok := addressMap[addrType]; ok || published == "" {
+			clock.reset()
+			continue
+		}
+		missing := clock.observe(now)
+		if missing < r.config.AddressRemovalGrace {
+			klog.V(2).Infof("Keeping %s %s for %v before removing it", addrType, published, (r.config.AddressRemovalGrace - missing).Round(time.Second))
+			addressMap[addrType] = published
+			continue
+		}
+		klog.Infof("Removing %s %s, missing for %v", addrType, published, missing.Round(time.Second))
+	}
+}
+
+// publishedAddress returns the published address of a type, or empty if none
+func publishedAddress(currentNode *v1.Node, addrType v1.NodeAddressType) string {
+	for _, addr := range currentNode.Status.Addresses {
+		if addr.Type == addrType {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+// withdrawExternalIP reports whether the published ExternalIP is withdrawn
+// after external detection failed, according to the withdrawal policy
+func (r *runner) withdrawExternalIP(published string) bool {
+	if r.config.ExternalIPWithdrawal == "" || published == "" {
+		r.staleExternalIP.reset()
+		return false
+	}
+	if r.config.ExternalIPWithdrawal == WithdrawExternalIPUnassigned && assignedLocally(published) {
+		klog.V(2).Infof("Keeping ExternalIP %s, it is still assigned to a local interface", published)
+		r.staleExternalIP.reset()
+		return false
+	}
+
+	stale := r.staleExternalIP.observe(time.Now())
+	if stale < r.config.ExternalIPWithdrawalGrace {
+		klog.V(2).Infof("Keeping stale ExternalIP %s for %v", published, (r.config.ExternalIPWithdrawalGrace - stale).Round(time.Second))
+		return false
+	}
+	klog.Warningf("Withdrawing ExternalIP %s, stale for %v", published, stale.Round(time.Second))
+	return true
+}
+
+// assignedLocally reports whether ip is assigned to a local interface. If
+// the interfaces cannot be listed, it is assumed to be.
+func assignedLocally(ip string) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		klog.Errorf("Failed to list local addresses: %v", err)
+		return true
+	}
+	target := net.ParseIP(ip)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(target) {
+			return true
+		}
+	}
+	return false
+}
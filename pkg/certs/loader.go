@@ -0,0 +1,82 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certs loads the serving certificates of the TLS endpoints of
+// local-ccm.
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Loader loads a serving certificate, reloading it when the files change,
+// e.g. when cert-manager renews the mounted secret
+type Loader struct {
+	name              string
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewLoader returns a loader of the certificate of the named endpoint
+func NewLoader(name, certFile, keyFile string) *Loader {
+	return &Loader{name: name, certFile: certFile, keyFile: keyFile}
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (l *Loader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	modTime := l.modTime
+	for _, path := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			if l.cert != nil {
+				// Keep serving the previous certificate while the secret is updated
+				return l.cert, nil
+			}
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if l.cert != nil && !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		if l.cert != nil {
+			klog.Errorf("Failed to reload %s certificate, keeping the previous one: %v", l.name, err)
+			return l.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load %s certificate: %w", l.name, err)
+	}
+	klog.Infof("Loaded %s certificate from %s", l.name, l.certFile)
+	l.cert = &cert
+	l.modTime = modTime
+	return l.cert, nil
+}
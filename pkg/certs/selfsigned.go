@@ -0,0 +1,192 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// Validity of the generated certificates. CAs are only valid as long as
+// the serving certificate they sign, as their key is not kept.
+const (
+	CAValidity      = ServingValidity
+	ServingValidity = 365 * 24 * time.Hour
+)
+
+// clockSkew backdates the generated certificates
+const clockSkew = 5 * time.Minute
+
+// KeyPair is a PEM-encoded certificate and its key
+type KeyPair struct {
+	Cert []byte
+	Key  []byte
+}
+
+// GenerateCA generates a self-signed CA
+func GenerateCA(commonName string, now time.Time) (KeyPair, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return generate(template, nil, nil)
+}
+
+// GenerateServing generates a serving certificate for the DNS names,
+// signed by the CA
+func GenerateServing(ca KeyPair, dnsNames []string, now time.Time) (KeyPair, error) {
+	caCert, err := ParseCert(ca.Cert)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	caKey, err := parseKey(ca.Key)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("invalid CA key: %w", err)
+	}
+	notAfter := now.Add(ServingValidity)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-clockSkew),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return generate(template, caCert, caKey)
+}
+
+// generate signs the template with the parent, or self-signs it if nil
+func generate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return KeyPair{}, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return KeyPair{
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// ParseCert parses the first certificate of the PEM data
+func ParseCert(data []byte) (*x509.Certificate, error) {
+	certs, err := ParseCerts(data)
+	if err != nil {
+		return nil, err
+	}
+	return certs[0], nil
+}
+
+// ParseCerts parses all certificates of the PEM data
+func ParseCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+func parseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// NeedsRenewal reports whether less than a third of the validity of the
+// certificate is left, so it is renewed well before it expires
+func NeedsRenewal(cert *x509.Certificate, now time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < lifetime/3
+}
+
+// Covers reports whether the serving certificate is signed by the CA and
+// valid for the DNS names
+func Covers(cert, ca *x509.Certificate, dnsNames []string) bool {
+	if cert.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	return slices.Equal(cert.DNSNames, dnsNames)
+}
+
+// Bundle joins the PEM certificates that are still valid, skipping
+// duplicates, for a CA bundle keeping previous CAs during rotation
+func Bundle(now time.Time, certs ...[]byte) []byte {
+	var bundle bytes.Buffer
+	seen := make(map[string]bool)
+	for _, data := range certs {
+		parsed, err := ParseCerts(data)
+		if err != nil {
+			continue
+		}
+		for _, cert := range parsed {
+			if now.After(cert.NotAfter) || seen[string(cert.Raw)] {
+				continue
+			}
+			seen[string(cert.Raw)] = true
+			_ = pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+	}
+	return bundle.Bytes()
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+// Config is the content of the local-ccm config file
+type Config struct {
+	// BGP configures the announcement of addresses to BGP peers
+	BGP *BGPConfig `json:"bgp,omitempty"`
+	// Detection configures how each address type is detected, overriding
+	// the detection flags that are not set explicitly
+	Detection *DetectionConfig `json:"detection,omitempty"`
+}
+
+// DetectionConfig configures the detection per address type
+type DetectionConfig struct {
+	InternalIP *AddressDetection `json:"internalIP,omitempty"`
+	ExternalIP *AddressDetection `json:"externalIP,omitempty"`
+}
+
+// AddressDetection configures the detection of one address type
+type AddressDetection struct {
+	// Target is the IP to detect the address for, comma-separated targets
+	// are tried in order. Detectors like interface only use it to select
+	// the address family.
+	Target string `json:"target,omitempty"`
+	// Detectors are tried in order until one succeeds, in the syntax of
+	// the --detector flag, e.g. ["interface:eth1"] or
+	// ["talos", "route:8.8.8.8"]
+	Detectors []string `json:"detectors,omitempty"`
+}
+
+// BGPConfig configures the local BGP speaker
+type BGPConfig struct {
+	// ASN is the autonomous system number of the nodes
+	ASN uint32 `json:"asn"`
+	// RouterID is the BGP identifier. If empty, the IPv4 InternalIP of the node is used.
+	RouterID string `json:"routerID,omitempty"`
+	// AdvertiseNodeExternalIPs also advertises the ExternalIPs of the node
+	AdvertiseNodeExternalIPs bool `json:"advertiseNodeExternalIPs,omitempty"`
+	// Peers are the routers to advertise to
+	Peers []BGPPeer `json:"peers"`
+}
+
+// BGPPeer configures a single BGP session
+type BGPPeer struct {
+	// Address is the IP of the peer
+	Address string `json:"address"`
+	// ASN is the autonomous system number of the peer
+	ASN uint32 `json:"asn"`
+	// Port is the TCP port of the peer, 179 if unset
+	Port int `json:"port,omitempty"`
+	// HoldTime is the proposed hold time, 90s if unset
+	HoldTime metav1.Duration `json:"holdTime,omitempty"`
+	// NodeSelector restricts the nodes peering with this router, e.g. to the
+	// nodes of one rack. If empty, all nodes peer.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// Load reads and validates the config file
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Validate checks the config for errors
+func (c *Config) Validate() error {
+	if c.Detection != nil {
+		for _, d := range []struct {
+			name      string
+			detection *AddressDetection
+		}{
+			{"internalIP", c.Detection.InternalIP},
+			{"externalIP", c.Detection.ExternalIP},
+		} {
+			if d.detection == nil || len(d.detection.Detectors) == 0 {
+				continue
+			}
+			if _, err := detector.ParseChain(d.detection.Detectors); err != nil {
+				return fmt.Errorf("detection.%s.detectors: %w", d.name, err)
+			}
+		}
+	}
+
+	if c.BGP == nil {
+		return nil
+	}
+
+	if c.BGP.ASN == 0 {
+		return fmt.Errorf("bgp.asn must be set")
+	}
+	if c.BGP.RouterID != "" {
+		if ip := net.ParseIP(c.BGP.RouterID); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("bgp.routerID %q is not an IPv4 address", c.BGP.RouterID)
+		}
+	}
+	for i, peer := range c.BGP.Peers {
+		if net.ParseIP(peer.Address) == nil {
+			return fmt.Errorf("bgp.peers[%d].address %q is not an IP address", i, peer.Address)
+		}
+		if peer.ASN == 0 {
+			return fmt.Errorf("bgp.peers[%d].asn must be set", i)
+		}
+		if peer.Port < 0 || peer.Port > 65535 {
+			return fmt.Errorf("bgp.peers[%d].port %d is out of range", i, peer.Port)
+		}
+		if peer.HoldTime.Duration != 0 && peer.HoldTime.Duration < 3*time.Second {
+			return fmt.Errorf("bgp.peers[%d].holdTime must be at least 3s", i)
+		}
+	}
+	return nil
+}
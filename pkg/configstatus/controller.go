@@ -0,0 +1,228 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configstatus reports the rollout of LocalCCMConfig resources in
+// their status, counting the nodes that applied each generation.
+package configstatus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// GenerationAnnotation records the LocalCCMConfig last applied by the agent
+// of a node as <name>/<generation>
+const GenerationAnnotation = "local-ccm.io/config-generation"
+
+// FormatGeneration returns the GenerationAnnotation value of a config
+func FormatGeneration(name string, generation int64) string {
+	return name + "/" + strconv.FormatInt(generation, 10)
+}
+
+// parseGeneration parses a GenerationAnnotation value
+func parseGeneration(value string) (string, int64, bool) {
+	i := strings.LastIndex(value, "/")
+	if i < 0 {
+		return "", 0, false
+	}
+	generation, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return value[:i], generation, true
+}
+
+// Controller counts the nodes per applied generation of each LocalCCMConfig
+// and writes them to its status
+type Controller struct {
+	client dynamic.Interface
+
+	nodeLister     corelisters.NodeLister
+	configInformer cache.SharedIndexInformer
+	synced         []cache.InformerSynced
+
+	// nodeHandler is removed from the shared node informer when Run returns
+	nodeInformer cache.SharedIndexInformer
+	nodeHandler  cache.ResourceEventHandlerRegistration
+
+	trigger chan struct{}
+}
+
+// NewController creates a status controller
+func NewController(client dynamic.Interface, factory informers.SharedInformerFactory, configFactory dynamicinformer.DynamicSharedInformerFactory) (*Controller, error) {
+	c := &Controller{
+		client:  client,
+		trigger: make(chan struct{}, 1),
+	}
+
+	nodeInformer := factory.Core().V1().Nodes()
+	registration, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { c.enqueue() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok1 := oldObj.(*v1.Node)
+			newNode, ok2 := newObj.(*v1.Node)
+			if ok1 && ok2 && oldNode.Annotations[GenerationAnnotation] == newNode.Annotations[GenerationAnnotation] {
+				return
+			}
+			c.enqueue()
+		},
+		DeleteFunc: func(interface{}) { c.enqueue() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+	c.nodeInformer, c.nodeHandler = nodeInformer.Informer(), registration
+	c.nodeLister = nodeInformer.Lister()
+
+	c.configInformer = configFactory.ForResource(v1alpha1.LocalCCMConfigResource).Informer()
+	if _, err := c.configInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.enqueue() },
+		UpdateFunc: func(oldObj, newObj interface{}) { c.enqueue() },
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add LocalCCMConfig event handler: %w", err)
+	}
+
+	c.synced = []cache.InformerSynced{registration.HasSynced, c.configInformer.HasSynced}
+	return c, nil
+}
+
+// Run keeps the status of the LocalCCMConfigs up to date until the context
+// is cancelled
+func (c *Controller) Run(ctx context.Context) {
+	defer func() {
+		if err := c.nodeInformer.RemoveEventHandler(c.nodeHandler); err != nil {
+			klog.Errorf("Failed to remove node event handler: %v", err)
+		}
+	}()
+
+	klog.Info("Starting LocalCCMConfig status controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		klog.Error("Failed to wait for LocalCCMConfig status controller caches to sync")
+		return
+	}
+
+	// Resync periodically in case an update failed
+	go wait.UntilWithContext(ctx, func(context.Context) { c.enqueue() }, time.Minute)
+
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Stopping LocalCCMConfig status controller")
+			return
+		case <-c.trigger:
+			if err := c.sync(ctx); err != nil {
+				klog.Errorf("Failed to update LocalCCMConfig status: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Controller) enqueue() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync updates the status of all LocalCCMConfigs from the node annotations
+func (c *Controller) sync(ctx context.Context) error {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	// Count the nodes per config and generation
+	counts := make(map[string]map[int64]int32)
+	for _, node := range nodes {
+		name, generation, ok := parseGeneration(node.Annotations[GenerationAnnotation])
+		if !ok {
+			continue
+		}
+		if counts[name] == nil {
+			counts[name] = make(map[int64]int32)
+		}
+		counts[name][generation]++
+	}
+
+	var errs []error
+	for _, obj := range c.configInformer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		if err := c.updateStatus(ctx, u, counts[u.GetName()]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// updateStatus writes the node counts of a config to its status if changed
+func (c *Controller) updateStatus(ctx context.Context, u *unstructured.Unstructured, counts map[int64]int32) error {
+	status := v1alpha1.LocalCCMConfigStatus{
+		ObservedGeneration: u.GetGeneration(),
+		UpdatedNodes:       counts[u.GetGeneration()],
+	}
+	for generation, nodes := range counts {
+		status.Generations = append(status.Generations, v1alpha1.GenerationStatus{Generation: generation, Nodes: nodes})
+	}
+	// Newest generation first
+	sort.Slice(status.Generations, func(i, j int) bool {
+		return status.Generations[i].Generation > status.Generations[j].Generation
+	})
+
+	current := &v1alpha1.LocalCCMConfig{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, current); err == nil && reflect.DeepEqual(current.Status, status) {
+		return nil
+	}
+
+	value, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("failed to convert status of LocalCCMConfig %s: %w", u.GetName(), err)
+	}
+	u = u.DeepCopy()
+	if err := unstructured.SetNestedField(u.Object, value, "status"); err != nil {
+		return err
+	}
+	if _, err := c.client.Resource(v1alpha1.LocalCCMConfigResource).UpdateStatus(ctx, u, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update status of LocalCCMConfig %s: %w", u.GetName(), err)
+	}
+	klog.V(2).Infof("Updated status of LocalCCMConfig %s: %d nodes on generation %d", u.GetName(), status.UpdatedNodes, status.ObservedGeneration)
+	return nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+)
+
+// Address flags reported by enumerators, as named by iproute2
+const (
+	FlagSecondary  = "secondary"
+	FlagTemporary  = "temporary"
+	FlagDeprecated = "deprecated"
+	FlagTentative  = "tentative"
+	FlagDADFailed  = "dadfailed"
+)
+
+// Address scopes of candidates
+const (
+	ScopeGlobal = "global"
+	ScopeLink   = "link"
+	ScopeHost   = "host"
+)
+
+// AddressCandidate is an address a detector can pick, with the metadata a
+// Policy ranks and filters it by
+type AddressCandidate struct {
+	Address   string `json:"address"`
+	Interface string `json:"interface,omitempty"`
+	// Scope is global, link or host, derived from the address
+	Scope string `json:"scope,omitempty"`
+	// Flags are the flags of the address, e.g. FlagSecondary
+	Flags []string `json:"flags,omitempty"`
+	// Gateway, RouteMetric and PrefixLength are of the route the candidate
+	// was found via, PrefixLength 0 for default routes
+	Gateway      string `json:"gateway,omitempty"`
+	RouteMetric  int    `json:"routeMetric,omitempty"`
+	PrefixLength int    `json:"prefixLength,omitempty"`
+	// Unhealthy tells why the link of the address cannot carry traffic,
+	// empty if it can
+	Unhealthy string `json:"unhealthy,omitempty"`
+}
+
+// HasFlag checks if the candidate has the flag
+func (c AddressCandidate) HasFlag(flag string) bool {
+	return slices.Contains(c.Flags, flag)
+}
+
+// Enumerator is implemented by detectors listing all candidate addresses for
+// a target instead of a single one, in the order they were found, leaving
+// the selection to a Policy
+type Enumerator interface {
+	Candidates(target string) ([]AddressCandidate, error)
+}
+
+// Policy ranks and filters the candidates of an Enumerator
+type Policy interface {
+	// Rank orders the candidates by preference
+	Rank(candidates []AddressCandidate)
+	// Reject returns why a candidate cannot be picked, or empty if it can
+	Reject(candidate AddressCandidate) string
+}
+
+// Names of the policies of ParsePolicy
+const (
+	PolicyPrimary = "primary"
+	PolicyStable  = "stable"
+)
+
+// PrimaryPolicy picks the primary address: the first global unicast address
+// that is neither secondary, temporary, deprecated nor tentative, preferring
+// healthy links, then more specific routes and lower route metrics
+type PrimaryPolicy struct{}
+
+// Rank moves the candidates of healthy links, more specific routes and lower
+// route metrics first
+func (PrimaryPolicy) Rank(candidates []AddressCandidate) {
+	rankByRoute(candidates)
+}
+
+// Reject returns why the candidate is not a primary address
+func (PrimaryPolicy) Reject(candidate AddressCandidate) string {
+	switch {
+	case candidate.Scope != ScopeGlobal:
+		return "not a global unicast address"
+	case candidate.HasFlag(FlagSecondary):
+		return "secondary address"
+	case candidate.HasFlag(FlagDeprecated) || candidate.HasFlag(FlagTentative) || candidate.HasFlag(FlagDADFailed):
+		return "deprecated or tentative address"
+	case candidate.HasFlag(FlagTemporary):
+		return "temporary address"
+	}
+	return ""
+}
+
+// StablePolicy is PrimaryPolicy accepting secondary addresses, e.g. for
+// hosts whose primary address is on another network than the node IP
+type StablePolicy struct{}
+
+// Rank ranks like PrimaryPolicy, so primary addresses still come first
+func (StablePolicy) Rank(candidates []AddressCandidate) {
+	rankByRoute(candidates)
+}
+
+// Reject returns why the candidate is not a stable address
+func (StablePolicy) Reject(candidate AddressCandidate) string {
+	if candidate.HasFlag(FlagSecondary) {
+		candidate.Flags = slices.DeleteFunc(slices.Clone(candidate.Flags), func(flag string) bool { return flag == FlagSecondary })
+	}
+	return PrimaryPolicy{}.Reject(candidate)
+}
+
+// rankByRoute moves the candidates of healthy links, more specific routes
+// and lower route metrics first, keeping the order of ties
+func rankByRoute(candidates []AddressCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.Unhealthy == "") != (b.Unhealthy == "") {
+			return a.Unhealthy == ""
+		}
+		if a.PrefixLength != b.PrefixLength {
+			return a.PrefixLength > b.PrefixLength
+		}
+		return a.RouteMetric < b.RouteMetric
+	})
+}
+
+// ParsePolicy returns the policy named PolicyPrimary or PolicyStable
+func ParsePolicy(name string) (Policy, error) {
+	switch name {
+	case "", PolicyPrimary:
+		return PrimaryPolicy{}, nil
+	case PolicyStable:
+		return StablePolicy{}, nil
+	}
+	return nil, fmt.Errorf("unknown address policy %q", name)
+}
+
+// WithPolicy sets the policy of the enumerating detectors of d, including
+// those of chains and pinned, probing and fallback detectors, returning the
+// others unchanged
+func WithPolicy(d Detector, policy Policy) Detector {
+	switch d := d.(type) {
+	case Route:
+		d.Policy = policy
+		return d
+	case Interface:
+		d.Policy = policy
+		return d
+	case DefaultInterface:
+		d.Policy = policy
+		return d
+	case Pinned:
+		d.Detector = WithPolicy(d.Detector, policy)
+		return d
+	case Probing:
+		d.Detector = WithPolicy(d.Detector, policy)
+		return d
+	case Talos:
+		if d.Fallback == nil {
+			d.Fallback = Route{}
+		}
+		d.Fallback = WithPolicy(d.Fallback, policy)
+		return d
+	case KubeVirt:
+		if d.Fallback == nil {
+			d.Fallback = Route{}
+		}
+		d.Fallback = WithPolicy(d.Fallback, policy)
+		return d
+	case Chain:
+		chain := make(Chain, len(d))
+		for i, link := range d {
+			chain[i] = WithPolicy(link, policy)
+		}
+		return chain
+	}
+	return d
+}
+
+// Select sets the address of the detection to the best ranked candidate the
+// policy does not reject, or PrimaryPolicy if nil, listing the other
+// candidates as filtered. A candidate of an unhealthy link is picked, but
+// marks the detection as degraded.
+func Select(policy Policy, detection Detection, candidates []AddressCandidate) Detection {
+	if policy == nil {
+		policy = PrimaryPolicy{}
+	}
+	ranked := slices.Clone(candidates)
+	policy.Rank(ranked)
+
+	var picked *AddressCandidate
+	for i := range ranked {
+		candidate := ranked[i]
+		reason := policy.Reject(candidate)
+		switch {
+		case reason != "":
+		case picked == nil:
+			picked = &ranked[i]
+			continue
+		case candidate.Interface != picked.Interface && candidate.Unhealthy != "":
+			reason = candidate.Unhealthy
+		case candidate.Interface != picked.Interface:
+			reason = fmt.Sprintf("route via %s ranks lower than via %s", candidate.Interface, picked.Interface)
+		default:
+			reason = "not the primary address of " + candidate.Interface
+		}
+		detection.Filtered = append(detection.Filtered, Candidate{Address: candidate.Address, Reason: reason})
+	}
+	if picked == nil {
+		detection.Error = "no usable address"
+		if detection.Interface != "" {
+			detection.Error = fmt.Sprintf("interface %s has no usable address", detection.Interface)
+		}
+		return detection
+	}
+
+	detection.Address = picked.Address
+	if picked.Interface != "" {
+		detection.Interface = picked.Interface
+	}
+	if picked.Gateway != "" {
+		detection.Gateway = picked.Gateway
+	}
+	// Keep the address of a link without carrier, but let chains skip it
+	detection.Degraded = picked.Unhealthy
+	return detection
+}
+
+// addressScope returns the scope of an address
+func addressScope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return ScopeHost
+	case ip.IsGlobalUnicast():
+		return ScopeGlobal
+	}
+	return ScopeLink
+}
+
+// targetFamilyIPv6 checks if the target selects the IPv6 family
+func targetFamilyIPv6(target string) bool {
+	ip := net.ParseIP(target)
+	return ip != nil && ip.To4() == nil
+}
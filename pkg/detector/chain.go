@@ -0,0 +1,91 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+
+	"k8s.io/klog/v2"
+)
+
+// Chain tries its detectors in order, returning the first detection that
+// neither failed nor is degraded, e.g. [talos, route:8.8.8.8]. The addresses
+// of the skipped detectors are listed as filtered candidates. If every
+// detector fails, the detection of the first one is returned.
+type Chain []Detector
+
+// Detect returns the first successful detection of the chain
+func (c Chain) Detect(target string) Detection {
+	var first Detection
+	var skipped []Candidate
+	for i, d := range c {
+		detection := d.Detect(target)
+		if detection.Error == "" && detection.Degraded == "" {
+			detection.Filtered = append(detection.Filtered, skipped...)
+			return detection
+		}
+		if i == 0 {
+			first = detection
+		}
+
+		reason := detection.Error
+		if reason == "" {
+			reason = detection.Degraded
+		}
+		klog.V(2).Infof("Detection via %s failed, trying the next detector: %s", detection.Strategy, reason)
+		if detection.Address != "" {
+			skipped = append(skipped, Candidate{
+				Address: detection.Address,
+				Reason:  fmt.Sprintf("%s: %s", detection.Strategy, reason),
+			})
+		}
+	}
+	return first
+}
+
+// Pinned detects the address via a fixed target instead of the configured
+// one, e.g. to try another target in a chain
+type Pinned struct {
+	Detector Detector
+	Target   string
+}
+
+// Detect detects the address via the pinned target
+func (p Pinned) Detect(string) Detection {
+	return p.Detector.Detect(p.Target)
+}
+
+// ParseChain creates a detector trying the detectors of specs in order. A
+// single spec yields the detector itself.
+func ParseChain(specs []string) (Detector, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no detectors")
+	}
+	if len(specs) == 1 {
+		return ParseDetector(specs[0])
+	}
+
+	chain := make(Chain, 0, len(specs))
+	for _, spec := range specs {
+		d, err := ParseDetector(spec)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, d)
+	}
+	return chain, nil
+}
@@ -0,0 +1,31 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+// StrategyDefaultInterface picks the primary address of the interface
+// carrying the default route
+const StrategyDefaultInterface = "default-interface"
+
+// DefaultInterface detects the primary address of the interface carrying the
+// default route with the lowest metric, of the address family of the target,
+// matching the common notion of "the node IP" better than the route to a
+// specific target. The target only selects the family.
+type DefaultInterface struct {
+	// Policy selects the address of the interfaces of the default routes,
+	// PrimaryPolicy if nil
+	Policy Policy
+}
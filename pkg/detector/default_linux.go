@@ -0,0 +1,92 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Candidates returns the addresses of the interfaces carrying the default
+// routes of the family of the target, ranked by their routes
+func (DefaultInterface) Candidates(target string) ([]AddressCandidate, error) {
+	family := netlinkFamily(target)
+	routes, err := defaultRoutes(target)
+	if err != nil {
+		return nil, err
+	}
+	ranked := rankRoutes(routes)
+	if len(ranked) == 0 {
+		return nil, errors.New("no default route found")
+	}
+	return routeCandidates(ranked, family, false)
+}
+
+// Detect returns the address of the interfaces carrying the default routes
+// of the family of the target selected by the policy, by default the
+// primary address of the interface of the route with the lowest metric
+func (d DefaultInterface) Detect(target string) Detection {
+	detection := Detection{
+		Strategy: StrategyDefaultInterface,
+		Target:   target,
+	}
+	candidates, err := d.Candidates(target)
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+	detection = Select(d.Policy, detection, candidates)
+	if detection.Error != "" {
+		detection.Error = "default route: " + detection.Error
+	}
+	return detection
+}
+
+// defaultRoutes returns the default routes of the family of the target, of
+// the first routing table consulted by the policy routing rules for the
+// target that has one
+func defaultRoutes(target string) ([]netlink.Route, error) {
+	family := netlinkFamily(target)
+	ip := net.ParseIP(target)
+	if ip == nil {
+		ip = net.IPv4zero
+		if family == netlink.FAMILY_V6 {
+			ip = net.IPv6zero
+		}
+	}
+	for _, lookup := range lookupTables(ip, family) {
+		routes, err := tableRoutes(family, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list routes of table %d: %w", lookup.table, err)
+		}
+		var defaults []netlink.Route
+		for _, route := range routes {
+			if prefixLength(route) == 0 {
+				defaults = append(defaults, route)
+			}
+		}
+		if len(defaults) > 0 {
+			return defaults, nil
+		}
+	}
+	return nil, nil
+}
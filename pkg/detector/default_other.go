@@ -0,0 +1,32 @@
+//go:build !linux || purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import "errors"
+
+// Candidates fails, as the routing table cannot be listed without netlink
+func (DefaultInterface) Candidates(target string) ([]AddressCandidate, error) {
+	return nil, errors.New("listing default routes requires netlink")
+}
+
+// Detect falls back to the source address of the route to the target, as
+// the routing table cannot be listed without netlink
+func (DefaultInterface) Detect(target string) Detection {
+	return detectUDP(target)
+}
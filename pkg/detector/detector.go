@@ -0,0 +1,142 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// StrategyStatic returns preconfigured addresses without touching the host
+const StrategyStatic = "static"
+
+// Detector detects the local address used to reach a target
+type Detector interface {
+	Detect(target string) Detection
+}
+
+// Route detects addresses from the routing table via netlink on Linux, and
+// falls back to UDP elsewhere or when built with the purego tag
+type Route struct {
+	// Policy selects the address of the link of a healthy route when the
+	// kernel routes via an unhealthy link, PrimaryPolicy if nil
+	Policy Policy
+}
+
+// Detect returns the source IP of the route to the target
+func (r Route) Detect(target string) Detection {
+	return detectRoute(target, r.Policy)
+}
+
+// Static returns fixed addresses, e.g. for CI and kind clusters without
+// meaningful routes
+type Static struct {
+	// Default is returned for targets without an entry in ByTarget
+	Default string
+	// ByTarget maps targets to the address returned for them
+	ByTarget map[string]string
+}
+
+// Detect returns the configured address of the target
+func (s Static) Detect(target string) Detection {
+	detection := Detection{
+		Strategy: StrategyStatic,
+		Target:   target,
+		Address:  s.Default,
+	}
+	if addr, ok := s.ByTarget[target]; ok {
+		detection.Address = addr
+	}
+	if detection.Address == "" {
+		detection.Error = fmt.Sprintf("no static address for target %s", target)
+	}
+	return detection
+}
+
+// ParseDetector creates a detector from its spec: "route" (the default),
+// "udp", "route:<target>" or "udp:<target>" pinning the target,
+// "interface:<name>" for the primary address of an interface,
+// "default-interface" for the primary address of the interface of the
+// default route, "talos[:<path>]" reading the Talos machine config,
+// "kubevirt[:<interface>]" preferring the pod network interface of KubeVirt
+// VMs, "static:<ip>" returning ip for every target,
+// "static:<target>=<ip>[,<target>=<ip>...]" returning an ip per target, or
+// the name of a plugin registered by LoadPlugins
+func ParseDetector(spec string) (Detector, error) {
+	if spec == "" || spec == StrategyRoute {
+		return Route{}, nil
+	}
+	if spec == StrategyUDP {
+		return UDP{}, nil
+	}
+	for _, pinnable := range []struct {
+		strategy string
+		detector Detector
+	}{{StrategyRoute, Route{}}, {StrategyUDP, UDP{}}} {
+		if target, ok := strings.CutPrefix(spec, pinnable.strategy+":"); ok {
+			if net.ParseIP(target) == nil {
+				return nil, fmt.Errorf("invalid target %q", target)
+			}
+			return Pinned{Detector: pinnable.detector, Target: target}, nil
+		}
+	}
+	if name, ok := strings.CutPrefix(spec, StrategyInterface+":"); ok && name != "" {
+		return Interface{Name: name}, nil
+	}
+	if spec == StrategyDefaultInterface {
+		return DefaultInterface{}, nil
+	}
+	if spec == StrategyTalos {
+		return Talos{}, nil
+	}
+	if path, ok := strings.CutPrefix(spec, StrategyTalos+":"); ok {
+		return Talos{ConfigPath: path}, nil
+	}
+	if spec == StrategyKubeVirt {
+		return KubeVirt{}, nil
+	}
+	if iface, ok := strings.CutPrefix(spec, StrategyKubeVirt+":"); ok {
+		return KubeVirt{Interface: iface}, nil
+	}
+
+	if plugin, ok := lookupPlugin(spec); ok {
+		return plugin, nil
+	}
+
+	value, ok := strings.CutPrefix(spec, StrategyStatic+":")
+	if !ok {
+		return nil, fmt.Errorf("unknown detector %q", spec)
+	}
+
+	static := Static{ByTarget: make(map[string]string)}
+	for _, entry := range strings.Split(value, ",") {
+		target, addr, perTarget := strings.Cut(entry, "=")
+		if !perTarget {
+			addr = target
+		}
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid static address %q", addr)
+		}
+		if perTarget {
+			static.ByTarget[target] = addr
+		} else {
+			static.Default = addr
+		}
+	}
+	return static, nil
+}
@@ -0,0 +1,72 @@
+//go:build !linux || purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+)
+
+// Candidates returns the addresses of the interface of the family of the
+// target. Address flags are not available without netlink.
+func (i Interface) Candidates(target string) ([]AddressCandidate, error) {
+	iface, err := net.InterfaceByName(i.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface %s: %v", i.Name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %v", i.Name, err)
+	}
+	var unhealthy string
+	if iface.Flags&net.FlagUp == 0 {
+		unhealthy = fmt.Sprintf("link %s is down", i.Name)
+	}
+	ipv6 := targetFamilyIPv6(target)
+	var candidates []AddressCandidate
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		candidates = append(candidates, AddressCandidate{
+			Address:   ipNet.IP.String(),
+			Interface: i.Name,
+			Scope:     addressScope(ipNet.IP),
+			Unhealthy: unhealthy,
+		})
+	}
+	return candidates, nil
+}
+
+// Detect returns the address of the interface of the family of the target
+// selected by the policy
+func (i Interface) Detect(target string) Detection {
+	detection := Detection{
+		Strategy:  StrategyInterface,
+		Target:    target,
+		Interface: i.Name,
+	}
+	candidates, err := i.Candidates(target)
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+	return Select(i.Policy, detection, candidates)
+}
@@ -17,49 +17,51 @@ limitations under the License.
 package detector
 
 import (
+	"errors"
 	"fmt"
 	"net"
 
-	"github.com/vishvananda/netlink"
 	"k8s.io/klog/v2"
 )
 
-// DetectIP detects the local IP address by using netlink to query the route
-// to the target IP and extracting the source IP from the route
+// DetectIP detects the local IP address used to reach the target IP, using
+// the route backend of the platform
 func DetectIP(targetIP string) (string, error) {
-	if targetIP == "" {
-		return "", fmt.Errorf("target IP is empty")
+	detection := Detect(targetIP)
+	if detection.Error != "" {
+		return "", errors.New(detection.Error)
 	}
 
-	// Parse target IP
-	dstIP := net.ParseIP(targetIP)
-	if dstIP == nil {
-		return "", fmt.Errorf("invalid target IP address: %s", targetIP)
-	}
+	klog.V(4).Infof("Detected IP: %s (target: %s)", detection.Address, targetIP)
 
-	klog.V(4).Infof("Detecting IP using target: %s", targetIP)
+	return detection.Address, nil
+}
 
-	// Get route to target IP using netlink
-	routes, err := netlink.RouteGet(dstIP)
-	if err != nil {
-		return "", fmt.Errorf("failed to get route to %s: %w", targetIP, err)
+// parseTarget parses the target IP of a detection
+func parseTarget(targetIP string) (net.IP, error) {
+	if targetIP == "" {
+		return nil, fmt.Errorf("target IP is empty")
 	}
-
-	if len(routes) == 0 {
-		return "", fmt.Errorf("no route found to %s", targetIP)
+	dstIP := net.ParseIP(targetIP)
+	if dstIP == nil {
+		return nil, fmt.Errorf("invalid target IP address: %s", targetIP)
 	}
+	return dstIP, nil
+}
 
-	// Get the first route (preferred route)
-	route := routes[0]
-
-	// Extract source IP from route
-	if route.Src == nil {
-		return "", fmt.Errorf("route to %s has no source IP", targetIP)
+// filteredCandidates returns the addresses of the interface that were not
+// picked as the source address to reach the target
+func filteredCandidates(src net.IP, addrs []net.IP, targetIP string) []Candidate {
+	var filtered []Candidate
+	for _, addr := range addrs {
+		if addr.Equal(src) || (addr.To4() == nil) != (src.To4() == nil) {
+			continue
+		}
+		reason := "not the preferred source of the route to " + targetIP
+		if !addr.IsGlobalUnicast() {
+			reason = "not a global unicast address"
+		}
+		filtered = append(filtered, Candidate{Address: addr.String(), Reason: reason})
 	}
-
-	detectedIP := route.Src.String()
-
-	klog.V(4).Infof("Detected IP: %s (target: %s)", detectedIP, targetIP)
-
-	return detectedIP, nil
+	return filtered
 }
@@ -0,0 +1,157 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// StrategyKubeVirt picks the address of the pod network interface of a
+// KubeVirt VM
+const StrategyKubeVirt = "kubevirt"
+
+const (
+	// dmiVendorPath holds the system vendor, "KubeVirt" in KubeVirt VMs
+	dmiVendorPath = "/sys/class/dmi/id/sys_vendor"
+	// sysClassNet lists the network interfaces with their devices
+	sysClassNet = "/sys/class/net"
+)
+
+var (
+	isKubeVirtOnce sync.Once
+	isKubeVirt     bool
+)
+
+// IsKubeVirt checks if the host is a KubeVirt VM by its DMI system vendor
+func IsKubeVirt() bool {
+	isKubeVirtOnce.Do(func() {
+		data, err := os.ReadFile(dmiVendorPath)
+		isKubeVirt = err == nil && strings.TrimSpace(string(data)) == "KubeVirt"
+		if isKubeVirt {
+			klog.V(2).Infof("Running in a KubeVirt VM")
+		}
+	})
+	return isKubeVirt
+}
+
+// KubeVirt detects addresses on KubeVirt VMs, where the routes of nested
+// networks (e.g. Multus secondary networks) often lead to the wrong NIC.
+// The address of the pod network interface is preferred over the source of
+// the route to the target. Outside of KubeVirt, it detects like Fallback.
+type KubeVirt struct {
+	// Interface is the pod network interface. If empty, the first virtio NIC
+	// in PCI order is used, as KubeVirt attaches the pod network first.
+	Interface string
+	// Fallback detects the route to the target, Route if nil
+	Fallback Detector
+}
+
+// Detect returns the address of the pod network interface for the target
+func (k KubeVirt) Detect(target string) Detection {
+	fallback := k.Fallback
+	if fallback == nil {
+		fallback = Route{}
+	}
+	detection := fallback.Detect(target)
+	if detection.Error != "" || !IsKubeVirt() {
+		return detection
+	}
+
+	iface := k.Interface
+	if iface == "" {
+		var err error
+		if iface, err = firstVirtioInterface(); err != nil {
+			klog.V(3).Infof("Keeping the route source, failed to find the pod network interface: %v", err)
+			return detection
+		}
+	}
+	if detection.Interface == iface {
+		return detection
+	}
+
+	addr, err := interfaceAddress(iface, net.ParseIP(detection.Address).To4() != nil)
+	if err != nil {
+		klog.V(3).Infof("Keeping the route source %s: %v", detection.Address, err)
+		return detection
+	}
+	detection.Filtered = append(detection.Filtered, Candidate{
+		Address: detection.Address,
+		Reason:  fmt.Sprintf("routed via %s instead of the pod network interface %s", detection.Interface, iface),
+	})
+	detection.Strategy = StrategyKubeVirt
+	detection.Address = addr.String()
+	detection.Interface = iface
+	detection.Gateway = ""
+	return detection
+}
+
+// firstVirtioInterface returns the virtio NIC with the lowest device path,
+// which follows the PCI address
+func firstVirtioInterface() (string, error) {
+	entries, err := os.ReadDir(sysClassNet)
+	if err != nil {
+		return "", fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	devices := make(map[string]string)
+	var names []string
+	for _, entry := range entries {
+		driver, err := os.Readlink(filepath.Join(sysClassNet, entry.Name(), "device", "driver"))
+		if err != nil || filepath.Base(driver) != "virtio_net" {
+			continue
+		}
+		device, err := filepath.EvalSymlinks(filepath.Join(sysClassNet, entry.Name(), "device"))
+		if err != nil {
+			continue
+		}
+		devices[entry.Name()] = device
+		names = append(names, entry.Name())
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no virtio network interface found")
+	}
+	sort.Slice(names, func(i, j int) bool { return devices[names[i]] < devices[names[j]] })
+	return names[0], nil
+}
+
+// interfaceAddress returns the first global unicast address of the interface
+// of the family
+func interfaceAddress(name string, ipv4 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || (ipNet.IP.To4() != nil) != ipv4 {
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	return nil, fmt.Errorf("interface %s has no global unicast address of the family", name)
+}
@@ -0,0 +1,126 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// maxLowerDepth bounds the resolution of stacked bonds and bridges
+const maxLowerDepth = 3
+
+// lowerHealth returns why a bond or bridge cannot carry traffic although it
+// has carrier, or empty if it can. The kernel keeps the carrier of a bridge
+// while any port has one, including the veth and tap ports of containers and
+// VMs, so the bridge is only healthy if one of its other ports is. A bond in
+// active-backup mode is only healthy if its active slave is.
+func lowerHealth(link netlink.Link, depth int) string {
+	var role string
+	switch link.(type) {
+	case *netlink.Bond:
+		role = "slave"
+	case *netlink.Bridge:
+		role = "port"
+	default:
+		return ""
+	}
+	if depth >= maxLowerDepth {
+		return ""
+	}
+	name := link.Attrs().Name
+	lowers, err := lowerLinks(link)
+	if err != nil {
+		klog.V(4).Infof("Failed to list the %ss of %s: %v", role, name, err)
+		return ""
+	}
+
+	if bond, ok := link.(*netlink.Bond); ok && bond.ActiveSlave > 0 {
+		for _, lower := range lowers {
+			if lower.Attrs().Index != bond.ActiveSlave {
+				continue
+			}
+			if reason := linkHealthAt(lower, depth+1); reason != "" {
+				return fmt.Sprintf("active slave of %s: %s", name, reason)
+			}
+			return ""
+		}
+	}
+
+	uplinks := 0
+	for _, lower := range lowers {
+		if isVirtualPort(lower) {
+			continue
+		}
+		uplinks++
+		if linkHealthAt(lower, depth+1) == "" {
+			return ""
+		}
+	}
+	if uplinks == 0 {
+		return ""
+	}
+	return fmt.Sprintf("no %s of %s has carrier", role, name)
+}
+
+// lowerLinks returns the slaves of a bond or the ports of a bridge
+func lowerLinks(link netlink.Link) ([]netlink.Link, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var lowers []netlink.Link
+	for _, lower := range links {
+		if lower.Attrs().MasterIndex == link.Attrs().Index {
+			lowers = append(lowers, lower)
+		}
+	}
+	return lowers, nil
+}
+
+// isVirtualPort checks if a bridge port connects a container or VM rather
+// than an uplink
+func isVirtualPort(link netlink.Link) bool {
+	switch link.(type) {
+	case *netlink.Veth, *netlink.Tuntap:
+		return true
+	}
+	return false
+}
+
+// upperLink returns the bond or bridge a link is enslaved to, which owns the
+// addresses of the link, or the link itself
+func upperLink(link netlink.Link) netlink.Link {
+	if link.Attrs().MasterIndex == 0 {
+		return link
+	}
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		klog.V(4).Infof("Failed to get the master of %s: %v", link.Attrs().Name, err)
+		return link
+	}
+	switch master.(type) {
+	case *netlink.Bond, *netlink.Bridge:
+		klog.V(3).Infof("Using %s, %s is enslaved to it", master.Attrs().Name, link.Attrs().Name)
+		return master
+	}
+	return link
+}
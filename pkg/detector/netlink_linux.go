@@ -0,0 +1,45 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// routeGet looks up the routes to dst, recording the call in the metrics
+func routeGet(dst net.IP) ([]netlink.Route, error) {
+	start := time.Now()
+	routes, err := netlink.RouteGet(dst)
+	metrics.ObserveNetlink(metrics.NetlinkRouteGet, start, err)
+	return routes, err
+}
+
+// addrList lists the addresses of the link, recording the call in the
+// metrics
+func addrList(link netlink.Link, family int) ([]netlink.Addr, error) {
+	start := time.Now()
+	addrs, err := netlink.AddrList(link, family)
+	metrics.ObserveNetlink(metrics.NetlinkAddrList, start, err)
+	return addrs, err
+}
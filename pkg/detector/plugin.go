@@ -0,0 +1,244 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultPluginTimeout is the default time a detector plugin may take
+const DefaultPluginTimeout = 5 * time.Second
+
+// Plugin runs a detector shipped outside of local-ccm, either an executable
+// called with the target as argument and the PluginRequest on stdin, or a
+// unix socket sent the PluginRequest as JSON. Both answer with a
+// PluginResponse as JSON.
+type Plugin struct {
+	// Name is the name the plugin is registered by, reported as strategy
+	Name string
+	Path string
+	// Socket is set if Path is a unix socket instead of an executable
+	Socket bool
+	// Timeout is the time the plugin may take. Defaults to
+	// DefaultPluginTimeout.
+	Timeout time.Duration
+}
+
+// PluginRequest is sent to plugins. It holds the interfaces of the host
+// with their addresses, so plugins without host access, e.g. WASM modules,
+// can pick an address among them.
+type PluginRequest struct {
+	Target     string         `json:"target"`
+	Interfaces []LinkSnapshot `json:"interfaces,omitempty"`
+}
+
+// newPluginRequest returns the request of a detection of target
+func newPluginRequest(target string) PluginRequest {
+	links, errs := takeLinkSnapshots()
+	for _, err := range errs {
+		klog.V(2).Infof("Incomplete interfaces of the plugin request: %s", err)
+	}
+	return PluginRequest{Target: target, Interfaces: links}
+}
+
+// PluginResponse is the answer of a plugin
+type PluginResponse struct {
+	Address   string `json:"address,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Gateway   string `json:"gateway,omitempty"`
+	// Error tells why no address was detected
+	Error string `json:"error,omitempty"`
+}
+
+// Detect asks the plugin for the address of the target
+func (p Plugin) Detect(target string) Detection {
+	detection := Detection{
+		Strategy: p.Name,
+		Target:   target,
+	}
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var response PluginResponse
+	var err error
+	if p.Socket {
+		response, err = p.ask(ctx, newPluginRequest(target))
+	} else {
+		response, err = p.run(ctx, newPluginRequest(target))
+	}
+	switch {
+	case err != nil:
+		detection.Error = fmt.Sprintf("plugin %s failed: %v", p.Name, err)
+	case response.Error != "":
+		detection.Error = response.Error
+	case net.ParseIP(response.Address) == nil:
+		detection.Error = fmt.Sprintf("plugin %s returned invalid address %q", p.Name, response.Address)
+	default:
+		detection.Address = response.Address
+		detection.Interface = response.Interface
+		detection.Gateway = response.Gateway
+	}
+	return detection
+}
+
+// run executes the plugin with the target as argument and the request on
+// stdin
+func (p Plugin) run(ctx context.Context, request PluginRequest) (PluginResponse, error) {
+	var response PluginResponse
+	stdin, err := json.Marshal(request)
+	if err != nil {
+		return response, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, request.Target)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return response, fmt.Errorf("%w: %s", err, msg)
+		}
+		return response, err
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return response, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return response, nil
+}
+
+// ask sends the request to the plugin socket and reads one response line
+func (p Plugin) ask(ctx context.Context, request PluginRequest) (PluginResponse, error) {
+	var response PluginResponse
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", p.Path)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return response, fmt.Errorf("failed to send request: %w", err)
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return response, fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := json.Unmarshal(line, &response); err != nil {
+		return response, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return response, nil
+}
+
+var (
+	pluginsMu sync.RWMutex
+	// plugins are the registered plugins by name
+	plugins = make(map[string]Detector)
+)
+
+// LoadPlugins registers the executables, unix sockets and WebAssembly
+// modules (*.wasm) in dir as detector plugins named by their file name
+// without the .wasm extension, so they can be used like the built-in
+// detectors. Names of built-in detectors, hidden files and other files are
+// skipped. It returns the names of the registered plugins.
+func LoadPlugins(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+
+	// Entries are sorted by name
+	var names []string
+	found := make(map[string]Detector)
+	for _, entry := range entries {
+		file := entry.Name()
+		name, wasm := strings.CutSuffix(file, ".wasm")
+		if strings.HasPrefix(name, ".") || strings.Contains(name, ":") || name == "" {
+			continue
+		}
+		if _, err := ParseDetector(name); err == nil {
+			klog.Warningf("Skipping detector plugin %s, which shadows a built-in detector", name)
+			continue
+		}
+		if _, ok := found[name]; ok {
+			klog.Warningf("Skipping detector plugin %s, which is registered already", file)
+			continue
+		}
+
+		path := filepath.Join(dir, file)
+		// Follow symlinks, e.g. to plugins installed elsewhere
+		info, err := os.Stat(path)
+		if err != nil {
+			klog.Warningf("Skipping detector plugin %s: %v", file, err)
+			continue
+		}
+		var plugin Detector
+		switch {
+		case wasm && info.Mode().IsRegular():
+			module, err := LoadWASMPlugin(name, path)
+			if err != nil {
+				klog.Warningf("Skipping detector plugin %s: %v", file, err)
+				continue
+			}
+			plugin = module
+		case info.Mode()&os.ModeSocket != 0:
+			plugin = Plugin{Name: name, Path: path, Socket: true}
+		case info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0:
+			plugin = Plugin{Name: name, Path: path}
+		default:
+			klog.V(2).Infof("Skipping %s, which is neither executable, a socket nor a WebAssembly module", path)
+			continue
+		}
+		found[name] = plugin
+		names = append(names, name)
+	}
+
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	for name, plugin := range found {
+		plugins[name] = plugin
+	}
+	return names, nil
+}
+
+// lookupPlugin returns the registered plugin of a name
+func lookupPlugin(name string) (Detector, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	plugin, ok := plugins[name]
+	return plugin, ok
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// DefaultProbeTimeout is the default time to wait for an echo reply of a target
+const DefaultProbeTimeout = time.Second
+
+// probeSeq numbers the echo requests
+var probeSeq atomic.Uint32
+
+// Probing wraps a detector, pinging the target from the detected address
+// before trusting the route lookup, as a blackholed route still yields a
+// source address. Detections whose target does not answer are marked as
+// degraded.
+type Probing struct {
+	Detector Detector
+	// Timeout is the time to wait for the reply. Defaults to
+	// DefaultProbeTimeout.
+	Timeout time.Duration
+}
+
+// Detect detects the address and probes the target from it. If the target
+// cannot be probed, e.g. without permission to send ICMP, the detection is
+// trusted.
+func (p Probing) Detect(target string) Detection {
+	detection := p.Detector.Detect(target)
+	if detection.Error != "" || detection.Address == "" {
+		return detection
+	}
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	reachable, err := probe(detection.Address, target, timeout)
+	if err != nil {
+		klog.Errorf("Failed to probe target %s from %s: %v", target, detection.Address, err)
+		return detection
+	}
+	if !reachable {
+		metrics.DetectionProbeFailures.Inc(target)
+		detection.Degraded = fmt.Sprintf("target %s did not answer an ICMP echo from %s", target, detection.Address)
+	}
+	return detection
+}
+
+// probe sends an ICMP echo request from source to target and reports whether
+// it is answered in time. Unprivileged ping sockets are used if the kernel
+// allows them (net.ipv4.ping_group_range), raw sockets otherwise.
+func probe(source, target string, timeout time.Duration) (bool, error) {
+	dst := net.ParseIP(target)
+	if dst == nil {
+		return false, fmt.Errorf("invalid target IP address: %s", target)
+	}
+
+	network, rawNetwork, protocol := "udp4", "ip4:icmp", 1
+	var echo, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if dst.To4() == nil {
+		network, rawNetwork, protocol = "udp6", "ip6:ipv6-icmp", 58
+		echo, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	var addr net.Addr = &net.UDPAddr{IP: dst}
+	conn, err := icmp.ListenPacket(network, source)
+	raw := err != nil
+	if raw {
+		addr = &net.IPAddr{IP: dst}
+		conn, err = icmp.ListenPacket(rawNetwork, source)
+		if err != nil {
+			return false, fmt.Errorf("failed to open ICMP socket: %w", err)
+		}
+	}
+	defer conn.Close()
+
+	// Ping sockets replace the ID by their port, so only raw sockets check it
+	id := os.Getpid() & 0xffff
+	seq := int(probeSeq.Add(1) & 0xffff)
+	request, err := (&icmp.Message{
+		Type: echo,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("local-ccm")},
+	}).Marshal(nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal echo request: %w", err)
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false, fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := conn.WriteTo(request, addr); err != nil {
+		// An unreachable network is reported right away
+		klog.V(3).Infof("Failed to send echo request to %s: %v", target, err)
+		return false, nil
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to read echo reply: %w", err)
+		}
+		msg, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || msg.Type != reply {
+			continue
+		}
+		body, ok := msg.Body.(*icmp.Echo)
+		if !ok || body.Seq != seq || (raw && body.ID != id) || !sameIP(from, dst) {
+			continue
+		}
+		return true, nil
+	}
+}
+
+// sameIP reports whether the address of a reply is ip
+func sameIP(addr net.Addr, ip net.IP) bool {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.Equal(ip)
+	case *net.IPAddr:
+		return a.IP.Equal(ip)
+	}
+	return false
+}
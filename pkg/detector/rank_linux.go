@@ -0,0 +1,194 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// rankedRoute is a candidate route with the state of its link
+type rankedRoute struct {
+	route netlink.Route
+	link  netlink.Link
+	// unhealthy tells why the link cannot carry traffic, empty if it can
+	unhealthy string
+}
+
+// netlinkFamily returns the netlink family selected by the target
+func netlinkFamily(target string) int {
+	if targetFamilyIPv6(target) {
+		return netlink.FAMILY_V6
+	}
+	return netlink.FAMILY_V4
+}
+
+// linkHealth returns why a link cannot carry traffic, or empty if it is up
+// with carrier. Links without an operational state, e.g. WireGuard or dummy
+// interfaces, count as up. Bonds and bridges are resolved to their slaves and
+// ports.
+func linkHealth(link netlink.Link) string {
+	return linkHealthAt(link, 0)
+}
+
+// linkHealthAt returns the health of a link at a depth of stacked bonds and
+// bridges
+func linkHealthAt(link netlink.Link, depth int) string {
+	attrs := link.Attrs()
+	switch {
+	case attrs.Flags&net.FlagUp == 0:
+		return fmt.Sprintf("link %s is down", attrs.Name)
+	case attrs.RawFlags&unix.IFF_LOWER_UP == 0:
+		return fmt.Sprintf("link %s has no carrier", attrs.Name)
+	case attrs.OperState != netlink.OperUp && attrs.OperState != netlink.OperUnknown:
+		return fmt.Sprintf("link %s is %s", attrs.Name, attrs.OperState)
+	}
+	return lowerHealth(link, depth)
+}
+
+// rankRoutes returns the routes with a link, preferring healthy links, then
+// more specific routes and lower metrics. Ties are broken by link index, so
+// the ranking is deterministic. Of multipath routes, the first next hop is
+// used. Routes via SR-IOV virtual functions and representors are skipped.
+func rankRoutes(routes []netlink.Route) []rankedRoute {
+	var ranked []rankedRoute
+	for _, route := range routes {
+		if route.LinkIndex == 0 && len(route.MultiPath) > 0 {
+			route.LinkIndex = route.MultiPath[0].LinkIndex
+			route.Gw = route.MultiPath[0].Gw
+		}
+		if route.LinkIndex == 0 {
+			continue
+		}
+		link, err := netlink.LinkByIndex(route.LinkIndex)
+		if err != nil {
+			continue
+		}
+		if reason := SRIOVReason(link.Attrs().Name); reason != "" {
+			klog.V(4).Infof("Skipping the route via %s", reason)
+			continue
+		}
+		ranked = append(ranked, rankedRoute{route: route, link: link, unhealthy: linkHealth(link)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if (a.unhealthy == "") != (b.unhealthy == "") {
+			return a.unhealthy == ""
+		}
+		if prefixA, prefixB := prefixLength(a.route), prefixLength(b.route); prefixA != prefixB {
+			return prefixA > prefixB
+		}
+		if a.route.Priority != b.route.Priority {
+			return a.route.Priority < b.route.Priority
+		}
+		return a.route.LinkIndex < b.route.LinkIndex
+	})
+	return ranked
+}
+
+// prefixLength returns the prefix length of the destination, 0 for default routes
+func prefixLength(route netlink.Route) int {
+	if route.Dst == nil {
+		return 0
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones
+}
+
+// addressFlags maps the kernel flags of addresses to the candidate flags
+var addressFlags = []struct {
+	flag int
+	name string
+}{
+	{unix.IFA_F_SECONDARY, FlagSecondary},
+	{unix.IFA_F_TEMPORARY, FlagTemporary},
+	{unix.IFA_F_DEPRECATED, FlagDeprecated},
+	{unix.IFA_F_TENTATIVE, FlagTentative},
+	{unix.IFA_F_DADFAILED, FlagDADFailed},
+}
+
+// linkCandidates returns the addresses of the link of the family as
+// candidates, in the order of the kernel
+func linkCandidates(link netlink.Link, family int) ([]AddressCandidate, error) {
+	name := link.Attrs().Name
+	addrs, err := addrList(link, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %w", name, err)
+	}
+	unhealthy := linkHealth(link)
+	candidates := make([]AddressCandidate, 0, len(addrs))
+	for _, addr := range addrs {
+		candidate := AddressCandidate{
+			Address:   addr.IP.String(),
+			Interface: name,
+			Scope:     addressScope(addr.IP),
+			Unhealthy: unhealthy,
+		}
+		for _, flag := range addressFlags {
+			if addr.Flags&flag.flag != 0 {
+				candidate.Flags = append(candidate.Flags, flag.name)
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// routeCandidates returns the addresses of the links of the ranked routes as
+// candidates, with the gateway, metric and prefix length of their route. With
+// preferredSource, a route with a preferred source only yields that address,
+// which the kernel uses even if it is a secondary address or on another link.
+func routeCandidates(ranked []rankedRoute, family int, preferredSource bool) ([]AddressCandidate, error) {
+	var candidates []AddressCandidate
+	seen := make(map[string]bool)
+	for _, route := range ranked {
+		linkCandidates, err := linkCandidates(route.link, family)
+		if err != nil {
+			return nil, err
+		}
+		if src := route.route.Src; preferredSource && src != nil {
+			source := AddressCandidate{Address: src.String(), Interface: route.link.Attrs().Name, Scope: addressScope(src), Unhealthy: route.unhealthy}
+			for _, candidate := range linkCandidates {
+				if candidate.Address == source.Address {
+					source.Flags = slices.DeleteFunc(candidate.Flags, func(flag string) bool { return flag == FlagSecondary })
+				}
+			}
+			linkCandidates = []AddressCandidate{source}
+		}
+		for _, candidate := range linkCandidates {
+			if seen[candidate.Address] {
+				continue
+			}
+			seen[candidate.Address] = true
+			if route.route.Gw != nil {
+				candidate.Gateway = route.route.Gw.String()
+			}
+			candidate.RouteMetric = route.route.Priority
+			candidate.PrefixLength = prefixLength(route.route)
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
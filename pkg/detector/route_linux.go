@@ -0,0 +1,200 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// Detect detects the local IP address by using netlink to query the route
+// to the target IP and extracting the source IP from the route, recording
+// the route used and the other addresses of the interface that were not picked
+func Detect(targetIP string) Detection {
+	return detectRoute(targetIP, nil)
+}
+
+// Candidates returns the source addresses of the routes covering the target,
+// of the first routing table consulted by the policy routing rules that has
+// one via a healthy link, or else the first that has one, ranked by their
+// routes: the preferred source of a route, or else the addresses of its link
+func (Route) Candidates(target string) ([]AddressCandidate, error) {
+	candidates, _, err := routeCandidatesTo(target)
+	return candidates, err
+}
+
+// routeCandidatesTo returns the candidates of Route.Candidates and the
+// routing table they were found in, 0 for the main table
+func routeCandidatesTo(target string) ([]AddressCandidate, int, error) {
+	targetIP, err := parseTarget(target)
+	if err != nil {
+		return nil, 0, err
+	}
+	family := netlinkFamily(target)
+	var first []rankedRoute
+	for _, lookup := range lookupTables(targetIP, family) {
+		ranked, err := coveringRoutes(targetIP, family, lookup)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(ranked) > 0 && ranked[0].unhealthy == "" {
+			first = ranked
+			break
+		}
+		if first == nil {
+			first = ranked
+		}
+	}
+	if len(first) == 0 {
+		return nil, 0, fmt.Errorf("no route found to %s", target)
+	}
+	candidates, err := routeCandidates(first, family, true)
+	return candidates, routeTable(first[0].route), err
+}
+
+// detectRoute ranks the candidates of the routes covering the target with
+// the policy, preferring the source the kernel picks for the target among
+// equally ranked candidates. The kernel's choice is kept if the routes
+// cannot be listed or the policy accepts none of them.
+func detectRoute(targetIP string, policy Policy) Detection {
+	detection := Detection{
+		Strategy: StrategyRoute,
+		Target:   targetIP,
+	}
+
+	route, err := routeTo(targetIP)
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+	kernel := kernelDetection(detection, route)
+
+	candidates, table, err := routeCandidatesTo(targetIP)
+	if err != nil {
+		klog.V(4).Infof("Using the kernel's route to %s: %v", targetIP, err)
+		return kernel
+	}
+	// Routes via SR-IOV virtual functions carry the traffic of workloads
+	if len(candidates) == 0 {
+		return kernel
+	}
+	if i := slices.IndexFunc(candidates, func(candidate AddressCandidate) bool {
+		return candidate.Address == kernel.Address
+	}); i > 0 {
+		preferred := candidates[i]
+		candidates = slices.Insert(slices.Delete(candidates, i, i+1), 0, preferred)
+	}
+
+	selected := Select(policy, detection, candidates)
+	if selected.Error != "" {
+		klog.V(3).Infof("Using the kernel's route to %s: %s", targetIP, selected.Error)
+		return kernel
+	}
+	if selected.Address == kernel.Address {
+		selected.Gateway = kernel.Gateway
+		selected.Table = kernel.Table
+		return selected
+	}
+	klog.V(2).Infof("Not using %s via %s for target %s: ranks lower than %s via %s", kernel.Address, kernel.Interface, targetIP, selected.Address, selected.Interface)
+	selected.Table = table
+	return selected
+}
+
+// kernelDetection returns the detection of the route the kernel picks,
+// listing the other addresses of its interface as filtered
+func kernelDetection(detection Detection, route *netlink.Route) Detection {
+	detection.Address = route.Src.String()
+	if route.Gw != nil {
+		detection.Gateway = route.Gw.String()
+	}
+	detection.Table = routeTable(*route)
+
+	link, err := netlink.LinkByIndex(route.LinkIndex)
+	if err != nil {
+		klog.V(4).Infof("Failed to get link %d: %v", route.LinkIndex, err)
+		return detection
+	}
+	detection.Interface = link.Attrs().Name
+
+	family := netlink.FAMILY_V4
+	if route.Src.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	addrs, err := addrList(link, family)
+	if err != nil {
+		klog.V(4).Infof("Failed to list addresses of %s: %v", detection.Interface, err)
+		return detection
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	detection.Filtered = filteredCandidates(route.Src, ips, detection.Target)
+
+	return detection
+}
+
+// routeTo returns the preferred route to the target IP, which has a source IP
+func routeTo(targetIP string) (*netlink.Route, error) {
+	dstIP, err := parseTarget(targetIP)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(4).Infof("Detecting IP using target: %s", targetIP)
+
+	// Get route to target IP using netlink
+	routes, err := routeGet(dstIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get route to %s: %w", targetIP, err)
+	}
+
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route found to %s", targetIP)
+	}
+
+	// Get the first route (preferred route)
+	route := routes[0]
+
+	// Extract source IP from route
+	if route.Src == nil {
+		return nil, fmt.Errorf("route to %s has no source IP", targetIP)
+	}
+
+	return &route, nil
+}
+
+// coveringRoutes returns the ranked routes of the table covering the target
+func coveringRoutes(target net.IP, family int, lookup tableLookup) ([]rankedRoute, error) {
+	routes, err := tableRoutes(family, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes of table %d: %w", lookup.table, err)
+	}
+	var covering []netlink.Route
+	for _, route := range routes {
+		if route.Dst == nil || route.Dst.Contains(target) {
+			covering = append(covering, route)
+		}
+	}
+	return rankRoutes(covering), nil
+}
@@ -0,0 +1,36 @@
+//go:build !linux || purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import "errors"
+
+// Detect detects the local IP address used to reach the target IP. Without
+// netlink, the kernel picks the source address of a connected UDP socket.
+func Detect(targetIP string) Detection {
+	return detectUDP(targetIP)
+}
+
+func detectRoute(targetIP string, _ Policy) Detection {
+	return detectUDP(targetIP)
+}
+
+// Candidates fails, as the routing table cannot be listed without netlink
+func (Route) Candidates(target string) ([]AddressCandidate, error) {
+	return nil, errors.New("listing routes requires netlink")
+}
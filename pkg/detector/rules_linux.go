@@ -0,0 +1,124 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"net"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// tableLookup is a routing table consulted by a policy routing rule
+type tableLookup struct {
+	table int
+	// suppressPrefixlen ignores the routes of the table with a prefix length
+	// up to it, -1 if none are ignored
+	suppressPrefixlen int
+}
+
+// mainTable is the lookup of the main table, used without policy routing
+var mainTable = []tableLookup{{table: unix.RT_TABLE_MAIN, suppressPrefixlen: -1}}
+
+// lookupTables returns the routing tables the policy routing rules consult
+// for traffic of local-ccm to the target, in order, like the kernel: locally
+// generated traffic enters from lo, without mark, source address, output
+// interface or ports, so rules selecting any of them do not apply. The
+// evaluation stops at a rule that does not look up a table, e.g. unreachable.
+// If the rules cannot be listed, the main table is consulted.
+func lookupTables(target net.IP, family int) []tableLookup {
+	rules, err := netlink.RuleList(family)
+	if err != nil {
+		klog.V(4).Infof("Failed to list the routing rules, using the main table: %v", err)
+		return mainTable
+	}
+	var lookups []tableLookup
+	for i := 0; i < len(rules); i++ {
+		rule := rules[i]
+		if !ruleMatches(rule, target) {
+			continue
+		}
+		switch {
+		case rule.Goto >= 0:
+			// Only jump forward, so the evaluation ends
+			for i+1 < len(rules) && rules[i+1].Priority < rule.Goto {
+				i++
+			}
+		case rule.Table != unix.RT_TABLE_UNSPEC:
+			lookups = append(lookups, tableLookup{table: rule.Table, suppressPrefixlen: rule.SuppressPrefixlen})
+		default:
+			klog.V(4).Infof("Routing rule %d does not look up a table, stopping", rule.Priority)
+			return lookups
+		}
+	}
+	return lookups
+}
+
+// ruleMatches checks if the rule selects traffic of local-ccm to the target
+func ruleMatches(rule netlink.Rule, target net.IP) bool {
+	mask := uint32(0)
+	if rule.Mask != nil {
+		mask = *rule.Mask
+	} else if rule.Mark != 0 {
+		mask = 0xffffffff
+	}
+	matches := (rule.Src == nil || prefixOnes(rule.Src) == 0) &&
+		(rule.Dst == nil || rule.Dst.Contains(target)) &&
+		(rule.IifName == "" || rule.IifName == "lo") &&
+		rule.OifName == "" &&
+		rule.Mark&mask == 0 &&
+		rule.Tos == 0 && rule.TunID == 0 && rule.IPProto == 0 &&
+		rule.Dport == nil && rule.Sport == nil &&
+		(rule.UIDRange == nil || (uint32(os.Getuid()) >= rule.UIDRange.Start && uint32(os.Getuid()) <= rule.UIDRange.End))
+	return matches != rule.Invert
+}
+
+// prefixOnes returns the prefix length of a network
+func prefixOnes(network *net.IPNet) int {
+	ones, _ := network.Mask.Size()
+	return ones
+}
+
+// tableRoutes returns the unicast routes of the table of the lookup that are
+// not suppressed by it
+func tableRoutes(family int, lookup tableLookup) ([]netlink.Route, error) {
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{Table: lookup.table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	var result []netlink.Route
+	for _, route := range routes {
+		if route.Type != unix.RTN_UNICAST || prefixLength(route) <= lookup.suppressPrefixlen {
+			continue
+		}
+		result = append(result, route)
+	}
+	return result, nil
+}
+
+// routeTable returns the routing table of a route for detections, 0 for the
+// main table
+func routeTable(route netlink.Route) int {
+	if route.Table == unix.RT_TABLE_MAIN {
+		return 0
+	}
+	return route.Table
+}
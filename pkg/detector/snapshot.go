@@ -0,0 +1,102 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"net/http"
+	"time"
+)
+
+// Snapshot is the routing state of the host at a detection: the policy
+// routing rules, the routes of the routing tables and the interfaces with
+// their addresses
+type Snapshot struct {
+	Time   time.Time       `json:"time"`
+	Rules  []RuleSnapshot  `json:"rules,omitempty"`
+	Routes []RouteSnapshot `json:"routes,omitempty"`
+	Links  []LinkSnapshot  `json:"links,omitempty"`
+	// Errors are the parts of the state that could not be listed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// RuleSnapshot is a policy routing rule, with its selectors and action
+type RuleSnapshot struct {
+	Priority int    `json:"priority"`
+	Family   string `json:"family"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Iif      string `json:"iif,omitempty"`
+	Oif      string `json:"oif,omitempty"`
+	// Mark is the firewall mark and mask, e.g. 0x1/0xff
+	Mark              string `json:"fwmark,omitempty"`
+	Invert            bool   `json:"not,omitempty"`
+	Table             int    `json:"table,omitempty"`
+	Goto              int    `json:"goto,omitempty"`
+	SuppressPrefixlen int    `json:"suppressPrefixlength,omitempty"`
+}
+
+// RouteSnapshot is a route of a routing table
+type RouteSnapshot struct {
+	Table       int    `json:"table"`
+	Destination string `json:"destination"`
+	Type        string `json:"type,omitempty"`
+	Gateway     string `json:"gateway,omitempty"`
+	Interface   string `json:"interface,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Metric      int    `json:"metric,omitempty"`
+	// NextHops are the gateways and interfaces of multipath routes
+	NextHops []string `json:"nextHops,omitempty"`
+}
+
+// LinkSnapshot is a network interface with its addresses
+type LinkSnapshot struct {
+	Name   string `json:"name"`
+	Index  int    `json:"index"`
+	Type   string `json:"type,omitempty"`
+	Master string `json:"master,omitempty"`
+	// Unhealthy tells why the link cannot carry traffic, empty if it can
+	Unhealthy string            `json:"unhealthy,omitempty"`
+	Addresses []AddressSnapshot `json:"addresses,omitempty"`
+}
+
+// AddressSnapshot is an address of an interface with its prefix
+type AddressSnapshot struct {
+	Address string   `json:"address"`
+	Scope   string   `json:"scope,omitempty"`
+	Flags   []string `json:"flags,omitempty"`
+}
+
+// SetSnapshot replaces the routing state of the latest detection
+func (s *State) SetSnapshot(snapshot Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = &snapshot
+}
+
+// RoutesHandler serves the routing state of the latest detection as JSON
+func (s *State) RoutesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		snapshot := s.snapshot
+		s.mu.RUnlock()
+		if snapshot == nil {
+			http.Error(w, "no detection yet", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, snapshot)
+	})
+}
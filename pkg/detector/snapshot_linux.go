@@ -0,0 +1,202 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// maxSnapshotRoutes caps the routes of a snapshot, as the tables of routers
+// may hold full BGP feeds
+const maxSnapshotRoutes = 1000
+
+// routeTypes names the route types of iproute2
+var routeTypes = map[int]string{
+	unix.RTN_UNICAST:     "unicast",
+	unix.RTN_LOCAL:       "local",
+	unix.RTN_BROADCAST:   "broadcast",
+	unix.RTN_ANYCAST:     "anycast",
+	unix.RTN_MULTICAST:   "multicast",
+	unix.RTN_BLACKHOLE:   "blackhole",
+	unix.RTN_UNREACHABLE: "unreachable",
+	unix.RTN_PROHIBIT:    "prohibit",
+	unix.RTN_THROW:       "throw",
+}
+
+// TakeSnapshot lists the routing state of the host via netlink. The local
+// table, which only holds the addresses of the host, is left out.
+func TakeSnapshot() Snapshot {
+	snapshot := Snapshot{Time: time.Now()}
+
+	rules, err := netlink.RuleList(netlink.FAMILY_ALL)
+	if err != nil {
+		snapshot.Errors = append(snapshot.Errors, fmt.Sprintf("failed to list rules: %v", err))
+	}
+	for _, rule := range rules {
+		// Leave out the rules of multicast routing
+		if rule.Family == netlink.FAMILY_V4 || rule.Family == netlink.FAMILY_V6 {
+			snapshot.Rules = append(snapshot.Rules, ruleSnapshot(rule))
+		}
+	}
+
+	links, errs := takeLinkSnapshots()
+	snapshot.Links = links
+	snapshot.Errors = append(snapshot.Errors, errs...)
+	names := make(map[int]string, len(links))
+	for _, link := range links {
+		names[link.Index] = link.Name
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		snapshot.Errors = append(snapshot.Errors, fmt.Sprintf("failed to list routes: %v", err))
+	}
+	for _, route := range routes {
+		if route.Table == unix.RT_TABLE_LOCAL {
+			continue
+		}
+		if len(snapshot.Routes) == maxSnapshotRoutes {
+			snapshot.Errors = append(snapshot.Errors, fmt.Sprintf("only the first %d routes are listed", maxSnapshotRoutes))
+			break
+		}
+		snapshot.Routes = append(snapshot.Routes, routeSnapshot(route, names))
+	}
+	return snapshot
+}
+
+// takeLinkSnapshots lists the links of the host with their addresses via
+// netlink, returning the errors of the parts that could not be listed
+func takeLinkSnapshots() ([]LinkSnapshot, []string) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, []string{fmt.Sprintf("failed to list links: %v", err)}
+	}
+	names := make(map[int]string, len(links))
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+	var snapshots []LinkSnapshot
+	var errs []string
+	for _, link := range links {
+		snapshot, err := takeLinkSnapshot(link, names)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, errs
+}
+
+// ruleSnapshot describes a rule
+func ruleSnapshot(rule netlink.Rule) RuleSnapshot {
+	snapshot := RuleSnapshot{
+		Priority:          rule.Priority,
+		Family:            familyName(rule.Family),
+		Iif:               rule.IifName,
+		Oif:               rule.OifName,
+		Invert:            rule.Invert,
+		Table:             rule.Table,
+		SuppressPrefixlen: rule.SuppressPrefixlen,
+	}
+	if rule.Src != nil {
+		snapshot.From = rule.Src.String()
+	}
+	if rule.Dst != nil {
+		snapshot.To = rule.Dst.String()
+	}
+	if rule.Mark != 0 || rule.Mask != nil {
+		snapshot.Mark = fmt.Sprintf("%#x", rule.Mark)
+		if rule.Mask != nil {
+			snapshot.Mark += fmt.Sprintf("/%#x", *rule.Mask)
+		}
+	}
+	if rule.Goto > 0 {
+		snapshot.Goto = rule.Goto
+	}
+	if snapshot.SuppressPrefixlen < 0 {
+		snapshot.SuppressPrefixlen = 0
+	}
+	return snapshot
+}
+
+// routeSnapshot describes a route, naming its links
+func routeSnapshot(route netlink.Route, names map[int]string) RouteSnapshot {
+	snapshot := RouteSnapshot{
+		Table:       route.Table,
+		Destination: "default",
+		Type:        routeTypes[route.Type],
+		Interface:   names[route.LinkIndex],
+		Metric:      route.Priority,
+	}
+	if route.Dst != nil && prefixLength(route) > 0 {
+		snapshot.Destination = route.Dst.String()
+	}
+	if route.Gw != nil {
+		snapshot.Gateway = route.Gw.String()
+	}
+	if route.Src != nil {
+		snapshot.Source = route.Src.String()
+	}
+	for _, nextHop := range route.MultiPath {
+		hop := "dev " + names[nextHop.LinkIndex]
+		if nextHop.Gw != nil {
+			hop = "via " + nextHop.Gw.String() + " " + hop
+		}
+		snapshot.NextHops = append(snapshot.NextHops, hop)
+	}
+	return snapshot
+}
+
+// takeLinkSnapshot describes a link with its addresses
+func takeLinkSnapshot(link netlink.Link, names map[int]string) (LinkSnapshot, error) {
+	attrs := link.Attrs()
+	snapshot := LinkSnapshot{
+		Name:      attrs.Name,
+		Index:     attrs.Index,
+		Type:      link.Type(),
+		Master:    names[attrs.MasterIndex],
+		Unhealthy: linkHealth(link),
+	}
+	addrs, err := addrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to list addresses of %s: %w", attrs.Name, err)
+	}
+	for _, addr := range addrs {
+		address := AddressSnapshot{Address: addr.IPNet.String(), Scope: addressScope(addr.IP)}
+		for _, flag := range addressFlags {
+			if addr.Flags&flag.flag != 0 {
+				address.Flags = append(address.Flags, flag.name)
+			}
+		}
+		snapshot.Addresses = append(snapshot.Addresses, address)
+	}
+	return snapshot, nil
+}
+
+// familyName names a netlink address family like iproute2
+func familyName(family int) string {
+	if family == netlink.FAMILY_V6 {
+		return "inet6"
+	}
+	return "inet"
+}
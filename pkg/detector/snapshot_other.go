@@ -0,0 +1,64 @@
+//go:build !linux || purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// TakeSnapshot lists the interfaces of the host with their addresses. Rules
+// and routes are only listed via netlink.
+func TakeSnapshot() Snapshot {
+	links, errs := takeLinkSnapshots()
+	return Snapshot{
+		Time:   time.Now(),
+		Links:  links,
+		Errors: append([]string{"rules and routes are only listed via netlink"}, errs...),
+	}
+}
+
+// takeLinkSnapshots lists the interfaces of the host with their addresses,
+// returning the errors of the parts that could not be listed
+func takeLinkSnapshots() ([]LinkSnapshot, []string) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, []string{fmt.Sprintf("failed to list interfaces: %v", err)}
+	}
+	var links []LinkSnapshot
+	var errs []string
+	for _, iface := range ifaces {
+		link := LinkSnapshot{Name: iface.Name, Index: iface.Index}
+		if iface.Flags&net.FlagUp == 0 {
+			link.Unhealthy = fmt.Sprintf("link %s is down", iface.Name)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to list addresses of %s: %v", iface.Name, err))
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				link.Addresses = append(link.Addresses, AddressSnapshot{Address: ipNet.String(), Scope: addressScope(ipNet.IP)})
+			}
+		}
+		links = append(links, link)
+	}
+	return links, errs
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// representorPortName matches the physical port names of the representors
+// of SR-IOV virtual functions and subfunctions in switchdev mode, e.g.
+// pf0vf3 or c1pf0sf2. The representors of the physical functions, e.g. p0,
+// are the uplinks.
+var representorPortName = regexp.MustCompile(`^(c\d+)?pf\d+(vf|sf)\d+$`)
+
+// SRIOVReason returns why the interface is skipped when enumerating the
+// interfaces of the node, or empty if it is not. SR-IOV virtual functions and
+// the representor ports of virtual functions and subfunctions carry the
+// traffic of workloads, so their addresses, if any, do not identify the node.
+func SRIOVReason(name string) string {
+	dir := filepath.Join(sysClassNet, name)
+	if _, err := os.Stat(filepath.Join(dir, "device", "physfn")); err == nil {
+		return "SR-IOV virtual function " + name
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "phys_port_name"))
+	if err == nil && representorPortName.MatchString(strings.TrimSpace(string(data))) {
+		return "SR-IOV representor port " + name
+	}
+	return ""
+}
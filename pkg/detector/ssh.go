@@ -0,0 +1,173 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// StrategySSH detects addresses on a remote host via SSH
+const StrategySSH = "ssh"
+
+// DefaultSSHTimeout is the default time an SSH detection may take,
+// including the connection
+const DefaultSSHTimeout = 10 * time.Second
+
+// SSH detects the addresses of a remote host by running `ip -j route get`
+// on it over SSH, for nodes without a local agent
+type SSH struct {
+	// Address is the host:port of the SSH server
+	Address string
+	Config  *ssh.ClientConfig
+	// Timeout is the time a detection may take. Defaults to
+	// DefaultSSHTimeout.
+	Timeout time.Duration
+}
+
+// routeGetResult is an entry of the JSON output of `ip -j route get`
+type routeGetResult struct {
+	Dev     string `json:"dev"`
+	Gateway string `json:"gateway"`
+	PrefSrc string `json:"prefsrc"`
+}
+
+// Detect returns the source IP of the route to the target on the remote host
+func (s SSH) Detect(target string) Detection {
+	detection := Detection{
+		Strategy: StrategySSH,
+		Target:   target,
+	}
+	if net.ParseIP(target) == nil {
+		detection.Error = fmt.Sprintf("invalid target %q", target)
+		return detection
+	}
+
+	out, err := s.run("ip -j route get " + target)
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+	var routes []routeGetResult
+	if err := json.Unmarshal(out, &routes); err != nil {
+		detection.Error = fmt.Sprintf("failed to parse routes of %s: %v", s.Address, err)
+		return detection
+	}
+	if len(routes) == 0 || net.ParseIP(routes[0].PrefSrc) == nil {
+		detection.Error = fmt.Sprintf("no source address on the route to %s on %s", target, s.Address)
+		return detection
+	}
+	detection.Address = routes[0].PrefSrc
+	detection.Interface = routes[0].Dev
+	detection.Gateway = routes[0].Gateway
+	return detection
+}
+
+// run executes the command on the remote host and returns its output
+func (s SSH) run(command string) ([]byte, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultSSHTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	conn, err := net.DialTimeout("tcp", s.Address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.Address, err)
+	}
+	// ssh.ClientConfig.Timeout only bounds the TCP connect, the deadline
+	// keeps a server stalling the handshake from blocking the detection
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set deadline of connection to %s: %w", s.Address, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.Address, s.Config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.Address, err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("failed to clear deadline of connection to %s: %w", s.Address, err)
+	}
+	// Closing the connection aborts a hanging command
+	timer := time.AfterFunc(time.Until(deadline), func() { client.Close() })
+	defer timer.Stop()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session on %s: %w", s.Address, err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Run(command); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to run %q on %s: %w: %s", command, s.Address, err, msg)
+		}
+		return nil, fmt.Errorf("failed to run %q on %s: %w", command, s.Address, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// NewSSHConfig returns the client config logging in as user with the PEM
+// private key, accepting the host keys of known_hosts only. Hashed host
+// names are not supported.
+func NewSSHConfig(user string, privateKey, knownHostsData []byte) (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	hostKeys := make(map[string][]ssh.PublicKey)
+	for rest := knownHostsData; len(rest) > 0; {
+		_, hosts, key, _, next, err := ssh.ParseKnownHosts(rest)
+		if err != nil {
+			break
+		}
+		for _, host := range hosts {
+			hostKeys[host] = append(hostKeys[host], key)
+		}
+		rest = next
+	}
+	if len(hostKeys) == 0 {
+		return nil, fmt.Errorf("no host keys in known_hosts")
+	}
+
+	return &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			for _, known := range hostKeys[knownhosts.Normalize(hostname)] {
+				if bytes.Equal(known.Marshal(), key.Marshal()) {
+					return nil
+				}
+			}
+			return fmt.Errorf("host key of %s is not in known_hosts", hostname)
+		},
+	}, nil
+}
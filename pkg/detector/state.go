@@ -0,0 +1,244 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// StrategyRoute picks the source IP of the route to a target
+	StrategyRoute = "route"
+	// StrategyPreserved keeps the address already set on the node, e.g. by kubelet
+	StrategyPreserved = "preserved"
+	// StrategyDeclared takes the address declared to the Kubernetes
+	// distribution, e.g. with the --node-external-ip flag of k3s
+	StrategyDeclared = "declared"
+)
+
+// Candidate is an address that was considered but not picked
+type Candidate struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+// Detection is the outcome of detecting one address
+type Detection struct {
+	// Strategy is how the address was found
+	Strategy string `json:"strategy"`
+	// Target is the IP the route was looked up for
+	Target    string `json:"target,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Gateway   string `json:"gateway,omitempty"`
+	// Table is the routing table of the route, if not the main table
+	Table int `json:"table,omitempty"`
+	// Address is the picked address, empty if none
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Degraded tells why the address is not trusted, e.g. as the target did
+	// not answer a probe
+	Degraded string `json:"degraded,omitempty"`
+	// Filtered are the candidates that were not picked, with the reason
+	Filtered []Candidate `json:"filtered,omitempty"`
+}
+
+// Report holds the detections of a reconciliation
+type Report struct {
+	Time     time.Time  `json:"time"`
+	Internal *Detection `json:"internal,omitempty"`
+	External *Detection `json:"external,omitempty"`
+	Egress   *Detection `json:"egress,omitempty"`
+	// BehindNAT is set if the route to the external target leaves from a
+	// non-public address, so the node is reachable through NAT only
+	BehindNAT bool `json:"behindNAT"`
+}
+
+// Addresses is the summary of a report served by the query API and
+// written to the output file
+type Addresses struct {
+	InternalIP string `json:"internalIP,omitempty"`
+	ExternalIP string `json:"externalIP,omitempty"`
+	BehindNAT  bool   `json:"behindNAT"`
+}
+
+// Addresses returns the summary of the report
+func (r *Report) Addresses() Addresses {
+	addresses := Addresses{BehindNAT: r.BehindNAT}
+	if r.Internal != nil {
+		addresses.InternalIP = r.Internal.Address
+	}
+	if r.External != nil {
+		addresses.ExternalIP = r.External.Address
+	}
+	return addresses
+}
+
+// failed checks if any detection of the report failed
+func (r *Report) failed() bool {
+	return (r.Internal != nil && r.Internal.Error != "") || (r.External != nil && r.External.Error != "")
+}
+
+// State holds the latest detection report and serves it as JSON
+type State struct {
+	mu     sync.RWMutex
+	report *Report
+	// snapshot is the routing state of the latest detection, if taken
+	snapshot *Snapshot
+
+	outputFile string
+	nodeIPFile string
+	written    map[string][]byte
+}
+
+// NewState creates an empty detection state. If outputFile is set, the
+// addresses of each successful detection are written to it on change. If
+// nodeIPFile is set, the detected InternalIP is written to it as
+// KUBELET_NODE_IP environment variable for kubelet.
+func NewState(outputFile, nodeIPFile string) *State {
+	return &State{
+		outputFile: outputFile,
+		nodeIPFile: nodeIPFile,
+		written:    make(map[string][]byte),
+	}
+}
+
+// Set replaces the latest report
+func (s *State) Set(report Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = &report
+
+	if s.outputFile != "" && !report.failed() {
+		if err := s.writeOutputFile(report.Addresses()); err != nil {
+			klog.Errorf("Failed to write %s: %v", s.outputFile, err)
+		}
+	}
+	if s.nodeIPFile != "" && report.Internal != nil && report.Internal.Error == "" && report.Internal.Address != "" {
+		data := fmt.Sprintf("# Written by local-ccm, do not edit\nKUBELET_NODE_IP=%s\n", report.Internal.Address)
+		if err := s.writeFile(s.nodeIPFile, []byte(data)); err != nil {
+			klog.Errorf("Failed to write %s: %v", s.nodeIPFile, err)
+		}
+	}
+}
+
+// writeOutputFile writes the addresses to the output file
+func (s *State) writeOutputFile(addresses Addresses) error {
+	data, err := json.MarshalIndent(addresses, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.writeFile(s.outputFile, append(data, '\n'))
+}
+
+// writeFile atomically replaces the file at path if data changed
+func (s *State) writeFile(path string, data []byte) error {
+	if bytes.Equal(data, s.written[path]) {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	// Write to a temporary file in the same directory and rename it, so
+	// readers never see a partial file
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to chmod temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+
+	s.written[path] = data
+	klog.Infof("Successfully wrote detected addresses to %s", path)
+	return nil
+}
+
+// Get returns the latest report, or nil if nothing was detected yet
+func (s *State) Get() *Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.report
+}
+
+// ServeHTTP writes the latest report as JSON
+func (s *State) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := s.Get()
+	if report == nil {
+		http.Error(w, "no detection yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// AddressesHandler serves the detected addresses and NAT status as JSON
+func (s *State) AddressesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := s.Get()
+		if report == nil {
+			http.Error(w, "no detection yet", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, struct {
+			Addresses
+			Time time.Time `json:"time"`
+		}{report.Addresses(), report.Time})
+	})
+}
+
+// IsBehindNAT checks if an address detected for the external target is not
+// publicly routable, i.e. private, shared (CGNAT) or link-local
+func IsBehindNAT(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return ip.IsPrivate() || sharedAddressSpace.Contains(ip) || ip.IsLinkLocalUnicast()
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// StrategyTalos picks addresses declared in the Talos machine config
+	StrategyTalos = "talos"
+	// DefaultTalosConfigPath is where Talos keeps the machine config on the host
+	DefaultTalosConfigPath = "/system/state/config.yaml"
+)
+
+// Talos detects addresses from the Talos machine config, which declares the
+// node addresses more authoritatively than the routing table. A declared
+// interface address on the network of the target is picked directly, unless
+// machine.kubelet.nodeIP.validSubnets excludes it like for the node IP of
+// kubelet. Other targets, e.g. behind a gateway, are detected via Fallback.
+type Talos struct {
+	// ConfigPath is the machine config file, DefaultTalosConfigPath if empty
+	ConfigPath string
+	// Fallback detects targets without a declared address, Route if nil
+	Fallback Detector
+}
+
+// talosConfig is the part of the v1alpha1 machine config read by Talos
+type talosConfig struct {
+	Machine struct {
+		Kubelet struct {
+			NodeIP struct {
+				ValidSubnets []string `json:"validSubnets"`
+			} `json:"nodeIP"`
+		} `json:"kubelet"`
+		Network struct {
+			Interfaces []talosInterface `json:"interfaces"`
+		} `json:"network"`
+	} `json:"machine"`
+}
+
+type talosInterface struct {
+	Interface string   `json:"interface"`
+	Addresses []string `json:"addresses"`
+	// CIDR is the deprecated single address
+	CIDR  string `json:"cidr"`
+	VLANs []struct {
+		VLANID    int      `json:"vlanId"`
+		Addresses []string `json:"addresses"`
+		CIDR      string   `json:"cidr"`
+	} `json:"vlans"`
+}
+
+// talosAddress is a declared interface address with its network
+type talosAddress struct {
+	ip      net.IP
+	network *net.IPNet
+	iface   string
+}
+
+// Detect returns the declared address for the target
+func (t Talos) Detect(target string) Detection {
+	detection := Detection{
+		Strategy: StrategyTalos,
+		Target:   target,
+	}
+
+	dstIP, err := parseTarget(target)
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+	config, err := loadTalosConfig(t.configPath())
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+	addresses, err := config.addresses()
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+
+	// Prefer the declared address on the network of the target
+	for _, addr := range addresses {
+		if addr.network.Contains(dstIP) && config.validNodeIP(addr.ip) {
+			detection.Address = addr.ip.String()
+			detection.Interface = addr.iface
+			for _, other := range addresses {
+				if other.ip.Equal(addr.ip) || (other.ip.To4() == nil) != (addr.ip.To4() == nil) {
+					continue
+				}
+				detection.Filtered = append(detection.Filtered, Candidate{
+					Address: other.ip.String(),
+					Reason:  "declared on another network than " + target,
+				})
+			}
+			return detection
+		}
+	}
+
+	fallback := t.Fallback
+	if fallback == nil {
+		fallback = Route{}
+	}
+	return fallback.Detect(target)
+}
+
+func (t Talos) configPath() string {
+	if t.ConfigPath == "" {
+		return DefaultTalosConfigPath
+	}
+	return t.ConfigPath
+}
+
+// loadTalosConfig reads the v1alpha1 document of a machine config, which
+// may hold further documents separated by "---"
+func loadTalosConfig(path string) (*talosConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Talos machine config: %w", err)
+	}
+	for _, doc := range strings.Split(string(data), "\n---") {
+		var header struct {
+			Version string `json:"version"`
+			Kind    string `json:"kind"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &header); err != nil {
+			return nil, fmt.Errorf("failed to parse Talos machine config %s: %w", path, err)
+		}
+		if header.Version != "v1alpha1" || header.Kind != "" {
+			continue
+		}
+		config := &talosConfig{}
+		if err := yaml.Unmarshal([]byte(doc), config); err != nil {
+			return nil, fmt.Errorf("failed to parse Talos machine config %s: %w", path, err)
+		}
+		return config, nil
+	}
+	return nil, fmt.Errorf("no v1alpha1 machine config in %s", path)
+}
+
+// addresses returns the static interface and VLAN addresses in declaration order
+func (c *talosConfig) addresses() ([]talosAddress, error) {
+	var addresses []talosAddress
+	add := func(iface string, declared []string, cidr string) error {
+		if cidr != "" {
+			declared = append(declared, cidr)
+		}
+		for _, value := range declared {
+			addr, err := parseTalosAddress(value)
+			if err != nil {
+				return err
+			}
+			addr.iface = iface
+			addresses = append(addresses, addr)
+		}
+		return nil
+	}
+	for _, iface := range c.Machine.Network.Interfaces {
+		if err := add(iface.Interface, iface.Addresses, iface.CIDR); err != nil {
+			return nil, err
+		}
+		for _, vlan := range iface.VLANs {
+			name := fmt.Sprintf("%s.%d", iface.Interface, vlan.VLANID)
+			if err := add(name, vlan.Addresses, vlan.CIDR); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// parseTalosAddress parses a declared address, a host address without prefix
+func parseTalosAddress(value string) (talosAddress, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return talosAddress{}, fmt.Errorf("invalid address %q in Talos machine config", value)
+		}
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		return talosAddress{ip: ip, network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+	ip, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return talosAddress{}, fmt.Errorf("invalid address %q in Talos machine config: %w", value, err)
+	}
+	return talosAddress{ip: ip, network: network}, nil
+}
+
+// validNodeIP checks ip against machine.kubelet.nodeIP.validSubnets, where
+// subnets prefixed with "!" exclude addresses. Without subnets, any address
+// is valid.
+func (c *talosConfig) validNodeIP(ip net.IP) bool {
+	subnets := c.Machine.Kubelet.NodeIP.ValidSubnets
+	if len(subnets) == 0 {
+		return true
+	}
+	included, hasIncludes := false, false
+	for _, subnet := range subnets {
+		value, exclude := strings.CutPrefix(subnet, "!")
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			continue
+		}
+		if exclude {
+			if network.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		hasIncludes = true
+		included = included || network.Contains(ip)
+	}
+	return included || !hasIncludes
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command wasmplugin is a detector plugin for the tests of the WASM plugins,
+// built with GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared. It
+// returns the first address of the interface named by the target, picked
+// from the interfaces of the request.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+type request struct {
+	Target     string `json:"target"`
+	Interfaces []struct {
+		Name      string `json:"name"`
+		Addresses []struct {
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"interfaces"`
+}
+
+type response struct {
+	Address   string `json:"address,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// buffers keeps the request and response buffers alive until the instance
+// is closed
+var buffers [][]byte
+
+func main() {}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	buf := make([]byte, size)
+	buffers = append(buffers, buf)
+	return uint32(uintptr(unsafe.Pointer(unsafe.SliceData(buf))))
+}
+
+//go:wasmexport detect
+func detect(ptr, size uint32) uint64 {
+	var req request
+	var resp response
+	data := unsafe.Slice((*byte)(unsafe.Pointer(uintptr(ptr))), size)
+	if err := json.Unmarshal(data, &req); err != nil {
+		resp.Error = fmt.Sprintf("invalid request: %v", err)
+	} else {
+		resp = pick(req)
+	}
+
+	out, _ := json.Marshal(resp)
+	buffers = append(buffers, out)
+	return uint64(uintptr(unsafe.Pointer(unsafe.SliceData(out))))<<32 | uint64(len(out))
+}
+
+func pick(req request) response {
+	for _, iface := range req.Interfaces {
+		if iface.Name != req.Target {
+			continue
+		}
+		if len(iface.Addresses) == 0 {
+			return response{Error: fmt.Sprintf("interface %s has no addresses", iface.Name)}
+		}
+		address, _, _ := strings.Cut(iface.Addresses[0].Address, "/")
+		return response{Address: address, Interface: iface.Name}
+	}
+	return response{Error: fmt.Sprintf("interface %s not found in %d interfaces", req.Target, len(req.Interfaces))}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"fmt"
+	"net"
+
+	"k8s.io/klog/v2"
+)
+
+// StrategyUDP picks the source address the kernel chooses for a UDP socket
+// connected to the target. Connecting a UDP socket sends no packets.
+const StrategyUDP = "udp"
+
+// UDP detects addresses by connecting UDP sockets, which works on every
+// platform and without privileges, but cannot report the gateway
+type UDP struct{}
+
+// Detect returns the local address of a UDP socket connected to the target
+func (UDP) Detect(target string) Detection {
+	return detectUDP(target)
+}
+
+func detectUDP(targetIP string) Detection {
+	detection := Detection{
+		Strategy: StrategyUDP,
+		Target:   targetIP,
+	}
+
+	dstIP, err := parseTarget(targetIP)
+	if err != nil {
+		detection.Error = err.Error()
+		return detection
+	}
+
+	klog.V(4).Infof("Detecting IP using target: %s", targetIP)
+
+	// The port is irrelevant, no packets are sent
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: dstIP, Port: 53})
+	if err != nil {
+		detection.Error = fmt.Sprintf("failed to get route to %s: %v", targetIP, err)
+		return detection
+	}
+	src := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+	detection.Address = src.String()
+
+	// Find the interface holding the address
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		klog.V(4).Infof("Failed to list interfaces: %v", err)
+		return detection
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		found := false
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ips = append(ips, ipNet.IP)
+			found = found || ipNet.IP.Equal(src)
+		}
+		if found {
+			detection.Interface = iface.Name
+			detection.Filtered = filteredCandidates(src, ips, targetIP)
+			break
+		}
+	}
+
+	return detection
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmMemoryLimitPages limits the memory of WASM plugins to 16 MiB
+const wasmMemoryLimitPages = 256
+
+var (
+	wasmRuntimeOnce sync.Once
+	wasmRuntime     wazero.Runtime
+	wasmRuntimeErr  error
+)
+
+// newWASMRuntime returns the runtime shared by the WASM plugins, with WASI
+// for the modules of TinyGo and Rust, but neither files, environment nor
+// network
+func newWASMRuntime() (wazero.Runtime, error) {
+	wasmRuntimeOnce.Do(func() {
+		ctx := context.Background()
+		config := wazero.NewRuntimeConfig().
+			WithMemoryLimitPages(wasmMemoryLimitPages).
+			WithCloseOnContextDone(true)
+		wasmRuntime = wazero.NewRuntimeWithConfig(ctx, config)
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, wasmRuntime); err != nil {
+			wasmRuntimeErr = fmt.Errorf("failed to instantiate WASI: %w", err)
+		}
+	})
+	return wasmRuntime, wasmRuntimeErr
+}
+
+// WASMPlugin runs a detector compiled to WebAssembly in a sandbox. The module
+// exports its memory, "alloc(size i32) i32" returning a buffer for the
+// request, and "detect(ptr i32, len i32) i64" taking the PluginRequest as JSON
+// and returning the location of the PluginResponse as JSON, packed as
+// ptr<<32 | len. Each detection runs in a fresh instance.
+type WASMPlugin struct {
+	Name    string
+	module  wazero.CompiledModule
+	runtime wazero.Runtime
+	// Timeout is the time the plugin may take. Defaults to
+	// DefaultPluginTimeout.
+	Timeout time.Duration
+}
+
+// LoadWASMPlugin compiles the WASM module at path
+func LoadWASMPlugin(name, path string) (*WASMPlugin, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module: %w", err)
+	}
+	runtime, err := newWASMRuntime()
+	if err != nil {
+		return nil, err
+	}
+	module, err := runtime.CompileModule(context.Background(), code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile module: %w", err)
+	}
+	for _, export := range []string{"alloc", "detect"} {
+		if _, ok := module.ExportedFunctions()[export]; !ok {
+			module.Close(context.Background())
+			return nil, fmt.Errorf("module does not export %s", export)
+		}
+	}
+	if _, ok := module.ExportedMemories()["memory"]; !ok {
+		module.Close(context.Background())
+		return nil, fmt.Errorf("module does not export its memory")
+	}
+	return &WASMPlugin{Name: name, module: module, runtime: runtime}, nil
+}
+
+// Detect asks the module for the address of the target
+func (p *WASMPlugin) Detect(target string) Detection {
+	detection := Detection{
+		Strategy: p.Name,
+		Target:   target,
+	}
+
+	response, err := p.call(target)
+	switch {
+	case err != nil:
+		detection.Error = fmt.Sprintf("plugin %s failed: %v", p.Name, err)
+	case response.Error != "":
+		detection.Error = response.Error
+	case net.ParseIP(response.Address) == nil:
+		detection.Error = fmt.Sprintf("plugin %s returned invalid address %q", p.Name, response.Address)
+	default:
+		detection.Address = response.Address
+		detection.Interface = response.Interface
+		detection.Gateway = response.Gateway
+	}
+	return detection
+}
+
+// call runs detect in a fresh instance of the module
+func (p *WASMPlugin) call(target string) (PluginResponse, error) {
+	var response PluginResponse
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Reactor modules are initialized, command modules are not started
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	instance, err := p.runtime.InstantiateModule(ctx, p.module, config)
+	if err != nil {
+		return response, fmt.Errorf("failed to instantiate module: %w", err)
+	}
+	defer instance.Close(ctx)
+
+	request, err := json.Marshal(newPluginRequest(target))
+	if err != nil {
+		return response, err
+	}
+	results, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(request)))
+	if err != nil {
+		return response, fmt.Errorf("failed to allocate request: %w", err)
+	}
+	ptr := uint32(results[0])
+	if !instance.Memory().Write(ptr, request) {
+		return response, fmt.Errorf("request buffer at %d is out of range", ptr)
+	}
+
+	results, err = instance.ExportedFunction("detect").Call(ctx, uint64(ptr), uint64(len(request)))
+	if err != nil {
+		return response, err
+	}
+	ptr, size := uint32(results[0]>>32), uint32(results[0])
+	data, ok := instance.Memory().Read(ptr, size)
+	if !ok {
+		return response, fmt.Errorf("response at %d with %d bytes is out of range", ptr, size)
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return response, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package detector
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildWASMPlugin compiles the plugin of testdata/wasmplugin
+func buildWASMPlugin(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+	path := filepath.Join(t.TempDir(), "wasmplugin.wasm")
+	cmd := exec.Command(goTool, "build", "-buildmode=c-shared", "-o", path, ".")
+	cmd.Dir = filepath.Join("testdata", "wasmplugin")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the WASM plugin: %v\n%s", err, out)
+	}
+	return path
+}
+
+func TestWASMPlugin(t *testing.T) {
+	plugin, err := LoadWASMPlugin("test", buildWASMPlugin(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The module picks the address among the interfaces of the request
+	links, _ := takeLinkSnapshots()
+	var link *LinkSnapshot
+	for i := range links {
+		if len(links[i].Addresses) > 0 {
+			link = &links[i]
+			break
+		}
+	}
+	if link == nil {
+		t.Skip("no interface with addresses")
+	}
+	want, _, _ := strings.Cut(link.Addresses[0].Address, "/")
+
+	detection := plugin.Detect(link.Name)
+	if detection.Error != "" {
+		t.Fatalf("detection failed: %s", detection.Error)
+	}
+	if detection.Strategy != "test" || detection.Target != link.Name ||
+		detection.Address != want || detection.Interface != link.Name {
+		t.Errorf("got %+v, want address %s of interface %s", detection, want, link.Name)
+	}
+
+	// Errors of the module are reported as such
+	detection = plugin.Detect("missing0")
+	if !strings.HasPrefix(detection.Error, "interface missing0 not found") || detection.Address != "" {
+		t.Errorf("got %+v, want the error of the module", detection)
+	}
+}
+
+func TestLoadWASMPluginInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.wasm")
+	if err := os.WriteFile(path, []byte("not a module"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWASMPlugin("invalid", path); err == nil {
+		t.Error("expected an error")
+	}
+}
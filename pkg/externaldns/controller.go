@@ -0,0 +1,346 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package externaldns maintains external-dns DNSEndpoint resources with the
+// ExternalIPs of the nodes
+package externaldns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// managedByLabel marks the DNSEndpoints maintained by local-ccm
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "local-ccm"
+
+	// namePrefix is prepended to the node name to name its DNSEndpoint
+	namePrefix = "node-"
+)
+
+// DNSEndpointResource is the resource of external-dns DNSEndpoint objects
+var DNSEndpointResource = schema.GroupVersionResource{Group: "externaldns.k8s.io", Version: "v1alpha1", Resource: "dnsendpoints"}
+
+// Controller maintains one DNSEndpoint per node with A and AAAA records for
+// its ExternalIPs. The DNS name is derived from a template with {node},
+// {zone} and {region} placeholders. Nodes are synced from a rate-limited
+// workqueue, so a failing node is retried with backoff without delaying the
+// others.
+type Controller struct {
+	client    dynamic.Interface
+	namespace string
+	template  string
+
+	nodeLister       corelisters.NodeLister
+	endpointInformer cache.SharedIndexInformer
+	endpointLister   cache.GenericNamespaceLister
+	synced           []cache.InformerSynced
+
+	// nodeHandler is removed from the shared node informer when Run returns
+	nodeInformer cache.SharedIndexInformer
+	nodeHandler  cache.ResourceEventHandlerRegistration
+
+	queue workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController creates a controller maintaining DNSEndpoints in namespace
+func NewController(client dynamic.Interface, factory informers.SharedInformerFactory, namespace, template string) (*Controller, error) {
+	c := &Controller{
+		client:    client,
+		namespace: namespace,
+		template:  template,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "dnsendpoint"},
+		),
+	}
+
+	nodeInformer := factory.Core().V1().Nodes()
+	registration, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueNode,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok1 := oldObj.(*v1.Node)
+			newNode, ok2 := newObj.(*v1.Node)
+			if ok1 && ok2 && reflect.DeepEqual(c.endpoints(oldNode), c.endpoints(newNode)) {
+				return
+			}
+			c.enqueueNode(newObj)
+		},
+		DeleteFunc: c.enqueueNode,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+	c.nodeInformer, c.nodeHandler = nodeInformer.Informer(), registration
+	c.nodeLister = nodeInformer.Lister()
+
+	// Watch the managed DNSEndpoints to delete those of removed nodes and to
+	// revert changes by others
+	endpointInformer := dynamicinformer.NewFilteredDynamicInformer(client, DNSEndpointResource, namespace, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.SelectorFromSet(labels.Set{managedByLabel: managedByValue}).String()
+		})
+	c.endpointInformer = endpointInformer.Informer()
+	if _, err := c.endpointInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueEndpoint,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueEndpoint(obj) },
+		DeleteFunc: c.enqueueEndpoint,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add DNSEndpoint event handler: %w", err)
+	}
+	c.endpointLister = endpointInformer.Lister().ByNamespace(namespace)
+
+	c.synced = []cache.InformerSynced{registration.HasSynced, c.endpointInformer.HasSynced}
+	return c, nil
+}
+
+// Run starts the workers and maintains the DNSEndpoints until the context is
+// cancelled
+func (c *Controller) Run(ctx context.Context, workers int) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+	defer func() {
+		if err := c.nodeInformer.RemoveEventHandler(c.nodeHandler); err != nil {
+			klog.Errorf("Failed to remove node event handler: %v", err)
+		}
+	}()
+
+	klog.Infof("Starting DNSEndpoint controller in namespace %s with %d workers", c.namespace, workers)
+
+	go c.endpointInformer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		klog.Error("Failed to wait for DNSEndpoint controller caches to sync")
+		return
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.worker, time.Second)
+	}
+
+	<-ctx.Done()
+	klog.Info("Stopping DNSEndpoint controller")
+}
+
+func (c *Controller) worker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	name, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	if err := c.syncNode(ctx, name); err != nil {
+		klog.Errorf("Failed to sync DNSEndpoint of node %s: %v", name, err)
+		c.queue.AddRateLimited(name)
+		return true
+	}
+
+	c.queue.Forget(name)
+	return true
+}
+
+func (c *Controller) enqueueNode(obj interface{}) {
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(name)
+}
+
+// enqueueEndpoint enqueues the node of a managed DNSEndpoint
+func (c *Controller) enqueueEndpoint(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if name, ok := strings.CutPrefix(accessor.GetName(), namePrefix); ok {
+		c.queue.Add(name)
+	}
+}
+
+// syncNode creates, updates or deletes the DNSEndpoint of a node
+func (c *Controller) syncNode(ctx context.Context, nodeName string) error {
+	var endpoints []interface{}
+	node, err := c.nodeLister.Get(nodeName)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("failed to get node: %w", err)
+	default:
+		endpoints = c.endpoints(node)
+	}
+
+	name := namePrefix + nodeName
+	resource := c.client.Resource(DNSEndpointResource).Namespace(c.namespace)
+	cached, err := c.endpointLister.Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get DNSEndpoint %s: %w", name, err)
+	}
+	existing, _ := cached.(*unstructured.Unstructured)
+
+	switch {
+	case existing == nil && len(endpoints) == 0:
+		return nil
+
+	case existing == nil:
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": DNSEndpointResource.GroupVersion().String(),
+			"kind":       "DNSEndpoint",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": c.namespace,
+				"labels": map[string]interface{}{
+					managedByLabel: managedByValue,
+				},
+			},
+			"spec": map[string]interface{}{
+				"endpoints": endpoints,
+			},
+		}}
+		if _, err := resource.Create(ctx, obj, metav1.CreateOptions{FieldManager: version.FieldManager()}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create DNSEndpoint %s: %w", name, err)
+		}
+		klog.Infof("Successfully created DNSEndpoint %s/%s", c.namespace, name)
+
+	case len(endpoints) == 0:
+		if err := resource.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete DNSEndpoint %s: %w", name, err)
+		}
+		klog.Infof("Successfully deleted DNSEndpoint %s/%s", c.namespace, name)
+
+	default:
+		current, _, _ := unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+		if reflect.DeepEqual(current, endpoints) {
+			return nil
+		}
+		obj := existing.DeepCopy()
+		if err := unstructured.SetNestedSlice(obj.Object, endpoints, "spec", "endpoints"); err != nil {
+			return err
+		}
+		if _, err := resource.Update(ctx, obj, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+			return fmt.Errorf("failed to update DNSEndpoint %s: %w", name, err)
+		}
+		klog.Infof("Successfully updated DNSEndpoint %s/%s", c.namespace, name)
+	}
+	return nil
+}
+
+// endpoints returns the A and AAAA records of the ExternalIPs of a node
+func (c *Controller) endpoints(node *v1.Node) []interface{} {
+	var ipv4, ipv6 []string
+	for _, addr := range node.Status.Addresses {
+		if addr.Type != v1.NodeExternalIP {
+			continue
+		}
+		ip := net.ParseIP(addr.Address)
+		switch {
+		case ip == nil:
+			continue
+		case ip.To4() != nil:
+			ipv4 = append(ipv4, ip.String())
+		default:
+			ipv6 = append(ipv6, ip.String())
+		}
+	}
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		return nil
+	}
+
+	name := c.dnsName(node)
+	if name == "" {
+		return nil
+	}
+
+	var endpoints []interface{}
+	for _, record := range []struct {
+		recordType string
+		targets    []string
+	}{{"A", ipv4}, {"AAAA", ipv6}} {
+		if len(record.targets) == 0 {
+			continue
+		}
+		sort.Strings(record.targets)
+		targets := make([]interface{}, 0, len(record.targets))
+		for _, target := range record.targets {
+			targets = append(targets, target)
+		}
+		endpoints = append(endpoints, map[string]interface{}{
+			"dnsName":    name,
+			"recordType": record.recordType,
+			"targets":    targets,
+		})
+	}
+	return endpoints
+}
+
+// dnsName renders the template for a node, or returns an empty string if a
+// placeholder cannot be filled or the result is not a valid DNS name
+func (c *Controller) dnsName(node *v1.Node) string {
+	zone := node.Labels[v1.LabelTopologyZone]
+	region := node.Labels[v1.LabelTopologyRegion]
+	if (zone == "" && strings.Contains(c.template, "{zone}")) || (region == "" && strings.Contains(c.template, "{region}")) {
+		klog.V(3).Infof("Skipping DNSEndpoint of node %s without topology labels", node.Name)
+		return ""
+	}
+
+	name := strings.NewReplacer(
+		"{node}", node.Name,
+		"{zone}", zone,
+		"{region}", region,
+	).Replace(c.template)
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		klog.Warningf("Ignoring invalid DNS name %q of node %s: %s", name, node.Name, strings.Join(errs, ", "))
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package facts derives facts about the network of a node from the detection
+// results and the host, and renders them into label and annotation values
+// via templates like "{interface}-{uplinks}".
+package facts
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+const (
+	// Interface is the interface of the detected InternalIP, or of the
+	// ExternalIP without internal detection
+	Interface = "interface"
+	// IPv4 and IPv6 tell whether the host has a global address of the
+	// family on an interface that is up
+	IPv4 = "ipv4"
+	IPv6 = "ipv6"
+	// DualStack tells whether the host has global addresses of both families
+	DualStack = "dualstack"
+	// NAT tells whether the node is reachable through NAT only
+	NAT = "nat"
+	// Uplinks is the number of interfaces carrying a default route
+	Uplinks = "uplinks"
+)
+
+// known are the names of the facts
+var known = map[string]bool{
+	Interface: true,
+	IPv4:      true,
+	IPv6:      true,
+	DualStack: true,
+	NAT:       true,
+	Uplinks:   true,
+}
+
+// Facts maps fact names to their values. Facts that could not be determined,
+// e.g. NAT while external detection fails, are missing.
+type Facts map[string]string
+
+// Collect derives the facts of a detection report and the host
+func Collect(report *detector.Report) Facts {
+	facts := make(Facts)
+
+	for _, detection := range []*detector.Detection{report.Internal, report.External} {
+		if detection != nil && detection.Error == "" && detection.Interface != "" {
+			facts[Interface] = detection.Interface
+			break
+		}
+	}
+	if report.External != nil && report.External.Error == "" {
+		facts[NAT] = strconv.FormatBool(report.BehindNAT)
+	}
+
+	if ipv4, ipv6, err := globalFamilies(); err != nil {
+		klog.V(2).Infof("Failed to list addresses: %v", err)
+	} else {
+		facts[IPv4] = strconv.FormatBool(ipv4)
+		facts[IPv6] = strconv.FormatBool(ipv6)
+		facts[DualStack] = strconv.FormatBool(ipv4 && ipv6)
+	}
+
+	if uplinks, err := countUplinks(); err != nil {
+		klog.V(2).Infof("Failed to count uplinks: %v", err)
+	} else if uplinks >= 0 {
+		facts[Uplinks] = strconv.Itoa(uplinks)
+	}
+	return facts
+}
+
+// globalFamilies reports whether interfaces that are up have global unicast
+// addresses of each family, skipping SR-IOV virtual functions and
+// representors
+func globalFamilies() (ipv4, ipv6 bool, err error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, false, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || detector.SRIOVReason(iface.Name) != "" {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			klog.V(4).Infof("Failed to list addresses of %s: %v", iface.Name, err)
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				ipv4 = true
+			} else {
+				ipv6 = true
+			}
+		}
+	}
+	return ipv4, ipv6, nil
+}
+
+// Template is a value with {fact} placeholders
+type Template struct {
+	// parts alternate between literal text and fact names, starting with text
+	parts []string
+}
+
+// Parse parses a template, rejecting unknown facts
+func Parse(template string) (*Template, error) {
+	t := &Template{}
+	rest := template
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			t.parts = append(t.parts, rest)
+			return t, nil
+		}
+		if rest[start] == '}' {
+			return nil, fmt.Errorf("unexpected } in template %q", template)
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated { in template %q", template)
+		}
+		name := rest[start+1 : start+end]
+		if !known[name] {
+			return nil, fmt.Errorf("unknown fact %q in template %q", name, template)
+		}
+		t.parts = append(t.parts, rest[:start], name)
+		rest = rest[start+end+1:]
+	}
+}
+
+// Execute renders the template with the facts. It fails if a fact of the
+// template is missing.
+func (t *Template) Execute(facts Facts) (string, error) {
+	var b strings.Builder
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			b.WriteString(part)
+			continue
+		}
+		value, ok := facts[part]
+		if !ok {
+			return "", fmt.Errorf("fact %s is unknown", part)
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
+
+// ParseTemplates parses "key=template" pairs into templates by key
+func ParseTemplates(pairs []string) (map[string]*Template, error) {
+	templates := make(map[string]*Template, len(pairs))
+	for _, pair := range pairs {
+		key, template, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=template, got %q", pair)
+		}
+		t, err := Parse(template)
+		if err != nil {
+			return nil, err
+		}
+		templates[key] = t
+	}
+	return templates, nil
+}
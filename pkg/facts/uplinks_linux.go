@@ -0,0 +1,59 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package facts
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/cozystack/local-ccm/pkg/detector"
+)
+
+// countUplinks counts the interfaces carrying a default route of the main
+// routing table, skipping SR-IOV virtual functions and representors
+func countUplinks() (int, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	links := make(map[int]bool)
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		if route.LinkIndex > 0 {
+			links[route.LinkIndex] = true
+		}
+		for _, hop := range route.MultiPath {
+			links[hop.LinkIndex] = true
+		}
+	}
+	uplinks := 0
+	for index := range links {
+		if link, err := netlink.LinkByIndex(index); err == nil && detector.SRIOVReason(link.Attrs().Name) != "" {
+			continue
+		}
+		uplinks++
+	}
+	return uplinks, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package features defines the feature gates of local-ccm, which let risky
+// behaviors ship disabled by default and be toggled per cluster
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feature is the name of a feature gate
+type Feature string
+
+// Stage is the maturity of a feature
+type Stage string
+
+const (
+	// Alpha features are disabled by default and may change or go away
+	Alpha Stage = "Alpha"
+	// Beta features are enabled by default
+	Beta Stage = "Beta"
+)
+
+// Spec describes a feature gate
+type Spec struct {
+	Default bool
+	Stage   Stage
+}
+
+const (
+	// ServerSideApply updates node addresses and labels with server-side
+	// apply instead of replacing them with JSON patches
+	ServerSideApply Feature = "ServerSideApply"
+	// EventDrivenReconcile reconciles on network events and node changes,
+	// i.e. allows the network event sources and the once-then-watch mode
+	EventDrivenReconcile Feature = "EventDrivenReconcile"
+	// LoadBalancerController allows the Service controller publishing the
+	// ingress of LoadBalancer Services
+	LoadBalancerController Feature = "LoadBalancerController"
+)
+
+// defaults holds all known feature gates
+var defaults = map[Feature]Spec{
+	ServerSideApply:        {Default: false, Stage: Alpha},
+	EventDrivenReconcile:   {Default: true, Stage: Beta},
+	LoadBalancerController: {Default: true, Stage: Beta},
+}
+
+// Gates holds the state of the feature gates. The nil value has all gates
+// at their default.
+type Gates struct {
+	enabled map[Feature]bool
+}
+
+// Parse parses a comma-separated list of Name=true|false pairs
+func Parse(value string) (*Gates, error) {
+	g := &Gates{enabled: make(map[Feature]bool)}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing value for feature gate %q", name)
+		}
+		feature := Feature(strings.TrimSpace(name))
+		if _, known := defaults[feature]; !known {
+			return nil, fmt.Errorf("unknown feature gate %q, known gates are %s", feature, strings.Join(Known(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for feature gate %s: %w", raw, feature, err)
+		}
+		g.enabled[feature] = enabled
+	}
+	return g, nil
+}
+
+// Enabled checks if a feature is enabled
+func (g *Gates) Enabled(feature Feature) bool {
+	if g != nil {
+		if enabled, ok := g.enabled[feature]; ok {
+			return enabled
+		}
+	}
+	return defaults[feature].Default
+}
+
+// Known returns the known feature gates with their stage and default, for
+// flag help
+func Known() []string {
+	known := make([]string, 0, len(defaults))
+	for feature, spec := range defaults {
+		known = append(known, fmt.Sprintf("%s=true|false (%s - default=%t)", feature, spec.Stage, spec.Default))
+	}
+	sort.Strings(known)
+	return known
+}
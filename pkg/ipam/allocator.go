@@ -0,0 +1,493 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// allocationsKey is the ConfigMap data key holding the JSON encoded allocations
+	allocationsKey = "allocations"
+)
+
+var (
+	// ErrPoolExhausted is returned when no free address is left in any pool of the requested family
+	ErrPoolExhausted = fmt.Errorf("no free address left in pools")
+	// ErrUnknownPool is returned when a requested pool does not exist
+	ErrUnknownPool = fmt.Errorf("unknown pool")
+	// ErrNotInPool is returned when a requested address is outside of all pools
+	ErrNotInPool = fmt.Errorf("address is not in any pool")
+	// ErrAllocated is returned when a requested address is already in use
+	ErrAllocated = fmt.Errorf("address is already allocated")
+)
+
+// Allocator allocates LoadBalancer IPs from a set of CIDR pools. Allocations
+// are persisted as lease records in a ConfigMap, so they survive restarts and
+// leader changes.
+type Allocator struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	pools     func() []Pool
+
+	mu sync.Mutex
+	// allocations maps allocated IPs to the namespace/name keys of the
+	// services holding them. IPs are shared by more than one service only if
+	// requested via Share.
+	allocations map[string][]string
+}
+
+// NewAllocator creates an allocator for the pools returned by the given
+// function, persisting its allocations in the named ConfigMap
+func NewAllocator(client kubernetes.Interface, namespace, name string, pools func() []Pool) *Allocator {
+	return &Allocator{
+		client:      client,
+		namespace:   namespace,
+		name:        name,
+		pools:       pools,
+		allocations: make(map[string][]string),
+	}
+}
+
+// ParsePools parses the CIDRs of address pools
+func ParsePools(cidrs []string) ([]*net.IPNet, error) {
+	pools := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, pool, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool CIDR %s: %w", cidr, err)
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// Load reads the persisted allocations from the ConfigMap
+func (a *Allocator) Load(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cm, err := a.client.CoreV1().ConfigMaps(a.namespace).Get(ctx, a.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.V(2).Infof("Allocations ConfigMap %s/%s not found, starting empty", a.namespace, a.name)
+		a.allocations = make(map[string][]string)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get allocations ConfigMap: %w", err)
+	}
+
+	stored := make(map[string]string)
+	if data := cm.Data[allocationsKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &stored); err != nil {
+			return fmt.Errorf("failed to parse allocations ConfigMap: %w", err)
+		}
+	}
+
+	allocations := make(map[string][]string, len(stored))
+	for ip, keys := range stored {
+		if !a.contains(net.ParseIP(ip)) {
+			klog.Warningf("Allocated IP %s of service %s is outside of all pools", ip, keys)
+		}
+		allocations[ip] = strings.Split(keys, ",")
+	}
+
+	a.allocations = allocations
+	klog.V(2).Infof("Loaded %d LoadBalancer IP allocations", len(allocations))
+	return nil
+}
+
+// Allocated returns the IPs allocated to a service
+func (a *Allocator) Allocated(key string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.allocatedLocked(key)
+}
+
+// Keys returns the keys of all services holding allocations
+func (a *Allocator) Keys() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, owners := range a.allocations {
+		for _, key := range owners {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Allocate returns the IP of the given family allocated to a service,
+// allocating a new one if needed. If poolName is set, the IP is allocated from
+// that pool, otherwise from any pool allowing auto-assignment. IPs listed in
+// inUse (e.g. assigned to other services by another LoadBalancer
+// implementation) are never handed out.
+func (a *Allocator) Allocate(ctx context.Context, key string, family v1.IPFamily, poolName string, inUse map[string]bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pools := a.pools()
+	for _, ip := range a.allocatedLocked(key) {
+		if ipFamily(net.ParseIP(ip)) != family {
+			continue
+		}
+		if pool := FindPool(pools, net.ParseIP(ip)); poolName == "" || (pool != nil && pool.Name == poolName) {
+			return ip, nil
+		}
+	}
+
+	found := false
+	for _, pool := range pools {
+		if poolName != "" && pool.Name != poolName {
+			continue
+		}
+		if poolName == "" && !pool.AutoAssign {
+			continue
+		}
+		found = true
+
+		ip := ""
+		for _, cidr := range pool.CIDRs {
+			if ipFamily(cidr.IP) != family {
+				continue
+			}
+			if ip = a.nextFree(cidr, inUse); ip != "" {
+				break
+			}
+		}
+		if ip == "" {
+			continue
+		}
+
+		a.allocations[ip] = []string{key}
+		if err := a.persist(ctx); err != nil {
+			delete(a.allocations, ip)
+			return "", err
+		}
+
+		klog.Infof("Allocated LoadBalancer IP %s to service %s", ip, key)
+		return ip, nil
+	}
+
+	if poolName != "" && !found {
+		return "", fmt.Errorf("failed to allocate %s address for service %s from pool %s: %w", family, key, poolName, ErrUnknownPool)
+	}
+	return "", fmt.Errorf("failed to allocate %s address for service %s: %w", family, key, ErrPoolExhausted)
+}
+
+// AllocateIP allocates a specific IP requested by a service. It fails with
+// ErrNotInPool if the IP is outside of all pools and with ErrAllocated if the
+// IP is held by another service or listed in inUse.
+func (a *Allocator) AllocateIP(ctx context.Context, key, ip string, inUse map[string]bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return fmt.Errorf("invalid address %q", ip)
+	}
+	ip = parsed.String()
+
+	if owners, allocated := a.allocations[ip]; allocated {
+		if slices.Contains(owners, key) {
+			return nil
+		}
+		return fmt.Errorf("%s requested by %s is held by %s: %w", ip, key, strings.Join(owners, ","), ErrAllocated)
+	}
+	if inUse[ip] {
+		return fmt.Errorf("%s requested by %s is used by another service: %w", ip, key, ErrAllocated)
+	}
+	if !a.contains(parsed) {
+		return fmt.Errorf("%s requested by %s: %w", ip, key, ErrNotInPool)
+	}
+
+	a.allocations[ip] = []string{key}
+	if err := a.persist(ctx); err != nil {
+		delete(a.allocations, ip)
+		return err
+	}
+
+	klog.Infof("Allocated requested LoadBalancer IP %s to service %s", ip, key)
+	return nil
+}
+
+// Share adds a service to the holders of an allocated IP. Checking that the
+// services may share the IP is up to the caller.
+func (a *Allocator) Share(ctx context.Context, key, ip string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	owners, allocated := a.allocations[ip]
+	if !allocated {
+		return fmt.Errorf("%s shared with %s is not allocated", ip, key)
+	}
+	if slices.Contains(owners, key) {
+		return nil
+	}
+
+	a.allocations[ip] = append(owners[:len(owners):len(owners)], key)
+	if err := a.persist(ctx); err != nil {
+		a.allocations[ip] = owners
+		return err
+	}
+
+	klog.Infof("Shared LoadBalancer IP %s of services %s with service %s", ip, strings.Join(owners, ","), key)
+	return nil
+}
+
+// Owners returns the keys of the services holding an IP
+func (a *Allocator) Owners(ip string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]string(nil), a.allocations[ip]...)
+}
+
+// IPs returns all allocated IPs
+func (a *Allocator) IPs() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ips := make([]string, 0, len(a.allocations))
+	for ip := range a.allocations {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// Release frees all IPs allocated to a service
+func (a *Allocator) Release(ctx context.Context, key string) error {
+	return a.ReleaseExcept(ctx, key, nil)
+}
+
+// ReleaseExcept frees the IPs allocated to a service except the given ones
+func (a *Allocator) ReleaseExcept(ctx context.Context, key string, keep []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	kept := make(map[string]bool)
+	for _, ip := range keep {
+		if parsed := net.ParseIP(strings.TrimSpace(ip)); parsed != nil {
+			kept[parsed.String()] = true
+		}
+	}
+
+	// released maps the released IPs to their previous holders
+	released := make(map[string][]string)
+	for ip, owners := range a.allocations {
+		if kept[ip] || !slices.Contains(owners, key) {
+			continue
+		}
+		released[ip] = owners
+
+		var remaining []string
+		for _, owner := range owners {
+			if owner != key {
+				remaining = append(remaining, owner)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(a.allocations, ip)
+		} else {
+			a.allocations[ip] = remaining
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+
+	if err := a.persist(ctx); err != nil {
+		for ip, owners := range released {
+			a.allocations[ip] = owners
+		}
+		return err
+	}
+
+	for ip := range released {
+		klog.Infof("Released LoadBalancer IP %s of service %s", ip, key)
+	}
+	return nil
+}
+
+func (a *Allocator) allocatedLocked(key string) []string {
+	var ips []string
+	for ip, owners := range a.allocations {
+		if slices.Contains(owners, key) {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// nextFree returns the first free address of the pool, skipping the network
+// and broadcast addresses of IPv4 pools larger than /31
+func (a *Allocator) nextFree(pool *net.IPNet, inUse map[string]bool) string {
+	ones, bits := pool.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	base := new(big.Int).SetBytes(pool.IP)
+	skipEdges := bits == 32 && ones < 31
+
+	for i := big.NewInt(0); i.Cmp(size) < 0; i.Add(i, big.NewInt(1)) {
+		if skipEdges && (i.Sign() == 0 || new(big.Int).Add(i, big.NewInt(1)).Cmp(size) == 0) {
+			continue
+		}
+		ip := bigToIP(new(big.Int).Add(base, i), len(pool.IP)).String()
+		if _, allocated := a.allocations[ip]; allocated || inUse[ip] {
+			continue
+		}
+		return ip
+	}
+	return ""
+}
+
+func (a *Allocator) contains(ip net.IP) bool {
+	return FindPool(a.pools(), ip) != nil
+}
+
+// InPool checks if an IP is part of the named pool
+func (a *Allocator) InPool(ip, poolName string) bool {
+	pool := FindPool(a.pools(), net.ParseIP(ip))
+	return pool != nil && pool.Name == poolName
+}
+
+// PoolUsage counts the addresses of a pool
+type PoolUsage struct {
+	Allocated int64
+	Free      int64
+}
+
+// Usage returns the usage of all pools by name. Free counts are capped at
+// the maximum int64 for large IPv6 pools.
+func (a *Allocator) Usage() map[string]PoolUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := make(map[string]PoolUsage)
+	for _, pool := range a.pools() {
+		size := new(big.Int)
+		for _, cidr := range pool.CIDRs {
+			size.Add(size, usableAddresses(cidr))
+		}
+
+		var allocated int64
+		for ip := range a.allocations {
+			if pool.Contains(net.ParseIP(ip)) {
+				allocated++
+			}
+		}
+
+		free := size.Sub(size, big.NewInt(allocated))
+		if !free.IsInt64() {
+			free.SetInt64(math.MaxInt64)
+		}
+		usage[pool.Name] = PoolUsage{Allocated: allocated, Free: max(free.Int64(), 0)}
+	}
+	return usage
+}
+
+// usableAddresses returns the number of allocatable addresses of a CIDR
+func usableAddresses(cidr *net.IPNet) *big.Int {
+	ones, bits := cidr.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	if bits == 32 && ones < 31 {
+		size.Sub(size, big.NewInt(2))
+	}
+	return size
+}
+
+// persist writes the allocations to the ConfigMap
+func (a *Allocator) persist(ctx context.Context) error {
+	// Shared IPs are stored with a comma-separated list of services
+	stored := make(map[string]string, len(a.allocations))
+	for ip, owners := range a.allocations {
+		stored[ip] = strings.Join(owners, ",")
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocations: %w", err)
+	}
+
+	configMaps := a.client.CoreV1().ConfigMaps(a.namespace)
+	cm, err := configMaps.Get(ctx, a.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      a.name,
+				Namespace: a.namespace,
+			},
+			Data: map[string]string{allocationsKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{FieldManager: version.FieldManager()}); err != nil {
+			return fmt.Errorf("failed to create allocations ConfigMap: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get allocations ConfigMap: %w", err)
+	}
+
+	cm = cm.DeepCopy()
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[allocationsKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update allocations ConfigMap: %w", err)
+	}
+	return nil
+}
+
+func ipFamily(ip net.IP) v1.IPFamily {
+	if ip.To4() != nil {
+		return v1.IPv4Protocol
+	}
+	return v1.IPv6Protocol
+}
+
+func bigToIP(n *big.Int, size int) net.IP {
+	b := n.Bytes()
+	ip := make(net.IP, size)
+	copy(ip[size-len(b):], b)
+	return ip
+}
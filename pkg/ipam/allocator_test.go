@@ -0,0 +1,338 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testNamespace = "kube-system"
+	testConfigMap = "local-ccm-allocations"
+)
+
+// testAllocator returns an allocator of auto-assigned pools with the CIDRs
+func testAllocator(t *testing.T, cidrs ...string) (*Allocator, *fake.Clientset) {
+	t.Helper()
+	parsed, err := ParsePools(cidrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := []Pool{{Name: "default", CIDRs: parsed, AutoAssign: true}}
+	client := fake.NewSimpleClientset()
+	return NewAllocator(client, testNamespace, testConfigMap, func() []Pool { return pools }), client
+}
+
+// failPersist makes the writes of the allocations ConfigMap fail
+func failPersist(client *fake.Clientset) {
+	for _, verb := range []string{"create", "update"} {
+		client.PrependReactor(verb, "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("injected %s failure", verb)
+		})
+	}
+}
+
+// persisted returns the allocations stored in the ConfigMap
+func persisted(t *testing.T, client *fake.Clientset) string {
+	t.Helper()
+	cm, err := client.CoreV1().ConfigMaps(testNamespace).Get(context.Background(), testConfigMap, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cm.Data[allocationsKey]
+}
+
+func TestAllocateAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		cidr   string
+		family v1.IPFamily
+		inUse  map[string]bool
+		want   []string
+		// free is the usage of the pool once exhausted, counting the
+		// addresses in use by others and of other families
+		free int64
+	}{
+		{
+			name:   "network and broadcast addresses skipped",
+			cidr:   "192.0.2.0/30",
+			family: v1.IPv4Protocol,
+			want:   []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name:   "addresses in use skipped",
+			cidr:   "192.0.2.0/29",
+			family: v1.IPv4Protocol,
+			inUse:  map[string]bool{"192.0.2.1": true, "192.0.2.3": true, "192.0.2.6": true},
+			want:   []string{"192.0.2.2", "192.0.2.4", "192.0.2.5"},
+			free:   3,
+		},
+		{
+			name:   "point-to-point /31",
+			cidr:   "192.0.2.4/31",
+			family: v1.IPv4Protocol,
+			want:   []string{"192.0.2.4", "192.0.2.5"},
+		},
+		{
+			name:   "single address",
+			cidr:   "192.0.2.7/32",
+			family: v1.IPv4Protocol,
+			want:   []string{"192.0.2.7"},
+		},
+		{
+			name:   "IPv6 without broadcast",
+			cidr:   "2001:db8::/126",
+			family: v1.IPv6Protocol,
+			want:   []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"},
+		},
+		{
+			name:   "no pool of the family",
+			cidr:   "192.0.2.0/30",
+			family: v1.IPv6Protocol,
+			free:   2,
+		},
+	}
+	for _, tc := range tests {
+		a, _ := testAllocator(t, tc.cidr)
+		var got []string
+		for i := 0; ; i++ {
+			ip, err := a.Allocate(context.Background(), fmt.Sprintf("default/svc-%d", i), tc.family, "", tc.inUse)
+			if err != nil {
+				if !errors.Is(err, ErrPoolExhausted) {
+					t.Errorf("%s: expected ErrPoolExhausted, got %v", tc.name, err)
+				}
+				break
+			}
+			got = append(got, ip)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: allocated %v, want %v", tc.name, got, tc.want)
+		}
+		usage := a.Usage()["default"]
+		if usage.Allocated != int64(len(tc.want)) || usage.Free != tc.free {
+			t.Errorf("%s: usage %+v after exhaustion", tc.name, usage)
+		}
+	}
+}
+
+func TestAllocateExisting(t *testing.T) {
+	ctx := context.Background()
+	a, _ := testAllocator(t, "192.0.2.0/29")
+
+	first, err := a.Allocate(ctx, "default/web", v1.IPv4Protocol, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := a.Allocate(ctx, "default/web", v1.IPv4Protocol, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first {
+		t.Errorf("second allocation returned %s, want %s", again, first)
+	}
+
+	if _, err := a.Allocate(ctx, "default/web", v1.IPv4Protocol, "missing", nil); !errors.Is(err, ErrUnknownPool) {
+		t.Errorf("expected ErrUnknownPool, got %v", err)
+	}
+}
+
+func TestAllocateIP(t *testing.T) {
+	ctx := context.Background()
+	a, _ := testAllocator(t, "192.0.2.0/29")
+
+	if err := a.AllocateIP(ctx, "default/web", "192.0.2.3", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AllocateIP(ctx, "default/web", "192.0.2.3", nil); err != nil {
+		t.Errorf("requesting a held IP again failed: %v", err)
+	}
+	for _, tc := range []struct {
+		ip    string
+		inUse map[string]bool
+		want  error
+	}{
+		{ip: "192.0.2.3", want: ErrAllocated},
+		{ip: "192.0.2.4", inUse: map[string]bool{"192.0.2.4": true}, want: ErrAllocated},
+		{ip: "198.51.100.1", want: ErrNotInPool},
+	} {
+		if err := a.AllocateIP(ctx, "default/other", tc.ip, tc.inUse); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.ip, tc.want, err)
+		}
+	}
+}
+
+func TestShareRefcount(t *testing.T) {
+	ctx := context.Background()
+	a, client := testAllocator(t, "192.0.2.0/30")
+
+	ip, err := a.Allocate(ctx, "default/tcp", v1.IPv4Protocol, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Share(ctx, "default/udp", ip); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Share(ctx, "default/udp", ip); err != nil {
+		t.Errorf("sharing again failed: %v", err)
+	}
+	if err := a.Share(ctx, "default/udp", "192.0.2.2"); err == nil {
+		t.Error("expected an error sharing an unallocated IP")
+	}
+	if got := fmt.Sprint(a.Owners(ip)); got != "[default/tcp default/udp]" {
+		t.Errorf("owners %s", got)
+	}
+	if got, want := persisted(t, client), `{"192.0.2.1":"default/tcp,default/udp"}`; got != want {
+		t.Errorf("persisted %s, want %s", got, want)
+	}
+
+	// The IP stays allocated until its last holder releases it
+	if err := a.Release(ctx, "default/tcp"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(a.Owners(ip)); got != "[default/udp]" {
+		t.Errorf("owners after the first release %s", got)
+	}
+	if next, err := a.Allocate(ctx, "default/web", v1.IPv4Protocol, "", nil); err != nil || next == ip {
+		t.Errorf("allocated %s (%v) while %s is shared", next, err, ip)
+	}
+	if err := a.Release(ctx, "default/udp"); err != nil {
+		t.Fatal(err)
+	}
+	if owners := a.Owners(ip); len(owners) != 0 {
+		t.Errorf("owners after the last release %v", owners)
+	}
+	if next, err := a.Allocate(ctx, "default/dns", v1.IPv4Protocol, "", nil); err != nil || next != ip {
+		t.Errorf("allocated %s (%v), want the released %s", next, err, ip)
+	}
+}
+
+func TestReleaseExcept(t *testing.T) {
+	ctx := context.Background()
+	a, _ := testAllocator(t, "192.0.2.0/29", "2001:db8::/126")
+
+	v4, err := a.Allocate(ctx, "default/web", v1.IPv4Protocol, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Allocate(ctx, "default/web", v1.IPv6Protocol, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleaseExcept(ctx, "default/web", []string{v4}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(a.Allocated("default/web")); got != "["+v4+"]" {
+		t.Errorf("allocated %s, want only %s", got, v4)
+	}
+}
+
+func TestPersistFailureRollback(t *testing.T) {
+	ctx := context.Background()
+	a, client := testAllocator(t, "192.0.2.0/29")
+	ip, err := a.Allocate(ctx, "default/tcp", v1.IPv4Protocol, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := persisted(t, client)
+	failPersist(client)
+
+	if _, err := a.Allocate(ctx, "default/web", v1.IPv4Protocol, "", nil); err == nil {
+		t.Error("Allocate: expected the persist error")
+	}
+	if err := a.AllocateIP(ctx, "default/web", "192.0.2.5", nil); err == nil {
+		t.Error("AllocateIP: expected the persist error")
+	}
+	if err := a.Share(ctx, "default/udp", ip); err == nil {
+		t.Error("Share: expected the persist error")
+	}
+	if err := a.Release(ctx, "default/tcp"); err == nil {
+		t.Error("Release: expected the persist error")
+	}
+
+	// The allocations are those persisted before the failures
+	if got := fmt.Sprint(a.IPs()); got != "["+ip+"]" {
+		t.Errorf("IPs %s, want [%s]", got, ip)
+	}
+	if got := fmt.Sprint(a.Owners(ip)); got != "[default/tcp]" {
+		t.Errorf("owners %s, want [default/tcp]", got)
+	}
+	if got := persisted(t, client); got != stored {
+		t.Errorf("persisted %s, want %s", got, stored)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	a, client := testAllocator(t, "192.0.2.0/29")
+	ip, err := a.Allocate(ctx, "default/tcp", v1.IPv4Protocol, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Share(ctx, "default/udp", ip); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AllocateIP(ctx, "default/web", "192.0.2.5", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new leader continues with the persisted allocations
+	reloaded := NewAllocator(client, testNamespace, testConfigMap, a.pools)
+	if err := reloaded.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(reloaded.IPs()), fmt.Sprint(a.IPs()); got != want {
+		t.Errorf("reloaded IPs %s, want %s", got, want)
+	}
+	if got := fmt.Sprint(reloaded.Owners(ip)); got != "[default/tcp default/udp]" {
+		t.Errorf("reloaded owners %s", got)
+	}
+	if got := fmt.Sprint(reloaded.Keys()); got != "[default/tcp default/udp default/web]" {
+		t.Errorf("reloaded keys %s", got)
+	}
+	if next, err := reloaded.Allocate(ctx, "default/dns", v1.IPv4Protocol, "", nil); err != nil || next == ip || next == "192.0.2.5" {
+		t.Errorf("allocated %s (%v) held before the reload", next, err)
+	}
+}
+
+func TestLoadMissingAndInvalid(t *testing.T) {
+	ctx := context.Background()
+	a, client := testAllocator(t, "192.0.2.0/29")
+	if err := a.Load(ctx); err != nil {
+		t.Fatalf("loading without ConfigMap failed: %v", err)
+	}
+	if ips := a.IPs(); len(ips) != 0 {
+		t.Errorf("IPs %v without ConfigMap", ips)
+	}
+
+	if _, err := client.CoreV1().ConfigMaps(testNamespace).Create(ctx, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: testConfigMap, Namespace: testNamespace},
+		Data:       map[string]string{allocationsKey: "not json"},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Load(ctx); err == nil {
+		t.Error("expected an error loading invalid allocations")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// StaticPoolName is the name of the pool configured via command-line flags
+const StaticPoolName = "default"
+
+// Pool is a named set of CIDRs LoadBalancer IPs are allocated from
+type Pool struct {
+	Name  string
+	CIDRs []*net.IPNet
+	// AutoAssign allows allocating from the pool without requesting it
+	AutoAssign bool
+	// NodeSelectors restrict the nodes announcing the addresses of the pool.
+	// If empty, all nodes are eligible.
+	NodeSelectors []labels.Selector
+}
+
+// Contains checks if an IP is part of the pool
+func (p *Pool) Contains(ip net.IP) bool {
+	for _, cidr := range p.CIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectsNode checks if a node with the given labels may announce the
+// addresses of the pool
+func (p *Pool) SelectsNode(nodeLabels map[string]string) bool {
+	if len(p.NodeSelectors) == 0 {
+		return true
+	}
+	for _, selector := range p.NodeSelectors {
+		if selector.Matches(labels.Set(nodeLabels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindPool returns the pool containing an IP, or nil
+func FindPool(pools []Pool, ip net.IP) *Pool {
+	for i := range pools {
+		if pools[i].Contains(ip) {
+			return &pools[i]
+		}
+	}
+	return nil
+}
+
+// PoolWatcher provides the pools configured via flags and IPAddressPool
+// resources
+type PoolWatcher struct {
+	static   []Pool
+	client   dynamic.Interface
+	informer cache.SharedIndexInformer
+}
+
+// NewPoolWatcher creates a pool watcher. If factory is nil, only the static
+// pools are provided.
+func NewPoolWatcher(client dynamic.Interface, factory dynamicinformer.DynamicSharedInformerFactory, static []Pool) *PoolWatcher {
+	w := &PoolWatcher{
+		static: static,
+		client: client,
+	}
+	if factory != nil {
+		w.informer = factory.ForResource(v1alpha1.IPAddressPoolResource).Informer()
+	}
+	return w
+}
+
+// AddEventHandler calls fn whenever an IPAddressPool changes
+func (w *PoolWatcher) AddEventHandler(fn func()) error {
+	if w.informer == nil {
+		return nil
+	}
+	_, err := w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { fn() },
+		UpdateFunc: func(oldObj, newObj interface{}) { fn() },
+		DeleteFunc: func(interface{}) { fn() },
+	})
+	return err
+}
+
+// HasSynced reports whether the IPAddressPools have been listed
+func (w *PoolWatcher) HasSynced() bool {
+	return w.informer == nil || w.informer.HasSynced()
+}
+
+// Pools returns all valid pools sorted by name. Invalid IPAddressPools are skipped.
+func (w *PoolWatcher) Pools() []Pool {
+	pools := append([]Pool(nil), w.static...)
+	if w.informer == nil {
+		return pools
+	}
+
+	for _, obj := range w.informer.GetStore().List() {
+		pool, err := w.convert(obj)
+		if err != nil {
+			klog.Warningf("Ignoring IPAddressPool: %v", err)
+			continue
+		}
+		pools = append(pools, *pool)
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
+
+func (w *PoolWatcher) convert(obj interface{}) (*Pool, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T", obj)
+	}
+	ipPool := &v1alpha1.IPAddressPool{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, ipPool); err != nil {
+		return nil, fmt.Errorf("failed to convert %s: %w", u.GetName(), err)
+	}
+
+	cidrs, err := ParsePools(ipPool.Spec.CIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ipPool.Name, err)
+	}
+	pool := &Pool{
+		Name:       ipPool.Name,
+		CIDRs:      cidrs,
+		AutoAssign: ipPool.Spec.AutoAssign == nil || *ipPool.Spec.AutoAssign,
+	}
+	for i := range ipPool.Spec.NodeSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&ipPool.Spec.NodeSelectors[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid node selector: %w", ipPool.Name, err)
+		}
+		pool.NodeSelectors = append(pool.NodeSelectors, selector)
+	}
+	return pool, nil
+}
+
+// UpdateStatus reports the usage of the allocator in the status of the
+// IPAddressPools
+func (w *PoolWatcher) UpdateStatus(ctx context.Context, allocator *Allocator) error {
+	if w.informer == nil {
+		return nil
+	}
+
+	usage := allocator.Usage()
+	for _, obj := range w.informer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		current := usage[u.GetName()]
+		allocated, _, _ := unstructured.NestedInt64(u.Object, "status", "allocated")
+		free, found, _ := unstructured.NestedInt64(u.Object, "status", "free")
+		if found && allocated == current.Allocated && free == current.Free {
+			continue
+		}
+
+		u = u.DeepCopy()
+		if err := unstructured.SetNestedField(u.Object, map[string]interface{}{
+			"allocated": current.Allocated,
+			"free":      current.Free,
+		}, "status"); err != nil {
+			return err
+		}
+		if _, err := w.client.Resource(v1alpha1.IPAddressPoolResource).UpdateStatus(ctx, u, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+			return fmt.Errorf("failed to update status of IPAddressPool %s: %w", u.GetName(), err)
+		}
+		klog.V(2).Infof("Updated status of IPAddressPool %s: %d allocated, %d free", u.GetName(), current.Allocated, current.Free)
+	}
+	return nil
+}
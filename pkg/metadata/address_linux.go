@@ -0,0 +1,51 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+)
+
+// AddLocalAddress adds ip as /32 or /128 to the loopback interface, so the
+// node accepts traffic to it from pods routed via the host. It returns a
+// function removing it again.
+func AddLocalAddress(ip net.IP) (func(), error) {
+	link, err := netlink.LinkByName("lo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loopback interface: %w", err)
+	}
+	bits := 32
+	if ip.To4() == nil {
+		bits = 128
+	}
+	addr := &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}
+	if err := netlink.AddrReplace(link, addr); err != nil {
+		return nil, fmt.Errorf("failed to add %s to loopback interface: %w", ip, err)
+	}
+	klog.Infof("Added %s to loopback interface", ip)
+	return func() {
+		if err := netlink.AddrDel(link, addr); err != nil {
+			klog.Errorf("Failed to remove %s from loopback interface: %v", ip, err)
+		}
+	}, nil
+}
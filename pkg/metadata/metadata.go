@@ -0,0 +1,138 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metadata serves a minimal instance metadata service in the style
+// of the EC2 IMDS, so workloads written for clouds can look up the addresses
+// and placement of their node on bare metal.
+package metadata
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultAddress is the well-known link-local address of metadata services
+const DefaultAddress = "169.254.169.254"
+
+// Metadata is the instance metadata of the node
+type Metadata struct {
+	NodeName   string `json:"nodeName"`
+	ProviderID string `json:"providerID,omitempty"`
+	Zone       string `json:"zone,omitempty"`
+	Region     string `json:"region,omitempty"`
+	InternalIP string `json:"internalIP,omitempty"`
+	ExternalIP string `json:"externalIP,omitempty"`
+}
+
+// Handler serves the metadata returned by its source
+type Handler struct {
+	source func() (*Metadata, error)
+}
+
+// NewHandler creates a metadata handler
+func NewHandler(source func() (*Metadata, error)) *Handler {
+	return &Handler{source: source}
+}
+
+// ServeHTTP serves the EC2-style paths below /latest/meta-data/ as plain
+// text, and all metadata as JSON on /local-ccm/v1/metadata
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Hand out tokens for IMDSv2 clients, they are not checked
+	if r.URL.Path == "/latest/api/token" {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := make([]byte, 16)
+		_, _ = rand.Read(token)
+		_, _ = w.Write([]byte(hex.EncodeToString(token)))
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	md, err := h.source()
+	if err != nil {
+		klog.V(2).Infof("Failed to get instance metadata: %v", err)
+		http.Error(w, "metadata not available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Path == "/local-ccm/v1/metadata" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(md)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/latest/meta-data/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	values := md.values()
+	if key == "" || key == "placement/" {
+		// List the available keys like IMDS, directories with a trailing slash
+		var keys []string
+		seen := make(map[string]bool)
+		for k, v := range values {
+			if v == "" || !strings.HasPrefix(k, key) {
+				continue
+			}
+			name := strings.TrimPrefix(k, key)
+			if dir, _, nested := strings.Cut(name, "/"); nested {
+				name = dir + "/"
+			}
+			if !seen[name] {
+				seen[name] = true
+				keys = append(keys, name)
+			}
+		}
+		sort.Strings(keys)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(strings.Join(keys, "\n")))
+		return
+	}
+
+	value := values[key]
+	if value == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(value))
+}
+
+// values maps the IMDS keys to the metadata
+func (md *Metadata) values() map[string]string {
+	return map[string]string{
+		"hostname":                    md.NodeName,
+		"local-hostname":              md.NodeName,
+		"instance-id":                 md.NodeName,
+		"provider-id":                 md.ProviderID,
+		"local-ipv4":                  md.InternalIP,
+		"public-ipv4":                 md.ExternalIP,
+		"placement/availability-zone": md.Zone,
+		"placement/region":            md.Region,
+	}
+}
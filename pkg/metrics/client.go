@@ -0,0 +1,144 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	clientmetrics "k8s.io/client-go/tools/metrics"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CredentialSourceExec is the source of credentials of an exec plugin
+	CredentialSourceExec = "exec"
+)
+
+const (
+	// throttleWarningLatency is the client-side throttling delay of a request
+	// above which a warning is logged
+	throttleWarningLatency = time.Second
+	// throttleWarningInterval limits the throttling warnings, so a saturated
+	// client does not flood the log
+	throttleWarningInterval = time.Minute
+)
+
+// CredentialRefreshFailures counts failed refreshes of the client credentials
+var CredentialRefreshFailures = NewCounterVec(
+	"local_ccm_credential_refresh_failures_total",
+	"Number of failed refreshes of the API server credentials by source.",
+	"source",
+)
+
+var execPluginCalls = NewCounterVec(
+	"local_ccm_exec_plugin_calls_total",
+	"Number of calls of the kubeconfig exec plugin by exit code and status.",
+	"code", "status",
+)
+
+var rateLimiterDuration = NewHistogramVec(
+	"local_ccm_rest_client_rate_limiter_duration_seconds",
+	"Time API requests waited for the client-side rate limiter by verb.",
+	[]float64{0.005, 0.025, 0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	"verb",
+)
+
+var requests = NewCounterVec(
+	"local_ccm_rest_client_requests_total",
+	"Number of API requests by status code and method.",
+	"code", "method",
+)
+
+var requestRetries = NewCounterVec(
+	"local_ccm_rest_client_request_retries_total",
+	"Number of retried API requests by status code and method.",
+	"code", "method",
+)
+
+// RegisterClientMetrics hooks the metrics into client-go. It must be called
+// before the clients are created.
+func RegisterClientMetrics() {
+	// Export the failure counter before the first failure, so alerts on its
+	// increase fire on the first one
+	CredentialRefreshFailures.Add(0, CredentialSourceExec)
+
+	clientmetrics.Register(clientmetrics.RegisterOpts{
+		ExecPluginCalls:    execPluginCallsAdapter{},
+		RateLimiterLatency: &rateLimiterAdapter{},
+		RequestResult:      &requestResultAdapter{},
+		RequestRetry:       requestRetryAdapter{},
+	})
+}
+
+type execPluginCallsAdapter struct{}
+
+func (execPluginCallsAdapter) Increment(exitCode int, callStatus string) {
+	execPluginCalls.Inc(strconv.Itoa(exitCode), callStatus)
+	if callStatus != "no_error" {
+		CredentialRefreshFailures.Inc(CredentialSourceExec)
+	}
+}
+
+// rateLimitedWarning logs a warning at most once per throttleWarningInterval
+type rateLimitedWarning struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (w *rateLimitedWarning) warningf(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Since(w.last) < throttleWarningInterval {
+		return
+	}
+	w.last = time.Now()
+	klog.Warningf(format, args...)
+}
+
+type rateLimiterAdapter struct {
+	warning rateLimitedWarning
+}
+
+func (a *rateLimiterAdapter) Observe(_ context.Context, verb string, u url.URL, latency time.Duration) {
+	rateLimiterDuration.Observe(latency.Seconds(), verb)
+	if latency > throttleWarningLatency {
+		a.warning.warningf("API request %s %s waited %v for client-side throttling, consider raising --kube-api-qps and --kube-api-burst",
+			verb, u.Path, latency.Round(time.Millisecond))
+	}
+}
+
+type requestResultAdapter struct {
+	warning rateLimitedWarning
+}
+
+func (a *requestResultAdapter) Increment(_ context.Context, code, method, host string) {
+	requests.Inc(code, method)
+	if code == strconv.Itoa(http.StatusTooManyRequests) {
+		a.warning.warningf("API server %s is throttling requests (%s %s), check its API priority and fairness settings", host, method, code)
+	}
+}
+
+type requestRetryAdapter struct{}
+
+func (requestRetryAdapter) IncrementRetry(_ context.Context, code, method, _ string) {
+	requestRetries.Inc(code, method)
+}
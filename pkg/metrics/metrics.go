@@ -0,0 +1,195 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics implements the metrics of local-ccm in the Prometheus
+// text exposition format, without depending on the Prometheus client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// labelSeparator joins label values into map keys
+const labelSeparator = "\xff"
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+// collector writes a metric in the text exposition format
+type collector interface {
+	metricName() string
+	write(w io.Writer)
+}
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// vec holds the values of a metric by label values
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	v := &vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	register(v)
+	return v
+}
+
+func (v *vec) metricName() string {
+	return v.name
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	return joinLabelValues(values)
+}
+
+func joinLabelValues(values []string) string {
+	return strings.Join(values, labelSeparator)
+}
+
+func (v *vec) add(delta float64, values []string) {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] += delta
+}
+
+func (v *vec) set(value float64, values []string) {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = value
+}
+
+// write writes the metric in the text exposition format
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]float64, len(keys))
+	for i, key := range keys {
+		values[i] = v.values[key]
+	}
+	v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+	for i, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, key), formatFloat(values[i]))
+	}
+}
+
+// labelValueEscaper escapes label values as the text exposition format does,
+// which leaves other characters, including non-ASCII ones, as they are
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the label pairs of a key, followed by extra pairs
+func formatLabels(labels []string, key string, extra ...string) string {
+	var pairs []string
+	if len(labels) > 0 {
+		values := strings.Split(key, labelSeparator)
+		for i, label := range labels {
+			pairs = append(pairs, label+`="`+labelValueEscaper.Replace(values[i])+`"`)
+		}
+	}
+	pairs = append(pairs, extra...)
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	v *vec
+}
+
+// NewCounterVec creates and registers a counter
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{v: newVec(name, help, "counter", labels)}
+}
+
+// Inc increments the counter of the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.v.add(1, values)
+}
+
+// Add adds delta to the counter of the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	c.v.add(delta, values)
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	v *vec
+}
+
+// NewGaugeVec creates and registers a gauge
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{v: newVec(name, help, "gauge", labels)}
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.v.set(value, values)
+}
+
+// Handler serves the registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registryMu.Lock()
+		collectors := append([]collector(nil), registry...)
+		registryMu.Unlock()
+		sort.Slice(collectors, func(i, j int) bool { return collectors[i].metricName() < collectors[j].metricName() })
+		for _, c := range collectors {
+			c.write(w)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import "time"
+
+const (
+	// NetlinkRouteGet is the operation of route lookups
+	NetlinkRouteGet = "RouteGet"
+	// NetlinkAddrList is the operation of address listings
+	NetlinkAddrList = "AddrList"
+)
+
+// NetlinkDuration observes the duration of netlink calls. Slow calls are a
+// symptom of pressure on the route table or conntrack.
+var NetlinkDuration = NewHistogramVec(
+	"local_ccm_netlink_duration_seconds",
+	"Duration of netlink calls by operation.",
+	[]float64{0.0001, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	"operation",
+)
+
+// NetlinkErrors counts failed netlink calls
+var NetlinkErrors = NewCounterVec(
+	"local_ccm_netlink_errors_total",
+	"Number of failed netlink calls by operation.",
+	"operation",
+)
+
+// ObserveNetlink records a netlink call of the operation started at start
+func ObserveNetlink(operation string, start time.Time, err error) {
+	NetlinkDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		NetlinkErrors.Inc(operation)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+// NodeIPDrift is 1 while the node IP of kubelet differs from the detected
+// InternalIP of the node
+var NodeIPDrift = NewGaugeVec(
+	"local_ccm_node_ip_drift",
+	"Whether the node IP of kubelet differs from the detected InternalIP.",
+)
+
+// DetectionProbeFailures counts detections whose target did not answer the
+// ICMP probe
+var DetectionProbeFailures = NewCounterVec(
+	"local_ccm_detection_probe_failures_total",
+	"Number of detections whose target did not answer an ICMP echo from the detected address, by target.",
+	"target",
+)
+
+// DetectionStrategy is 1 for the strategy that detected an address type
+// with its own detectors
+var DetectionStrategy = NewGaugeVec(
+	"local_ccm_detection_strategy",
+	"Whether the address type was detected by the strategy, for address types with their own detectors.",
+	"type", "strategy",
+)
+
+// AddressUpdatesDeferred counts the address changes not published yet to
+// keep the minimum update interval
+var AddressUpdatesDeferred = NewCounterVec(
+	"local_ccm_address_updates_deferred_total",
+	"Number of address changes whose update was deferred to keep the minimum update interval.",
+)
+
+// AddressConflicts counts the changes of the addresses written by local-ccm
+// by other writers
+var AddressConflicts = NewCounterVec(
+	"local_ccm_address_conflicts_total",
+	"Number of changes of the addresses written by local-ccm by another writer, by field manager of the writer.",
+	"writer",
+)
+
+// SimulatedReconcileDuration observes the reconciliations of the fake nodes
+// of the scale simulation
+var SimulatedReconcileDuration = NewHistogramVec(
+	"local_ccm_simulation_reconcile_duration_seconds",
+	"Duration of the reconciliations of simulated nodes by result.",
+	[]float64{0.005, 0.025, 0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	"result",
+)
@@ -0,0 +1,522 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netevents
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// defaultSystemBusPath is the socket of the D-Bus system bus
+const defaultSystemBusPath = "/run/dbus/system_bus_socket"
+
+const (
+	dbusMethodCall = 1
+	dbusReply      = 2
+	dbusError      = 3
+	dbusSignal     = 4
+)
+
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+)
+
+// dbusMaxMessage is the maximum message size of the D-Bus specification
+const dbusMaxMessage = 1 << 27
+
+// dbusMaxDepth bounds the nesting of decoded values
+const dbusMaxDepth = 64
+
+// dbusConn is a minimal D-Bus client, which only subscribes to signals
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// dbusMessage is a received message with its decoded body
+type dbusMessage struct {
+	typ         byte
+	path        string
+	iface       string
+	member      string
+	errorName   string
+	replySerial uint32
+	body        []interface{}
+}
+
+// watchDBus subscribes to the signals matching the rules on the system bus
+// and notifies about those describe accepts
+func watchDBus(ctx context.Context, matches []string, describe func(*dbusMessage) (string, bool), notify func(string)) error {
+	conn, err := dialSystemBus(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.conn.Close() })
+	defer stop()
+
+	for _, match := range matches {
+		if _, err := conn.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", match); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", match, err)
+		}
+	}
+	klog.Infof("Watching network events on the D-Bus system bus")
+
+	for {
+		msg, err := conn.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read from D-Bus: %w", err)
+		}
+		if msg.typ != dbusSignal {
+			continue
+		}
+		if reason, ok := describe(msg); ok {
+			notify(reason)
+		}
+	}
+}
+
+// dialSystemBus connects and authenticates to the system bus
+func dialSystemBus(ctx context.Context) (*dbusConn, error) {
+	path := defaultSystemBusPath
+	if addr, ok := strings.CutPrefix(os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"), "unix:path="); ok {
+		path, _, _ = strings.Cut(addr, ",")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to D-Bus system bus: %w", err)
+	}
+	c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	// Authenticate with the credentials of the socket
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := conn.Write([]byte("\x00AUTH EXTERNAL " + uid + "\r\n")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate to D-Bus: %w", err)
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate to D-Bus: %q %v", strings.TrimSpace(line), err)
+	}
+	if _, err := conn.Write([]byte("BEGIN\r\n")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to authenticate to D-Bus: %w", err)
+	}
+
+	if _, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to register with D-Bus: %w", err)
+	}
+	return c, nil
+}
+
+// call calls a method with string arguments and waits for its reply
+func (c *dbusConn) call(dest, path, iface, member string, args ...string) (*dbusMessage, error) {
+	c.serial++
+	serial := c.serial
+
+	var body dbusEncoder
+	for _, arg := range args {
+		body.string(arg)
+	}
+
+	var fields dbusEncoder
+	field := func(code byte, sig, value string) {
+		fields.align(8)
+		fields.byte(code)
+		fields.signature(sig)
+		if sig == "g" {
+			fields.signature(value)
+		} else {
+			fields.string(value)
+		}
+	}
+	field(dbusFieldPath, "o", path)
+	field(dbusFieldInterface, "s", iface)
+	field(dbusFieldMember, "s", member)
+	field(dbusFieldDestination, "s", dest)
+	if len(args) > 0 {
+		field(dbusFieldSignature, "g", strings.Repeat("s", len(args)))
+	}
+
+	// The header fields start 8-aligned at offset 16, so their alignment
+	// is kept when appended
+	var msg dbusEncoder
+	msg.byte('l')
+	msg.byte(dbusMethodCall)
+	msg.byte(0)
+	msg.byte(1)
+	msg.uint32(uint32(len(body.buf)))
+	msg.uint32(serial)
+	msg.uint32(uint32(len(fields.buf)))
+	msg.buf = append(msg.buf, fields.buf...)
+	msg.align(8)
+	msg.buf = append(msg.buf, body.buf...)
+	if _, err := c.conn.Write(msg.buf); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if reply.replySerial != serial || (reply.typ != dbusReply && reply.typ != dbusError) {
+			continue
+		}
+		if reply.typ == dbusError {
+			return nil, fmt.Errorf("%s: %v", reply.errorName, reply.body)
+		}
+		return reply, nil
+	}
+}
+
+// read reads the next message
+func (c *dbusConn) read() (*dbusMessage, error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, head); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch head[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid byte order %q", head[0])
+	}
+	bodyLen, fieldsLen := order.Uint32(head[4:]), order.Uint32(head[12:])
+	if bodyLen > dbusMaxMessage || fieldsLen > dbusMaxMessage {
+		return nil, fmt.Errorf("message too large")
+	}
+	headerLen := (16 + int(fieldsLen) + 7) &^ 7
+	data := make([]byte, headerLen+int(bodyLen))
+	copy(data, head)
+	if _, err := io.ReadFull(c.reader, data[16:]); err != nil {
+		return nil, err
+	}
+
+	msg := &dbusMessage{typ: head[1]}
+	d := &dbusDecoder{buf: data[:headerLen], pos: 12, order: order}
+	fields, err := d.value("a(yv)", 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	signature := ""
+	for _, f := range fields.([]interface{}) {
+		entry := f.([]interface{})
+		code, value := entry[0].(byte), entry[1]
+		switch code {
+		case dbusFieldPath:
+			msg.path, _ = value.(string)
+		case dbusFieldInterface:
+			msg.iface, _ = value.(string)
+		case dbusFieldMember:
+			msg.member, _ = value.(string)
+		case dbusFieldErrorName:
+			msg.errorName, _ = value.(string)
+		case dbusFieldReplySerial:
+			msg.replySerial, _ = value.(uint32)
+		case dbusFieldSignature:
+			signature, _ = value.(string)
+		}
+	}
+
+	body := &dbusDecoder{buf: data[headerLen:], order: order}
+	if msg.body, err = body.values(signature, 0); err != nil {
+		return nil, fmt.Errorf("invalid body of %s.%s: %w", msg.iface, msg.member, err)
+	}
+	return msg, nil
+}
+
+// dbusEncoder marshals the little-endian values of method calls
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+}
+
+func (e *dbusEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// dbusDecoder unmarshals values. Structs decode to []interface{}, arrays of
+// dict entries to map[interface{}]interface{}, other arrays to
+// []interface{}, and variants to their value.
+type dbusDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *dbusDecoder) align(n int) error {
+	pos := (d.pos + n - 1) &^ (n - 1)
+	if pos > len(d.buf) {
+		return io.ErrUnexpectedEOF
+	}
+	d.pos = pos
+	return nil
+}
+
+func (d *dbusDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// values decodes the values of a signature
+func (d *dbusDecoder) values(sig string, depth int) ([]interface{}, error) {
+	var values []interface{}
+	for sig != "" {
+		typ, rest, err := dbusNextType(sig)
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.value(typ, depth)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		sig = rest
+	}
+	return values, nil
+}
+
+// value decodes a value of a single complete type
+func (d *dbusDecoder) value(typ string, depth int) (interface{}, error) {
+	if depth > dbusMaxDepth {
+		return nil, fmt.Errorf("values nested too deeply")
+	}
+	fixed := func(n int) ([]byte, error) {
+		if err := d.align(n); err != nil {
+			return nil, err
+		}
+		return d.next(n)
+	}
+	switch typ[0] {
+	case 'y':
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		b, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint32(b) != 0, nil
+	case 'n', 'q':
+		b, err := fixed(2)
+		if err != nil {
+			return nil, err
+		}
+		if typ[0] == 'n' {
+			return int16(d.order.Uint16(b)), nil
+		}
+		return d.order.Uint16(b), nil
+	case 'i', 'u', 'h':
+		b, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		if typ[0] == 'i' {
+			return int32(d.order.Uint32(b)), nil
+		}
+		return d.order.Uint32(b), nil
+	case 'x', 't', 'd':
+		b, err := fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		switch typ[0] {
+		case 'x':
+			return int64(d.order.Uint64(b)), nil
+		case 'd':
+			return math.Float64frombits(d.order.Uint64(b)), nil
+		}
+		return d.order.Uint64(b), nil
+	case 's', 'o':
+		b, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(d.order.Uint32(b)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(s[:len(s)-1]), nil
+	case 'g':
+		n, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(s[:len(s)-1]), nil
+	case 'v':
+		sig, err := d.value("g", depth)
+		if err != nil {
+			return nil, err
+		}
+		inner, rest, err := dbusNextType(sig.(string))
+		if err != nil || rest != "" {
+			return nil, fmt.Errorf("invalid variant signature %q", sig)
+		}
+		return d.value(inner, depth+1)
+	case 'a':
+		b, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		n := int(d.order.Uint32(b))
+		elem := typ[1:]
+		if err := d.align(dbusAlignment(elem[0])); err != nil {
+			return nil, err
+		}
+		end := d.pos + n
+		if n < 0 || end > len(d.buf) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if elem[0] == '{' {
+			dict := make(map[interface{}]interface{})
+			for d.pos < end {
+				entry, err := d.value(elem, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				kv := entry.([]interface{})
+				if len(kv) != 2 || dbusAlignment(elem[1]) == 8 || elem[1] == 'a' || elem[1] == 'v' {
+					return nil, fmt.Errorf("invalid dict entry %q", elem)
+				}
+				dict[kv[0]] = kv[1]
+			}
+			return dict, nil
+		}
+		array := []interface{}{}
+		for d.pos < end {
+			value, err := d.value(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case '(', '{':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		return d.values(typ[1:len(typ)-1], depth+1)
+	}
+	return nil, fmt.Errorf("unsupported type %q", typ)
+}
+
+// dbusNextType splits the first single complete type off a signature
+func dbusNextType(sig string) (string, string, error) {
+	if sig == "" {
+		return "", "", fmt.Errorf("missing type")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, rest, err := dbusNextType(sig[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(', '{':
+		depth := 0
+		for i := 0; i < len(sig); i++ {
+			switch sig[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					if i < 2 {
+						return "", "", fmt.Errorf("empty container in signature %q", sig)
+					}
+					return sig[:i+1], sig[i+1:], nil
+				}
+			}
+		}
+		return "", "", fmt.Errorf("unbalanced signature %q", sig)
+	}
+	return sig[:1], sig[1:], nil
+}
+
+// dbusAlignment returns the alignment of a type code
+func dbusAlignment(code byte) int {
+	switch code {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
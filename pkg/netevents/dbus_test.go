@@ -0,0 +1,562 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netevents
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testField is a header field of a test message
+type testField struct {
+	code  byte
+	sig   string
+	value interface{}
+}
+
+// testMessage marshals a little-endian message
+func testMessage(typ byte, serial uint32, fields []testField, body []byte) []byte {
+	var f dbusEncoder
+	for _, field := range fields {
+		f.align(8)
+		f.byte(field.code)
+		f.signature(field.sig)
+		switch v := field.value.(type) {
+		case uint32:
+			f.uint32(v)
+		case string:
+			if field.sig == "g" {
+				f.signature(v)
+			} else {
+				f.string(v)
+			}
+		}
+	}
+	var msg dbusEncoder
+	msg.byte('l')
+	msg.byte(typ)
+	msg.byte(0)
+	msg.byte(1)
+	msg.uint32(uint32(len(body)))
+	msg.uint32(serial)
+	msg.uint32(uint32(len(f.buf)))
+	msg.buf = append(msg.buf, f.buf...)
+	msg.align(8)
+	return append(msg.buf, body...)
+}
+
+// testArray marshals an array whose elements are aligned to align
+func testArray(e *dbusEncoder, align int, elems func()) {
+	e.uint32(0)
+	lenPos := len(e.buf) - 4
+	e.align(align)
+	start := len(e.buf)
+	elems()
+	binary.LittleEndian.PutUint32(e.buf[lenPos:], uint32(len(e.buf)-start))
+}
+
+// testPropertiesChanged marshals the body of a PropertiesChanged signal
+// with string properties
+func testPropertiesChanged(iface string, changed map[string]string, invalidated ...string) []byte {
+	var e dbusEncoder
+	e.string(iface)
+	testArray(&e, 8, func() {
+		for _, key := range sortedKeys(changed) {
+			e.align(8)
+			e.string(key)
+			e.signature("s")
+			e.string(changed[key])
+		}
+	})
+	testArray(&e, 4, func() {
+		for _, name := range invalidated {
+			e.string(name)
+		}
+	})
+	return e.buf
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func testSignal(serial uint32, path, iface, member, sig string, body []byte) []byte {
+	fields := []testField{
+		{dbusFieldPath, "o", path},
+		{dbusFieldInterface, "s", iface},
+		{dbusFieldMember, "s", member},
+	}
+	if sig != "" {
+		fields = append(fields, testField{dbusFieldSignature, "g", sig})
+	}
+	return testMessage(dbusSignal, serial, fields, body)
+}
+
+func readMessage(t *testing.T, data []byte) *dbusMessage {
+	t.Helper()
+	c := &dbusConn{reader: bufio.NewReader(bytes.NewReader(data))}
+	msg, err := c.read()
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	return msg
+}
+
+func TestReadPropertiesChanged(t *testing.T) {
+	body := testPropertiesChanged("org.freedesktop.network1.Link",
+		map[string]string{"CarrierState": "carrier", "OperationalState": "routable"}, "AddressState")
+	msg := readMessage(t, testSignal(7, "/org/freedesktop/network1/link/_32",
+		"org.freedesktop.DBus.Properties", "PropertiesChanged", "sa{sv}as", body))
+
+	if msg.typ != dbusSignal || msg.path != "/org/freedesktop/network1/link/_32" ||
+		msg.iface != "org.freedesktop.DBus.Properties" || msg.member != "PropertiesChanged" {
+		t.Fatalf("unexpected header: %+v", msg)
+	}
+	want := []interface{}{
+		"org.freedesktop.network1.Link",
+		map[interface{}]interface{}{"CarrierState": "carrier", "OperationalState": "routable"},
+		[]interface{}{"AddressState"},
+	}
+	if !reflect.DeepEqual(msg.body, want) {
+		t.Errorf("body = %#v, want %#v", msg.body, want)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	var body dbusEncoder
+	body.string(":1.42")
+	msg := readMessage(t, testMessage(dbusReply, 3, []testField{
+		{dbusFieldReplySerial, "u", uint32(1)},
+		{dbusFieldSignature, "g", "s"},
+	}, body.buf))
+	if msg.typ != dbusReply || msg.replySerial != 1 {
+		t.Fatalf("unexpected header: %+v", msg)
+	}
+	if !reflect.DeepEqual(msg.body, []interface{}{":1.42"}) {
+		t.Errorf("body = %#v", msg.body)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	valid := testSignal(1, "/", "i", "m", "s", func() []byte {
+		var e dbusEncoder
+		e.string("value")
+		return e.buf
+	}())
+
+	for name, data := range map[string][]byte{
+		"byte order": append([]byte{'x'}, valid[1:]...),
+		"truncated":  valid[:len(valid)-3],
+		"too large": func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint32(b[4:], dbusMaxMessage+1)
+			return b
+		}(),
+		"body shorter than signature": testSignal(1, "/", "i", "m", "ss", func() []byte {
+			var e dbusEncoder
+			e.string("value")
+			return e.buf
+		}()),
+	} {
+		c := &dbusConn{reader: bufio.NewReader(bytes.NewReader(data))}
+		if _, err := c.read(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDecodeBigEndian(t *testing.T) {
+	// (qus) in big endian: 0x0102, 0x03040506, "ab"
+	data := []byte{
+		0x01, 0x02, 0, 0,
+		0x03, 0x04, 0x05, 0x06,
+		0, 0, 0, 2, 'a', 'b', 0,
+	}
+	d := &dbusDecoder{buf: data, order: binary.BigEndian}
+	value, err := d.value("(qus)", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{uint16(0x0102), uint32(0x03040506), "ab"}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("value = %#v, want %#v", value, want)
+	}
+}
+
+func TestDecodeVariant(t *testing.T) {
+	var e dbusEncoder
+	e.signature("u")
+	e.uint32(100)
+	d := &dbusDecoder{buf: e.buf, order: binary.LittleEndian}
+	value, err := d.value("v", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != uint32(100) {
+		t.Errorf("value = %#v", value)
+	}
+
+	for _, sig := range []string{"uu", "", "("} {
+		var e dbusEncoder
+		e.signature(sig)
+		d := &dbusDecoder{buf: e.buf, order: binary.LittleEndian}
+		if _, err := d.value("v", 0); err == nil {
+			t.Errorf("variant with signature %q: expected an error", sig)
+		}
+	}
+}
+
+func TestDecodeDepth(t *testing.T) {
+	// Variants containing variants, deeper than dbusMaxDepth
+	var e dbusEncoder
+	for i := 0; i <= dbusMaxDepth+1; i++ {
+		e.signature("v")
+	}
+	d := &dbusDecoder{buf: e.buf, order: binary.LittleEndian}
+	if _, err := d.value("v", 0); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("expected a nesting error, got %v", err)
+	}
+}
+
+func TestNextType(t *testing.T) {
+	for _, tc := range []struct {
+		sig, typ, rest string
+		err            bool
+	}{
+		{sig: "sa{sv}as", typ: "s", rest: "a{sv}as"},
+		{sig: "a{sv}as", typ: "a{sv}", rest: "as"},
+		{sig: "a(yv)", typ: "a(yv)"},
+		{sig: "(u(ss))u", typ: "(u(ss))", rest: "u"},
+		{sig: "", err: true},
+		{sig: "a", err: true},
+		{sig: "()", err: true},
+		{sig: "(ss", err: true},
+	} {
+		typ, rest, err := dbusNextType(tc.sig)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.sig)
+			}
+			continue
+		}
+		if err != nil || typ != tc.typ || rest != tc.rest {
+			t.Errorf("%q: got %q %q %v, want %q %q", tc.sig, typ, rest, err, tc.typ, tc.rest)
+		}
+	}
+}
+
+func TestCallMarshal(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &dbusConn{conn: client, reader: bufio.NewReader(client)}
+
+	type result struct {
+		msg *dbusMessage
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		msg, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "type='signal'")
+		done <- result{msg, err}
+	}()
+
+	// Decode the call with the reader of the server side
+	s := &dbusConn{conn: server, reader: bufio.NewReader(server)}
+	call, err := s.read()
+	if err != nil {
+		t.Fatalf("failed to read call: %v", err)
+	}
+	if call.typ != dbusMethodCall || call.path != "/org/freedesktop/DBus" ||
+		call.iface != "org.freedesktop.DBus" || call.member != "AddMatch" ||
+		!reflect.DeepEqual(call.body, []interface{}{"type='signal'"}) {
+		t.Fatalf("unexpected call: %+v", call)
+	}
+
+	// A signal and a reply to another call are skipped
+	var body dbusEncoder
+	body.string("ok")
+	for _, msg := range [][]byte{
+		testSignal(1, "/", "i", "m", "", nil),
+		testMessage(dbusReply, 2, []testField{{dbusFieldReplySerial, "u", uint32(99)}}, nil),
+		testMessage(dbusReply, 3, []testField{{dbusFieldReplySerial, "u", uint32(1)}, {dbusFieldSignature, "g", "s"}}, body.buf),
+	} {
+		if _, err := server.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("call failed: %v", r.err)
+	}
+	if !reflect.DeepEqual(r.msg.body, []interface{}{"ok"}) {
+		t.Errorf("reply body = %#v", r.msg.body)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &dbusConn{conn: client, reader: bufio.NewReader(client)}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "AddMatch", "invalid")
+		done <- err
+	}()
+	s := &dbusConn{conn: server, reader: bufio.NewReader(server)}
+	if _, err := s.read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write(testMessage(dbusError, 1, []testField{
+		{dbusFieldErrorName, "s", "org.freedesktop.DBus.Error.MatchRuleInvalid"},
+		{dbusFieldReplySerial, "u", uint32(1)},
+	}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "MatchRuleInvalid") {
+		t.Errorf("expected the D-Bus error, got %v", err)
+	}
+}
+
+func TestDescribeNetworkd(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		msg    *dbusMessage
+		reason string
+		ok     bool
+	}{
+		{
+			name: "link properties",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/network1/link/_3999",
+				member: "PropertiesChanged",
+				body: []interface{}{
+					"org.freedesktop.network1.Link",
+					map[interface{}]interface{}{"OperationalState": "routable", "CarrierState": "carrier", "Other": uint32(1)},
+					[]interface{}{},
+				},
+			},
+			reason: "networkd link 999: CarrierState=carrier, OperationalState=routable, Other",
+			ok:     true,
+		},
+		{
+			name: "manager properties",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/network1",
+				member: "PropertiesChanged",
+				body:   []interface{}{"org.freedesktop.network1.Manager", map[interface{}]interface{}{"OnlineState": "online"}, []interface{}{}},
+			},
+			reason: "networkd /org/freedesktop/network1: OnlineState=online",
+			ok:     true,
+		},
+		{
+			name: "only invalidated properties",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/network1/link/_32",
+				member: "PropertiesChanged",
+				body:   []interface{}{"org.freedesktop.network1.Link", map[interface{}]interface{}{}, []interface{}{"AddressState"}},
+			},
+		},
+		{
+			name: "other member",
+			msg:  &dbusMessage{member: "StateChanged", body: []interface{}{uint32(1), uint32(2), uint32(3)}},
+		},
+		{
+			name: "invalid body",
+			msg:  &dbusMessage{member: "PropertiesChanged", body: []interface{}{"iface", "not a dict"}},
+		},
+	} {
+		reason, ok := describeNetworkd(tc.msg)
+		if reason != tc.reason || ok != tc.ok {
+			t.Errorf("%s: got %q %v, want %q %v", tc.name, reason, ok, tc.reason, tc.ok)
+		}
+	}
+}
+
+func TestDescribeNetworkManager(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		msg    *dbusMessage
+		reason string
+		ok     bool
+	}{
+		{
+			name: "device state",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/NetworkManager/Devices/2",
+				member: "StateChanged",
+				body:   []interface{}{uint32(100), uint32(70), uint32(0)},
+			},
+			reason: "NetworkManager device /org/freedesktop/NetworkManager/Devices/2: ip-config -> activated (reason 0)",
+			ok:     true,
+		},
+		{
+			name: "unknown device state",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/NetworkManager/Devices/2",
+				member: "StateChanged",
+				body:   []interface{}{uint32(5), uint32(100), uint32(1)},
+			},
+			reason: "NetworkManager device /org/freedesktop/NetworkManager/Devices/2: activated -> 5 (reason 1)",
+			ok:     true,
+		},
+		{
+			name: "DHCP lease",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/NetworkManager/DHCP4Config/1",
+				member: "PropertiesChanged",
+				body:   []interface{}{"org.freedesktop.NetworkManager.DHCP4Config", map[interface{}]interface{}{}, []interface{}{}},
+			},
+			reason: "NetworkManager DHCP4Config lease /org/freedesktop/NetworkManager/DHCP4Config/1 changed",
+			ok:     true,
+		},
+		{
+			name: "IP configuration",
+			msg: &dbusMessage{
+				path:   "/org/freedesktop/NetworkManager/IP6Config/3",
+				member: "PropertiesChanged",
+				body:   []interface{}{"org.freedesktop.NetworkManager.IP6Config", map[interface{}]interface{}{}, []interface{}{}},
+			},
+			reason: "NetworkManager IP6Config /org/freedesktop/NetworkManager/IP6Config/3 changed",
+			ok:     true,
+		},
+		{
+			name: "short state change",
+			msg:  &dbusMessage{member: "StateChanged", body: []interface{}{uint32(100)}},
+		},
+		{
+			name: "other member",
+			msg:  &dbusMessage{member: "DeviceAdded"},
+		},
+	} {
+		reason, ok := describeNetworkManager(tc.msg)
+		if reason != tc.reason || ok != tc.ok {
+			t.Errorf("%s: got %q %v, want %q %v", tc.name, reason, ok, tc.reason, tc.ok)
+		}
+	}
+}
+
+func TestUnescapeBusLabel(t *testing.T) {
+	for label, want := range map[string]string{
+		"_32":    "2",
+		"_31_30": "10",
+		"eth0":   "eth0",
+		"_3":     "_3",
+		"_zz1":   "_zz1",
+	} {
+		if got := unescapeBusLabel(label); got != want {
+			t.Errorf("unescapeBusLabel(%q) = %q, want %q", label, got, want)
+		}
+	}
+}
+
+// TestWatchDBus runs watchDBus against a fake system bus, which checks the
+// authentication and subscriptions and then sends signals
+func TestWatchDBus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bus")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+path+",guid=0")
+
+	matches := make(chan string, len(networkdMatches))
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+
+		line, err := s.reader.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "\x00AUTH EXTERNAL ") {
+			t.Errorf("unexpected authentication %q: %v", line, err)
+			return
+		}
+		conn.Write([]byte("OK 0123456789abcdef\r\n"))
+		if line, err := s.reader.ReadString('\n'); err != nil || line != "BEGIN\r\n" {
+			t.Errorf("unexpected authentication %q: %v", line, err)
+			return
+		}
+
+		var serial uint32
+		for i := 0; i < 1+len(networkdMatches); i++ {
+			call, err := s.read()
+			if err != nil {
+				t.Errorf("failed to read call: %v", err)
+				return
+			}
+			serial++
+			if call.member == "AddMatch" {
+				matches <- call.body[0].(string)
+			}
+			conn.Write(testMessage(dbusReply, serial, []testField{{dbusFieldReplySerial, "u", serial}}, nil))
+		}
+
+		// Only the signal accepted by describeNetworkd is notified
+		conn.Write(testSignal(10, "/org/freedesktop/network1", "org.freedesktop.network1.Manager", "Reload", "", nil))
+		conn.Write(testMessage(dbusReply, 11, []testField{{dbusFieldReplySerial, "u", uint32(42)}}, nil))
+		conn.Write(testSignal(12, "/org/freedesktop/network1/link/_32", "org.freedesktop.DBus.Properties", "PropertiesChanged", "sa{sv}as",
+			testPropertiesChanged("org.freedesktop.network1.Link", map[string]string{"CarrierState": "no-carrier"})))
+		time.Sleep(time.Second)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reasons := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- watchDBus(ctx, networkdMatches, describeNetworkd, func(reason string) { reasons <- reason })
+	}()
+
+	select {
+	case reason := <-reasons:
+		if !strings.HasPrefix(reason, "networkd link ") || !strings.HasSuffix(reason, ": CarrierState=no-carrier") {
+			t.Errorf("unexpected reason %q", reason)
+		}
+	case err := <-errs:
+		t.Fatalf("watch failed: %v", err)
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the signal")
+	}
+	if match := <-matches; match != networkdMatches[0] {
+		t.Errorf("subscribed to %q, want %q", match, networkdMatches[0])
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("watch returned %v after cancellation", err)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package netevents watches the host for network changes, so addresses are
+// detected again right away instead of on the next periodic reconciliation
+package netevents
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// SourceNetlink watches address and link changes via netlink
+	SourceNetlink = "netlink"
+	// SourceNetworkd watches the link states of systemd-networkd via D-Bus
+	SourceNetworkd = "networkd"
+	// SourceNetworkManager watches the device states and DHCP leases of
+	// NetworkManager via D-Bus
+	SourceNetworkManager = "networkmanager"
+)
+
+// ValidSource checks if source is a known event source
+func ValidSource(source string) bool {
+	switch source {
+	case SourceNetlink, SourceNetworkd, SourceNetworkManager:
+		return true
+	}
+	return false
+}
+
+// Watch calls notify with a description of each network change reported by
+// source until ctx is done, or returns an error if the source fails
+func Watch(ctx context.Context, source string, notify func(reason string)) error {
+	switch source {
+	case SourceNetlink:
+		return watchNetlink(ctx, notify)
+	case SourceNetworkd:
+		return watchDBus(ctx, networkdMatches, describeNetworkd, notify)
+	case SourceNetworkManager:
+		return watchDBus(ctx, networkManagerMatches, describeNetworkManager, notify)
+	}
+	return fmt.Errorf("unknown network event source %q", source)
+}
@@ -0,0 +1,103 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netevents
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// watchNetlink notifies about added or removed global addresses, and about
+// operational state changes of links with global addresses. Links without
+// addresses, e.g. the veths of pods, are ignored.
+func watchNetlink(ctx context.Context, notify func(string)) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	addrs := make(chan netlink.AddrUpdate)
+	if err := netlink.AddrSubscribe(addrs, done); err != nil {
+		return fmt.Errorf("failed to subscribe to address changes: %w", err)
+	}
+	links := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(links, done); err != nil {
+		return fmt.Errorf("failed to subscribe to link changes: %w", err)
+	}
+	klog.Infof("Watching network events via netlink")
+
+	states := make(map[int]netlink.LinkOperState)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update, ok := <-addrs:
+			if !ok {
+				return fmt.Errorf("address subscription closed")
+			}
+			if !update.LinkAddress.IP.IsGlobalUnicast() {
+				continue
+			}
+			action := "removed from"
+			if update.NewAddr {
+				action = "added to"
+			}
+			notify(fmt.Sprintf("address %s %s %s", update.LinkAddress.String(), action, linkName(fmt.Sprint(update.LinkIndex))))
+		case update, ok := <-links:
+			if !ok {
+				return fmt.Errorf("link subscription closed")
+			}
+			attrs := update.Attrs()
+			if update.Header.Type == unix.RTM_DELLINK {
+				delete(states, attrs.Index)
+				continue
+			}
+			state, known := states[attrs.Index]
+			states[attrs.Index] = attrs.OperState
+			if known && state == attrs.OperState {
+				continue
+			}
+			if !hasGlobalAddress(update.Link) {
+				continue
+			}
+			notify(fmt.Sprintf("link %s is %s", attrs.Name, attrs.OperState))
+		}
+	}
+}
+
+// hasGlobalAddress checks if the link has a global unicast address
+func hasGlobalAddress(link netlink.Link) bool {
+	start := time.Now()
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	metrics.ObserveNetlink(metrics.NetlinkAddrList, start, err)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsGlobalUnicast() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netevents
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// networkdMatches subscribe to the property changes of systemd-networkd,
+// e.g. the carrier, operational and address states of its links
+var networkdMatches = []string{
+	"type='signal',sender='org.freedesktop.network1',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged'",
+}
+
+// networkdLinkPrefix prefixes the object paths of links, followed by the
+// escaped interface index
+const networkdLinkPrefix = "/org/freedesktop/network1/link/"
+
+// describeNetworkd describes the changed properties of a link, e.g.
+// "networkd link eth0: AddressState=routable, CarrierState=carrier"
+func describeNetworkd(msg *dbusMessage) (string, bool) {
+	if msg.member != "PropertiesChanged" || len(msg.body) < 2 {
+		return "", false
+	}
+	changed, ok := msg.body[1].(map[interface{}]interface{})
+	if !ok {
+		return "", false
+	}
+
+	object := msg.path
+	if index, ok := strings.CutPrefix(msg.path, networkdLinkPrefix); ok {
+		object = "link " + linkName(unescapeBusLabel(index))
+	}
+	var props []string
+	for key, value := range changed {
+		name, _ := key.(string)
+		if s, ok := value.(string); ok {
+			props = append(props, fmt.Sprintf("%s=%s", name, s))
+		} else {
+			props = append(props, name)
+		}
+	}
+	if len(props) == 0 {
+		return "", false
+	}
+	sort.Strings(props)
+	return fmt.Sprintf("networkd %s: %s", object, strings.Join(props, ", ")), true
+}
+
+// unescapeBusLabel decodes the "_XX" hex escapes of object path labels
+func unescapeBusLabel(label string) string {
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] == '_' && i+2 < len(label) {
+			if c, err := strconv.ParseUint(label[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(label[i])
+	}
+	return b.String()
+}
+
+// linkName returns the name of the interface index, or the index if unknown
+func linkName(index string) string {
+	if i, err := strconv.Atoi(index); err == nil {
+		if iface, err := net.InterfaceByIndex(i); err == nil {
+			return iface.Name
+		}
+	}
+	return index
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netevents
+
+import (
+	"fmt"
+	"strings"
+)
+
+const networkManagerMatch = "type='signal',sender='org.freedesktop.NetworkManager',"
+
+// networkManagerMatches subscribe to the device state changes and to the
+// DHCP leases and IP configurations of NetworkManager
+var networkManagerMatches = []string{
+	networkManagerMatch + "interface='org.freedesktop.NetworkManager.Device',member='StateChanged'",
+	networkManagerMatch + "interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',arg0='org.freedesktop.NetworkManager.DHCP4Config'",
+	networkManagerMatch + "interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',arg0='org.freedesktop.NetworkManager.DHCP6Config'",
+	networkManagerMatch + "interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',arg0='org.freedesktop.NetworkManager.IP4Config'",
+	networkManagerMatch + "interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',arg0='org.freedesktop.NetworkManager.IP6Config'",
+}
+
+// networkManagerDeviceStates names the NMDeviceState values
+var networkManagerDeviceStates = map[uint32]string{
+	0:   "unknown",
+	10:  "unmanaged",
+	20:  "unavailable",
+	30:  "disconnected",
+	40:  "prepare",
+	50:  "config",
+	60:  "need-auth",
+	70:  "ip-config",
+	80:  "ip-check",
+	90:  "secondaries",
+	100: "activated",
+	110: "deactivating",
+	120: "failed",
+}
+
+// describeNetworkManager describes device state changes, e.g.
+// "NetworkManager device /org/freedesktop/NetworkManager/Devices/2:
+// ip-config -> activated", and DHCP lease or IP configuration changes
+func describeNetworkManager(msg *dbusMessage) (string, bool) {
+	switch msg.member {
+	case "StateChanged":
+		if len(msg.body) < 3 {
+			return "", false
+		}
+		newState, _ := msg.body[0].(uint32)
+		oldState, _ := msg.body[1].(uint32)
+		reason, _ := msg.body[2].(uint32)
+		return fmt.Sprintf("NetworkManager device %s: %s -> %s (reason %d)",
+			msg.path, deviceState(oldState), deviceState(newState), reason), true
+	case "PropertiesChanged":
+		if len(msg.body) < 1 {
+			return "", false
+		}
+		iface, _ := msg.body[0].(string)
+		kind := strings.TrimPrefix(iface, "org.freedesktop.NetworkManager.")
+		if strings.HasPrefix(kind, "DHCP") {
+			return fmt.Sprintf("NetworkManager %s lease %s changed", kind, msg.path), true
+		}
+		return fmt.Sprintf("NetworkManager %s %s changed", kind, msg.path), true
+	}
+	return "", false
+}
+
+func deviceState(state uint32) string {
+	if name, ok := networkManagerDeviceStates[state]; ok {
+		return name
+	}
+	return fmt.Sprint(state)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkstatus
+
+import (
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+)
+
+// conditions classifies the failed detections and the errors of a
+// reconciliation into conditions, without their transition times
+func conditions(detections []v1alpha1.DetectionStatus, errs []error) []metav1.Condition {
+	var failed, conflicts, unauthorized []string
+	unauthorizedReason := "Unauthorized"
+	for _, detection := range detections {
+		if detection.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", detection.Type, detection.Error))
+		}
+	}
+	for _, err := range errs {
+		switch {
+		case apierrors.IsConflict(err):
+			conflicts = append(conflicts, err.Error())
+		case apierrors.IsUnauthorized(err):
+			unauthorized = append(unauthorized, err.Error())
+		case apierrors.IsForbidden(err):
+			unauthorized = append(unauthorized, err.Error())
+			unauthorizedReason = "Forbidden"
+		}
+	}
+	return []metav1.Condition{
+		condition(v1alpha1.ConditionDetectionFailed, failed, "DetectionFailed", "Detected"),
+		condition(v1alpha1.ConditionPatchConflict, conflicts, "Conflict", "NoConflict"),
+		condition(v1alpha1.ConditionUnauthorized, unauthorized, unauthorizedReason, "Authorized"),
+	}
+}
+
+// condition returns a condition that is true with the messages if there are
+// any
+func condition(conditionType string, messages []string, trueReason, falseReason string) metav1.Condition {
+	if len(messages) == 0 {
+		return metav1.Condition{Type: conditionType, Status: metav1.ConditionFalse, Reason: falseReason}
+	}
+	return metav1.Condition{
+		Type:    conditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  trueReason,
+		Message: strings.Join(messages, "; "),
+	}
+}
+
+// transitionConditions sets the transition times of the conditions to now,
+// keeping the previous ones of the conditions whose status is unchanged
+func transitionConditions(previous, conditions []metav1.Condition, now metav1.Time) []metav1.Condition {
+	result := make([]metav1.Condition, 0, len(conditions))
+	for _, condition := range conditions {
+		condition.LastTransitionTime = now
+		if old := meta.FindStatusCondition(previous, condition.Type); old != nil && old.Status == condition.Status {
+			condition.LastTransitionTime = old.LastTransitionTime
+		}
+		result = append(result, condition)
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package networkstatus publishes the network state of a node as seen by its
+// agent in a NodeNetworkStatus resource, for diagnostics tooling.
+package networkstatus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// Publisher maintains the NodeNetworkStatus of a node
+type Publisher struct {
+	client dynamic.Interface
+
+	// published is the last published status, without its update time
+	published *v1alpha1.NodeNetworkStatusStatus
+}
+
+// NewPublisher creates a publisher
+func NewPublisher(client dynamic.Interface) *Publisher {
+	return &Publisher{client: client}
+}
+
+// Collect builds the status from a detection report and the errors of a
+// reconciliation, adding the interfaces and default routes of the host
+func Collect(report *detector.Report, errs []error) v1alpha1.NodeNetworkStatusStatus {
+	status := v1alpha1.NodeNetworkStatusStatus{BehindNAT: report.BehindNAT}
+
+	for _, d := range []struct {
+		addressType string
+		detection   *detector.Detection
+	}{
+		{"InternalIP", report.Internal},
+		{"ExternalIP", report.External},
+		{"EgressIP", report.Egress},
+	} {
+		if d.detection == nil {
+			continue
+		}
+		detection := v1alpha1.DetectionStatus{
+			Type:      d.addressType,
+			Strategy:  d.detection.Strategy,
+			Target:    d.detection.Target,
+			Interface: d.detection.Interface,
+			Gateway:   d.detection.Gateway,
+			Address:   d.detection.Address,
+			Error:     d.detection.Error,
+			Degraded:  d.detection.Degraded,
+		}
+		for _, candidate := range d.detection.Filtered {
+			detection.Candidates = append(detection.Candidates, v1alpha1.CandidateStatus{
+				Address: candidate.Address,
+				Reason:  candidate.Reason,
+			})
+		}
+		status.Detections = append(status.Detections, detection)
+	}
+
+	interfaces, err := listInterfaces()
+	if err != nil {
+		klog.V(2).Infof("Failed to list interfaces: %v", err)
+	}
+	status.Interfaces = interfaces
+
+	routes, err := listDefaultRoutes()
+	if err != nil {
+		klog.V(2).Infof("Failed to list routes: %v", err)
+	}
+	status.Routes = routes
+
+	for _, err := range errs {
+		status.Errors = append(status.Errors, err.Error())
+	}
+	status.Conditions = conditions(status.Detections, errs)
+	return status
+}
+
+// listInterfaces returns the network interfaces of the host with their addresses
+func listInterfaces() ([]v1alpha1.InterfaceStatus, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]v1alpha1.InterfaceStatus, 0, len(ifaces))
+	for _, iface := range ifaces {
+		status := v1alpha1.InterfaceStatus{
+			Name:         iface.Name,
+			Up:           iface.Flags&net.FlagUp != 0,
+			MTU:          int32(iface.MTU),
+			HardwareAddr: iface.HardwareAddr.String(),
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			klog.V(4).Infof("Failed to list addresses of %s: %v", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			status.Addresses = append(status.Addresses, addr.String())
+		}
+		interfaces = append(interfaces, status)
+	}
+	return interfaces, nil
+}
+
+// Publish writes the status to the NodeNetworkStatus of the node if it
+// changed, creating it owned by the node if missing
+func (p *Publisher) Publish(ctx context.Context, node *v1.Node, status v1alpha1.NodeNetworkStatusStatus) error {
+	if p.published != nil && reflect.DeepEqual(*p.published, status) {
+		klog.V(3).Info("Network status unchanged, skipping update")
+		return nil
+	}
+	published := status
+	status.LastUpdateTime = metav1.Now()
+
+	client := p.client.Resource(v1alpha1.NodeNetworkStatusResource)
+	u, err := client.Get(ctx, node.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		u = &unstructured.Unstructured{}
+		u.SetAPIVersion(v1alpha1.GroupName + "/" + v1alpha1.Version)
+		u.SetKind("NodeNetworkStatus")
+		u.SetName(node.Name)
+		// Delete the status along with its node
+		u.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       node.Name,
+			UID:        node.UID,
+		}})
+		u, err = client.Create(ctx, u, metav1.CreateOptions{FieldManager: version.FieldManager()})
+		if err != nil {
+			return fmt.Errorf("failed to create NodeNetworkStatus %s: %w", node.Name, err)
+		}
+		klog.Infof("Successfully created NodeNetworkStatus %s", node.Name)
+	} else if err != nil {
+		return fmt.Errorf("failed to get NodeNetworkStatus %s: %w", node.Name, err)
+	}
+	// Keep the transition times of the conditions whose status is unchanged
+	current := &v1alpha1.NodeNetworkStatus{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, current); err != nil {
+		klog.V(2).Infof("Failed to convert NodeNetworkStatus %s, resetting its conditions: %v", node.Name, err)
+	}
+	status.Conditions = transitionConditions(current.Status.Conditions, status.Conditions, status.LastUpdateTime)
+	value, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return fmt.Errorf("failed to convert network status: %w", err)
+	}
+
+	if err := unstructured.SetNestedField(u.Object, value, "status"); err != nil {
+		return err
+	}
+	if _, err := client.UpdateStatus(ctx, u, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update status of NodeNetworkStatus %s: %w", node.Name, err)
+	}
+	p.published = &published
+	klog.V(2).Infof("Successfully updated NodeNetworkStatus %s", node.Name)
+	return nil
+}
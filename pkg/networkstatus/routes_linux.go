@@ -0,0 +1,60 @@
+//go:build linux && !purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkstatus
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+)
+
+// listDefaultRoutes returns the default routes of the main routing table
+func listDefaultRoutes() ([]v1alpha1.RouteStatus, error) {
+	nlRoutes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	var routes []v1alpha1.RouteStatus
+	for _, nlRoute := range nlRoutes {
+		// Only default routes, the full table may be large on routers
+		if nlRoute.Dst != nil {
+			if ones, _ := nlRoute.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+		route := v1alpha1.RouteStatus{Destination: "default"}
+		if nlRoute.Gw != nil {
+			route.Gateway = nlRoute.Gw.String()
+		}
+		if nlRoute.Src != nil {
+			route.Source = nlRoute.Src.String()
+		}
+		if link, err := netlink.LinkByIndex(nlRoute.LinkIndex); err == nil {
+			route.Interface = link.Attrs().Name
+		} else {
+			klog.V(4).Infof("Failed to get link %d: %v", nlRoute.LinkIndex, err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
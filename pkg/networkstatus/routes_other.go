@@ -0,0 +1,26 @@
+//go:build !linux || purego
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkstatus
+
+import "github.com/cozystack/local-ccm/pkg/apis/v1alpha1"
+
+// listDefaultRoutes returns no routes, listing them requires netlink
+func listDefaultRoutes() ([]v1alpha1.RouteStatus, error) {
+	return nil, nil
+}
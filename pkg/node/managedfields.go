@@ -0,0 +1,166 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// applyManagerSuffixes are the suffixes patchAs appends to the field manager
+// of server-side applies
+var applyManagerSuffixes = []string{"status", "annotations"}
+
+// AdoptManagedFields renames the managed fields entries of the node written
+// by local-ccm with another field manager, such as the "local-ccm" manager
+// of older versions, to the field manager of the Updater. Entries that then
+// share a manager, operation and subresource are merged.
+func (u *Updater) AdoptManagedFields(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		currentNode, err := u.GetNode(ctx)
+		if err != nil {
+			return err
+		}
+		entries, adopted, err := adoptManagedFields(currentNode.ManagedFields, u.fieldManager)
+		if err != nil || len(adopted) == 0 {
+			return err
+		}
+		// The resource version makes the patch fail with a conflict if the
+		// managed fields changed since they were read. Apply configurations
+		// hold no managed fields, so the patch is built from a map.
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": currentNode.ResourceVersion,
+				"managedFields":   entries,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal managed fields patch: %w", err)
+		}
+		opts := metav1.PatchOptions{FieldManager: u.fieldManager}
+		if u.dryRun {
+			opts.DryRun = []string{metav1.DryRunAll}
+		}
+		if _, err := u.client.CoreV1().Nodes().Patch(ctx, u.nodeName, types.MergePatchType, patch, opts); err != nil {
+			return err
+		}
+		klog.Infof("Adopted managed fields of node %s from field managers %s", u.nodeName, strings.Join(adopted, ", "))
+		return nil
+	})
+}
+
+// adoptManagedFields renames the entries of other local-ccm field managers
+// to manager, returning the merged entries and the adopted managers
+func adoptManagedFields(entries []metav1.ManagedFieldsEntry, manager string) ([]metav1.ManagedFieldsEntry, []string, error) {
+	var adopted []string
+	result := make([]metav1.ManagedFieldsEntry, 0, len(entries))
+	for _, entry := range entries {
+		target, ok := adoptedManager(entry.Manager, manager)
+		if ok {
+			adopted = append(adopted, entry.Manager)
+			entry.Manager = target
+		}
+		merged := false
+		for i := range result {
+			if result[i].Manager != entry.Manager || result[i].Operation != entry.Operation ||
+				result[i].Subresource != entry.Subresource || result[i].APIVersion != entry.APIVersion {
+				continue
+			}
+			if err := mergeManagedFieldsEntry(&result[i], entry); err != nil {
+				return nil, nil, fmt.Errorf("failed to merge managed fields of %s: %w", entry.Manager, err)
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			result = append(result, entry)
+		}
+	}
+	return result, adopted, nil
+}
+
+// adoptedManager returns the field manager replacing a field manager of
+// local-ccm other than manager, keeping the suffix of server-side applies
+func adoptedManager(entryManager, manager string) (string, bool) {
+	if entryManager == manager || strings.HasPrefix(entryManager, manager+"-") {
+		return "", false
+	}
+	if entryManager != version.Name && !strings.HasPrefix(entryManager, version.Name+"-") &&
+		!strings.HasPrefix(entryManager, version.Name+"/") {
+		return "", false
+	}
+	for _, suffix := range applyManagerSuffixes {
+		if strings.HasSuffix(entryManager, "-"+suffix) {
+			return manager + "-" + suffix, true
+		}
+	}
+	return manager, true
+}
+
+// mergeManagedFieldsEntry merges the fields of entry into into, keeping the
+// later time
+func mergeManagedFieldsEntry(into *metav1.ManagedFieldsEntry, entry metav1.ManagedFieldsEntry) error {
+	if entry.Time != nil && (into.Time == nil || into.Time.Before(entry.Time)) {
+		into.Time = entry.Time
+	}
+	if entry.FieldsV1 == nil {
+		return nil
+	}
+	if into.FieldsV1 == nil {
+		into.FieldsV1 = entry.FieldsV1
+		return nil
+	}
+	var fields, other map[string]interface{}
+	if err := json.Unmarshal(into.FieldsV1.Raw, &fields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(entry.FieldsV1.Raw, &other); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(mergeFields(fields, other))
+	if err != nil {
+		return err
+	}
+	into.FieldsV1 = &metav1.FieldsV1{Raw: raw}
+	return nil
+}
+
+// mergeFields merges two field sets of the FieldsV1 format
+func mergeFields(fields, other map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	for key, value := range other {
+		child, ok := value.(map[string]interface{})
+		existing, isMap := fields[key].(map[string]interface{})
+		if ok && isMap {
+			fields[key] = mergeFields(existing, child)
+		} else if _, found := fields[key]; !found {
+			fields[key] = value
+		}
+	}
+	return fields
+}
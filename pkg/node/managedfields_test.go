@@ -0,0 +1,328 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testManager = "local-ccm/v2.0.0"
+
+// testEntry returns a managed fields entry of a manager with the given
+// FieldsV1 and time in seconds
+func testEntry(manager string, operation metav1.ManagedFieldsOperationType, subresource, fields string, seconds int64) metav1.ManagedFieldsEntry {
+	entry := metav1.ManagedFieldsEntry{
+		Manager:     manager,
+		Operation:   operation,
+		APIVersion:  "v1",
+		Subresource: subresource,
+		FieldsType:  "FieldsV1",
+	}
+	if fields != "" {
+		entry.FieldsV1 = &metav1.FieldsV1{Raw: []byte(fields)}
+	}
+	if seconds != 0 {
+		entry.Time = &metav1.Time{Time: time.Unix(seconds, 0).UTC()}
+	}
+	return entry
+}
+
+// fieldsEqual compares two FieldsV1 documents
+func fieldsEqual(t *testing.T, got *metav1.FieldsV1, want string) bool {
+	t.Helper()
+	if got == nil {
+		return want == ""
+	}
+	var gotFields, wantFields interface{}
+	if err := json.Unmarshal(got.Raw, &gotFields); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantFields); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(gotFields, wantFields)
+}
+
+func TestAdoptManagedFields(t *testing.T) {
+	const (
+		labels     = `{"f:metadata":{"f:labels":{"f:example.com/a":{}}}}`
+		otherLabel = `{"f:metadata":{"f:labels":{"f:example.com/b":{}}}}`
+		bothLabels = `{"f:metadata":{"f:labels":{"f:example.com/a":{},"f:example.com/b":{}}}}`
+		addresses  = `{"f:status":{"f:addresses":{}}}`
+		taints     = `{"f:spec":{"f:taints":{}}}`
+	)
+	update, apply := metav1.ManagedFieldsOperationUpdate, metav1.ManagedFieldsOperationApply
+
+	type result struct {
+		manager     string
+		operation   metav1.ManagedFieldsOperationType
+		subresource string
+		fields      string
+		seconds     int64
+	}
+	tests := []struct {
+		name    string
+		entries []metav1.ManagedFieldsEntry
+		want    []result
+		adopted []string
+	}{
+		{
+			name: "legacy manager renamed",
+			entries: []metav1.ManagedFieldsEntry{
+				testEntry("local-ccm", update, "", labels, 10),
+				testEntry("local-ccm", update, "status", addresses, 20),
+			},
+			want: []result{
+				{testManager, update, "", labels, 10},
+				{testManager, update, "status", addresses, 20},
+			},
+			adopted: []string{"local-ccm", "local-ccm"},
+		},
+		{
+			name: "earlier version renamed with the suffix of its applies",
+			entries: []metav1.ManagedFieldsEntry{
+				testEntry("local-ccm/v1.9.0-status", apply, "status", addresses, 10),
+				testEntry("local-ccm/v1.9.0-annotations", apply, "", labels, 10),
+				testEntry("local-ccm/v1.9.0", apply, "", taints, 10),
+			},
+			want: []result{
+				{testManager + "-status", apply, "status", addresses, 10},
+				{testManager + "-annotations", apply, "", labels, 10},
+				{testManager, apply, "", taints, 10},
+			},
+			adopted: []string{"local-ccm/v1.9.0-status", "local-ccm/v1.9.0-annotations", "local-ccm/v1.9.0"},
+		},
+		{
+			name: "merged into the existing entry of the manager",
+			entries: []metav1.ManagedFieldsEntry{
+				testEntry(testManager, update, "", labels, 30),
+				testEntry("local-ccm", update, "", otherLabel, 40),
+			},
+			want: []result{
+				{testManager, update, "", bothLabels, 40},
+			},
+			adopted: []string{"local-ccm"},
+		},
+		{
+			name: "merged keeping the later time",
+			entries: []metav1.ManagedFieldsEntry{
+				testEntry("local-ccm", update, "", otherLabel, 40),
+				testEntry(testManager, update, "", labels, 30),
+			},
+			want: []result{
+				{testManager, update, "", bothLabels, 40},
+			},
+			adopted: []string{"local-ccm"},
+		},
+		{
+			name: "entries of other operations and subresources kept apart",
+			entries: []metav1.ManagedFieldsEntry{
+				testEntry(testManager, update, "", labels, 10),
+				testEntry("local-ccm", apply, "", otherLabel, 10),
+				testEntry("local-ccm", update, "status", addresses, 10),
+			},
+			want: []result{
+				{testManager, update, "", labels, 10},
+				{testManager, apply, "", otherLabel, 10},
+				{testManager, update, "status", addresses, 10},
+			},
+			adopted: []string{"local-ccm", "local-ccm"},
+		},
+		{
+			name: "other managers untouched",
+			entries: []metav1.ManagedFieldsEntry{
+				testEntry("kubelet", update, "status", addresses, 10),
+				testEntry("cilium-agent", update, "", labels, 10),
+				testEntry(testManager, update, "", taints, 10),
+				testEntry(testManager+"-status", apply, "status", addresses, 10),
+			},
+			want: []result{
+				{"kubelet", update, "status", addresses, 10},
+				{"cilium-agent", update, "", labels, 10},
+				{testManager, update, "", taints, 10},
+				{testManager + "-status", apply, "status", addresses, 10},
+			},
+		},
+	}
+	for _, tc := range tests {
+		entries, adopted, err := adoptManagedFields(tc.entries, testManager)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if fmt.Sprint(adopted) != fmt.Sprint(tc.adopted) {
+			t.Errorf("%s: adopted %v, want %v", tc.name, adopted, tc.adopted)
+		}
+		if len(entries) != len(tc.want) {
+			t.Errorf("%s: got %d entries, want %d: %+v", tc.name, len(entries), len(tc.want), entries)
+			continue
+		}
+		for i, want := range tc.want {
+			got := entries[i]
+			if got.Manager != want.manager || got.Operation != want.operation || got.Subresource != want.subresource ||
+				got.Time.Unix() != want.seconds || !fieldsEqual(t, got.FieldsV1, want.fields) {
+				t.Errorf("%s: entry %d is %s %s %q %s at %v, want %+v", tc.name, i,
+					got.Manager, got.Operation, got.Subresource, got.FieldsV1.Raw, got.Time, want)
+			}
+		}
+	}
+}
+
+func TestAdoptManagedFieldsInvalid(t *testing.T) {
+	entries := []metav1.ManagedFieldsEntry{
+		testEntry(testManager, metav1.ManagedFieldsOperationUpdate, "", `{"f:metadata":{}}`, 10),
+		testEntry("local-ccm", metav1.ManagedFieldsOperationUpdate, "", `not json`, 10),
+	}
+	if _, _, err := adoptManagedFields(entries, testManager); err == nil {
+		t.Error("expected an error merging invalid fields")
+	}
+}
+
+func TestMergeFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields string
+		other  string
+		want   string
+	}{
+		{
+			name:   "disjoint",
+			fields: `{"f:spec":{"f:taints":{}}}`,
+			other:  `{"f:status":{"f:addresses":{}}}`,
+			want:   `{"f:spec":{"f:taints":{}},"f:status":{"f:addresses":{}}}`,
+		},
+		{
+			name:   "nested",
+			fields: `{"f:metadata":{"f:labels":{".":{},"f:a":{}}}}`,
+			other:  `{"f:metadata":{"f:labels":{".":{},"f:b":{}},"f:annotations":{"f:c":{}}}}`,
+			want:   `{"f:metadata":{"f:labels":{".":{},"f:a":{},"f:b":{}},"f:annotations":{"f:c":{}}}}`,
+		},
+		{
+			name:   "into empty",
+			fields: `null`,
+			other:  `{"f:spec":{"f:taints":{}}}`,
+			want:   `{"f:spec":{"f:taints":{}}}`,
+		},
+	}
+	for _, tc := range tests {
+		var fields, other map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.fields), &fields); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tc.other), &other); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := json.Marshal(mergeFields(fields, other))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fieldsEqual(t, &metav1.FieldsV1{Raw: raw}, tc.want) {
+			t.Errorf("%s: merged %s, want %s", tc.name, raw, tc.want)
+		}
+	}
+}
+
+func TestAdoptManagedFieldsConflict(t *testing.T) {
+	const (
+		labels     = `{"f:metadata":{"f:labels":{"f:example.com/a":{}}}}`
+		otherLabel = `{"f:metadata":{"f:labels":{"f:example.com/b":{}}}}`
+	)
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "node-1",
+			ResourceVersion: "1",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				testEntry("local-ccm", metav1.ManagedFieldsOperationUpdate, "", labels, 10),
+			},
+		},
+	})
+
+	// The first patch conflicts with a write of another legacy entry, which
+	// the retry adopts as well
+	var patches []map[string]interface{}
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		var patch map[string]interface{}
+		if err := json.Unmarshal(action.(k8stesting.PatchAction).GetPatch(), &patch); err != nil {
+			t.Fatal(err)
+		}
+		patches = append(patches, patch)
+		if len(patches) > 1 {
+			return false, nil, nil
+		}
+
+		obj, err := client.Tracker().Get(schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, "", "node-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := obj.(*v1.Node).DeepCopy()
+		n.ResourceVersion = "2"
+		n.ManagedFields = append(n.ManagedFields,
+			testEntry("local-ccm", metav1.ManagedFieldsOperationUpdate, "status", otherLabel, 20))
+		if err := client.Tracker().Update(schema.GroupVersionResource{Version: "v1", Resource: "nodes"}, n, ""); err != nil {
+			t.Fatal(err)
+		}
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "node-1", fmt.Errorf("the object has been modified"))
+	})
+
+	u := NewUpdater(client, "node-1", WithFieldManager(testManager))
+	if err := u.AdoptManagedFields(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patches) != 2 {
+		t.Fatalf("sent %d patches, want 2", len(patches))
+	}
+	for i, want := range []string{"1", "2"} {
+		if got := patches[i]["metadata"].(map[string]interface{})["resourceVersion"]; got != want {
+			t.Errorf("patch %d has resourceVersion %v, want %s", i, got, want)
+		}
+	}
+
+	n, err := client.CoreV1().Nodes().Get(context.Background(), "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.ManagedFields) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(n.ManagedFields), n.ManagedFields)
+	}
+	for _, entry := range n.ManagedFields {
+		if entry.Manager != testManager {
+			t.Errorf("entry of %s %q was not adopted", entry.Manager, entry.Subresource)
+		}
+	}
+
+	// Nothing is patched once all entries are adopted
+	if err := u.AdoptManagedFields(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 2 {
+		t.Errorf("sent %d patches after adopting all entries, want 2", len(patches))
+	}
+}
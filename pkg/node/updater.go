@@ -22,10 +22,16 @@ import (
 	"fmt"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/version"
 )
 
 const (
@@ -33,49 +39,206 @@ const (
 	TaintKey = "node.cloudprovider.kubernetes.io/uninitialized"
 )
 
+// Interface reads and updates the managed node
+type Interface interface {
+	// GetNode retrieves the current node object
+	GetNode(ctx context.Context) (*v1.Node, error)
+	// UpdateAddresses replaces the node's status addresses
+	UpdateAddresses(ctx context.Context, addresses []v1.NodeAddress) error
+	// UpdateLabels sets the given labels, leaving other labels intact
+	UpdateLabels(ctx context.Context, labels map[string]string) error
+	// RemoveLabels removes the given labels, leaving other labels intact
+	RemoveLabels(ctx context.Context, keys ...string) error
+	// UpdateAnnotations sets the given annotations, leaving other annotations intact
+	UpdateAnnotations(ctx context.Context, annotations map[string]string) error
+	// RemoveAnnotations removes the given annotations, leaving other annotations intact
+	RemoveAnnotations(ctx context.Context, keys ...string) error
+	// RemoveTaint removes the cloud provider taint
+	RemoveTaint(ctx context.Context) error
+	// AddTaint adds a taint, replacing a taint with the same key and effect
+	AddTaint(ctx context.Context, taint v1.Taint) error
+	// RemoveTaintKey removes the taints with the given key
+	RemoveTaintKey(ctx context.Context, key string) error
+}
+
+var _ Interface = &Updater{}
+
 // Updater handles updating node addresses and removing taints
 type Updater struct {
 	client   kubernetes.Interface
 	nodeName string
+
+	fieldManager string
+	patchType    types.PatchType
+	dryRun       bool
+	backoff      wait.Backoff
+}
+
+// Option configures an Updater
+type Option func(*Updater)
+
+// WithFieldManager sets the field manager recorded for the patches, by
+// default version.FieldManager()
+func WithFieldManager(fieldManager string) Option {
+	return func(u *Updater) {
+		u.fieldManager = fieldManager
+	}
+}
+
+// WithPatchType sets how the node is patched: types.MergePatchType (the
+// default) sends the apply configurations as JSON merge patches, replacing
+// addresses and taints and merging labels; types.JSONPatchType is treated
+// alike. types.ApplyPatchType updates addresses, labels and annotations with
+// server-side apply, owning only the applied entries, while taints are merge
+// patched as the taint is owned by kubelet.
+func WithPatchType(patchType types.PatchType) Option {
+	return func(u *Updater) {
+		u.patchType = patchType
+	}
+}
+
+// WithDryRun makes the API server validate patches without persisting them
+func WithDryRun(dryRun bool) Option {
+	return func(u *Updater) {
+		u.dryRun = dryRun
+	}
+}
+
+// WithRetry retries patches failing with conflicts, timeouts or throttling
+// according to backoff. By default patches are not retried.
+func WithRetry(backoff wait.Backoff) Option {
+	return func(u *Updater) {
+		u.backoff = backoff
+	}
 }
 
 // NewUpdater creates a new node updater
-func NewUpdater(client kubernetes.Interface, nodeName string) *Updater {
-	return &Updater{
-		client:   client,
-		nodeName: nodeName,
+func NewUpdater(client kubernetes.Interface, nodeName string, opts ...Option) *Updater {
+	u := &Updater{
+		client:       client,
+		nodeName:     nodeName,
+		fieldManager: version.FieldManager(),
+		patchType:    types.MergePatchType,
+		backoff:      wait.Backoff{Steps: 1},
 	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
 }
 
-// UpdateAddresses updates the node's status addresses
-func (u *Updater) UpdateAddresses(ctx context.Context, addresses []v1.NodeAddress) error {
-	klog.V(2).Infof("Updating addresses for node %s: %v", u.nodeName, addresses)
+// patch applies a patch to the node, retrying according to the backoff
+func (u *Updater) patch(ctx context.Context, patchType types.PatchType, data []byte, subresources ...string) error {
+	opts := metav1.PatchOptions{FieldManager: u.fieldManager}
+	if u.dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return retry.OnError(u.backoff, isRetriable, func() error {
+		_, err := u.client.CoreV1().Nodes().Patch(ctx, u.nodeName, patchType, data, opts, subresources...)
+		return err
+	})
+}
 
-	// Create JSON patch for addresses
-	patch := []map[string]interface{}{
-		{
-			"op":    "replace",
-			"path":  "/status/addresses",
-			"value": addresses,
-		},
+// apply applies a configuration of the node with server-side apply, as the
+// field manager suffixed with manager, retrying according to the backoff.
+// Configurations with a status are applied to the status subresource.
+func (u *Updater) apply(ctx context.Context, manager string, config *corev1ac.NodeApplyConfiguration) error {
+	// Each apply configuration must hold all fields of its manager, so
+	// addresses, labels and annotations are applied by separate managers
+	opts := metav1.ApplyOptions{FieldManager: u.fieldManager, Force: true}
+	if manager != "" {
+		opts.FieldManager += "-" + manager
+	}
+	if u.dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
 	}
+	if logger := klog.V(4); logger.Enabled() {
+		data, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal apply configuration: %w", err)
+		}
+		logger.Infof("Applying configuration to node %s as %s: %s", u.nodeName, opts.FieldManager, string(data))
+	}
+	return retry.OnError(u.backoff, isRetriable, func() error {
+		var err error
+		if config.Status != nil {
+			_, err = u.client.CoreV1().Nodes().ApplyStatus(ctx, config, opts)
+		} else {
+			_, err = u.client.CoreV1().Nodes().Apply(ctx, config, opts)
+		}
+		return err
+	})
+}
 
-	patchBytes, err := json.Marshal(patch)
+// mergePatch patches the node with a JSON merge patch of the configuration,
+// which must not set the name or type. Lists are replaced, maps merged.
+func (u *Updater) mergePatch(ctx context.Context, config *corev1ac.NodeApplyConfiguration, subresources ...string) error {
+	data, err := json.Marshal(config)
 	if err != nil {
 		return fmt.Errorf("failed to marshal patch: %w", err)
 	}
+	return u.rawMergePatch(ctx, data, subresources...)
+}
+
+// clearPatch patches the node with a JSON merge patch setting the field to
+// null, which apply configurations cannot express: they omit empty lists and
+// hold no null map values. This removes lists and map entries.
+func (u *Updater) clearPatch(ctx context.Context, path []string, keys ...string) error {
+	var patch interface{}
+	if len(keys) > 0 {
+		entries := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			entries[key] = nil
+		}
+		patch = entries
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		patch = map[string]interface{}{path[i]: patch}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch: %w", err)
+	}
+	var subresources []string
+	if path[0] == "status" {
+		subresources = append(subresources, "status")
+	}
+	return u.rawMergePatch(ctx, data, subresources...)
+}
+
+func (u *Updater) rawMergePatch(ctx context.Context, data []byte, subresources ...string) error {
+	klog.V(4).Infof("Applying patch to node %s: %s", u.nodeName, string(data))
+	return u.patch(ctx, types.MergePatchType, data, subresources...)
+}
+
+func isRetriable(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err)
+}
+
+// UpdateAddresses replaces the node's status addresses
+func (u *Updater) UpdateAddresses(ctx context.Context, addresses []v1.NodeAddress) error {
+	klog.V(2).Infof("Updating addresses for node %s: %v", u.nodeName, addresses)
+
+	status := corev1ac.NodeStatus()
+	for _, address := range addresses {
+		status.WithAddresses(corev1ac.NodeAddress().WithType(address.Type).WithAddress(address.Address))
+	}
 
-	klog.V(4).Infof("Applying patch to node %s: %s", u.nodeName, string(patchBytes))
+	if u.patchType == types.ApplyPatchType {
+		if err := u.apply(ctx, "status", corev1ac.Node(u.nodeName).WithStatus(status)); err != nil {
+			return fmt.Errorf("failed to apply node addresses: %w", err)
+		}
+		klog.Infof("Successfully updated addresses for node %s", u.nodeName)
+		return nil
+	}
 
-	// Apply patch
-	_, err = u.client.CoreV1().Nodes().Patch(
-		ctx,
-		u.nodeName,
-		types.JSONPatchType,
-		patchBytes,
-		metav1.PatchOptions{},
-		"status",
-	)
+	var err error
+	if len(addresses) == 0 {
+		err = u.clearPatch(ctx, []string{"status", "addresses"})
+	} else {
+		err = u.mergePatch(ctx, (&corev1ac.NodeApplyConfiguration{}).WithStatus(status), "status")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to patch node addresses: %w", err)
 	}
@@ -86,7 +249,12 @@ func (u *Updater) UpdateAddresses(ctx context.Context, addresses []v1.NodeAddres
 
 // RemoveTaint removes the cloud provider taint from the node
 func (u *Updater) RemoveTaint(ctx context.Context) error {
-	klog.V(2).Infof("Removing taint %s from node %s", TaintKey, u.nodeName)
+	return u.RemoveTaintKey(ctx, TaintKey)
+}
+
+// RemoveTaintKey removes the taints with the given key from the node
+func (u *Updater) RemoveTaintKey(ctx context.Context, key string) error {
+	klog.V(2).Infof("Removing taint %s from node %s", key, u.nodeName)
 
 	// Get current node
 	node, err := u.client.CoreV1().Nodes().Get(ctx, u.nodeName, metav1.GetOptions{})
@@ -94,52 +262,151 @@ func (u *Updater) RemoveTaint(ctx context.Context) error {
 		return fmt.Errorf("failed to get node: %w", err)
 	}
 
-	// Check if taint exists
-	taintIndex := -1
-	for i, taint := range node.Spec.Taints {
-		if taint.Key == TaintKey {
-			taintIndex = i
-			break
+	// Remove the taints with the key if any
+	newTaints := make([]v1.Taint, 0, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != key {
+			newTaints = append(newTaints, taint)
 		}
 	}
 
-	if taintIndex == -1 {
-		klog.V(3).Infof("Taint %s not found on node %s, skipping removal", TaintKey, u.nodeName)
+	if len(newTaints) == len(node.Spec.Taints) {
+		klog.V(3).Infof("Taint %s not found on node %s, skipping removal", key, u.nodeName)
 		return nil
 	}
 
-	// Remove taint
-	newTaints := append(node.Spec.Taints[:taintIndex], node.Spec.Taints[taintIndex+1:]...)
-
-	// Create JSON patch for taints
-	patch := []map[string]interface{}{
-		{
-			"op":    "replace",
-			"path":  "/spec/taints",
-			"value": newTaints,
-		},
+	if err := u.patchTaints(ctx, newTaints); err != nil {
+		return fmt.Errorf("failed to remove taint: %w", err)
 	}
 
-	patchBytes, err := json.Marshal(patch)
+	klog.Infof("Successfully removed taint %s from node %s", key, u.nodeName)
+	return nil
+}
+
+// AddTaint adds a taint to the node, replacing a taint with the same key and
+// effect
+func (u *Updater) AddTaint(ctx context.Context, taint v1.Taint) error {
+	klog.V(2).Infof("Adding taint %s to node %s", taint.ToString(), u.nodeName)
+
+	// Get current node
+	node, err := u.client.CoreV1().Nodes().Get(ctx, u.nodeName, metav1.GetOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to marshal patch: %w", err)
+		return fmt.Errorf("failed to get node: %w", err)
 	}
 
-	klog.V(4).Infof("Applying taint removal patch to node %s: %s", u.nodeName, string(patchBytes))
+	newTaints := make([]v1.Taint, 0, len(node.Spec.Taints)+1)
+	for _, existing := range node.Spec.Taints {
+		if existing.MatchTaint(&taint) {
+			if existing.Value == taint.Value {
+				klog.V(3).Infof("Taint %s already on node %s, skipping", taint.ToString(), u.nodeName)
+				return nil
+			}
+			continue
+		}
+		newTaints = append(newTaints, existing)
+	}
+	newTaints = append(newTaints, taint)
 
-	// Apply patch
-	_, err = u.client.CoreV1().Nodes().Patch(
-		ctx,
-		u.nodeName,
-		types.JSONPatchType,
-		patchBytes,
-		metav1.PatchOptions{},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to remove taint: %w", err)
+	if err := u.patchTaints(ctx, newTaints); err != nil {
+		return fmt.Errorf("failed to add taint: %w", err)
+	}
+
+	klog.Infof("Successfully added taint %s to node %s", taint.ToString(), u.nodeName)
+	return nil
+}
+
+// patchTaints replaces the taints of the node. The taints are shared with
+// kubelet and other controllers, so they cannot be updated with server-side
+// apply.
+func (u *Updater) patchTaints(ctx context.Context, taints []v1.Taint) error {
+	if len(taints) == 0 {
+		return u.clearPatch(ctx, []string{"spec", "taints"})
+	}
+	spec := corev1ac.NodeSpec()
+	for _, taint := range taints {
+		t := corev1ac.Taint().WithKey(taint.Key).WithValue(taint.Value).WithEffect(taint.Effect)
+		if taint.TimeAdded != nil {
+			t.WithTimeAdded(*taint.TimeAdded)
+		}
+		spec.WithTaints(t)
+	}
+	return u.mergePatch(ctx, (&corev1ac.NodeApplyConfiguration{}).WithSpec(spec))
+}
+
+// SetProviderID sets the providerID of the node, which the API server only
+// accepts while it is empty
+func (u *Updater) SetProviderID(ctx context.Context, providerID string) error {
+	klog.V(2).Infof("Setting providerID of node %s to %s", u.nodeName, providerID)
+	config := (&corev1ac.NodeApplyConfiguration{}).WithSpec(corev1ac.NodeSpec().WithProviderID(providerID))
+	if err := u.mergePatch(ctx, config); err != nil {
+		return fmt.Errorf("failed to set providerID: %w", err)
+	}
+	klog.Infof("Successfully set providerID of node %s to %s", u.nodeName, providerID)
+	return nil
+}
+
+// UpdateLabels sets the given labels on the node, leaving other labels intact
+func (u *Updater) UpdateLabels(ctx context.Context, labels map[string]string) error {
+	klog.V(2).Infof("Updating labels for node %s: %v", u.nodeName, labels)
+
+	// Apply a configuration owning the labels, or merge them
+	if u.patchType == types.ApplyPatchType {
+		if err := u.apply(ctx, "", corev1ac.Node(u.nodeName).WithLabels(labels)); err != nil {
+			return fmt.Errorf("failed to apply node labels: %w", err)
+		}
+		klog.Infof("Successfully updated labels for node %s", u.nodeName)
+		return nil
+	}
+	if err := u.mergePatch(ctx, (&corev1ac.NodeApplyConfiguration{}).WithLabels(labels)); err != nil {
+		return fmt.Errorf("failed to patch node labels: %w", err)
+	}
+
+	klog.Infof("Successfully updated labels for node %s", u.nodeName)
+	return nil
+}
+
+// RemoveLabels removes the given labels from the node
+func (u *Updater) RemoveLabels(ctx context.Context, keys ...string) error {
+	klog.V(2).Infof("Removing labels %v from node %s", keys, u.nodeName)
+
+	if err := u.clearPatch(ctx, []string{"metadata", "labels"}, keys...); err != nil {
+		return fmt.Errorf("failed to remove node labels: %w", err)
+	}
+
+	klog.Infof("Successfully removed labels %v from node %s", keys, u.nodeName)
+	return nil
+}
+
+// UpdateAnnotations sets the given annotations on the node, leaving other
+// annotations intact
+func (u *Updater) UpdateAnnotations(ctx context.Context, annotations map[string]string) error {
+	klog.V(2).Infof("Updating annotations for node %s: %v", u.nodeName, annotations)
+
+	// Apply a configuration owning the annotations, or merge them
+	if u.patchType == types.ApplyPatchType {
+		if err := u.apply(ctx, "annotations", corev1ac.Node(u.nodeName).WithAnnotations(annotations)); err != nil {
+			return fmt.Errorf("failed to apply node annotations: %w", err)
+		}
+		klog.Infof("Successfully updated annotations for node %s", u.nodeName)
+		return nil
+	}
+	if err := u.mergePatch(ctx, (&corev1ac.NodeApplyConfiguration{}).WithAnnotations(annotations)); err != nil {
+		return fmt.Errorf("failed to patch node annotations: %w", err)
+	}
+
+	klog.Infof("Successfully updated annotations for node %s", u.nodeName)
+	return nil
+}
+
+// RemoveAnnotations removes the given annotations from the node
+func (u *Updater) RemoveAnnotations(ctx context.Context, keys ...string) error {
+	klog.V(2).Infof("Removing annotations %v from node %s", keys, u.nodeName)
+
+	if err := u.clearPatch(ctx, []string{"metadata", "annotations"}, keys...); err != nil {
+		return fmt.Errorf("failed to remove node annotations: %w", err)
 	}
 
-	klog.Infof("Successfully removed taint %s from node %s", TaintKey, u.nodeName)
+	klog.Infof("Successfully removed annotations %v from node %s", keys, u.nodeName)
 	return nil
 }
 
@@ -0,0 +1,198 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodeaddresses
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// Addresses are the published addresses of a node
+type Addresses struct {
+	InternalIPs []string `json:"internalIPs"`
+	ExternalIPs []string `json:"externalIPs"`
+}
+
+// Publisher maintains a ConfigMap mapping node names to the JSON encoded
+// addresses of the nodes, for consumption by external automation
+type Publisher struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	nodeLister corelisters.NodeLister
+	synced     cache.InformerSynced
+
+	// nodeHandler is removed from the shared node informer when Run returns
+	nodeInformer cache.SharedIndexInformer
+	nodeHandler  cache.ResourceEventHandlerRegistration
+
+	trigger chan struct{}
+}
+
+// NewPublisher creates a publisher writing to the named ConfigMap
+func NewPublisher(client kubernetes.Interface, factory informers.SharedInformerFactory, namespace, name string) (*Publisher, error) {
+	p := &Publisher{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		trigger:   make(chan struct{}, 1),
+	}
+
+	nodeInformer := factory.Core().V1().Nodes()
+	registration, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { p.enqueue() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok1 := oldObj.(*v1.Node)
+			newNode, ok2 := newObj.(*v1.Node)
+			if ok1 && ok2 && reflect.DeepEqual(nodeAddresses(oldNode), nodeAddresses(newNode)) {
+				return
+			}
+			p.enqueue()
+		},
+		DeleteFunc: func(interface{}) { p.enqueue() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+	p.nodeInformer, p.nodeHandler = nodeInformer.Informer(), registration
+	p.nodeLister = nodeInformer.Lister()
+	p.synced = registration.HasSynced
+
+	return p, nil
+}
+
+// Run keeps the ConfigMap up to date until the context is cancelled
+func (p *Publisher) Run(ctx context.Context) {
+	defer func() {
+		if err := p.nodeInformer.RemoveEventHandler(p.nodeHandler); err != nil {
+			klog.Errorf("Failed to remove node event handler: %v", err)
+		}
+	}()
+
+	klog.Infof("Starting node addresses publisher for ConfigMap %s/%s", p.namespace, p.name)
+
+	if !cache.WaitForCacheSync(ctx.Done(), p.synced) {
+		klog.Error("Failed to wait for node addresses publisher caches to sync")
+		return
+	}
+
+	// Resync periodically in case an update failed
+	go wait.UntilWithContext(ctx, func(context.Context) { p.enqueue() }, time.Minute)
+
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Stopping node addresses publisher")
+			return
+		case <-p.trigger:
+			if err := p.sync(ctx); err != nil {
+				klog.Errorf("Failed to publish node addresses: %v", err)
+			}
+		}
+	}
+}
+
+func (p *Publisher) enqueue() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync writes the addresses of all nodes to the ConfigMap
+func (p *Publisher) sync(ctx context.Context) error {
+	nodes, err := p.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	data := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		value, err := json.Marshal(nodeAddresses(node))
+		if err != nil {
+			return fmt.Errorf("failed to marshal addresses of node %s: %w", node.Name, err)
+		}
+		data[node.Name] = string(value)
+	}
+
+	configMaps := p.client.CoreV1().ConfigMaps(p.namespace)
+	cm, err := configMaps.Get(ctx, p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.name,
+				Namespace: p.namespace,
+			},
+			Data: data,
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{FieldManager: version.FieldManager()}); err != nil {
+			return fmt.Errorf("failed to create ConfigMap: %w", err)
+		}
+		klog.Infof("Successfully created ConfigMap %s/%s with addresses of %d nodes", p.namespace, p.name, len(data))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+
+	if reflect.DeepEqual(cm.Data, data) || (len(cm.Data) == 0 && len(data) == 0) {
+		klog.V(3).Info("Node addresses unchanged, skipping update")
+		return nil
+	}
+
+	cm = cm.DeepCopy()
+	cm.Data = data
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update ConfigMap: %w", err)
+	}
+	klog.Infof("Successfully updated ConfigMap %s/%s with addresses of %d nodes", p.namespace, p.name, len(data))
+	return nil
+}
+
+// nodeAddresses returns the InternalIPs and ExternalIPs of a node
+func nodeAddresses(node *v1.Node) Addresses {
+	addresses := Addresses{
+		InternalIPs: []string{},
+		ExternalIPs: []string{},
+	}
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case v1.NodeInternalIP:
+			addresses.InternalIPs = append(addresses.InternalIPs, addr.Address)
+		case v1.NodeExternalIP:
+			addresses.ExternalIPs = append(addresses.ExternalIPs, addr.Address)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodeendpoints publishes the ExternalIPs of the nodes as the
+// EndpointSlices of a headless Service
+package nodeendpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/service"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// managedBy is the endpointslice.kubernetes.io/managed-by value of the
+// EndpointSlices maintained by local-ccm
+const managedBy = "local-ccm.io/node-endpoints"
+
+// Controller maintains a headless Service without selector and one
+// EndpointSlice per IP family with the ExternalIPs of the selected nodes
+type Controller struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	selector  labels.Selector
+
+	nodeLister corelisters.NodeLister
+	synced     cache.InformerSynced
+
+	// nodeHandler is removed from the shared node informer when Run returns
+	nodeInformer cache.SharedIndexInformer
+	nodeHandler  cache.ResourceEventHandlerRegistration
+
+	trigger chan struct{}
+}
+
+// NewController creates a controller maintaining the named Service
+func NewController(client kubernetes.Interface, factory informers.SharedInformerFactory, namespace, name string, selector labels.Selector) (*Controller, error) {
+	c := &Controller{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		selector:  selector,
+		trigger:   make(chan struct{}, 1),
+	}
+
+	nodeInformer := factory.Core().V1().Nodes()
+	registration, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.enqueue() },
+		UpdateFunc: func(interface{}, interface{}) { c.enqueue() },
+		DeleteFunc: func(interface{}) { c.enqueue() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+	c.nodeInformer, c.nodeHandler = nodeInformer.Informer(), registration
+	c.nodeLister = nodeInformer.Lister()
+	c.synced = registration.HasSynced
+
+	return c, nil
+}
+
+// Run maintains the Service and its EndpointSlices until the context is cancelled
+func (c *Controller) Run(ctx context.Context) {
+	defer func() {
+		if err := c.nodeInformer.RemoveEventHandler(c.nodeHandler); err != nil {
+			klog.Errorf("Failed to remove node event handler: %v", err)
+		}
+	}()
+
+	klog.Infof("Starting node endpoints controller for Service %s/%s", c.namespace, c.name)
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		klog.Error("Failed to wait for node endpoints controller caches to sync")
+		return
+	}
+
+	// Resync periodically in case an update failed
+	go wait.UntilWithContext(ctx, func(context.Context) { c.enqueue() }, time.Minute)
+
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Stopping node endpoints controller")
+			return
+		case <-c.trigger:
+			if err := c.sync(ctx); err != nil {
+				klog.Errorf("Failed to sync node endpoints: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Controller) enqueue() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync ensures the Service exists and its EndpointSlices match the nodes
+func (c *Controller) sync(ctx context.Context) error {
+	if err := c.ensureService(ctx); err != nil {
+		return err
+	}
+
+	nodes, err := c.nodeLister.List(c.selector)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	endpoints := map[discoveryv1.AddressType][]discoveryv1.Endpoint{}
+	for _, node := range nodes {
+		ready := service.IsNodeReady(node)
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != v1.NodeExternalIP {
+				continue
+			}
+			ip := net.ParseIP(addr.Address)
+			if ip == nil {
+				continue
+			}
+			addressType := discoveryv1.AddressTypeIPv4
+			if ip.To4() == nil {
+				addressType = discoveryv1.AddressTypeIPv6
+			}
+			endpoint := discoveryv1.Endpoint{
+				Addresses:  []string{ip.String()},
+				Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+				NodeName:   &node.Name,
+			}
+			if zone := node.Labels[v1.LabelTopologyZone]; zone != "" {
+				endpoint.Zone = &zone
+			}
+			endpoints[addressType] = append(endpoints[addressType], endpoint)
+		}
+	}
+
+	var errs []error
+	for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+		if err := c.syncSlice(ctx, addressType, endpoints[addressType]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ensureService creates the headless Service if it does not exist
+func (c *Controller) ensureService(ctx context.Context) error {
+	_, err := c.client.CoreV1().Services(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get Service: %w", err)
+	}
+
+	policy := v1.IPFamilyPolicyPreferDualStack
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      c.name,
+			Namespace: c.namespace,
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP:      v1.ClusterIPNone,
+			IPFamilyPolicy: &policy,
+		},
+	}
+	if _, err := c.client.CoreV1().Services(c.namespace).Create(ctx, svc, metav1.CreateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to create Service: %w", err)
+	}
+	klog.Infof("Successfully created headless Service %s/%s", c.namespace, c.name)
+	return nil
+}
+
+// syncSlice creates, updates or deletes the EndpointSlice of an address type
+func (c *Controller) syncSlice(ctx context.Context, addressType discoveryv1.AddressType, endpoints []discoveryv1.Endpoint) error {
+	slices := c.client.DiscoveryV1().EndpointSlices(c.namespace)
+	name := c.name + "-" + strings.ToLower(string(addressType))
+
+	current, err := slices.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if len(endpoints) == 0 {
+			return nil
+		}
+		slice := &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: c.namespace,
+				Labels: map[string]string{
+					discoveryv1.LabelServiceName: c.name,
+					discoveryv1.LabelManagedBy:   managedBy,
+				},
+			},
+			AddressType: addressType,
+			Endpoints:   endpoints,
+			Ports:       []discoveryv1.EndpointPort{},
+		}
+		if _, err := slices.Create(ctx, slice, metav1.CreateOptions{FieldManager: version.FieldManager()}); err != nil {
+			return fmt.Errorf("failed to create EndpointSlice %s: %w", name, err)
+		}
+		klog.Infof("Successfully created EndpointSlice %s/%s with %d endpoints", c.namespace, name, len(endpoints))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get EndpointSlice %s: %w", name, err)
+	}
+
+	if len(endpoints) == 0 {
+		if err := slices.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete EndpointSlice %s: %w", name, err)
+		}
+		klog.Infof("Successfully deleted EndpointSlice %s/%s", c.namespace, name)
+		return nil
+	}
+
+	if equality.Semantic.DeepEqual(current.Endpoints, endpoints) {
+		return nil
+	}
+	updated := current.DeepCopy()
+	updated.Endpoints = endpoints
+	if _, err := slices.Update(ctx, updated, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update EndpointSlice %s: %w", name, err)
+	}
+	klog.Infof("Successfully updated EndpointSlice %s/%s with %d endpoints", c.namespace, name, len(endpoints))
+	return nil
+}
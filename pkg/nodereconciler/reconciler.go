@@ -0,0 +1,105 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nodereconciler mounts the reconcile logic of local-ccm into a
+// controller-runtime manager. It lives apart from package ccm, so only
+// programs using it depend on controller-runtime.
+package nodereconciler
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/cozystack/local-ccm/pkg/ccm"
+	"github.com/cozystack/local-ccm/pkg/metrics"
+)
+
+// MetricsPath is the path of the local-ccm metrics on the metrics server of
+// the manager, next to the metrics of its controllers
+const MetricsPath = "/metrics/local-ccm"
+
+// Reconciler is a controller-runtime reconciler of the local node. The name
+// of the request is the node name; requests for other nodes are ignored, as
+// addresses can only be detected on the host of the node.
+type Reconciler struct {
+	reconciler *ccm.Reconciler
+	nodeName   string
+}
+
+var _ reconcile.Reconciler = &Reconciler{}
+
+// New creates a reconciler of the node named in config. The node is read
+// from the cache of the manager and patched with its client. The clients of
+// config used by the other steps, e.g. events and leases, are created from
+// the rest config of the manager unless set.
+func New(mgr ctrl.Manager, config ccm.Config) (*Reconciler, error) {
+	if config.NodeUpdater == nil {
+		config.NodeUpdater = NewNodeUpdater(mgr.GetClient(), config.NodeName)
+	}
+	if config.Client == nil {
+		client, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+		config.Client = client
+	}
+	if config.DynamicClient == nil {
+		dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+		}
+		config.DynamicClient = dynamicClient
+	}
+
+	reconciler, err := ccm.NewReconciler(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconciler{reconciler: reconciler, nodeName: config.NodeName}, nil
+}
+
+// Reconcile reconciles the node named by the request and requeues it after
+// the reconcile interval, as addresses may change without any API event
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	requeueAfter, err := r.reconciler.ReconcileNode(ctx, req.Name)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// SetupWithManager watches the local node with the manager and serves the
+// local-ccm metrics at MetricsPath of its metrics server
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.AddMetricsServerExtraHandler(MetricsPath, metrics.Handler()); err != nil {
+		return fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	return builder.ControllerManagedBy(mgr).
+		Named("local-ccm").
+		For(&v1.Node{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(object client.Object) bool {
+			return object.GetName() == r.nodeName
+		}))).
+		Complete(r)
+}
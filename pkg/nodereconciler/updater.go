@@ -0,0 +1,193 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodereconciler
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/cozystack/local-ccm/pkg/node"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+// NodeUpdater reads the node with a controller-runtime client, from the
+// cache of the manager for its default client, and merge patches the
+// changes to it. Taints are patched with the resourceVersion they were read
+// at, so taints of kubelet missing from a stale cache are not dropped.
+type NodeUpdater struct {
+	client   client.Client
+	nodeName string
+}
+
+var _ node.Interface = &NodeUpdater{}
+
+// NewNodeUpdater creates an updater of the named node
+func NewNodeUpdater(client client.Client, nodeName string) *NodeUpdater {
+	return &NodeUpdater{client: client, nodeName: nodeName}
+}
+
+// GetNode reads the node
+func (u *NodeUpdater) GetNode(ctx context.Context) (*v1.Node, error) {
+	n := &v1.Node{}
+	if err := u.client.Get(ctx, client.ObjectKey{Name: u.nodeName}, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// update reads the node, modifies it and patches the difference, if modify
+// reports a change
+func (u *NodeUpdater) update(ctx context.Context, status, optimisticLock bool, modify func(*v1.Node) bool) error {
+	n, err := u.GetNode(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+	var patch client.Patch
+	if optimisticLock {
+		patch = client.MergeFromWithOptions(n.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	} else {
+		patch = client.MergeFrom(n.DeepCopy())
+	}
+	if !modify(n) {
+		return nil
+	}
+
+	fieldOwner := client.FieldOwner(version.FieldManager())
+	if status {
+		return u.client.Status().Patch(ctx, n, patch, fieldOwner)
+	}
+	return u.client.Patch(ctx, n, patch, fieldOwner)
+}
+
+// UpdateAddresses replaces the status addresses of the node
+func (u *NodeUpdater) UpdateAddresses(ctx context.Context, addresses []v1.NodeAddress) error {
+	if err := u.update(ctx, true, false, func(n *v1.Node) bool {
+		n.Status.Addresses = addresses
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to patch node addresses: %w", err)
+	}
+	klog.Infof("Successfully updated addresses for node %s", u.nodeName)
+	return nil
+}
+
+// UpdateLabels sets the given labels, leaving other labels intact
+func (u *NodeUpdater) UpdateLabels(ctx context.Context, labels map[string]string) error {
+	if err := u.update(ctx, false, false, func(n *v1.Node) bool {
+		n.Labels = setKeys(n.Labels, labels)
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to patch node labels: %w", err)
+	}
+	return nil
+}
+
+// RemoveLabels removes the given labels, leaving other labels intact
+func (u *NodeUpdater) RemoveLabels(ctx context.Context, keys ...string) error {
+	if err := u.update(ctx, false, false, func(n *v1.Node) bool {
+		for _, key := range keys {
+			delete(n.Labels, key)
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to remove node labels: %w", err)
+	}
+	return nil
+}
+
+// UpdateAnnotations sets the given annotations, leaving other annotations intact
+func (u *NodeUpdater) UpdateAnnotations(ctx context.Context, annotations map[string]string) error {
+	if err := u.update(ctx, false, false, func(n *v1.Node) bool {
+		n.Annotations = setKeys(n.Annotations, annotations)
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to patch node annotations: %w", err)
+	}
+	return nil
+}
+
+// RemoveAnnotations removes the given annotations, leaving other annotations intact
+func (u *NodeUpdater) RemoveAnnotations(ctx context.Context, keys ...string) error {
+	if err := u.update(ctx, false, false, func(n *v1.Node) bool {
+		for _, key := range keys {
+			delete(n.Annotations, key)
+		}
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to remove node annotations: %w", err)
+	}
+	return nil
+}
+
+// RemoveTaint removes the cloud provider taint
+func (u *NodeUpdater) RemoveTaint(ctx context.Context) error {
+	return u.RemoveTaintKey(ctx, node.TaintKey)
+}
+
+// RemoveTaintKey removes the taints with the given key
+func (u *NodeUpdater) RemoveTaintKey(ctx context.Context, key string) error {
+	if err := u.update(ctx, false, true, func(n *v1.Node) bool {
+		taints := make([]v1.Taint, 0, len(n.Spec.Taints))
+		for _, taint := range n.Spec.Taints {
+			if taint.Key != key {
+				taints = append(taints, taint)
+			}
+		}
+		changed := len(taints) != len(n.Spec.Taints)
+		n.Spec.Taints = taints
+		return changed
+	}); err != nil {
+		return fmt.Errorf("failed to remove taint: %w", err)
+	}
+	return nil
+}
+
+// AddTaint adds a taint, replacing a taint with the same key and effect
+func (u *NodeUpdater) AddTaint(ctx context.Context, taint v1.Taint) error {
+	if err := u.update(ctx, false, true, func(n *v1.Node) bool {
+		taints := make([]v1.Taint, 0, len(n.Spec.Taints)+1)
+		for _, existing := range n.Spec.Taints {
+			if existing.MatchTaint(&taint) {
+				if existing.Value == taint.Value {
+					return false
+				}
+				continue
+			}
+			taints = append(taints, existing)
+		}
+		n.Spec.Taints = append(taints, taint)
+		return true
+	}); err != nil {
+		return fmt.Errorf("failed to add taint: %w", err)
+	}
+	return nil
+}
+
+// setKeys returns the map with the given entries set
+func setKeys(m, entries map[string]string) map[string]string {
+	if m == nil {
+		m = make(map[string]string, len(entries))
+	}
+	for key, value := range entries {
+		m[key] = value
+	}
+	return m
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Route describes a pod CIDR route to a node, mirroring cloudprovider.Route
+// from k8s.io/cloud-provider
+type Route struct {
+	TargetNode      types.NodeName
+	DestinationCIDR string
+	Gateway         string
+}
+
+// Routes implements the Routes API of the cloud provider interface by
+// programming static routes on the local host via netlink (Linux only)
+type Routes struct {
+	nodeLister corelisters.NodeLister
+	synced     cache.InformerSynced
+	nodeName   string
+}
+
+// NewRoutes creates a Routes provider for the local node, reading the other
+// nodes from the shared node informer. The informer must be started by the
+// caller.
+func NewRoutes(nodeInformer coreinformers.NodeInformer, nodeName string) *Routes {
+	return &Routes{
+		nodeLister: nodeInformer.Lister(),
+		synced:     nodeInformer.Informer().HasSynced,
+		nodeName:   nodeName,
+	}
+}
+
+// Sync programs routes to the pod CIDRs of all other nodes via their
+// InternalIP and removes stale routes left over from deleted nodes
+func (r *Routes) Sync(ctx context.Context) error {
+	if !cache.WaitForCacheSync(ctx.Done(), r.synced) {
+		return fmt.Errorf("failed to wait for the node cache to sync")
+	}
+	nodes, err := r.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	// Build desired routes
+	desired := make(map[string]*Route)
+	for _, node := range nodes {
+		if node.Name == r.nodeName {
+			continue
+		}
+		for _, route := range nodeRoutes(node) {
+			desired[route.DestinationCIDR] = route
+		}
+	}
+
+	existing, err := r.ListRoutes(ctx, nodes)
+	if err != nil {
+		return err
+	}
+
+	// Remove stale routes
+	current := make(map[string]*Route)
+	for _, route := range existing {
+		if want, ok := desired[route.DestinationCIDR]; ok && want.Gateway == route.Gateway {
+			current[route.DestinationCIDR] = route
+			continue
+		}
+		if err := r.DeleteRoute(ctx, route); err != nil {
+			return err
+		}
+	}
+
+	// Create missing routes
+	for cidr, route := range desired {
+		if _, ok := current[cidr]; ok {
+			klog.V(4).Infof("Route %s via %s already exists", cidr, route.Gateway)
+			continue
+		}
+		if err := r.CreateRoute(ctx, route); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nodeRoutes returns the routes needed to reach the pod CIDRs of a node, one
+// per CIDR via the first InternalIP of its family, so dual-stack nodes get
+// routes of both families
+func nodeRoutes(node *v1.Node) []*Route {
+	gateways := nodeInternalIPs(node)
+	if len(gateways) == 0 {
+		klog.V(3).Infof("Node %s has no InternalIP, skipping routes", node.Name)
+		return nil
+	}
+
+	podCIDRs := node.Spec.PodCIDRs
+	if len(podCIDRs) == 0 && node.Spec.PodCIDR != "" {
+		podCIDRs = []string{node.Spec.PodCIDR}
+	}
+
+	var routes []*Route
+	for _, podCIDR := range podCIDRs {
+		_, dst, err := net.ParseCIDR(podCIDR)
+		if err != nil {
+			klog.Warningf("Node %s has invalid pod CIDR %s: %v", node.Name, podCIDR, err)
+			continue
+		}
+		gateway, ok := gateways[dst.IP.To4() != nil]
+		if !ok {
+			klog.V(3).Infof("Node %s has no InternalIP of the family of pod CIDR %s, skipping", node.Name, podCIDR)
+			continue
+		}
+		routes = append(routes, &Route{
+			TargetNode:      types.NodeName(node.Name),
+			DestinationCIDR: dst.String(),
+			Gateway:         gateway,
+		})
+	}
+
+	return routes
+}
+
+// nodeInternalIPs returns the first InternalIP of each family of the node,
+// keyed by whether it is IPv4
+func nodeInternalIPs(node *v1.Node) map[bool]string {
+	ips := make(map[bool]string)
+	for _, addr := range node.Status.Addresses {
+		ip := net.ParseIP(addr.Address)
+		if addr.Type != v1.NodeInternalIP || ip == nil {
+			continue
+		}
+		if _, ok := ips[ip.To4() != nil]; !ok {
+			ips[ip.To4() != nil] = addr.Address
+		}
+	}
+	return ips
+}
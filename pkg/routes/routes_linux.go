@@ -0,0 +1,121 @@
+//go:build linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// RouteProtocol marks routes programmed by local-ccm, so they can be told
+	// apart from routes managed by the system or other daemons
+	RouteProtocol netlink.RouteProtocol = 201
+)
+
+// ListRoutes returns the routes programmed by local-ccm on the local host.
+// TargetNode is resolved from the gateway using the given nodes.
+func (r *Routes) ListRoutes(ctx context.Context, nodes []*v1.Node) ([]*Route, error) {
+	gatewayNodes := make(map[string]types.NodeName)
+	for _, node := range nodes {
+		for _, ip := range nodeInternalIPs(node) {
+			gatewayNodes[ip] = types.NodeName(node.Name)
+		}
+	}
+
+	nlRoutes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		Protocol: RouteProtocol,
+	}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	routes := make([]*Route, 0, len(nlRoutes))
+	for _, nlRoute := range nlRoutes {
+		if nlRoute.Dst == nil || nlRoute.Gw == nil {
+			continue
+		}
+		routes = append(routes, &Route{
+			TargetNode:      gatewayNodes[nlRoute.Gw.String()],
+			DestinationCIDR: nlRoute.Dst.String(),
+			Gateway:         nlRoute.Gw.String(),
+		})
+	}
+
+	return routes, nil
+}
+
+// CreateRoute programs a route to the pod CIDR of the target node
+func (r *Routes) CreateRoute(ctx context.Context, route *Route) error {
+	nlRoute, err := toNetlinkRoute(route)
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Creating route %s via %s (node %s)", route.DestinationCIDR, route.Gateway, route.TargetNode)
+
+	if err := netlink.RouteReplace(nlRoute); err != nil {
+		return fmt.Errorf("failed to create route %s via %s: %w", route.DestinationCIDR, route.Gateway, err)
+	}
+
+	klog.Infof("Successfully created route %s via %s (node %s)", route.DestinationCIDR, route.Gateway, route.TargetNode)
+	return nil
+}
+
+// DeleteRoute removes a route previously created by CreateRoute
+func (r *Routes) DeleteRoute(ctx context.Context, route *Route) error {
+	nlRoute, err := toNetlinkRoute(route)
+	if err != nil {
+		return err
+	}
+
+	klog.V(2).Infof("Deleting route %s via %s", route.DestinationCIDR, route.Gateway)
+
+	if err := netlink.RouteDel(nlRoute); err != nil {
+		return fmt.Errorf("failed to delete route %s via %s: %w", route.DestinationCIDR, route.Gateway, err)
+	}
+
+	klog.Infof("Successfully deleted route %s via %s", route.DestinationCIDR, route.Gateway)
+	return nil
+}
+
+// toNetlinkRoute converts a Route to its netlink representation
+func toNetlinkRoute(route *Route) (*netlink.Route, error) {
+	_, dst, err := net.ParseCIDR(route.DestinationCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination CIDR %s: %w", route.DestinationCIDR, err)
+	}
+
+	gw := net.ParseIP(route.Gateway)
+	if gw == nil {
+		return nil, fmt.Errorf("invalid gateway address: %s", route.Gateway)
+	}
+
+	return &netlink.Route{
+		Dst:      dst,
+		Gw:       gw,
+		Protocol: RouteProtocol,
+	}, nil
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routes
+
+import (
+	"context"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ListRoutes always fails, static routes require Linux
+func (r *Routes) ListRoutes(ctx context.Context, nodes []*v1.Node) ([]*Route, error) {
+	return nil, fmt.Errorf("static routes are only supported on Linux")
+}
+
+// CreateRoute always fails, static routes require Linux
+func (r *Routes) CreateRoute(ctx context.Context, route *Route) error {
+	return fmt.Errorf("static routes are only supported on Linux")
+}
+
+// DeleteRoute always fails, static routes require Linux
+func (r *Routes) DeleteRoute(ctx context.Context, route *Route) error {
+	return fmt.Errorf("static routes are only supported on Linux")
+}
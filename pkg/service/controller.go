@@ -0,0 +1,773 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/ipam"
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// LoadBalancerIPsAnnotation requests specific LoadBalancer IPs for a
+	// service as a comma-separated list
+	LoadBalancerIPsAnnotation = "local-ccm.io/loadBalancerIPs"
+	// MetalLBLoadBalancerIPsAnnotation is the MetalLB equivalent of
+	// LoadBalancerIPsAnnotation, honored for compatibility
+	MetalLBLoadBalancerIPsAnnotation = "metallb.universe.tf/loadBalancerIPs"
+	// AllowSharedIPAnnotation holds a sharing key. Services with the same
+	// sharing key may share LoadBalancer IPs as long as their ports differ.
+	AllowSharedIPAnnotation = "local-ccm.io/allow-shared-ip"
+	// NodeSelectorAnnotation holds a label selector restricting the nodes
+	// publishing or announcing the LoadBalancer IPs of a service
+	NodeSelectorAnnotation = "local-ccm.io/node-selector"
+	// MetalLBAllowSharedIPAnnotation is the MetalLB equivalent of
+	// AllowSharedIPAnnotation, honored for compatibility
+	MetalLBAllowSharedIPAnnotation = "metallb.universe.tf/allow-shared-ip"
+	// AddressPoolAnnotation requests LoadBalancer IPs from the named pool,
+	// which may disable auto-assignment
+	AddressPoolAnnotation = "local-ccm.io/address-pool"
+	// MetalLBAddressPoolAnnotation is the MetalLB equivalent of
+	// AddressPoolAnnotation, honored for compatibility
+	MetalLBAddressPoolAnnotation = "metallb.universe.tf/address-pool"
+
+	// poolStatusInterval is the interval between pool status updates
+	poolStatusInterval = 10 * time.Second
+)
+
+// loadBackoff is the backoff between attempts to load the allocations,
+// which are needed before any LoadBalancer IP is assigned
+var loadBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      time.Minute,
+}
+
+// Config holds the settings of the service controller
+type Config struct {
+	// ForwarderImage is the image of the klipper-lb style forwarder
+	// DaemonSet maintained for each LoadBalancer Service. Empty disables
+	// forwarders.
+	ForwarderImage string
+	// Allocator allocates LoadBalancer IPs from address pools. If nil, node
+	// IPs are published as ingress instead.
+	Allocator *ipam.Allocator
+	// Pools provides the pools of the allocator. Services are resynced on
+	// pool changes and the pool usage is reported in IPAddressPool status.
+	Pools *ipam.PoolWatcher
+	// LoadBalancerClass restricts the controller to services with this
+	// spec.loadBalancerClass. If empty, only services without a class are
+	// handled.
+	LoadBalancerClass string
+	// Recorder records events on services
+	Recorder record.EventRecorder
+	// Hostname publishes hostnames instead of ingress IPs. It is either
+	// HostnameReverseDNS or a template with {service}, {namespace}, {zone}
+	// and {ip} placeholders. Empty disables hostnames.
+	Hostname string
+}
+
+// Controller publishes node IPs, or IPs allocated from address pools, as the
+// ingress addresses of Services of type LoadBalancer, optionally running a
+// hostPort forwarder DaemonSet per Service
+type Controller struct {
+	client         kubernetes.Interface
+	forwarderImage string
+	allocator      *ipam.Allocator
+	pools          *ipam.PoolWatcher
+	lbClass        string
+	recorder       record.EventRecorder
+	hostnames      *hostnamer
+
+	serviceLister       corelisters.ServiceLister
+	nodeLister          corelisters.NodeLister
+	endpointSliceLister discoverylisters.EndpointSliceLister
+	daemonSetLister     appslisters.DaemonSetLister
+	synced              []cache.InformerSynced
+	// handlers are removed from the shared informers when Run returns
+	handlers map[cache.SharedIndexInformer]cache.ResourceEventHandlerRegistration
+
+	queue workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController creates a new service controller
+func NewController(client kubernetes.Interface, factory informers.SharedInformerFactory, config Config) (*Controller, error) {
+	c := &Controller{
+		client:         client,
+		forwarderImage: config.ForwarderImage,
+		allocator:      config.Allocator,
+		pools:          config.Pools,
+		lbClass:        config.LoadBalancerClass,
+		recorder:       config.Recorder,
+		handlers:       make(map[cache.SharedIndexInformer]cache.ResourceEventHandlerRegistration),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "service"},
+		),
+	}
+
+	if config.Hostname != "" {
+		c.hostnames = newHostnamer(config.Hostname)
+	}
+
+	serviceInformer := factory.Core().V1().Services()
+	registration, err := serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueService,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueService(obj) },
+		DeleteFunc: c.enqueueService,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add service event handler: %w", err)
+	}
+	c.handlers[serviceInformer.Informer()] = registration
+	c.serviceLister = serviceInformer.Lister()
+	c.synced = append(c.synced, registration.HasSynced)
+
+	nodeInformer := factory.Core().V1().Nodes()
+	registration, err = nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.enqueueAllServices() },
+		UpdateFunc: c.handleNodeUpdate,
+		DeleteFunc: func(interface{}) { c.enqueueAllServices() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add node event handler: %w", err)
+	}
+	c.handlers[nodeInformer.Informer()] = registration
+	c.nodeLister = nodeInformer.Lister()
+	c.synced = append(c.synced, registration.HasSynced)
+
+	endpointSliceInformer := factory.Discovery().V1().EndpointSlices()
+	registration, err = endpointSliceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleEndpointSlice,
+		UpdateFunc: func(_, obj interface{}) { c.handleEndpointSlice(obj) },
+		DeleteFunc: c.handleEndpointSlice,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add endpoint slice event handler: %w", err)
+	}
+	c.handlers[endpointSliceInformer.Informer()] = registration
+	c.endpointSliceLister = endpointSliceInformer.Lister()
+	c.synced = append(c.synced, registration.HasSynced)
+
+	if c.pools != nil {
+		if err := c.pools.AddEventHandler(c.enqueueAllServices); err != nil {
+			return nil, fmt.Errorf("failed to add pool event handler: %w", err)
+		}
+		c.synced = append(c.synced, c.pools.HasSynced)
+	}
+
+	if c.forwarderImage != "" {
+		daemonSetInformer := factory.Apps().V1().DaemonSets()
+		c.daemonSetLister = daemonSetInformer.Lister()
+		c.synced = append(c.synced, daemonSetInformer.Informer().HasSynced)
+	}
+
+	return c, nil
+}
+
+// Run starts the workers and blocks until the context is cancelled
+func (c *Controller) Run(ctx context.Context, workers int) {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+	defer func() {
+		for informer, registration := range c.handlers {
+			if err := informer.RemoveEventHandler(registration); err != nil {
+				klog.Errorf("Failed to remove event handler: %v", err)
+			}
+		}
+	}()
+
+	klog.Info("Starting service controller")
+
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		klog.Error("Failed to wait for service controller caches to sync")
+		return
+	}
+
+	if c.allocator != nil {
+		if err := wait.ExponentialBackoffWithContext(ctx, loadBackoff, func(ctx context.Context) (bool, error) {
+			if err := c.allocator.Load(ctx); err != nil {
+				klog.Errorf("Failed to load LoadBalancer IP allocations, retrying: %v", err)
+				return false, nil
+			}
+			return true, nil
+		}); err != nil {
+			klog.Info("Stopping service controller")
+			return
+		}
+		// Services deleted while no controller was running still hold allocations
+		for _, key := range c.allocator.Keys() {
+			c.queue.Add(key)
+		}
+
+		if c.pools != nil {
+			go wait.UntilWithContext(ctx, func(ctx context.Context) {
+				if err := c.pools.UpdateStatus(ctx, c.allocator); err != nil {
+					klog.Errorf("Failed to update pool status: %v", err)
+				}
+			}, poolStatusInterval)
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.worker, time.Second)
+	}
+
+	<-ctx.Done()
+	klog.Info("Stopping service controller")
+}
+
+func (c *Controller) worker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.syncService(ctx, key); err != nil {
+		klog.Errorf("Failed to sync service %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) enqueueService(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *Controller) enqueueAllServices() {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, svc := range services {
+		if c.isManaged(svc) {
+			c.enqueueService(svc)
+		}
+	}
+}
+
+// isManaged reports whether the service is a LoadBalancer service of the
+// class handled by this controller
+func (c *Controller) isManaged(svc *v1.Service) bool {
+	return IsManaged(svc, c.lbClass)
+}
+
+// IsManaged reports whether the service is a LoadBalancer service of the
+// given class. An empty class matches services without a class.
+func IsManaged(svc *v1.Service, lbClass string) bool {
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return false
+	}
+	if svc.Spec.LoadBalancerClass == nil {
+		return lbClass == ""
+	}
+	return *svc.Spec.LoadBalancerClass == lbClass
+}
+
+func (c *Controller) handleNodeUpdate(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*v1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*v1.Node)
+	if !ok {
+		return
+	}
+
+	// Only addresses, readiness and labels influence the published ingress
+	if nodeIngressIP(oldNode) == nodeIngressIP(newNode) &&
+		IsNodeReady(oldNode) == IsNodeReady(newNode) &&
+		labels.Equals(oldNode.Labels, newNode.Labels) {
+		return
+	}
+	c.enqueueAllServices()
+}
+
+// handleEndpointSlice enqueues the service of an endpoint slice if its
+// ingress depends on the location of its endpoints
+func (c *Controller) handleEndpointSlice(obj interface{}) {
+	key := EndpointSliceServiceKey(obj)
+	if key == "" {
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+	svc, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return
+	}
+	if c.allocator == nil && c.isManaged(svc) && HasLocalTrafficPolicy(svc) {
+		c.queue.Add(key)
+	}
+}
+
+// syncService reconciles the LoadBalancer status of a single service
+func (c *Controller) syncService(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	svc, err := c.serviceLister.Services(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		// Forwarder DaemonSets are garbage collected via owner references
+		klog.V(3).Infof("Service %s deleted", key)
+		return c.releaseIPs(ctx, key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+
+	if !c.isManaged(svc) {
+		if err := c.releaseIPs(ctx, key); err != nil {
+			return err
+		}
+		if c.forwarderImage != "" {
+			return c.deleteForwarder(ctx, svc)
+		}
+		return nil
+	}
+
+	klog.V(2).Infof("Syncing LoadBalancer service %s", key)
+
+	if c.forwarderImage != "" {
+		if err := c.ensureForwarder(ctx, svc); err != nil {
+			return err
+		}
+	}
+
+	var ingress []v1.LoadBalancerIngress
+	if c.allocator != nil {
+		ingress, err = c.allocateIngress(ctx, key, svc)
+	} else {
+		ingress, err = c.nodeIngress(svc)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.hostnames != nil {
+		if ingress, err = c.ingressHostnames(ctx, svc, ingress); err != nil {
+			return fmt.Errorf("failed to derive ingress hostnames: %w", err)
+		}
+	}
+
+	if ingressEqual(svc.Status.LoadBalancer.Ingress, ingress) {
+		klog.V(3).Infof("LoadBalancer ingress of service %s unchanged, skipping update", key)
+		return nil
+	}
+
+	svcCopy := svc.DeepCopy()
+	svcCopy.Status.LoadBalancer.Ingress = ingress
+	if _, err := c.client.CoreV1().Services(namespace).UpdateStatus(ctx, svcCopy, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update service status: %w", err)
+	}
+
+	klog.Infof("Successfully updated LoadBalancer ingress of service %s: %v", key, ingressIPs(ingress))
+	return nil
+}
+
+// nodeIngress builds the ingress list of a service from node IPs. Services
+// with externalTrafficPolicy Local only get the IPs of nodes running their
+// endpoints, as other nodes drop their traffic. Services with a node selector
+// only get the IPs of the selected nodes.
+func (c *Controller) nodeIngress(svc *v1.Service) ([]v1.LoadBalancerIngress, error) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	selector, err := NodeSelector(svc)
+	if err != nil {
+		c.recorder.Eventf(svc, v1.EventTypeWarning, "InvalidNodeSelector", "Invalid %s annotation: %v", NodeSelectorAnnotation, err)
+		return nil, err
+	}
+
+	var endpointNodes map[string]bool
+	if HasLocalTrafficPolicy(svc) {
+		if endpointNodes, err = EndpointNodes(c.endpointSliceLister, svc); err != nil {
+			return nil, err
+		}
+	}
+	return serviceIngress(svc, nodes, selector, endpointNodes), nil
+}
+
+// allocateIngress builds the ingress list of a service from pool addresses.
+// Requested addresses are allocated if possible, otherwise one address per IP
+// family of the service is allocated.
+func (c *Controller) allocateIngress(ctx context.Context, key string, svc *v1.Service) ([]v1.LoadBalancerIngress, error) {
+	inUse, err := c.foreignIngressIPs(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if requested := requestedIPs(svc); len(requested) > 0 {
+		var ingress []v1.LoadBalancerIngress
+		for _, ip := range requested {
+			if err := c.allocateIP(ctx, key, svc, ip, inUse); err != nil {
+				c.recorder.Eventf(svc, v1.EventTypeWarning, "AllocationFailed",
+					"Failed to allocate requested IP %s: %v", ip, err)
+				return nil, err
+			}
+			ingress = append(ingress, v1.LoadBalancerIngress{IP: ip})
+		}
+		// Release addresses that are no longer requested
+		if err := c.allocator.ReleaseExcept(ctx, key, requested); err != nil {
+			return nil, fmt.Errorf("failed to release LoadBalancer IPs: %w", err)
+		}
+		return ingress, nil
+	}
+
+	families := svc.Spec.IPFamilies
+	if len(families) == 0 {
+		families = []v1.IPFamily{v1.IPv4Protocol}
+	}
+
+	var ingress []v1.LoadBalancerIngress
+	var allocated []string
+	for _, family := range families {
+		ip, err := c.allocateFamily(ctx, key, svc, family, inUse)
+		if err != nil {
+			c.recorder.Eventf(svc, v1.EventTypeWarning, "AllocationFailed",
+				"Failed to allocate %s address: %v", family, err)
+			return nil, err
+		}
+		ingress = append(ingress, v1.LoadBalancerIngress{IP: ip})
+		allocated = append(allocated, ip)
+	}
+	// Release addresses of removed families or of a previously requested pool
+	if err := c.allocator.ReleaseExcept(ctx, key, allocated); err != nil {
+		return nil, fmt.Errorf("failed to release LoadBalancer IPs: %w", err)
+	}
+	return ingress, nil
+}
+
+// allocateIP allocates a requested IP, sharing it if it is held by services
+// the service may share it with
+func (c *Controller) allocateIP(ctx context.Context, key string, svc *v1.Service, ip string, inUse map[string]bool) error {
+	owners := c.allocator.Owners(ip)
+	if len(owners) == 0 || slices.Contains(owners, key) {
+		return c.allocator.AllocateIP(ctx, key, ip, inUse)
+	}
+	if err := c.canShare(svc, owners); err != nil {
+		return fmt.Errorf("%s requested by %s: %w", ip, key, err)
+	}
+	return c.allocator.Share(ctx, key, ip)
+}
+
+// allocateFamily returns the IP of the given family allocated to a service.
+// Services with a sharing key join an existing IP of their sharing key if
+// possible, other services get a dedicated IP.
+func (c *Controller) allocateFamily(ctx context.Context, key string, svc *v1.Service, family v1.IPFamily, inUse map[string]bool) (string, error) {
+	poolName := requestedPool(svc)
+	if sharingKey(svc) != "" {
+		for _, ip := range c.allocator.Allocated(key) {
+			if ipFamily(ip) == family && (poolName == "" || c.allocator.InPool(ip, poolName)) {
+				return ip, nil
+			}
+		}
+		for _, ip := range c.allocator.IPs() {
+			if ipFamily(ip) != family || (poolName != "" && !c.allocator.InPool(ip, poolName)) {
+				continue
+			}
+			if c.canShare(svc, c.allocator.Owners(ip)) != nil {
+				continue
+			}
+			if err := c.allocator.Share(ctx, key, ip); err != nil {
+				return "", err
+			}
+			return ip, nil
+		}
+	}
+	return c.allocator.Allocate(ctx, key, family, poolName, inUse)
+}
+
+// requestedPool returns the name of the pool requested by a service
+func requestedPool(svc *v1.Service) string {
+	if pool := svc.Annotations[AddressPoolAnnotation]; pool != "" {
+		return pool
+	}
+	return svc.Annotations[MetalLBAddressPoolAnnotation]
+}
+
+// canShare checks if a service may share an IP held by the given services.
+// All of them must have the same sharing key and use different ports.
+func (c *Controller) canShare(svc *v1.Service, owners []string) error {
+	key := sharingKey(svc)
+	for _, owner := range owners {
+		namespace, name, err := cache.SplitMetaNamespaceKey(owner)
+		if err != nil {
+			return err
+		}
+		other, err := c.serviceLister.Services(namespace).Get(name)
+		if err != nil || key == "" || sharingKey(other) != key {
+			return fmt.Errorf("held by %s: %w", owner, ipam.ErrAllocated)
+		}
+		if port := conflictingPort(svc, other); port != "" {
+			return fmt.Errorf("port %s is already used by %s: %w", port, owner, ipam.ErrAllocated)
+		}
+	}
+	return nil
+}
+
+// sharingKey returns the IP sharing key of a service
+func sharingKey(svc *v1.Service) string {
+	if key := svc.Annotations[AllowSharedIPAnnotation]; key != "" {
+		return key
+	}
+	return svc.Annotations[MetalLBAllowSharedIPAnnotation]
+}
+
+// conflictingPort returns the first port/protocol used by both services
+func conflictingPort(a, b *v1.Service) string {
+	for _, pa := range a.Spec.Ports {
+		for _, pb := range b.Spec.Ports {
+			if pa.Port == pb.Port && portProtocol(pa) == portProtocol(pb) {
+				return fmt.Sprintf("%d/%s", pa.Port, portProtocol(pa))
+			}
+		}
+	}
+	return ""
+}
+
+func portProtocol(port v1.ServicePort) v1.Protocol {
+	if port.Protocol == "" {
+		return v1.ProtocolTCP
+	}
+	return port.Protocol
+}
+
+// requestedIPs returns the LoadBalancer IPs requested by a service via
+// annotations or the deprecated spec.loadBalancerIP field
+func requestedIPs(svc *v1.Service) []string {
+	value := svc.Annotations[LoadBalancerIPsAnnotation]
+	if value == "" {
+		value = svc.Annotations[MetalLBLoadBalancerIPsAnnotation]
+	}
+	if value == "" {
+		value = svc.Spec.LoadBalancerIP
+	}
+	if value == "" {
+		return nil
+	}
+
+	var ips []string
+	for _, ip := range strings.Split(value, ",") {
+		if parsed := net.ParseIP(strings.TrimSpace(ip)); parsed != nil {
+			ips = append(ips, parsed.String())
+		} else {
+			klog.Warningf("Ignoring invalid LoadBalancer IP %q requested by service %s/%s", ip, svc.Namespace, svc.Name)
+		}
+	}
+	return ips
+}
+
+// foreignIngressIPs returns the ingress IPs of all other services, so
+// addresses assigned by other LoadBalancer implementations are not reused
+func (c *Controller) foreignIngressIPs(key string) (map[string]bool, error) {
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	inUse := make(map[string]bool)
+	for _, svc := range services {
+		svcKey, err := cache.MetaNamespaceKeyFunc(svc)
+		if err != nil || svcKey == key {
+			continue
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			if ing.IP != "" {
+				inUse[ing.IP] = true
+			}
+		}
+	}
+
+	// Our own allocations are tracked by the allocator itself
+	for _, ownerKey := range c.allocator.Keys() {
+		for _, ip := range c.allocator.Allocated(ownerKey) {
+			delete(inUse, ip)
+		}
+	}
+	return inUse, nil
+}
+
+// releaseIPs frees the pool addresses allocated to a service
+func (c *Controller) releaseIPs(ctx context.Context, key string) error {
+	if c.allocator == nil {
+		return nil
+	}
+	if err := c.allocator.Release(ctx, key); err != nil {
+		return fmt.Errorf("failed to release LoadBalancer IPs: %w", err)
+	}
+	return nil
+}
+
+// serviceIngress builds the ingress list from the IPs of all ready nodes
+// matching the node selector and the IP families of the service. If
+// endpointNodes is not nil, only the nodes it contains are used.
+func serviceIngress(svc *v1.Service, nodes []*v1.Node, selector labels.Selector, endpointNodes map[string]bool) []v1.LoadBalancerIngress {
+	families := make(map[v1.IPFamily]bool)
+	for _, family := range svc.Spec.IPFamilies {
+		families[family] = true
+	}
+
+	seen := make(map[string]bool)
+	var ips []string
+	for _, node := range nodes {
+		if !IsNodeReady(node) {
+			continue
+		}
+		if _, excluded := node.Labels[v1.LabelNodeExcludeBalancers]; excluded {
+			continue
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		if endpointNodes != nil && !endpointNodes[node.Name] {
+			continue
+		}
+		ip := nodeIngressIP(node)
+		if ip == "" || seen[ip] {
+			continue
+		}
+		if len(families) > 0 && !families[ipFamily(ip)] {
+			continue
+		}
+		seen[ip] = true
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	ingress := make([]v1.LoadBalancerIngress, 0, len(ips))
+	for _, ip := range ips {
+		ingress = append(ingress, v1.LoadBalancerIngress{IP: ip})
+	}
+	return ingress
+}
+
+// NodeSelector returns the selector of the nodes eligible for a service,
+// which selects all nodes unless the service has a node selector annotation
+func NodeSelector(svc *v1.Service) (labels.Selector, error) {
+	value := svc.Annotations[NodeSelectorAnnotation]
+	if value == "" {
+		return labels.Everything(), nil
+	}
+	return labels.Parse(value)
+}
+
+// nodeIngressIP returns the ExternalIP of the node, falling back to its
+// InternalIP for nodes without a public address
+func nodeIngressIP(node *v1.Node) string {
+	var internalIP string
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case v1.NodeExternalIP:
+			return addr.Address
+		case v1.NodeInternalIP:
+			if internalIP == "" {
+				internalIP = addr.Address
+			}
+		}
+	}
+	return internalIP
+}
+
+// IsNodeReady reports whether the node has a true Ready condition
+func IsNodeReady(node *v1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func ipFamily(ip string) v1.IPFamily {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return v1.IPv6Protocol
+	}
+	return v1.IPv4Protocol
+}
+
+// ingressEqual checks if two ingress lists contain the same addresses in the same order
+func ingressEqual(a, b []v1.LoadBalancerIngress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].IP != b[i].IP || a[i].Hostname != b[i].Hostname {
+			return false
+		}
+	}
+	return true
+}
+
+func ingressIPs(ingress []v1.LoadBalancerIngress) []string {
+	ips := make([]string, 0, len(ingress))
+	for _, ing := range ingress {
+		if ing.Hostname != "" {
+			ips = append(ips, ing.Hostname)
+		} else {
+			ips = append(ips, ing.IP)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// HasLocalTrafficPolicy reports whether external traffic of the service is
+// only delivered to endpoints on the receiving node
+func HasLocalTrafficPolicy(svc *v1.Service) bool {
+	return svc.Spec.ExternalTrafficPolicy == v1.ServiceExternalTrafficPolicyLocal
+}
+
+// EndpointNodes returns the names of the nodes running ready endpoints of a service
+func EndpointNodes(lister discoverylisters.EndpointSliceLister, svc *v1.Service) (map[string]bool, error) {
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: svc.Name})
+	endpointSlices, err := lister.EndpointSlices(svc.Namespace).List(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoint slices: %w", err)
+	}
+
+	nodes := make(map[string]bool)
+	for _, slice := range endpointSlices {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.NodeName == nil {
+				continue
+			}
+			// An unknown readiness is to be interpreted as ready
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+			nodes[*endpoint.NodeName] = true
+		}
+	}
+	return nodes, nil
+}
+
+// EndpointSliceServiceKey returns the namespace/name key of the service
+// owning an endpoint slice, or an empty string
+func EndpointSliceServiceKey(obj interface{}) string {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return ""
+	}
+	name := slice.Labels[discoveryv1.LabelServiceName]
+	if name == "" {
+		return ""
+	}
+	return slice.Namespace + "/" + name
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/version"
+)
+
+const (
+	// ServiceNameLabel marks forwarder DaemonSets and pods with the name of their service
+	ServiceNameLabel = "svccontroller.local-ccm.io/svcname"
+
+	// forwarderHashAnnotation stores the hash of the desired forwarder pod template
+	forwarderHashAnnotation = "local-ccm.io/forwarder-hash"
+)
+
+// forwarderName returns the name of the forwarder DaemonSet for a service
+func forwarderName(svc *v1.Service) string {
+	name := "svclb-" + svc.Name
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.TrimSuffix(name, "-")
+}
+
+// ensureForwarder creates or updates the hostPort forwarder DaemonSet of a service
+func (c *Controller) ensureForwarder(ctx context.Context, svc *v1.Service) error {
+	desired, err := c.newForwarder(svc)
+	if err != nil {
+		return err
+	}
+
+	existing, err := c.daemonSetLister.DaemonSets(svc.Namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		klog.V(2).Infof("Creating forwarder DaemonSet %s/%s", desired.Namespace, desired.Name)
+		if _, err := c.client.AppsV1().DaemonSets(svc.Namespace).Create(ctx, desired, metav1.CreateOptions{FieldManager: version.FieldManager()}); err != nil {
+			return fmt.Errorf("failed to create forwarder DaemonSet: %w", err)
+		}
+		klog.Infof("Successfully created forwarder DaemonSet %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get forwarder DaemonSet: %w", err)
+	}
+
+	if existing.Annotations[forwarderHashAnnotation] == desired.Annotations[forwarderHashAnnotation] {
+		klog.V(3).Infof("Forwarder DaemonSet %s/%s unchanged, skipping update", existing.Namespace, existing.Name)
+		return nil
+	}
+
+	updated := existing.DeepCopy()
+	updated.Annotations = desired.Annotations
+	updated.Spec.Template = desired.Spec.Template
+	if _, err := c.client.AppsV1().DaemonSets(svc.Namespace).Update(ctx, updated, metav1.UpdateOptions{FieldManager: version.FieldManager()}); err != nil {
+		return fmt.Errorf("failed to update forwarder DaemonSet: %w", err)
+	}
+
+	klog.Infof("Successfully updated forwarder DaemonSet %s/%s", updated.Namespace, updated.Name)
+	return nil
+}
+
+// deleteForwarder removes the forwarder DaemonSet of a service if it exists
+func (c *Controller) deleteForwarder(ctx context.Context, svc *v1.Service) error {
+	name := forwarderName(svc)
+	if _, err := c.daemonSetLister.DaemonSets(svc.Namespace).Get(name); apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	klog.V(2).Infof("Deleting forwarder DaemonSet %s/%s", svc.Namespace, name)
+	err := c.client.AppsV1().DaemonSets(svc.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete forwarder DaemonSet: %w", err)
+	}
+
+	klog.Infof("Successfully deleted forwarder DaemonSet %s/%s", svc.Namespace, name)
+	return nil
+}
+
+// newForwarder builds the desired forwarder DaemonSet of a service. Each
+// service port gets a container that binds the port on the host and forwards
+// traffic to the cluster IPs of the service.
+func (c *Controller) newForwarder(svc *v1.Service) (*appsv1.DaemonSet, error) {
+	podLabels := map[string]string{
+		ServiceNameLabel: svc.Name,
+	}
+
+	destIPs := svc.Spec.ClusterIPs
+	if len(destIPs) == 0 {
+		destIPs = []string{svc.Spec.ClusterIP}
+	}
+
+	var containers []v1.Container
+	for _, port := range svc.Spec.Ports {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = v1.ProtocolTCP
+		}
+		containers = append(containers, v1.Container{
+			Name:  fmt.Sprintf("lb-%s-%d", strings.ToLower(string(protocol)), port.Port),
+			Image: c.forwarderImage,
+			Env: []v1.EnvVar{
+				{Name: "SRC_PORT", Value: strconv.Itoa(int(port.Port))},
+				{Name: "SRC_RANGES", Value: "0.0.0.0/0"},
+				{Name: "DEST_PROTO", Value: string(protocol)},
+				{Name: "DEST_PORT", Value: strconv.Itoa(int(port.Port))},
+				{Name: "DEST_IPS", Value: strings.Join(destIPs, ",")},
+			},
+			Ports: []v1.ContainerPort{
+				{
+					Name:          fmt.Sprintf("lb-%s-%d", strings.ToLower(string(protocol)), port.Port),
+					ContainerPort: port.Port,
+					HostPort:      port.Port,
+					Protocol:      protocol,
+				},
+			},
+			SecurityContext: &v1.SecurityContext{
+				Capabilities: &v1.Capabilities{
+					Add: []v1.Capability{"NET_ADMIN"},
+				},
+			},
+		})
+	}
+
+	template := v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: podLabels,
+		},
+		Spec: v1.PodSpec{
+			Containers: containers,
+			Tolerations: []v1.Toleration{
+				{Operator: v1.TolerationOpExists},
+			},
+		},
+	}
+
+	hash, err := templateHash(&template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      forwarderName(svc),
+			Namespace: svc.Namespace,
+			Labels:    podLabels,
+			Annotations: map[string]string{
+				forwarderHashAnnotation: hash,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(svc, v1.SchemeGroupVersion.WithKind("Service")),
+			},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: podLabels,
+			},
+			Template: template,
+		},
+	}, nil
+}
+
+// templateHash returns a stable hash of the pod template, used to detect
+// changes without comparing against server-side defaulted fields
+func templateHash(template *v1.PodTemplateSpec) (string, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal pod template: %w", err)
+	}
+	h := fnv.New32a()
+	h.Write(data)
+	return strconv.FormatUint(uint64(h.Sum32()), 16), nil
+}
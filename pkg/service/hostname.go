@@ -0,0 +1,156 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// HostnameReverseDNS publishes the reverse DNS names of ingress IPs
+	HostnameReverseDNS = "reverse-dns"
+
+	lookupTimeout = 2 * time.Second
+	// Failed lookups are retried sooner than successful ones are refreshed
+	lookupTTL         = 5 * time.Minute
+	negativeLookupTTL = 30 * time.Second
+)
+
+// hostnamer derives the hostnames of ingress IPs, either via reverse DNS or
+// from a template with {service}, {namespace}, {zone} and {ip} placeholders
+type hostnamer struct {
+	template string
+
+	mu    sync.Mutex
+	cache map[string]cachedName
+}
+
+type cachedName struct {
+	name    string
+	expires time.Time
+}
+
+func newHostnamer(template string) *hostnamer {
+	return &hostnamer{
+		template: template,
+		cache:    make(map[string]cachedName),
+	}
+}
+
+// hostname returns the hostname of an ingress IP, or an empty string if none
+// could be derived
+func (h *hostnamer) hostname(ctx context.Context, svc *v1.Service, ip, zone string) string {
+	var name string
+	if h.template == HostnameReverseDNS {
+		name = h.lookup(ctx, ip)
+	} else {
+		if zone == "" && strings.Contains(h.template, "{zone}") {
+			return ""
+		}
+		name = strings.NewReplacer(
+			"{service}", svc.Name,
+			"{namespace}", svc.Namespace,
+			"{zone}", zone,
+			"{ip}", strings.NewReplacer(".", "-", ":", "-").Replace(ip),
+		).Replace(h.template)
+	}
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return ""
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		klog.Warningf("Ignoring invalid hostname %q of LoadBalancer IP %s: %s", name, ip, strings.Join(errs, ", "))
+		return ""
+	}
+	return name
+}
+
+// lookup returns the first PTR record of an IP
+func (h *hostnamer) lookup(ctx context.Context, ip string) string {
+	h.mu.Lock()
+	cached, ok := h.cache[ip]
+	h.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	var name string
+	ttl := negativeLookupTTL
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		klog.V(3).Infof("No reverse DNS name for LoadBalancer IP %s: %v", ip, err)
+	} else {
+		name = names[0]
+		ttl = lookupTTL
+	}
+
+	h.mu.Lock()
+	h.cache[ip] = cachedName{name: name, expires: time.Now().Add(ttl)}
+	h.mu.Unlock()
+	return name
+}
+
+// ingressHostnames replaces ingress IPs by hostnames. Pool addresses keep
+// their IP next to the hostname, as it is what gets announced. IPs without a
+// hostname are published as is.
+func (c *Controller) ingressHostnames(ctx context.Context, svc *v1.Service, ingress []v1.LoadBalancerIngress) ([]v1.LoadBalancerIngress, error) {
+	zones := make(map[string]string)
+	if c.allocator == nil {
+		nodes, err := c.nodeLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			zones[nodeIngressIP(node)] = node.Labels[v1.LabelTopologyZone]
+		}
+	}
+
+	seen := make(map[string]bool)
+	result := make([]v1.LoadBalancerIngress, 0, len(ingress))
+	for _, ing := range ingress {
+		hostname := c.hostnames.hostname(ctx, svc, ing.IP, zones[ing.IP])
+		if hostname == "" {
+			result = append(result, ing)
+			continue
+		}
+		// Several nodes of a zone share a templated hostname
+		if seen[hostname] {
+			continue
+		}
+		seen[hostname] = true
+
+		if c.allocator != nil {
+			result = append(result, v1.LoadBalancerIngress{IP: ing.IP, Hostname: hostname})
+		} else {
+			result = append(result, v1.LoadBalancerIngress{Hostname: hostname})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package systemd implements the notification protocol of systemd services
+// (sd_notify), so local-ccm can run as a Type=notify unit with a watchdog
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Ready tells systemd that startup finished
+	Ready = "READY=1"
+	// Stopping tells systemd that the service is shutting down
+	Stopping = "STOPPING=1"
+	// Watchdog keeps the watchdog of the service from firing
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the newline-separated state assignments to the service
+// manager. It reports false without error if the process was not started
+// by systemd with a notification socket.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	addr := &net.UnixAddr{Name: path, Net: "unixgram"}
+	if path[0] == '@' {
+		// Abstract socket namespace
+		addr.Name = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notification socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// Status formats a STATUS= assignment shown by systemctl status. Newlines,
+// e.g. of joined errors, would end the assignment, so they are replaced.
+func Status(status string) string {
+	return "STATUS=" + strings.ReplaceAll(strings.TrimSpace(status), "\n", "; ")
+}
+
+// WatchdogTimeout returns the watchdog timeout of the service, or zero if
+// the watchdog is disabled or meant for another process
+func WatchdogTimeout() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
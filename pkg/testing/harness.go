@@ -0,0 +1,198 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testing runs the local-ccm reconciler against the API server of
+// envtest and checks the resulting node, so distributions can run
+// conformance-style checks of their configuration. envtest runs etcd and
+// kube-apiserver from the binaries in KUBEBUILDER_ASSETS, e.g. installed
+// with setup-envtest.
+package testing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/cozystack/local-ccm/pkg/ccm"
+	"github.com/cozystack/local-ccm/pkg/detector"
+	"github.com/cozystack/local-ccm/pkg/node"
+)
+
+// DefaultAddress is the address detected by the static detector used by
+// Reconcile unless the config sets a detector
+const DefaultAddress = "192.0.2.1"
+
+// Harness creates a node in an API server, reconciles it once and checks
+// the result
+type Harness struct {
+	// Environment is the envtest environment started by Start, nil if the
+	// clients were created otherwise
+	Environment   *envtest.Environment
+	Client        kubernetes.Interface
+	DynamicClient dynamic.Interface
+	NodeName      string
+}
+
+// Start starts an envtest API server, installing the CRDs found in
+// crdDirectories, e.g. deploy/crds of local-ccm, and returns a harness of
+// the named node using it. The harness must be stopped with Stop.
+func Start(nodeName string, crdDirectories ...string) (*Harness, error) {
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     crdDirectories,
+		ErrorIfCRDPathMissing: len(crdDirectories) > 0,
+	}
+	restConfig, err := env.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start envtest: %w", err)
+	}
+
+	h := &Harness{Environment: env, NodeName: nodeName}
+	if h.Client, err = kubernetes.NewForConfig(restConfig); err == nil {
+		h.DynamicClient, err = dynamic.NewForConfig(restConfig)
+	}
+	if err != nil {
+		if stopErr := env.Stop(); stopErr != nil {
+			return nil, fmt.Errorf("failed to create clients: %w (and to stop envtest: %v)", err, stopErr)
+		}
+		return nil, fmt.Errorf("failed to create clients: %w", err)
+	}
+	return h, nil
+}
+
+// Stop stops the envtest API server
+func (h *Harness) Stop() error {
+	if h.Environment == nil {
+		return nil
+	}
+	if err := h.Environment.Stop(); err != nil {
+		return fmt.Errorf("failed to stop envtest: %w", err)
+	}
+	return nil
+}
+
+// CreateTaintedNode creates the node with the uninitialized taint set by
+// kubelet with --cloud-provider=external and the given status addresses
+func (h *Harness) CreateTaintedNode(ctx context.Context, addresses ...v1.NodeAddress) error {
+	n := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: h.NodeName},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{
+				Key:    node.TaintKey,
+				Value:  "true",
+				Effect: v1.TaintEffectNoSchedule,
+			}},
+		},
+	}
+	created, err := h.Client.CoreV1().Nodes().Create(ctx, n, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create node: %w", err)
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	created.Status.Addresses = addresses
+	if _, err := h.Client.CoreV1().Nodes().UpdateStatus(ctx, created, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to set node addresses: %w", err)
+	}
+	return nil
+}
+
+// Reconcile runs a single reconciliation with config against the node. The
+// node name and clients of the harness are filled in, and a static detector
+// returning DefaultAddress is used unless config sets one, so no host
+// access is needed.
+func (h *Harness) Reconcile(ctx context.Context, config ccm.Config) error {
+	config.NodeName = h.NodeName
+	config.Client = h.Client
+	config.DynamicClient = h.DynamicClient
+	config.RunOnce = true
+	if config.Detector == nil {
+		config.Detector = detector.Static{Default: DefaultAddress}
+	}
+	return ccm.Run(ctx, config)
+}
+
+// CheckAddresses checks that the node has exactly the given status addresses
+func (h *Harness) CheckAddresses(ctx context.Context, want ...v1.NodeAddress) error {
+	n, err := h.Client.CoreV1().Nodes().Get(ctx, h.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+
+	got := sortedAddresses(n.Status.Addresses)
+	want = sortedAddresses(want)
+	if len(got) != len(want) {
+		return fmt.Errorf("node %s has addresses %v, want %v", h.NodeName, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return fmt.Errorf("node %s has addresses %v, want %v", h.NodeName, got, want)
+		}
+	}
+	return nil
+}
+
+// CheckTaints checks that the node has exactly the taints with the given
+// keys, e.g. none once the uninitialized taint was removed
+func (h *Harness) CheckTaints(ctx context.Context, keys ...string) error {
+	n, err := h.Client.CoreV1().Nodes().Get(ctx, h.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+
+	got := make([]string, 0, len(n.Spec.Taints))
+	for _, taint := range n.Spec.Taints {
+		got = append(got, taint.Key)
+	}
+	sort.Strings(got)
+	want := append([]string(nil), keys...)
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("node %s has taints %v, want %v", h.NodeName, got, want)
+	}
+	return nil
+}
+
+// CheckLabels checks that the node has the given labels
+func (h *Harness) CheckLabels(ctx context.Context, want map[string]string) error {
+	n, err := h.Client.CoreV1().Nodes().Get(ctx, h.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+	for k, v := range want {
+		if got, ok := n.Labels[k]; !ok || got != v {
+			return fmt.Errorf("node %s has label %s=%q, want %q", h.NodeName, k, got, v)
+		}
+	}
+	return nil
+}
+
+func sortedAddresses(addresses []v1.NodeAddress) []v1.NodeAddress {
+	sorted := append([]v1.NodeAddress(nil), addresses...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Address < sorted[j].Address
+	})
+	return sorted
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/cozystack/local-ccm/pkg/ccm"
+	lcctesting "github.com/cozystack/local-ccm/pkg/testing"
+)
+
+func TestReconcileTaintedNode(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, see setup-envtest")
+	}
+
+	h, err := lcctesting.Start("node-1", filepath.Join("..", "..", "deploy", "crds"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := h.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	hostname := v1.NodeAddress{Type: v1.NodeHostName, Address: "node-1"}
+	if err := h.CreateTaintedNode(ctx, hostname); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Reconcile(ctx, ccm.Config{
+		InternalIPTarget: "10.0.0.1",
+		RemoveTaint:      true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.CheckAddresses(ctx, hostname, v1.NodeAddress{Type: v1.NodeInternalIP, Address: lcctesting.DefaultAddress}); err != nil {
+		t.Error(err)
+	}
+	if err := h.CheckTaints(ctx); err != nil {
+		t.Error(err)
+	}
+}
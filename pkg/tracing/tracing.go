@@ -0,0 +1,91 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing propagates a W3C trace context from the reconciliations of
+// local-ccm into its requests to the API server, so the audit events of the
+// API server can be correlated with the logs of a reconciliation.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TraceparentHeader carries the W3C trace context of a request
+	TraceparentHeader = "traceparent"
+	// AuditIDHeader sets the ID of the audit event of a request, which the
+	// API server takes from the request if set
+	AuditIDHeader = "Audit-ID"
+)
+
+type traceKey struct{}
+
+// Start returns a context carrying a new trace, and the ID of the trace
+func Start(ctx context.Context) (context.Context, string) {
+	traceID := randomID(16)
+	return context.WithValue(ctx, traceKey{}, traceID), traceID
+}
+
+// TraceID returns the ID of the trace carried by ctx, empty if none
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceKey{}).(string)
+	return traceID
+}
+
+// WrapTransport wraps the transport of a client to send the requests made
+// with a traced context as spans of the trace. The span is sent in the
+// traceparent header and, as "<trace ID>-<span ID>", as audit ID.
+func WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &transport{rt: rt}
+}
+
+type transport struct {
+	rt http.RoundTripper
+}
+
+// RoundTrip sends the request as a span of the trace of its context if any
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	traceID := TraceID(req.Context())
+	if traceID == "" {
+		return t.rt.RoundTrip(req)
+	}
+
+	// Round trippers must not modify the request
+	spanID := randomID(8)
+	req = req.Clone(req.Context())
+	req.Header.Set(TraceparentHeader, "00-"+traceID+"-"+spanID+"-01")
+	req.Header.Set(AuditIDHeader, traceID+"-"+spanID)
+	klog.V(4).Infof("Sending %s %s as span %s of trace %s", req.Method, req.URL.Path, spanID, traceID)
+	return t.rt.RoundTrip(req)
+}
+
+// WrappedRoundTripper returns the wrapped transport
+func (t *transport) WrappedRoundTripper() http.RoundTripper {
+	return t.rt
+}
+
+// randomID returns n random bytes in hex
+func randomID(n int) string {
+	id := make([]byte, n)
+	// crypto/rand does not fail on supported platforms
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
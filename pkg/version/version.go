@@ -0,0 +1,44 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package version holds the version of local-ccm, set at build time with
+// -ldflags="-X github.com/cozystack/local-ccm/pkg/version.Version=<version>".
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Name is the name of local-ccm, prefixing its field managers
+const Name = "local-ccm"
+
+// Version is the version of local-ccm
+var Version = "dev"
+
+// FieldManager returns the field manager of the writes of local-ccm,
+// "local-ccm/<version>", so the owner of a field in the managed fields
+// tells the version that wrote it
+func FieldManager() string {
+	return Name + "/" + Version
+}
+
+// UserAgent returns the User-Agent of the API requests of local-ccm on a
+// node, "local-ccm/<version> (<os>/<arch>) node/<node>", so audit logs and
+// API priority and fairness tell it apart from other controllers
+func UserAgent(nodeName string) string {
+	return fmt.Sprintf("%s/%s (%s/%s) node/%s", Name, Version, runtime.GOOS, runtime.GOARCH, nodeName)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/cozystack/local-ccm/pkg/certs"
+)
+
+// GetCertificate returns the serving certificate, e.g. of certs.Loader
+type GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// ServeFiles serves handler on Path via TLS with the certificate files,
+// reloaded on change, until ctx is done
+func ServeFiles(ctx context.Context, addr, certFile, keyFile string, handler http.Handler) error {
+	loader := certs.NewLoader("webhook", certFile, keyFile)
+	// Fail early on missing or invalid certificates
+	if _, err := loader.GetCertificate(nil); err != nil {
+		return err
+	}
+	return Serve(ctx, addr, loader.GetCertificate, handler)
+}
+
+// Serve serves handler on Path via TLS until ctx is done
+func Serve(ctx context.Context, addr string, getCertificate GetCertificate, handler http.Handler) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, handler)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: getCertificate,
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	klog.Infof("Serving node admission webhook on %s%s", addr, Path)
+	if err := server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve webhook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhook serves a mutating admission webhook for Nodes, so
+// registering nodes are initialized before the first reconciliation.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Path is the URL path the webhook is served on
+const Path = "/mutate-nodes"
+
+// maxRequestSize bounds the size of admission reviews
+const maxRequestSize = 3 << 20
+
+// MutateFunc mutates a node in place on the given operation
+type MutateFunc func(node *v1.Node, operation admissionv1.Operation)
+
+// Handler serves AdmissionReviews of Nodes, responding with a JSON patch
+// applying the mutations of its MutateFunc. It never denies a request.
+type Handler struct {
+	mutate MutateFunc
+}
+
+// NewHandler creates a webhook handler
+func NewHandler(mutate MutateFunc) *Handler {
+	return &Handler{mutate: mutate}
+}
+
+// ServeHTTP handles an AdmissionReview
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	response := h.review(review.Request)
+	review.Request = nil
+	review.Response = response
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to marshal response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// review admits the request, patching nodes with the mutations
+func (h *Handler) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	nodeResource := metav1.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	if req.Resource != nodeResource || req.SubResource != "" {
+		return response
+	}
+
+	original := &v1.Node{}
+	if err := json.Unmarshal(req.Object.Raw, original); err != nil {
+		klog.Errorf("Failed to decode node of admission request: %v", err)
+		return response
+	}
+	node := original.DeepCopy()
+	h.mutate(node, req.Operation)
+
+	patch := jsonPatch(original, node)
+	if len(patch) == 0 {
+		klog.V(3).Infof("No mutations for node %s", original.Name)
+		return response
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		klog.Errorf("Failed to marshal patch of node %s: %v", original.Name, err)
+		return response
+	}
+
+	klog.Infof("Mutating node %s on %s", original.Name, req.Operation)
+	klog.V(4).Infof("Patch of node %s: %s", original.Name, string(data))
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = data
+	response.PatchType = &patchType
+	return response
+}
+
+// patchOperation is a JSON patch operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// jsonPatch returns the operations turning original into node for the
+// fields the mutations may change. An add replaces an existing value.
+func jsonPatch(original, node *v1.Node) []patchOperation {
+	var patch []patchOperation
+	add := func(path string, before, after interface{}) {
+		if !reflect.DeepEqual(before, after) {
+			patch = append(patch, patchOperation{Op: "add", Path: path, Value: after})
+		}
+	}
+	add("/metadata/labels", original.Labels, node.Labels)
+	add("/spec/providerID", original.Spec.ProviderID, node.Spec.ProviderID)
+	add("/spec/taints", original.Spec.Taints, node.Spec.Taints)
+	add("/status/addresses", original.Status.Addresses, node.Status.Addresses)
+	return patch
+}
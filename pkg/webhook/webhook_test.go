@@ -0,0 +1,274 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// applyAdd applies a JSON patch of add operations to doc like the API
+// server, failing if the parent of a path does not exist
+func applyAdd(doc []byte, patch []byte) ([]byte, error) {
+	var operations []patchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, err
+	}
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	for _, op := range operations {
+		if op.Op != "add" {
+			return nil, fmt.Errorf("unexpected operation %s", op.Op)
+		}
+		if !strings.HasPrefix(op.Path, "/") {
+			return nil, fmt.Errorf("invalid path %q", op.Path)
+		}
+		tokens := strings.Split(op.Path[1:], "/")
+		parent, ok := root.(map[string]interface{})
+		for _, token := range tokens[:len(tokens)-1] {
+			if !ok {
+				break
+			}
+			parent, ok = parent[unescape(token)].(map[string]interface{})
+		}
+		if !ok {
+			return nil, fmt.Errorf("parent of %s does not exist", op.Path)
+		}
+		parent[unescape(tokens[len(tokens)-1])] = op.Value
+	}
+	return json.Marshal(root)
+}
+
+// unescape decodes a JSON pointer token, see RFC 6901
+func unescape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
+
+func TestJSONPatch(t *testing.T) {
+	taint := v1.Taint{Key: "node.cloudprovider.kubernetes.io/uninitialized", Value: "true", Effect: v1.TaintEffectNoSchedule}
+	tests := []struct {
+		name     string
+		original v1.Node
+		mutate   func(*v1.Node)
+		paths    []string
+	}{
+		{
+			name:     "no status.addresses",
+			original: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+			mutate: func(n *v1.Node) {
+				n.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}
+			},
+			paths: []string{"/status/addresses"},
+		},
+		{
+			name:     "no spec.taints",
+			original: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+			mutate:   func(n *v1.Node) { n.Spec.Taints = []v1.Taint{taint} },
+			paths:    []string{"/spec/taints"},
+		},
+		{
+			name: "taints removed",
+			original: v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+				Spec:       v1.NodeSpec{Taints: []v1.Taint{taint}},
+			},
+			mutate: func(n *v1.Node) { n.Spec.Taints = nil },
+			paths:  []string{"/spec/taints"},
+		},
+		{
+			name:     "no labels",
+			original: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+			mutate: func(n *v1.Node) {
+				n.Labels = map[string]string{"node.kubernetes.io/instance-type": "local"}
+			},
+			paths: []string{"/metadata/labels"},
+		},
+		{
+			// Label keys are carried in the value of the labels, so keys
+			// with / and ~ need no ~1 and ~0 escaping in the path
+			name: "keys needing escaping",
+			original: v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   "node-1",
+				Labels: map[string]string{"example.com/a~b": "1", "kubernetes.io/hostname": "node-1"},
+			}},
+			mutate: func(n *v1.Node) {
+				delete(n.Labels, "example.com/a~b")
+				n.Labels["topology.kubernetes.io/zone"] = "zone~1/a"
+			},
+			paths: []string{"/metadata/labels"},
+		},
+		{
+			name:     "provider ID and addresses",
+			original: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+			mutate: func(n *v1.Node) {
+				n.Spec.ProviderID = "local://node-1"
+				n.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeHostName, Address: "node-1"}}
+			},
+			paths: []string{"/spec/providerID", "/status/addresses"},
+		},
+		{
+			name:     "no mutations",
+			original: v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"a": "b"}}},
+			mutate:   func(n *v1.Node) { n.Labels = map[string]string{"a": "b"} },
+		},
+	}
+	for _, tc := range tests {
+		node := tc.original.DeepCopy()
+		tc.mutate(node)
+		patch := jsonPatch(&tc.original, node)
+
+		var paths []string
+		for _, op := range patch {
+			paths = append(paths, op.Path)
+		}
+		if fmt.Sprint(paths) != fmt.Sprint(tc.paths) {
+			t.Errorf("%s: patched %v, want %v", tc.name, paths, tc.paths)
+		}
+
+		// The patch applies to the node as sent by the API server
+		doc, err := json.Marshal(&tc.original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patched, err := applyAdd(doc, data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got := &v1.Node{}
+		if err := json.Unmarshal(patched, got); err != nil {
+			t.Fatal(err)
+		}
+		if !equality.Semantic.DeepEqual(got, node) {
+			t.Errorf("%s: patched node %s, want %+v", tc.name, patched, node)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler(func(n *v1.Node, operation admissionv1.Operation) {
+		if operation == admissionv1.Create {
+			n.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}}
+		}
+	})
+	nodeResource := metav1.GroupVersionResource{Version: "v1", Resource: "nodes"}
+	original, err := json.Marshal(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		resource  metav1.GroupVersionResource
+		sub       string
+		operation admissionv1.Operation
+		patch     string
+	}{
+		{
+			name:      "node created",
+			resource:  nodeResource,
+			operation: admissionv1.Create,
+			patch:     `[{"op":"add","path":"/status/addresses","value":[{"type":"InternalIP","address":"10.0.0.1"}]}]`,
+		},
+		{
+			name:      "node updated without mutations",
+			resource:  nodeResource,
+			operation: admissionv1.Update,
+		},
+		{
+			name:      "status subresource",
+			resource:  nodeResource,
+			sub:       "status",
+			operation: admissionv1.Create,
+		},
+		{
+			name:      "other resource",
+			resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			operation: admissionv1.Create,
+		},
+	}
+	for _, tc := range tests {
+		body, err := json.Marshal(&admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:         types.UID("uid-1"),
+				Resource:    tc.resource,
+				SubResource: tc.sub,
+				Operation:   tc.operation,
+				Object:      runtime.RawExtension{Raw: original},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body)))
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tc.name, recorder.Code, recorder.Body)
+			continue
+		}
+
+		review := &admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), review); err != nil {
+			t.Fatal(err)
+		}
+		response := review.Response
+		if response == nil || response.UID != "uid-1" || !response.Allowed || review.Request != nil {
+			t.Errorf("%s: unexpected review %+v", tc.name, review)
+			continue
+		}
+		if string(response.Patch) != tc.patch {
+			t.Errorf("%s: patch %s, want %s", tc.name, response.Patch, tc.patch)
+		}
+		if (response.PatchType != nil) != (tc.patch != "") {
+			t.Errorf("%s: patch type %v", tc.name, response.PatchType)
+		}
+	}
+}
+
+func TestHandlerInvalid(t *testing.T) {
+	handler := NewHandler(func(*v1.Node, admissionv1.Operation) {})
+	for name, req := range map[string]*http.Request{
+		"method":     httptest.NewRequest(http.MethodGet, Path, nil),
+		"body":       httptest.NewRequest(http.MethodPost, Path, strings.NewReader("not json")),
+		"no request": httptest.NewRequest(http.MethodPost, Path, strings.NewReader(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`)),
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code == http.StatusOK {
+			t.Errorf("%s: expected an error status", name)
+		}
+	}
+}
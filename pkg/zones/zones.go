@@ -0,0 +1,110 @@
+/*
+Copyright 2025 The local-ccm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zones
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Zone describes the failure domain and region of a node, mirroring
+// cloudprovider.Zone from k8s.io/cloud-provider
+type Zone struct {
+	FailureDomain string
+	Region        string
+}
+
+// Labels returns the topology labels describing the zone. Empty fields are
+// omitted.
+func (z Zone) Labels() map[string]string {
+	labels := make(map[string]string)
+	if z.FailureDomain != "" {
+		labels[v1.LabelTopologyZone] = z.FailureDomain
+	}
+	if z.Region != "" {
+		labels[v1.LabelTopologyRegion] = z.Region
+	}
+	return labels
+}
+
+// Zones implements the Zones API of the cloud provider interface for bare
+// metal nodes using statically configured zone and region
+type Zones struct {
+	client   kubernetes.Interface
+	nodeName string
+	zone     Zone
+}
+
+// NewZones creates a Zones provider for the local node. If region is empty it
+// is derived from the zone by stripping its last dash-separated segment, so
+// "fsn1-dc14" becomes region "fsn1"
+func NewZones(client kubernetes.Interface, nodeName, zone, region string) *Zones {
+	if region == "" {
+		region = DeriveRegion(zone)
+	}
+	return &Zones{
+		client:   client,
+		nodeName: nodeName,
+		zone: Zone{
+			FailureDomain: zone,
+			Region:        region,
+		},
+	}
+}
+
+// DeriveRegion derives a region name from a zone name
+func DeriveRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// Enabled reports whether a zone or region is configured
+func (z *Zones) Enabled() bool {
+	return z.zone.FailureDomain != "" || z.zone.Region != ""
+}
+
+// GetZone returns the zone of the local node
+func (z *Zones) GetZone(ctx context.Context) (Zone, error) {
+	return z.zone, nil
+}
+
+// GetZoneByNodeName returns the zone of the named node. The local node uses
+// the configured zone, other nodes are resolved from the topology labels
+// published by their own local-ccm instance.
+func (z *Zones) GetZoneByNodeName(ctx context.Context, nodeName types.NodeName) (Zone, error) {
+	if string(nodeName) == z.nodeName {
+		return z.zone, nil
+	}
+
+	node, err := z.client.CoreV1().Nodes().Get(ctx, string(nodeName), metav1.GetOptions{})
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+
+	return Zone{
+		FailureDomain: node.Labels[v1.LabelTopologyZone],
+		Region:        node.Labels[v1.LabelTopologyRegion],
+	}, nil
+}